@@ -4,7 +4,11 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -33,6 +37,57 @@ type FileTarget struct {
 type WaitingFile struct {
 	Name string
 	Size int64
+	// SHA256 is the hex-encoded SHA-256 digest of the file's contents.
+	// If the sender provided a digest, the file was verified against it
+	// when received. It is empty if not known, such as for files received
+	// before tailscaled last restarted.
+	SHA256 string `json:",omitempty"`
+}
+
+// FileSHA256Header is the HTTP header in which the hex-encoded SHA-256
+// digest of a file is sent with Taildrop, so that the receiver can verify
+// it. When resuming a transfer, it is the digest of the entire file, not
+// just the part being sent.
+const FileSHA256Header = "Tailscale-File-Sha256"
+
+// DirFile is a file in a directory being sent with Taildrop.
+type DirFile struct {
+	// Path is the slash-separated path of the file, relative to the
+	// directory being sent.
+	Path string
+	// Size is the size of the file in bytes.
+	Size int64
+	// SHA256, if non-empty, is the hex-encoded SHA-256 digest of the
+	// file's contents, which the receiver verifies.
+	SHA256 string `json:",omitempty"`
+}
+
+// DirManifest lists the files in a directory being sent with Taildrop.
+// It is the body POSTed to the PeerAPI (and LocalAPI) to begin sending a
+// directory. Empty directories are not sent.
+type DirManifest struct {
+	Files []DirFile
+}
+
+// PutDirResponse is the response to beginning to send a directory with
+// Taildrop.
+type PutDirResponse struct {
+	// Done lists the paths of files which the receiver already has in
+	// full from an earlier, interrupted attempt, and need not be sent
+	// again.
+	Done []string
+}
+
+// PendingFile is a file (or directory) received with Taildrop which is
+// waiting for the user to accept it before it appears with the
+// WaitingFiles.
+type PendingFile struct {
+	Name  string
+	Size  int64 // for directories, the total size of their files
+	IsDir bool  `json:",omitempty"`
+	// Sender describes who sent the file, if known.
+	Sender   string `json:",omitempty"`
+	Received time.Time
 }
 
 // SetPushDeviceTokenRequest is the body POSTed to the LocalAPI endpoint /set-device-token.
@@ -605,6 +605,41 @@ func (lc *LocalClient) DeleteWaitingFile(ctx context.Context, baseName string) e
 	return err
 }
 
+// PendingFiles returns the received files which are waiting to be
+// accepted, because the receiving node's Taildrop policy requires
+// confirmation.
+func (lc *LocalClient) PendingFiles(ctx context.Context) ([]apitype.PendingFile, error) {
+	return lc.AwaitPendingFiles(ctx, 0)
+}
+
+// AwaitPendingFiles is like PendingFiles but takes a duration to await for
+// an answer, as with AwaitWaitingFiles.
+func (lc *LocalClient) AwaitPendingFiles(ctx context.Context, d time.Duration) ([]apitype.PendingFile, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/file-pending/?waitsec="+fmt.Sprint(int(d.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]apitype.PendingFile](body)
+}
+
+// AcceptPendingFile accepts the pending file name, after which it is
+// one of the WaitingFiles. It returns the name of the waiting file, which
+// differs from name if a file of that name was already waiting.
+func (lc *LocalClient) AcceptPendingFile(ctx context.Context, name string) (string, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/file-pending/"+url.PathEscape(name), 200, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := decodeJSON[struct{ Name string }](body)
+	return res.Name, err
+}
+
+// RejectPendingFile deletes the pending file name.
+func (lc *LocalClient) RejectPendingFile(ctx context.Context, name string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/file-pending/"+url.PathEscape(name), http.StatusNoContent, nil)
+	return err
+}
+
 func (lc *LocalClient) GetWaitingFile(ctx context.Context, baseName string) (rc io.ReadCloser, size int64, err error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/files/"+url.PathEscape(baseName), nil)
 	if err != nil {
@@ -639,6 +674,14 @@ func (lc *LocalClient) FileTargets(ctx context.Context) ([]apitype.FileTarget, e
 // A size of -1 means unknown.
 // The name parameter is the original filename, not escaped.
 func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID, size int64, name string, r io.Reader) error {
+	return lc.PushFileWithSHA256(ctx, target, size, name, "", r)
+}
+
+// PushFileWithSHA256 is like PushFile, but also sends sha256, the
+// hex-encoded SHA-256 digest of the file's contents. The receiver discards
+// the file, and an error is returned, if the contents it receives do not
+// match. An empty sha256 means unknown.
+func (lc *LocalClient) PushFileWithSHA256(ctx context.Context, target tailcfg.StableNodeID, size int64, name, sha256 string, r io.Reader) error {
 	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put/"+string(target)+"/"+url.PathEscape(name), r)
 	if err != nil {
 		return err
@@ -646,6 +689,48 @@ func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID
 	if size != -1 {
 		req.ContentLength = size
 	}
+	if sha256 != "" {
+		req.Header.Set(apitype.FileSHA256Header, sha256)
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == 200 {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	all, _ := io.ReadAll(res.Body)
+	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
+}
+
+// BeginPushDir begins sending a directory named name, containing the files
+// listed in manifest, to the target node. Each file not reported as done
+// in the response must then be sent with PushDirFile, after which
+// CommitPushDir completes the transfer.
+//
+// If an earlier attempt to send the same directory was interrupted, the
+// transfer is resumed.
+func (lc *LocalClient) BeginPushDir(ctx context.Context, target tailcfg.StableNodeID, name string, manifest apitype.DirManifest) (*apitype.PutDirResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(name), 200, jsonBody(manifest))
+	if err != nil {
+		return nil, err
+	}
+	res, err := decodeJSON[*apitype.PutDirResponse](body)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// PushDirFile sends the file at the slash-separated path relPath within
+// the directory dirName, whose transfer was started with BeginPushDir.
+func (lc *LocalClient) PushDirFile(ctx context.Context, target tailcfg.StableNodeID, dirName, relPath string, size int64, r io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", "http://"+apitype.LocalAPIHost+"/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(dirName)+"/"+url.PathEscape(relPath), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
 	res, err := lc.doLocalRequestNiceError(req)
 	if err != nil {
 		return err
@@ -658,6 +743,13 @@ func (lc *LocalClient) PushFile(ctx context.Context, target tailcfg.StableNodeID
 	return bestError(fmt.Errorf("%s: %s", res.Status, all), all)
 }
 
+// CommitPushDir completes sending the directory dirName to the target node,
+// once all its files have been sent with PushDirFile.
+func (lc *LocalClient) CommitPushDir(ctx context.Context, target tailcfg.StableNodeID, dirName string) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/file-put-dir/"+string(target)+"/"+url.PathEscape(dirName)+"?commit=1", 200, nil)
+	return err
+}
+
 // CheckIPForwarding asks the local Tailscale daemon whether it looks like the
 // machine is properly configured to forward IP packets as a subnet router
 // or exit node.
@@ -1081,6 +1173,16 @@ func (lc *LocalClient) NetworkLockLog(ctx context.Context, maxEntries int) ([]ip
 	return decodeJSON[[]ipnstate.NetworkLockUpdate](body)
 }
 
+// NetworkLockExport returns every AUM known to the node's tailnet key
+// authority, as JSON lines suitable for tka.ReadExport.
+func (lc *LocalClient) NetworkLockExport(ctx context.Context) ([]byte, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/export", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return body, nil
+}
+
 // NetworkLockForceLocalDisable forcibly shuts down network lock on this node.
 func (lc *LocalClient) NetworkLockForceLocalDisable(ctx context.Context) error {
 	// This endpoint expects an empty JSON stanza as the payload.
@@ -1146,6 +1248,45 @@ func (lc *LocalClient) NetworkLockSubmitRecoveryAUM(ctx context.Context, aum tka
 	return nil
 }
 
+// NetworkLockGenProposal generates an AUM which adds and removes the given
+// tailnet-lock keys, and sets the key-change threshold if threshold is not
+// negative. The returned AUM is signed by the node's tailnet lock key, and
+// may need co-signing by other trusted keys before it is submitted.
+func (lc *LocalClient) NetworkLockGenProposal(ctx context.Context, addKeys, removeKeys []tka.Key, threshold int) ([]byte, error) {
+	vr := struct {
+		AddKeys    []tka.Key
+		RemoveKeys []tka.Key
+		Threshold  int
+	}{addKeys, removeKeys, threshold}
+
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/generate-proposal", 200, jsonBody(vr))
+	if err != nil {
+		return nil, fmt.Errorf("sending generate-proposal: %w", err)
+	}
+	return body, nil
+}
+
+// NetworkLockCosignProposal co-signs a proposal AUM using the node's tailnet lock key.
+func (lc *LocalClient) NetworkLockCosignProposal(ctx context.Context, aum tka.AUM) ([]byte, error) {
+	r := bytes.NewReader(aum.Serialize())
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/cosign-proposal", 200, r)
+	if err != nil {
+		return nil, fmt.Errorf("sending cosign-proposal: %w", err)
+	}
+	return body, nil
+}
+
+// NetworkLockSubmitProposal submits a sufficiently co-signed proposal AUM to
+// the control plane.
+func (lc *LocalClient) NetworkLockSubmitProposal(ctx context.Context, aum tka.AUM) error {
+	r := bytes.NewReader(aum.Serialize())
+	_, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-proposal", 200, r)
+	if err != nil {
+		return fmt.Errorf("sending submit-proposal: %w", err)
+	}
+	return nil
+}
+
 // SetServeConfig sets or replaces the serving settings.
 // If config is nil, settings are cleared and serving is disabled.
 func (lc *LocalClient) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
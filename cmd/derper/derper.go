@@ -56,6 +56,11 @@
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
+
+	clientBytesLimit   = flag.Int("per-client-bytes-limit", 0, "if positive, rate limit in bytes/sec for packets sent by each client; excess packets are dropped")
+	clientBytesBurst   = flag.Int("per-client-bytes-burst", 0, "burst limit in bytes for -per-client-bytes-limit; defaults to one second's worth")
+	clientPacketsLimit = flag.Int("per-client-packets-limit", 0, "if positive, rate limit in packets/sec for packets sent by each client; excess packets are dropped")
+	clientPacketsBurst = flag.Int("per-client-packets-burst", 0, "burst limit in packets for -per-client-packets-limit; defaults to one second's worth")
 )
 
 var (
@@ -152,6 +157,7 @@ func main() {
 
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
+	s.SetClientRateLimits(*clientBytesLimit, *clientBytesBurst, *clientPacketsLimit, *clientPacketsBurst)
 
 	if *meshPSKFile != "" {
 		b, err := os.ReadFile(*meshPSKFile)
@@ -59,6 +59,8 @@ type ConnectorReconciler struct {
 
 	clock tstime.Clock
 
+	proxyClassEnabled bool // whether ENABLE_PROXY_CLASS is set
+
 	mu sync.Mutex // protects following
 
 	// subnetRouters tracks the subnet routers managed by this Tailscale
@@ -159,6 +161,15 @@ func (a *ConnectorReconciler) Reconcile(ctx context.Context, req reconcile.Reque
 		return reconcile.Result{}, nil
 	}
 
+	if pc := enabledProxyClass(a.proxyClassEnabled, a.recorder, cn, cn.Spec.ProxyClass); pc != "" {
+		if ready, err := proxyClassIsReady(ctx, a.Client, pc); err != nil {
+			return reconcile.Result{}, fmt.Errorf("error verifying ProxyClass for Connector: %w", err)
+		} else if !ready {
+			logger.Infof("ProxyClass %s specified for the Connector, but is not (yet) Ready, waiting..", pc)
+			return reconcile.Result{}, nil
+		}
+	}
+
 	var sb strings.Builder
 	sb.WriteString(string(cn.Spec.SubnetRouter.Routes[0]))
 	for _, r := range cn.Spec.SubnetRouter.Routes[1:] {
@@ -218,12 +229,17 @@ func (a *ConnectorReconciler) maybeProvisionSubnetRouter(ctx context.Context, lo
 
 	crl := childResourceLabels(cn.Name, a.tsnamespace, "subnetrouter")
 	hostname := hostnameForSubnetRouter(cn)
+	proxyClass := cn.Spec.ProxyClass
+	if !a.proxyClassEnabled {
+		proxyClass = "" // already reported by Reconcile
+	}
 	sts := &tailscaleSTSConfig{
 		ParentResourceName:  cn.Name,
 		ParentResourceUID:   string(cn.UID),
 		Hostname:            hostname,
 		ChildResourceLabels: crl,
 		Routes:              cidrs,
+		ProxyClass:          proxyClass,
 	}
 	for _, tag := range cn.Spec.SubnetRouter.Tags {
 		sts.Tags = append(sts.Tags, string(tag))
@@ -33,6 +33,8 @@ type IngressReconciler struct {
 	ssr      *tailscaleSTSReconciler
 	logger   *zap.SugaredLogger
 
+	proxyClassEnabled bool // whether ENABLE_PROXY_CLASS is set
+
 	mu sync.Mutex // protects following
 
 	// managedIngresses is a set of all ingress resources that we're currently
@@ -109,6 +111,16 @@ func (a *IngressReconciler) maybeCleanup(ctx context.Context, logger *zap.Sugare
 // This function adds a finalizer to ing, ensuring that we can handle orderly
 // deprovisioning later.
 func (a *IngressReconciler) maybeProvision(ctx context.Context, logger *zap.SugaredLogger, ing *networkingv1.Ingress) error {
+	proxyClass := enabledProxyClass(a.proxyClassEnabled, a.recorder, ing, ing.Labels[LabelProxyClass])
+	if proxyClass != "" {
+		if ready, err := proxyClassIsReady(ctx, a.Client, proxyClass); err != nil {
+			return fmt.Errorf("error verifying ProxyClass for Ingress: %w", err)
+		} else if !ready {
+			logger.Infof("ProxyClass %s specified for the Ingress, but is not (yet) Ready, waiting..", proxyClass)
+			return nil
+		}
+	}
+
 	if !slices.Contains(ing.Finalizers, FinalizerName) {
 		// This log line is printed exactly once during initial provisioning,
 		// because once the finalizer is in place this block gets skipped. So,
@@ -226,6 +238,7 @@ func (a *IngressReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 		ServeConfig:         sc,
 		Tags:                tags,
 		ChildResourceLabels: crl,
+		ProxyClass:          proxyClass,
 	}
 
 	if _, err := a.ssr.Provision(ctx, logger, sts); err != nil {
@@ -56,13 +56,14 @@ func main() {
 	tailscale.I_Acknowledge_This_API_Is_Unstable = true
 
 	var (
-		tsNamespace       = defaultEnv("OPERATOR_NAMESPACE", "")
-		tslogging         = defaultEnv("OPERATOR_LOGGING", "info")
-		image             = defaultEnv("PROXY_IMAGE", "tailscale/tailscale:latest")
-		priorityClassName = defaultEnv("PROXY_PRIORITY_CLASS_NAME", "")
-		tags              = defaultEnv("PROXY_TAGS", "tag:k8s")
-		tsFirewallMode    = defaultEnv("PROXY_FIREWALL_MODE", "")
-		tsEnableConnector = defaultBool("ENABLE_CONNECTOR", false)
+		tsNamespace        = defaultEnv("OPERATOR_NAMESPACE", "")
+		tslogging          = defaultEnv("OPERATOR_LOGGING", "info")
+		image              = defaultEnv("PROXY_IMAGE", "tailscale/tailscale:latest")
+		priorityClassName  = defaultEnv("PROXY_PRIORITY_CLASS_NAME", "")
+		tags               = defaultEnv("PROXY_TAGS", "tag:k8s")
+		tsFirewallMode     = defaultEnv("PROXY_FIREWALL_MODE", "")
+		tsEnableConnector  = defaultBool("ENABLE_CONNECTOR", false)
+		tsEnableProxyClass = defaultBool("ENABLE_PROXY_CLASS", false)
 	)
 
 	var opts []kzap.Opts
@@ -93,7 +94,7 @@ func main() {
 	maybeLaunchAPIServerProxy(zlog, restConfig, s, mode)
 	// TODO (irbekrm): gather the reconciler options into an opts struct
 	// rather than passing a million of them in one by one.
-	runReconcilers(zlog, s, tsNamespace, restConfig, tsClient, image, priorityClassName, tags, tsFirewallMode, tsEnableConnector)
+	runReconcilers(zlog, s, tsNamespace, restConfig, tsClient, image, priorityClassName, tags, tsFirewallMode, tsEnableConnector, tsEnableProxyClass)
 }
 
 // initTSNet initializes the tsnet.Server and logs in to Tailscale. It uses the
@@ -201,7 +202,7 @@ func initTSNet(zlog *zap.SugaredLogger) (*tsnet.Server, *tailscale.Client) {
 
 // runReconcilers starts the controller-runtime manager and registers the
 // ServiceReconciler. It blocks forever.
-func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string, restConfig *rest.Config, tsClient *tailscale.Client, image, priorityClassName, tags, tsFirewallMode string, enableConnector bool) {
+func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string, restConfig *rest.Config, tsClient *tailscale.Client, image, priorityClassName, tags, tsFirewallMode string, enableConnector, enableProxyClass bool) {
 	var (
 		isDefaultLoadBalancer = defaultBool("OPERATOR_DEFAULT_LOAD_BALANCER", false)
 	)
@@ -223,7 +224,7 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 			},
 		},
 	}
-	if enableConnector {
+	if enableConnector || enableProxyClass {
 		mgrOpts.Scheme = tsapi.GlobalScheme
 	}
 	mgr, err := manager.New(restConfig, mgrOpts)
@@ -245,34 +246,44 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 		proxyPriorityClassName: priorityClassName,
 		tsFirewallMode:         tsFirewallMode,
 	}
-	err = builder.
+	svcBuilder := builder.
 		ControllerManagedBy(mgr).
 		Named("service-reconciler").
 		Watches(&corev1.Service{}, svcFilter).
 		Watches(&appsv1.StatefulSet{}, svcChildFilter).
-		Watches(&corev1.Secret{}, svcChildFilter).
+		Watches(&corev1.Secret{}, svcChildFilter)
+	if enableProxyClass {
+		svcBuilder = svcBuilder.Watches(&tsapi.ProxyClass{}, handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForSvc(mgr.GetClient(), startlog)))
+	}
+	err = svcBuilder.
 		Complete(&ServiceReconciler{
 			ssr:                   ssr,
 			Client:                mgr.GetClient(),
 			logger:                zlog.Named("service-reconciler"),
 			isDefaultLoadBalancer: isDefaultLoadBalancer,
+			proxyClassEnabled:     enableProxyClass,
 			recorder:              eventRecorder,
 		})
 	if err != nil {
 		startlog.Fatalf("could not create controller: %v", err)
 	}
 	ingressChildFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("ingress"))
-	err = builder.
+	ingressBuilder := builder.
 		ControllerManagedBy(mgr).
 		For(&networkingv1.Ingress{}).
 		Watches(&appsv1.StatefulSet{}, ingressChildFilter).
 		Watches(&corev1.Secret{}, ingressChildFilter).
-		Watches(&corev1.Service{}, ingressChildFilter).
+		Watches(&corev1.Service{}, ingressChildFilter)
+	if enableProxyClass {
+		ingressBuilder = ingressBuilder.Watches(&tsapi.ProxyClass{}, handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForIngress(mgr.GetClient(), startlog)))
+	}
+	err = ingressBuilder.
 		Complete(&IngressReconciler{
-			ssr:      ssr,
-			recorder: eventRecorder,
-			Client:   mgr.GetClient(),
-			logger:   zlog.Named("ingress-reconciler"),
+			ssr:               ssr,
+			recorder:          eventRecorder,
+			Client:            mgr.GetClient(),
+			logger:            zlog.Named("ingress-reconciler"),
+			proxyClassEnabled: enableProxyClass,
 		})
 	if err != nil {
 		startlog.Fatalf("could not create controller: %v", err)
@@ -280,19 +291,37 @@ func runReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string
 
 	if enableConnector {
 		connectorFilter := handler.EnqueueRequestsFromMapFunc(managedResourceHandlerForType("subnetrouter"))
-		err = builder.ControllerManagedBy(mgr).
+		connectorBuilder := builder.ControllerManagedBy(mgr).
 			For(&tsapi.Connector{}).
 			Watches(&appsv1.StatefulSet{}, connectorFilter).
-			Watches(&corev1.Secret{}, connectorFilter).
+			Watches(&corev1.Secret{}, connectorFilter)
+		if enableProxyClass {
+			connectorBuilder = connectorBuilder.Watches(&tsapi.ProxyClass{}, handler.EnqueueRequestsFromMapFunc(proxyClassHandlerForConnector(mgr.GetClient(), startlog)))
+		}
+		err = connectorBuilder.
 			Complete(&ConnectorReconciler{
-				ssr:      ssr,
-				recorder: eventRecorder,
+				ssr:               ssr,
+				recorder:          eventRecorder,
+				Client:            mgr.GetClient(),
+				logger:            zlog.Named("connector-reconciler"),
+				clock:             tstime.DefaultClock{},
+				proxyClassEnabled: enableProxyClass,
+			})
+		if err != nil {
+			startlog.Fatal("could not create connector reconciler: %v", err)
+		}
+	}
+	if enableProxyClass {
+		err = builder.ControllerManagedBy(mgr).
+			For(&tsapi.ProxyClass{}).
+			Complete(&ProxyClassReconciler{
 				Client:   mgr.GetClient(),
-				logger:   zlog.Named("connector-reconciler"),
+				recorder: eventRecorder,
+				logger:   zlog.Named("proxyclass-reconciler"),
 				clock:    tstime.DefaultClock{},
 			})
 		if err != nil {
-			startlog.Fatal("could not create connector reconciler: %v", err)
+			startlog.Fatalf("could not create proxyclass reconciler: %v", err)
 		}
 	}
 	startlog.Infof("Startup complete, operator running, version: %s", version.Long())
@@ -357,6 +386,62 @@ func serviceHandler(_ context.Context, o client.Object) []reconcile.Request {
 
 }
 
+// proxyClassHandlerForSvc returns a handler that, for a given ProxyClass,
+// returns a list of reconcile requests for all Services labeled with
+// tailscale.com/proxy-class: <proxy class name>.
+func proxyClassHandlerForSvc(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		svcList := new(corev1.ServiceList)
+		if err := cl.List(ctx, svcList, client.MatchingLabels{LabelProxyClass: o.GetName()}); err != nil {
+			logger.Debugf("error listing Services for ProxyClass: %v", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(svcList.Items))
+		for _, svc := range svcList.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&svc)})
+		}
+		return reqs
+	}
+}
+
+// proxyClassHandlerForIngress returns a handler that, for a given ProxyClass,
+// returns a list of reconcile requests for all Ingresses labeled with
+// tailscale.com/proxy-class: <proxy class name>.
+func proxyClassHandlerForIngress(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		ingList := new(networkingv1.IngressList)
+		if err := cl.List(ctx, ingList, client.MatchingLabels{LabelProxyClass: o.GetName()}); err != nil {
+			logger.Debugf("error listing Ingresses for ProxyClass: %v", err)
+			return nil
+		}
+		reqs := make([]reconcile.Request, 0, len(ingList.Items))
+		for _, ing := range ingList.Items {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ing)})
+		}
+		return reqs
+	}
+}
+
+// proxyClassHandlerForConnector returns a handler that, for a given
+// ProxyClass, returns a list of reconcile requests for all Connectors that
+// have .spec.proxyClass set to its name.
+func proxyClassHandlerForConnector(cl client.Client, logger *zap.SugaredLogger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		connList := new(tsapi.ConnectorList)
+		if err := cl.List(ctx, connList); err != nil {
+			logger.Debugf("error listing Connectors for ProxyClass: %v", err)
+			return nil
+		}
+		var reqs []reconcile.Request
+		for _, conn := range connList.Items {
+			if conn.Spec.ProxyClass == o.GetName() {
+				reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&conn)})
+			}
+		}
+		return reqs
+	}
+}
+
 // isMagicDNSName reports whether name is a full tailnet node FQDN (with or
 // without final dot).
 func isMagicDNSName(name string) bool {
@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metavalidation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstime"
+)
+
+const (
+	reasonProxyClassInvalid  = "ProxyClassInvalid"
+	reasonProxyClassValid    = "ProxyClassValid"
+	reasonProxyClassDisabled = "ProxyClassDisabled"
+
+	messageProxyClassInvalid  = "ProxyClass is not valid: %v"
+	messageProxyClassDisabled = "ProxyClass %s is ignored, as ProxyClass support is not enabled; set ENABLE_PROXY_CLASS=true to apply it"
+)
+
+// ProxyClassReconciler validates ProxyClass resources and marks the valid
+// ones as ready to be applied to proxies.
+type ProxyClassReconciler struct {
+	client.Client
+
+	recorder record.EventRecorder
+	logger   *zap.SugaredLogger
+	clock    tstime.Clock
+}
+
+func (a *ProxyClassReconciler) Reconcile(ctx context.Context, req reconcile.Request) (_ reconcile.Result, err error) {
+	logger := a.logger.With("proxyclass", req.Name)
+	logger.Debugf("starting reconcile")
+	defer logger.Debugf("reconcile finished")
+
+	pc := new(tsapi.ProxyClass)
+	err = a.Get(ctx, req.NamespacedName, pc)
+	if apierrors.IsNotFound(err) {
+		logger.Debugf("ProxyClass not found, assuming it was deleted")
+		return reconcile.Result{}, nil
+	} else if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to get tailscale.com ProxyClass: %w", err)
+	}
+	if !pc.DeletionTimestamp.IsZero() {
+		logger.Debugf("ProxyClass is being deleted, nothing to do")
+		return reconcile.Result{}, nil
+	}
+
+	oldPCStatus := pc.Status.DeepCopy()
+	if errs := validateProxyClass(pc); len(errs) > 0 {
+		msg := fmt.Sprintf(messageProxyClassInvalid, errs.ToAggregate())
+		a.recorder.Event(pc, corev1.EventTypeWarning, reasonProxyClassInvalid, msg)
+		tsoperator.SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionFalse, reasonProxyClassInvalid, msg, pc.Generation, a.clock, logger)
+	} else {
+		tsoperator.SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionTrue, reasonProxyClassValid, reasonProxyClassValid, pc.Generation, a.clock, logger)
+	}
+	if !apiequality.Semantic.DeepEqual(oldPCStatus, &pc.Status) {
+		if err := a.Client.Status().Update(ctx, pc); err != nil {
+			return reconcile.Result{}, fmt.Errorf("failed to update ProxyClass status: %w", err)
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// validateProxyClass returns the problems with pc that can't be caught by
+// the CRD's schema validation.
+func validateProxyClass(pc *tsapi.ProxyClass) (violations field.ErrorList) {
+	sts := pc.Spec.StatefulSet
+	if sts == nil {
+		return nil
+	}
+	path := field.NewPath("spec", "statefulSet")
+	violations = append(violations, metavalidation.ValidateLabels(sts.Labels, path.Child("labels"))...)
+	violations = append(violations, apivalidation.ValidateAnnotations(sts.Annotations, path.Child("annotations"))...)
+	if pod := sts.Pod; pod != nil {
+		path := path.Child("pod")
+		violations = append(violations, metavalidation.ValidateLabels(pod.Labels, path.Child("labels"))...)
+		violations = append(violations, apivalidation.ValidateAnnotations(pod.Annotations, path.Child("annotations"))...)
+		violations = append(violations, metavalidation.ValidateLabels(pod.NodeSelector, path.Child("nodeSelector"))...)
+	}
+	return violations
+}
+
+// enabledProxyClass returns name, the ProxyClass specified for the proxy of
+// obj, if ProxyClass support is enabled. Otherwise nothing would ever mark
+// the ProxyClass ready, so it records a warning event on obj and returns ""
+// so that the proxy is provisioned without it.
+func enabledProxyClass(enabled bool, recorder record.EventRecorder, obj runtime.Object, name string) string {
+	if name == "" || enabled {
+		return name
+	}
+	recorder.Eventf(obj, corev1.EventTypeWarning, reasonProxyClassDisabled, messageProxyClassDisabled, name)
+	return ""
+}
+
+// proxyClassIsReady reports whether the ProxyClass with the given name exists
+// and has been validated, so that it can be applied to proxies. Resources
+// that refer to a ProxyClass that isn't ready are reconciled again once it
+// is.
+func proxyClassIsReady(ctx context.Context, cl client.Client, name string) (bool, error) {
+	pc := new(tsapi.ProxyClass)
+	err := cl.Get(ctx, types.NamespacedName{Name: name}, pc)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get ProxyClass %s: %w", name, err)
+	}
+	return tsoperator.ProxyClassIsReady(pc), nil
+}
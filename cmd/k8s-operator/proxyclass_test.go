@@ -0,0 +1,212 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	tsoperator "tailscale.com/k8s-operator"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
+	"tailscale.com/tstest"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/mak"
+)
+
+func TestProxyClass(t *testing.T) {
+	pc := &tsapi.ProxyClass{
+		TypeMeta: metav1.TypeMeta{Kind: "ProxyClass", APIVersion: "tailscale.com/v1alpha1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test",
+			// The apiserver is supposed to set the UID, but the fake client
+			// doesn't. So, set it explicitly because other code later depends
+			// on it being set.
+			UID: types.UID("1234-UID"),
+		},
+		Spec: tsapi.ProxyClassSpec{
+			StatefulSet: &tsapi.StatefulSet{
+				Labels:      map[string]string{"foo": "bar", "xyz1234": "abc567"},
+				Annotations: map[string]string{"foo.io/bar": "{'key': 'val1232'}"},
+				Pod: &tsapi.Pod{
+					Labels:       map[string]string{"foo": "bar", "xyz1234": "abc567"},
+					Annotations:  map[string]string{"foo.io/bar": "{'key': 'val1232'}"},
+					NodeSelector: map[string]string{"beta.kubernetes.io/os": "linux"},
+				},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pc).
+		WithStatusSubresource(pc).
+		Build()
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := record.NewFakeRecorder(3)
+	cl := tstest.NewClock(tstest.ClockOpts{Start: time.Now().Truncate(time.Second)})
+	pcr := &ProxyClassReconciler{
+		Client:   fc,
+		logger:   zl.Sugar(),
+		clock:    cl,
+		recorder: fr,
+	}
+
+	// 1. A valid ProxyClass resource gets its status updated to Ready.
+	expectReconciled(t, pcr, "", "test")
+	tsoperator.SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionTrue, reasonProxyClassValid, reasonProxyClassValid, 0, cl, zl.Sugar())
+	expectEqual(t, fc, pc)
+
+	// 2. An invalid ProxyClass resource gets its status updated to Invalid.
+	pc.Spec.StatefulSet.Labels["foo"] = "?!someVal"
+	mustUpdate(t, fc, "", "test", func(proxyClass *tsapi.ProxyClass) {
+		proxyClass.Spec.StatefulSet.Labels = pc.Spec.StatefulSet.Labels
+	})
+	expectReconciled(t, pcr, "", "test")
+	msg := `ProxyClass is not valid: spec.statefulSet.labels: Invalid value: "?!someVal": a valid label must be an empty string or consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyValue',  or 'my_value',  or '12345', regex used for validation is '(([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9])?')`
+	tsoperator.SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionFalse, reasonProxyClassInvalid, msg, 0, cl, zl.Sugar())
+	expectEqual(t, fc, pc)
+	expectedEvent := "Warning ProxyClassInvalid " + msg
+	if got := <-fr.Events; got != expectedEvent {
+		t.Errorf("got event %q, want %q", got, expectedEvent)
+	}
+}
+
+func TestProxyClassForService(t *testing.T) {
+	pc := &tsapi.ProxyClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "custom-metadata"},
+		Spec: tsapi.ProxyClassSpec{
+			StatefulSet: &tsapi.StatefulSet{
+				Labels: map[string]string{"foo": "bar"},
+				Pod: &tsapi.Pod{
+					Labels:       map[string]string{"bar": "foo"},
+					NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+					TailscaleContainer: &tsapi.Container{
+						Image: "ghcr.io/tailscale/tailscale:custom",
+						Env:   []tsapi.Env{{Name: "TS_DEBUG_MTU", Value: "1280"}},
+					},
+				},
+			},
+		},
+	}
+	fc := fake.NewClientBuilder().
+		WithScheme(tsapi.GlobalScheme).
+		WithObjects(pc).
+		WithStatusSubresource(pc).
+		Build()
+	ft := &fakeTSClient{}
+	zl, err := zap.NewDevelopment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := tstest.NewClock(tstest.ClockOpts{Start: time.Now().Truncate(time.Second)})
+	sr := &ServiceReconciler{
+		Client: fc,
+		ssr: &tailscaleSTSReconciler{
+			Client:            fc,
+			tsClient:          ft,
+			defaultTags:       []string{"tag:k8s"},
+			operatorNamespace: "operator-ns",
+			proxyImage:        "tailscale/tailscale",
+		},
+		logger:            zl.Sugar(),
+		proxyClassEnabled: true,
+	}
+
+	// 1. A Service that refers to a ProxyClass that isn't ready doesn't get
+	// a proxy.
+	mustCreate(t, fc, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "default",
+			// The apiserver is supposed to set the UID, but the fake client
+			// doesn't. So, set it explicitly because other code later depends
+			// on it being set.
+			UID:    types.UID("1234-UID"),
+			Labels: map[string]string{LabelProxyClass: "custom-metadata"},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:         "10.20.30.40",
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: ptr.To("tailscale"),
+		},
+	})
+	expectReconciled(t, sr, "default", "test")
+	sts, err := getSingleObject[appsv1.StatefulSet](context.Background(), fc, "operator-ns", childResourceLabels("test", "default", "svc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sts != nil {
+		t.Fatalf("StatefulSet %s created for ProxyClass that is not ready", sts.Name)
+	}
+
+	// 2. Once the ProxyClass is ready, the proxy is created with its
+	// configuration.
+	mustUpdateStatus(t, fc, "", "custom-metadata", func(pc *tsapi.ProxyClass) {
+		tsoperator.SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionTrue, reasonProxyClassValid, reasonProxyClassValid, pc.Generation, cl, zl.Sugar())
+	})
+	expectReconciled(t, sr, "default", "test")
+	fullName, shortName := findGenName(t, fc, "default", "test", "svc")
+	opts := stsOpts{
+		name:       shortName,
+		secretName: fullName,
+		hostname:   "default-test",
+	}
+	want := expectedSTS(opts)
+	want.Labels["foo"] = "bar"
+	want.Annotations = map[string]string{annotationProxyClassLabels: "foo"}
+	want.Spec.Template.Labels["bar"] = "foo"
+	want.Spec.Template.Spec.NodeSelector = map[string]string{"kubernetes.io/os": "linux"}
+	tsContainer := &want.Spec.Template.Spec.Containers[0]
+	tsContainer.Image = "ghcr.io/tailscale/tailscale:custom"
+	tsContainer.Env = append(tsContainer.Env, corev1.EnvVar{Name: "TS_DEBUG_MTU", Value: "1280"})
+	expectEqual(t, fc, want)
+
+	// 3. When the Service no longer refers to the ProxyClass, the proxy's
+	// configuration is reverted to the defaults, but labels and annotations
+	// set by others are kept.
+	mustUpdate(t, fc, "operator-ns", shortName, func(ss *appsv1.StatefulSet) {
+		ss.Labels["other"] = "label"
+		ss.Annotations["other"] = "annotation"
+	})
+	mustUpdate(t, fc, "default", "test", func(svc *corev1.Service) {
+		delete(svc.Labels, LabelProxyClass)
+	})
+	expectReconciled(t, sr, "default", "test")
+	want = expectedSTS(opts)
+	want.Labels["other"] = "label"
+	want.Annotations = map[string]string{"other": "annotation"}
+	expectEqual(t, fc, want)
+
+	// 4. If ProxyClass support is disabled, the label is ignored with a
+	// warning, rather than waiting for the ProxyClass forever.
+	rec := record.NewFakeRecorder(1)
+	sr.proxyClassEnabled = false
+	sr.recorder = rec
+	mustUpdate(t, fc, "default", "test", func(svc *corev1.Service) {
+		mak.Set(&svc.Labels, LabelProxyClass, "custom-metadata")
+	})
+	expectReconciled(t, sr, "default", "test")
+	expectEqual(t, fc, want)
+	select {
+	case ev := <-rec.Events:
+		if !strings.Contains(ev, reasonProxyClassDisabled) {
+			t.Errorf("got event %q, want %s", ev, reasonProxyClassDisabled)
+		}
+	default:
+		t.Error("no event recorded for ignored ProxyClass")
+	}
+}
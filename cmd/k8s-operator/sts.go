@@ -11,8 +11,10 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 
 	"go.uber.org/zap"
@@ -21,11 +23,13 @@
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apiserver/pkg/storage/names"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/ipn"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
 	"tailscale.com/types/opt"
@@ -53,12 +57,23 @@
 	// Annotations settable by users on ingresses.
 	AnnotationFunnel = "tailscale.com/funnel"
 
+	// Labels settable by users on services and ingresses.
+	// LabelProxyClass is the name of the ProxyClass to apply to the proxy.
+	LabelProxyClass = "tailscale.com/proxy-class"
+
 	// Annotations set by the operator on pods to trigger restarts when the
 	// hostname, IP or FQDN changes.
 	podAnnotationLastSetClusterIP         = "tailscale.com/operator-last-set-cluster-ip"
 	podAnnotationLastSetHostname          = "tailscale.com/operator-last-set-hostname"
 	podAnnotationLastSetTailnetTargetIP   = "tailscale.com/operator-last-set-ts-tailnet-target-ip"
 	podAnnotationLastSetTailnetTargetFQDN = "tailscale.com/operator-last-set-ts-tailnet-target-fqdn"
+
+	// Annotations set by the operator on StatefulSets to the comma-separated
+	// keys of the labels and annotations applied from a ProxyClass, so that
+	// they can be removed once the ProxyClass no longer sets them without
+	// removing those set by other tools.
+	annotationProxyClassLabels      = "tailscale.com/operator-proxy-class-labels"
+	annotationProxyClassAnnotations = "tailscale.com/operator-proxy-class-annotations"
 )
 
 type tailscaleSTSConfig struct {
@@ -82,6 +97,10 @@ type tailscaleSTSConfig struct {
 	// Routes is a list of CIDRs to pass via --advertise-routes flag
 	// Should only be set if this is config for subnetRouter
 	Routes string
+
+	// ProxyClass is the name of the ProxyClass to apply to the proxy's
+	// StatefulSet, if any. The ProxyClass must be ready.
+	ProxyClass string
 }
 
 type tailscaleSTSReconciler struct {
@@ -465,8 +484,138 @@ func (a *tailscaleSTSReconciler) reconcileSTS(ctx context.Context, logger *zap.S
 		"app": sts.ParentResourceUID,
 	}
 	ss.Spec.Template.Spec.PriorityClassName = a.proxyPriorityClassName
+	if sts.ProxyClass != "" {
+		pc := new(tsapi.ProxyClass)
+		if err := a.Get(ctx, types.NamespacedName{Name: sts.ProxyClass}, pc); err != nil {
+			return nil, fmt.Errorf("failed to get ProxyClass %s: %w", sts.ProxyClass, err)
+		}
+		applyProxyClassToStatefulSet(pc, &ss)
+	}
 	logger.Debugf("reconciling statefulset %s/%s", ss.GetNamespace(), ss.GetName())
-	return createOrUpdate(ctx, a.Client, a.operatorNamespace, &ss, func(s *appsv1.StatefulSet) { s.Spec = ss.Spec })
+	updateSS := func(s *appsv1.StatefulSet) {
+		s.Spec = ss.Spec
+		ownedLabels := strings.Split(s.Annotations[annotationProxyClassLabels], ",")
+		ownedAnnotations := append(strings.Split(s.Annotations[annotationProxyClassAnnotations], ","),
+			annotationProxyClassLabels, annotationProxyClassAnnotations)
+		s.Labels = updateOwnedMetadata(s.Labels, ss.Labels, ownedLabels)
+		s.Annotations = updateOwnedMetadata(s.Annotations, ss.Annotations, ownedAnnotations)
+	}
+	return createOrUpdate(ctx, a.Client, a.operatorNamespace, &ss, updateSS)
+}
+
+// applyProxyClassToStatefulSet applies the configuration in pc to ss. Labels
+// and annotations from pc are added to those set by the operator, but don't
+// replace them. Other fields in pc replace those set by the operator.
+func applyProxyClassToStatefulSet(pc *tsapi.ProxyClass, ss *appsv1.StatefulSet) {
+	wantsSS := pc.Spec.StatefulSet
+	if wantsSS == nil {
+		return
+	}
+	ss.Labels = mergeStringMaps(ss.Labels, wantsSS.Labels)
+	ss.Annotations = mergeStringMaps(ss.Annotations, wantsSS.Annotations)
+	if len(wantsSS.Labels) > 0 {
+		mak.Set(&ss.Annotations, annotationProxyClassLabels, joinKeys(wantsSS.Labels))
+	}
+	if len(wantsSS.Annotations) > 0 {
+		mak.Set(&ss.Annotations, annotationProxyClassAnnotations, joinKeys(wantsSS.Annotations))
+	}
+	wantsPod := wantsSS.Pod
+	if wantsPod == nil {
+		return
+	}
+	tmpl := &ss.Spec.Template
+	tmpl.Labels = mergeStringMaps(tmpl.Labels, wantsPod.Labels)
+	tmpl.Annotations = mergeStringMaps(tmpl.Annotations, wantsPod.Annotations)
+	if wantsPod.SecurityContext != nil {
+		tmpl.Spec.SecurityContext = wantsPod.SecurityContext
+	}
+	if len(wantsPod.ImagePullSecrets) > 0 {
+		tmpl.Spec.ImagePullSecrets = wantsPod.ImagePullSecrets
+	}
+	if len(wantsPod.NodeSelector) > 0 {
+		tmpl.Spec.NodeSelector = wantsPod.NodeSelector
+	}
+	if len(wantsPod.Tolerations) > 0 {
+		tmpl.Spec.Tolerations = wantsPod.Tolerations
+	}
+	for i := range tmpl.Spec.Containers {
+		if c := &tmpl.Spec.Containers[i]; c.Name == "tailscale" {
+			applyContainerConfig(wantsPod.TailscaleContainer, c)
+		}
+	}
+	for i := range tmpl.Spec.InitContainers {
+		if c := &tmpl.Spec.InitContainers[i]; c.Name == "sysctler" {
+			applyContainerConfig(wantsPod.TailscaleInitContainer, c)
+		}
+	}
+}
+
+// applyContainerConfig applies the configuration in wants, which may be nil,
+// to c. Fields which are unset in wants are left as configured by the
+// operator. Environment variables from wants replace those of the same name
+// set by the operator.
+func applyContainerConfig(wants *tsapi.Container, c *corev1.Container) {
+	if wants == nil {
+		return
+	}
+	if wants.Image != "" {
+		c.Image = wants.Image
+	}
+	if wants.ImagePullPolicy != "" {
+		c.ImagePullPolicy = wants.ImagePullPolicy
+	}
+	if wants.SecurityContext != nil {
+		c.SecurityContext = wants.SecurityContext
+	}
+	if r := wants.Resources; len(r.Limits) > 0 || len(r.Requests) > 0 || len(r.Claims) > 0 {
+		c.Resources = r
+	}
+	for _, e := range wants.Env {
+		ev := corev1.EnvVar{Name: string(e.Name), Value: e.Value}
+		if i := slices.IndexFunc(c.Env, func(v corev1.EnvVar) bool { return v.Name == ev.Name }); i >= 0 {
+			c.Env[i] = ev
+		} else {
+			c.Env = append(c.Env, ev)
+		}
+	}
+}
+
+// mergeStringMaps returns a new map with the entries of base, and those
+// entries of extra whose keys aren't in base.
+func mergeStringMaps(base, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return base
+	}
+	m := maps.Clone(extra)
+	maps.Copy(m, base)
+	return m
+}
+
+// joinKeys returns the sorted keys of m, separated by commas.
+func joinKeys(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return strings.Join(keys, ",")
+}
+
+// updateOwnedMetadata returns cur, the labels or annotations of an existing
+// object, updated to those in want. Of the keys in cur that aren't in want,
+// only those in owned, which were previously set by the operator, are
+// removed; the others were set by other tools, and are left alone.
+func updateOwnedMetadata(cur, want map[string]string, owned []string) map[string]string {
+	m := maps.Clone(cur)
+	for _, k := range owned {
+		if _, ok := want[k]; !ok {
+			delete(m, k)
+		}
+	}
+	for k, v := range want {
+		mak.Set(&m, k, v)
+	}
+	return m
 }
 
 // ptrObject is a type constraint for pointer types that implement
@@ -7,6 +7,11 @@
 
 import (
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 )
 
 // Test_statefulSetNameBase tests that parent name portion in a StatefulSet name
@@ -48,3 +53,52 @@ func Test_statefulSetNameBase(t *testing.T) {
 		})
 	}
 }
+
+func Test_applyContainerConfig(t *testing.T) {
+	defaults := func() *corev1.Container {
+		return &corev1.Container{
+			Image: "tailscale/tailscale",
+			Env: []corev1.EnvVar{
+				{Name: "TS_USERSPACE", Value: "false"},
+				{Name: "TS_AUTH_ONCE", Value: "true"},
+			},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1m")},
+			},
+		}
+	}
+
+	c := defaults()
+	applyContainerConfig(nil, c)
+	if diff := cmp.Diff(c, defaults()); diff != "" {
+		t.Errorf("nil config changed container (-got +want):\n%s", diff)
+	}
+
+	c = defaults()
+	applyContainerConfig(&tsapi.Container{
+		Env: []tsapi.Env{
+			{Name: "TS_USERSPACE", Value: "true"},
+			{Name: "TS_DEBUG_FIREWALL_MODE", Value: "nftables"},
+		},
+	}, c)
+	want := defaults()
+	want.Env = []corev1.EnvVar{
+		{Name: "TS_USERSPACE", Value: "true"},
+		{Name: "TS_AUTH_ONCE", Value: "true"},
+		{Name: "TS_DEBUG_FIREWALL_MODE", Value: "nftables"},
+	}
+	if diff := cmp.Diff(c, want); diff != "" {
+		t.Errorf("env override (-got +want):\n%s", diff)
+	}
+
+	c = defaults()
+	limits := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+	}
+	applyContainerConfig(&tsapi.Container{Resources: limits}, c)
+	want = defaults()
+	want.Resources = limits
+	if diff := cmp.Diff(c, want); diff != "" {
+		t.Errorf("resources override (-got +want):\n%s", diff)
+	}
+}
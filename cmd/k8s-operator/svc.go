@@ -29,6 +29,7 @@ type ServiceReconciler struct {
 	ssr                   *tailscaleSTSReconciler
 	logger                *zap.SugaredLogger
 	isDefaultLoadBalancer bool
+	proxyClassEnabled     bool // whether ENABLE_PROXY_CLASS is set
 
 	mu sync.Mutex // protects following
 
@@ -159,6 +160,15 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 	if err != nil {
 		return err
 	}
+	proxyClass := enabledProxyClass(a.proxyClassEnabled, a.recorder, svc, svc.Labels[LabelProxyClass])
+	if proxyClass != "" {
+		if ready, err := proxyClassIsReady(ctx, a.Client, proxyClass); err != nil {
+			return fmt.Errorf("error verifying ProxyClass for Service: %w", err)
+		} else if !ready {
+			logger.Infof("ProxyClass %s specified for the Service, but is not (yet) Ready, waiting..", proxyClass)
+			return nil
+		}
+	}
 
 	if !slices.Contains(svc.Finalizers, FinalizerName) {
 		// This log line is printed exactly once during initial provisioning,
@@ -183,6 +193,7 @@ func (a *ServiceReconciler) maybeProvision(ctx context.Context, logger *zap.Suga
 		Hostname:            hostname,
 		Tags:                tags,
 		ChildResourceLabels: crl,
+		ProxyClass:          proxyClass,
 	}
 
 	a.mu.Lock()
@@ -0,0 +1,291 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"sigs.k8s.io/yaml"
+	"tailscale.com/prober"
+)
+
+// config is the top-level structure of a probe configuration file, which
+// may be written in YAML or JSON.
+type config struct {
+	// Interval is the default interval for probes which do not specify
+	// their own.
+	Interval duration `json:"interval,omitempty"`
+	// Labels are added to the metrics of every probe.
+	Labels map[string]string `json:"labels,omitempty"`
+	Probes []probeConfig     `json:"probes"`
+	// Alerts, if set, configures notifications when probes fail.
+	Alerts *alertsConfig `json:"alerts,omitempty"`
+}
+
+// alertsConfig configures where to send alerts about failing probes.
+type alertsConfig struct {
+	// Failures is the number of consecutive failures of a probe after
+	// which an alert fires. If zero, 1 is used.
+	Failures int `json:"failures,omitempty"`
+	// Successes is the number of consecutive successes of a probe after
+	// which its alert is resolved. If zero, 1 is used.
+	Successes int `json:"successes,omitempty"`
+
+	// Webhooks receive each alert as a JSON object.
+	Webhooks []webhookConfig `json:"webhooks,omitempty"`
+	// Slack are the URLs of Slack-compatible incoming webhooks.
+	Slack []string `json:"slack,omitempty"`
+	// SMTP, if set, sends each alert as an email.
+	SMTP *smtpConfig `json:"smtp,omitempty"`
+}
+
+type webhookConfig struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type smtpConfig struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string   `json:"addr"`
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	// Username, if set, is used with the password in the environment
+	// variable named by PasswordEnv to authenticate to the server.
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+}
+
+// probeConfig describes a single probe.
+type probeConfig struct {
+	// Name uniquely identifies the probe, and is used as the value of
+	// its "name" metric label.
+	Name string `json:"name"`
+	// Type is the kind of probe: one of http, tcp, tls, dns, stun or icmp.
+	Type string `json:"type"`
+	// Target is what to probe: a URL for http probes, a host for icmp
+	// probes, and a host:port for all others.
+	Target string `json:"target"`
+	// Interval is how often to run the probe. If zero, the config's
+	// default interval is used.
+	Interval duration `json:"interval,omitempty"`
+	// Labels are added to the probe's metrics.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Want is text which must be present in the body of http probe
+	// responses.
+	Want string `json:"want,omitempty"`
+
+	// Query is the name to look up for dns probes.
+	Query string `json:"query,omitempty"`
+	// QueryType is the record type to look up for dns probes, such as
+	// "A" or "TXT". If empty, "A" is used.
+	QueryType string `json:"queryType,omitempty"`
+	// RCode is the expected response code for dns probes, such as
+	// "NOERROR" or "NXDOMAIN". If empty, "NOERROR" is used.
+	RCode string `json:"rcode,omitempty"`
+	// Answers must all be present in the response to dns probes.
+	Answers []string `json:"answers,omitempty"`
+}
+
+// duration is a time.Duration which is encoded as a string, such as "30s".
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// parseConfig parses and validates a probe configuration, filling in
+// default intervals and merging the config-wide labels into each probe.
+func parseConfig(b []byte, defaultInterval time.Duration) (*config, error) {
+	var c config
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return nil, err
+	}
+	if c.Interval == 0 {
+		c.Interval = duration(defaultInterval)
+	}
+
+	seen := make(map[string]bool)
+	for i := range c.Probes {
+		p := &c.Probes[i]
+		if p.Name == "" {
+			return nil, fmt.Errorf("probe %d: missing name", i)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("probe %q: duplicate name", p.Name)
+		}
+		seen[p.Name] = true
+		if p.Interval == 0 {
+			p.Interval = c.Interval
+		}
+		if p.Interval <= 0 {
+			return nil, fmt.Errorf("probe %q: interval must be positive", p.Name)
+		}
+		labels := make(map[string]string, len(c.Labels)+len(p.Labels))
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+		for k, v := range p.Labels {
+			labels[k] = v
+		}
+		if _, ok := labels["name"]; ok {
+			return nil, fmt.Errorf("probe %q: the \"name\" label is reserved", p.Name)
+		}
+		p.Labels = labels
+		if _, err := p.probeFunc(); err != nil {
+			return nil, fmt.Errorf("probe %q: %w", p.Name, err)
+		}
+	}
+	if c.Alerts != nil {
+		if _, err := c.Alerts.sinks(); err != nil {
+			return nil, fmt.Errorf("alerts: %w", err)
+		}
+	}
+	return &c, nil
+}
+
+// sinks returns the prober.AlertSinks described by the config.
+func (a *alertsConfig) sinks() ([]prober.AlertSink, error) {
+	if a.Failures < 0 || a.Successes < 0 {
+		return nil, errors.New("failures and successes must not be negative")
+	}
+	var sinks []prober.AlertSink
+	for _, w := range a.Webhooks {
+		if err := checkURL(w.URL); err != nil {
+			return nil, fmt.Errorf("webhook: %w", err)
+		}
+		h := make(http.Header)
+		for k, v := range w.Headers {
+			h.Set(k, v)
+		}
+		sinks = append(sinks, &prober.WebhookSink{URL: w.URL, Header: h})
+	}
+	for _, u := range a.Slack {
+		if err := checkURL(u); err != nil {
+			return nil, fmt.Errorf("slack: %w", err)
+		}
+		sinks = append(sinks, &prober.SlackSink{URL: u})
+	}
+	if m := a.SMTP; m != nil {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("smtp: addr must be host:port: %w", err)
+		}
+		if m.From == "" || len(m.To) == 0 {
+			return nil, errors.New("smtp: from and to are required")
+		}
+		s := &prober.SMTPSink{Addr: m.Addr, From: m.From, To: m.To}
+		if m.Username != "" {
+			s.Auth = smtp.PlainAuth("", m.Username, os.Getenv(m.PasswordEnv), host)
+		}
+		sinks = append(sinks, s)
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("no webhooks, slack or smtp configured")
+	}
+	return sinks, nil
+}
+
+func checkURL(s string) error {
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") {
+		return fmt.Errorf("%q is not an http:// or https:// URL", s)
+	}
+	return nil
+}
+
+// probeFunc returns the prober.ProbeFunc which implements the probe.
+func (p *probeConfig) probeFunc() (prober.ProbeFunc, error) {
+	if p.Target == "" {
+		return nil, errors.New("missing target")
+	}
+	needHostPort := func() error {
+		if _, _, err := net.SplitHostPort(p.Target); err != nil {
+			return fmt.Errorf("target must be host:port: %w", err)
+		}
+		return nil
+	}
+
+	switch p.Type {
+	case "http":
+		if err := checkURL(p.Target); err != nil {
+			return nil, fmt.Errorf("target: %w", err)
+		}
+		return prober.HTTP(p.Target, p.Want), nil
+	case "tcp":
+		if err := needHostPort(); err != nil {
+			return nil, err
+		}
+		return prober.TCP(p.Target), nil
+	case "tls":
+		if err := needHostPort(); err != nil {
+			return nil, err
+		}
+		return prober.TLS(p.Target), nil
+	case "stun":
+		if err := needHostPort(); err != nil {
+			return nil, err
+		}
+		return prober.STUN(p.Target), nil
+	case "icmp":
+		return prober.ICMP(p.Target), nil
+	case "dns":
+		if err := needHostPort(); err != nil {
+			return nil, err
+		}
+		if p.Query == "" {
+			return nil, errors.New("dns probes require a query")
+		}
+		qtype, ok := dnsTypes[strings.ToUpper(p.QueryType)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported queryType %q", p.QueryType)
+		}
+		rcode, ok := dnsRCodes[strings.ToUpper(p.RCode)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported rcode %q", p.RCode)
+		}
+		return prober.DNS(p.Target, p.Query, qtype, rcode, p.Answers...), nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %q", p.Type)
+	}
+}
+
+var dnsTypes = map[string]dnsmessage.Type{
+	"":      dnsmessage.TypeA,
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"SOA":   dnsmessage.TypeSOA,
+	"SRV":   dnsmessage.TypeSRV,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+var dnsRCodes = map[string]dnsmessage.RCode{
+	"":         dnsmessage.RCodeSuccess,
+	"NOERROR":  dnsmessage.RCodeSuccess,
+	"FORMERR":  dnsmessage.RCodeFormatError,
+	"SERVFAIL": dnsmessage.RCodeServerFailure,
+	"NXDOMAIN": dnsmessage.RCodeNameError,
+	"NOTIMP":   dnsmessage.RCodeNotImplemented,
+	"REFUSED":  dnsmessage.RCodeRefused,
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/prober"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			config: `
+interval: 1m
+labels: {env: prod}
+probes:
+  - name: www
+    type: http
+    target: https://example.com/
+    want: Example
+  - name: dns
+    type: dns
+    target: 127.0.0.1:53
+    query: example.com
+    queryType: aaaa
+    rcode: NXDOMAIN
+    interval: 5s
+`,
+		},
+		{
+			name:   "json",
+			config: `{"probes": [{"name": "stun", "type": "stun", "target": "127.0.0.1:3478"}]}`,
+		},
+		{
+			name:    "unknown_type",
+			config:  `{"probes": [{"name": "x", "type": "gopher", "target": "a:1"}]}`,
+			wantErr: "unknown probe type",
+		},
+		{
+			name:    "unknown_field",
+			config:  `{"probes": [{"name": "x", "type": "tcp", "target": "a:1", "bogus": 1}]}`,
+			wantErr: "bogus",
+		},
+		{
+			name:    "duplicate_name",
+			config:  `{"probes": [{"name": "x", "type": "tcp", "target": "a:1"}, {"name": "x", "type": "tcp", "target": "b:1"}]}`,
+			wantErr: "duplicate",
+		},
+		{
+			name:    "reserved_label",
+			config:  `{"probes": [{"name": "x", "type": "tcp", "target": "a:1", "labels": {"name": "y"}}]}`,
+			wantErr: "reserved",
+		},
+		{
+			name:    "tcp_without_port",
+			config:  `{"probes": [{"name": "x", "type": "tcp", "target": "a"}]}`,
+			wantErr: "host:port",
+		},
+		{
+			name: "alerts",
+			config: `
+probes: [{name: x, type: tcp, target: "a:1"}]
+alerts:
+  failures: 3
+  slack: [https://hooks.example.com/x]
+  smtp: {addr: "smtp.example.com:587", from: a@example.com, to: [b@example.com]}
+`,
+		},
+		{
+			name:    "alerts_without_sinks",
+			config:  `{"probes": [], "alerts": {"failures": 3}}`,
+			wantErr: "no webhooks",
+		},
+		{
+			name:    "alerts_bad_webhook",
+			config:  `{"probes": [], "alerts": {"webhooks": [{"url": "ftp://x"}]}}`,
+			wantErr: "webhook",
+		},
+		{
+			name:    "bad_rcode",
+			config:  `{"probes": [{"name": "x", "type": "dns", "target": "a:53", "query": "b", "rcode": "MAYBE"}]}`,
+			wantErr: "rcode",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseConfig([]byte(tt.config), time.Minute)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfig() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfig() failed: %v", err)
+			}
+			for _, p := range c.Probes {
+				if p.Interval == 0 {
+					t.Errorf("probe %q has no interval", p.Name)
+				}
+			}
+		})
+	}
+
+	c, err := parseConfig([]byte(tests[0].config), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := time.Duration(c.Probes[0].Interval), time.Minute; got != want {
+		t.Errorf("default interval = %v, want %v", got, want)
+	}
+	if got, want := time.Duration(c.Probes[1].Interval), 5*time.Second; got != want {
+		t.Errorf("probe interval = %v, want %v", got, want)
+	}
+	if got := c.Probes[1].Labels["env"]; got != "prod" {
+		t.Errorf("config-wide label env = %q, want %q", got, "prod")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "probes.yaml")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := &reloader{path: path, prober: prober.New(), probes: map[string]*runningProbe{}}
+	names := func() []string {
+		var out []string
+		for name := range r.prober.ProbeInfo() {
+			out = append(out, name)
+		}
+		return out
+	}
+
+	write(`
+probes:
+  - {name: a, type: tcp, target: "127.0.0.1:1", interval: 1h}
+  - {name: b, type: tcp, target: "127.0.0.1:2", interval: 1h}
+`)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload() failed: %v", err)
+	}
+	if got := len(names()); got != 2 {
+		t.Fatalf("running probes = %v, want 2", names())
+	}
+	b := r.probes["b"].probe
+
+	// An invalid config leaves the running probes alone.
+	write(`probes: [{name: a, type: nope}]`)
+	if err := r.reload(); err == nil {
+		t.Fatal("reload() of invalid config succeeded, want error")
+	}
+	if got := len(names()); got != 2 {
+		t.Fatalf("running probes after invalid config = %v, want 2", names())
+	}
+
+	write(`
+probes:
+  - {name: b, type: tcp, target: "127.0.0.1:2", interval: 1h}
+  - {name: c, type: tcp, target: "127.0.0.1:3", interval: 1h}
+`)
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload() failed: %v", err)
+	}
+	if _, ok := r.probes["a"]; ok {
+		t.Error("probe a was not removed")
+	}
+	if r.probes["b"].probe != b {
+		t.Error("unchanged probe b was restarted")
+	}
+	if _, ok := r.probes["c"]; !ok {
+		t.Error("probe c was not added")
+	}
+
+	// A config with a probe which can't be created, even though it
+	// parsed, is rejected without changing the running probes.
+	bad := &config{Probes: []probeConfig{{Name: "d", Type: "tcp", Target: "nope"}}}
+	if err := r.apply(bad); err == nil {
+		t.Fatal("apply() of unusable probe succeeded, want error")
+	}
+	if _, ok := r.probes["b"]; !ok || len(r.probes) != 2 {
+		t.Errorf("running probes after failed apply = %v, want b and c", names())
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The prober binary runs blackbox probes defined in a YAML or JSON file,
+// exporting their results as Prometheus metrics.
+//
+// The file is reloaded when it changes, or on SIGHUP. For example:
+//
+//	interval: 30s
+//	labels:
+//	  env: prod
+//	probes:
+//	  - name: www
+//	    type: http
+//	    target: https://example.com/
+//	    want: Example Domain
+//	  - name: resolver
+//	    type: dns
+//	    target: 192.0.2.53:53
+//	    query: example.com
+//	    answers: [192.0.2.1]
+//	    labels:
+//	      server: resolver1
+//
+// Probe types are http, tcp, tls, dns, stun and icmp. Metrics are served
+// at /metrics, and a summary of probe results at /.
+//
+// Alerts about failing probes can be sent to webhooks, Slack-compatible
+// webhooks and email, by adding an alerts section:
+//
+//	alerts:
+//	  failures: 3   # consecutive failures before alerting
+//	  successes: 2  # consecutive successes before resolving
+//	  slack: [https://hooks.slack.com/services/...]
+//	  webhooks:
+//	    - url: https://alerts.example.com/hook
+//	      headers: {Authorization: Bearer ...}
+//	  smtp:
+//	    addr: smtp.example.com:587
+//	    from: prober@example.com
+//	    to: [oncall@example.com]
+//	    username: prober
+//	    passwordEnv: SMTP_PASSWORD
+//
+// Changes to the alerts section take effect when the prober is restarted.
+//
+// With --tsnet-hostname, the prober joins the tailnet as its own node and
+// serves only there, using TS_AUTHKEY to log in if needed.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"tailscale.com/prober"
+	"tailscale.com/tsnet"
+	"tailscale.com/tsweb"
+	"tailscale.com/tsweb/promvarz"
+)
+
+var (
+	configPath     = flag.String("config", "", "path to the YAML or JSON file of probe definitions")
+	listen         = flag.String("listen", ":8030", "HTTP listen address; ignored with --tsnet-hostname")
+	interval       = flag.Duration("interval", 30*time.Second, "default probe interval, if the config does not specify one")
+	reloadInterval = flag.Duration("reload-interval", 10*time.Second, "how often to check the config file for changes")
+	spread         = flag.Bool("spread", true, "whether to spread probing over time")
+	tsnetHostname  = flag.String("tsnet-hostname", "", "if non-empty, join the tailnet with this hostname and serve only there")
+	tsnetDir       = flag.String("tsnet-dir", "", "directory for tsnet state; defaults to a directory under the user config directory")
+	alertInterval  = flag.Duration("alert-interval", 10*time.Second, "how often to check probe results for alerts to send")
+)
+
+func main() {
+	flag.Parse()
+	if *configPath == "" {
+		log.Fatal("--config is required")
+	}
+
+	p := prober.New().WithSpread(*spread)
+	r := &reloader{path: *configPath, prober: p, probes: map[string]*runningProbe{}}
+	if err := r.reload(); err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+	go r.watch()
+	if a := r.alerts(); a != nil {
+		sinks, err := a.sinks()
+		if err != nil {
+			log.Fatalf("configuring alerts: %v", err)
+		}
+		alerter := &prober.Alerter{
+			Prober:             p,
+			Sinks:              sinks,
+			FailuresToAlert:    a.Failures,
+			SuccessesToResolve: a.Successes,
+		}
+		go alerter.Run(context.Background(), *alertInterval)
+	}
+
+	mux := http.NewServeMux()
+	tsweb.Debugger(mux)
+	mux.HandleFunc("/metrics", promvarz.Handler)
+	mux.HandleFunc("/", serveStatus(p))
+
+	ln, err := listener()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("serving on %v", ln.Addr())
+	log.Fatal(http.Serve(ln, mux))
+}
+
+// listener returns the listener for the HTTP server: either on the
+// tailnet, or on the --listen address.
+func listener() (net.Listener, error) {
+	if *tsnetHostname == "" {
+		return net.Listen("tcp", *listen)
+	}
+	s := &tsnet.Server{
+		Hostname: *tsnetHostname,
+		Dir:      *tsnetDir,
+	}
+	if _, err := s.Up(context.Background()); err != nil {
+		return nil, fmt.Errorf("joining tailnet: %w", err)
+	}
+	return s.Listen("tcp", ":80")
+}
+
+// runningProbe is a probe started from a probeConfig.
+type runningProbe struct {
+	cfg   probeConfig
+	probe *prober.Probe
+}
+
+// reloader keeps the probes run by a Prober in sync with a config file.
+type reloader struct {
+	path   string
+	prober *prober.Prober
+
+	mu      sync.Mutex
+	last    []byte // contents of the config file when last loaded
+	lastBad []byte // contents of the config file when it last failed to load
+	cfg     *config
+	probes  map[string]*runningProbe
+}
+
+// watch reloads the config file whenever it changes, or on SIGHUP.
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t := time.NewTicker(*reloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-hup:
+			log.Printf("SIGHUP received, reloading config")
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("reloading config: %v; keeping previous probes", err)
+		}
+	}
+}
+
+// reload reads the config file and, if it has changed, starts, stops and
+// restarts probes to match it. If the config is invalid, the running
+// probes are left unchanged.
+func (r *reloader) reload() error {
+	b, err := os.ReadFile(r.path)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last != nil && string(b) == string(r.last) || r.lastBad != nil && string(b) == string(r.lastBad) {
+		// Unchanged, or already reported as invalid.
+		return nil
+	}
+	c, err := parseConfig(b, *interval)
+	if err != nil {
+		r.lastBad = b
+		return err
+	}
+	if err := r.apply(c); err != nil {
+		r.lastBad = b
+		return err
+	}
+	r.lastBad = nil
+	r.last = b
+	if r.cfg != nil && !reflect.DeepEqual(r.cfg.Alerts, c.Alerts) {
+		log.Printf("alerts configuration changed; restart the prober for it to take effect")
+	}
+	r.cfg = c
+	return nil
+}
+
+// alerts returns the alerts section of the loaded config, if any.
+func (r *reloader) alerts() *alertsConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cfg == nil {
+		return nil
+	}
+	return r.cfg.Alerts
+}
+
+// apply starts, stops and restarts probes to match c. If any probe of c
+// can't be created, it returns an error and leaves the running probes
+// unchanged. r.mu must be held.
+func (r *reloader) apply(c *config) error {
+	fns := make(map[string]prober.ProbeFunc)
+	for _, pc := range c.Probes {
+		if rp := r.probes[pc.Name]; rp != nil && reflect.DeepEqual(rp.cfg, pc) {
+			continue
+		}
+		fn, err := pc.probeFunc()
+		if err != nil {
+			return fmt.Errorf("probe %s: %w", pc.Name, err)
+		}
+		fns[pc.Name] = fn
+	}
+
+	want := make(map[string]bool, len(c.Probes))
+	for _, pc := range c.Probes {
+		want[pc.Name] = true
+	}
+	for name, rp := range r.probes {
+		if !want[name] {
+			log.Printf("removing probe %s", name)
+			rp.probe.Close()
+			delete(r.probes, name)
+		}
+	}
+	for _, pc := range c.Probes {
+		fn, ok := fns[pc.Name]
+		if !ok {
+			continue
+		}
+		if rp := r.probes[pc.Name]; rp != nil {
+			log.Printf("restarting probe %s with new config", pc.Name)
+			rp.probe.Close()
+		} else {
+			log.Printf("adding %s probe %s for %s", pc.Type, pc.Name, pc.Target)
+		}
+		r.probes[pc.Name] = &runningProbe{
+			cfg:   pc,
+			probe: r.prober.Run(pc.Name, time.Duration(pc.Interval), pc.Labels, fn),
+		}
+	}
+	return nil
+}
+
+func serveStatus(p *prober.Prober) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var good, bad []string
+		for name, i := range p.ProbeInfo() {
+			if i.End.IsZero() {
+				// Do not show probes that have not finished yet.
+				continue
+			}
+			if i.Result {
+				good = append(good, fmt.Sprintf("%s: %s", name, i.Latency))
+			} else {
+				bad = append(bad, fmt.Sprintf("%s: %s", name, i.Error))
+			}
+		}
+		sort.Strings(good)
+		sort.Strings(bad)
+
+		summary := "All good"
+		if len(bad) > 0 {
+			// Returning a 500 allows monitoring this server externally and configuring
+			// an alert on HTTP response code.
+			w.WriteHeader(http.StatusInternalServerError)
+			summary = fmt.Sprintf("%d problems", len(bad))
+		}
+		io.WriteString(w, "<html><head><style>.bad { font-weight: bold; color: #700; }</style></head>\n")
+		fmt.Fprintf(w, "<body><h1>prober</h1>\n%s:<ul>", summary)
+		for _, s := range bad {
+			fmt.Fprintf(w, "<li class=bad>%s</li>\n", html.EscapeString(s))
+		}
+		for _, s := range good {
+			fmt.Fprintf(w, "<li>%s</li>\n", html.EscapeString(s))
+		}
+		io.WriteString(w, "</ul></body></html>\n")
+	}
+}
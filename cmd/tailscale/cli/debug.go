@@ -170,6 +170,12 @@
 			Exec:      debugControlKnobs,
 			ShortHelp: "see current control knobs",
 		},
+		{
+			Name:       "dns-cache",
+			Exec:       debugDNSCache,
+			ShortUsage: "dns-cache [stats|flush]",
+			ShortHelp:  "print stats about, or flush, the MagicDNS resolver's response cache",
+		},
 		{
 			Name:      "prefs",
 			Exec:      runPrefs,
@@ -1002,6 +1008,33 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	return nil
 }
 
+func debugDNSCache(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("unexpected arguments")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "stats":
+		case "flush":
+			if err := localClient.DebugAction(ctx, "dns-cache-flush"); err != nil {
+				return err
+			}
+			printf("DNS cache flushed\n")
+			return nil
+		default:
+			return fmt.Errorf("unknown dns-cache action %q; want stats or flush", args[0])
+		}
+	}
+	v, err := localClient.DebugResultJSON(ctx, "dns-cache-stats")
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	e.Encode(v)
+	return nil
+}
+
 func debugControlKnobs(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected arguments")
@@ -6,10 +6,13 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"mime"
 	"net/http"
@@ -65,8 +68,8 @@ func (c *countingReader) Read(buf []byte) (int, error) {
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "file cp <files...> <target>:",
-	ShortHelp:  "Copy file(s) to a host",
+	ShortUsage: "file cp <files or directories...> <target>:",
+	ShortHelp:  "Copy file(s) or directories to a host",
 	Exec:       runCp,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("cp")
@@ -88,7 +91,7 @@ func runCp(ctx context.Context, args []string) error {
 		return runCpTargets(ctx, args)
 	}
 	if len(args) < 2 {
-		return errors.New("usage: tailscale file cp <files...> <target>:")
+		return errors.New("usage: tailscale file cp <files or directories...> <target>:")
 	}
 	files, target := args[:len(args)-1], args[len(args)-1]
 	target, ok := strings.CutSuffix(target, ":")
@@ -133,6 +136,7 @@ func runCp(ctx context.Context, args []string) error {
 		var fileContents *countingReader
 		var name = cpArgs.name
 		var contentLength int64 = -1
+		var sum string // hex SHA-256 of the file, if known
 		if fileArg == "-" {
 			fileContents = &countingReader{Reader: os.Stdin}
 			if name == "" {
@@ -155,9 +159,26 @@ func runCp(ctx context.Context, args []string) error {
 				return err
 			}
 			if fi.IsDir() {
-				return errors.New("directories not supported")
+				f.Close()
+				if name == "" {
+					abs, err := filepath.Abs(fileArg)
+					if err != nil {
+						return err
+					}
+					name = filepath.Base(abs)
+				}
+				if err := sendDir(ctx, stableID, fileArg, name); err != nil {
+					return err
+				}
+				continue
 			}
 			contentLength = fi.Size()
+			if sum, err = sha256Hex(f, contentLength); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
 			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
 			if name == "" {
 				name = filepath.Base(fileArg)
@@ -172,16 +193,9 @@ func runCp(ctx context.Context, args []string) error {
 			log.Printf("sending %q to %v/%v/%v ...", name, target, ip, stableID)
 		}
 
-		var group syncs.WaitGroup
-		ctxProgress, cancelProgress := context.WithCancel(ctx)
-		defer cancelProgress()
-		if isatty.IsTerminal(os.Stderr.Fd()) {
-			group.Go(func() { progressPrinter(ctxProgress, name, fileContents.n.Load, contentLength) })
-		}
-
-		err := localClient.PushFile(ctx, stableID, contentLength, name, fileContents)
-		cancelProgress()
-		group.Wait() // wait for progress printer to stop before reporting the error
+		err := withProgress(ctx, name, fileContents, contentLength, func() error {
+			return localClient.PushFileWithSHA256(ctx, stableID, contentLength, name, sum, fileContents)
+		})
 		if err != nil {
 			return err
 		}
@@ -192,6 +206,131 @@ func runCp(ctx context.Context, args []string) error {
 	return nil
 }
 
+// sendDir sends the files within the local directory dir to stableID, as
+// a directory called name with the same structure. Anything other than
+// regular files and directories, such as symlinks, is skipped.
+//
+// If an earlier attempt was interrupted, files which were sent in full
+// are not sent again, and partially sent files are resumed.
+func sendDir(ctx context.Context, stableID tailcfg.StableNodeID, dir, name string) error {
+	var manifest apitype.DirManifest
+	err := filepath.WalkDir(dir, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if de.IsDir() {
+			return nil
+		}
+		if !de.Type().IsRegular() {
+			fmt.Fprintf(Stderr, "# skipping %s: not a regular file\n", path)
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sum, err := sha256Hex(f, fi.Size())
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, apitype.DirFile{
+			Path:   filepath.ToSlash(rel),
+			Size:   fi.Size(),
+			SHA256: sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(manifest.Files) == 0 {
+		return fmt.Errorf("no files to send in %s", dir)
+	}
+
+	if cpArgs.verbose {
+		log.Printf("sending directory %q (%d files) ...", name, len(manifest.Files))
+	}
+	res, err := localClient.BeginPushDir(ctx, stableID, name, manifest)
+	if err != nil {
+		return fmt.Errorf("sending directory %q: %w", name, err)
+	}
+	done := make(map[string]bool, len(res.Done))
+	for _, p := range res.Done {
+		done[p] = true
+	}
+	for _, df := range manifest.Files {
+		if done[df.Path] {
+			if cpArgs.verbose {
+				log.Printf("%s/%s already sent", name, df.Path)
+			}
+			continue
+		}
+		if err := sendDirFile(ctx, stableID, dir, name, df); err != nil {
+			return fmt.Errorf("sending %s/%s: %w", name, df.Path, err)
+		}
+	}
+	if err := localClient.CommitPushDir(ctx, stableID, name); err != nil {
+		return fmt.Errorf("sending directory %q: %w", name, err)
+	}
+	if cpArgs.verbose {
+		log.Printf("sent directory %q", name)
+	}
+	return nil
+}
+
+// sendDirFile sends the file df, from the local directory dir, as part of
+// the directory dirName being sent by sendDir.
+func sendDirFile(ctx context.Context, stableID tailcfg.StableNodeID, dir, dirName string, df apitype.DirFile) error {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(df.Path)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	contents := &countingReader{Reader: io.LimitReader(f, df.Size)}
+	return withProgress(ctx, dirName+"/"+df.Path, contents, df.Size, func() error {
+		return localClient.PushDirFile(ctx, stableID, dirName, df.Path, df.Size, contents)
+	})
+}
+
+// sha256Hex returns the hex-encoded SHA-256 digest of the first size
+// bytes of r, which must be exactly that long.
+func sha256Hex(r io.Reader, size int64) (string, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, io.LimitReader(r, size))
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("file changed size while reading: read %d bytes, want %d", n, size)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// withProgress calls push, which reads contents, printing its progress to
+// stderr if stderr is a terminal.
+func withProgress(ctx context.Context, name string, contents *countingReader, contentLength int64, push func() error) error {
+	var group syncs.WaitGroup
+	ctxProgress, cancelProgress := context.WithCancel(ctx)
+	defer cancelProgress()
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		group.Go(func() { progressPrinter(ctxProgress, name, contents.n.Load, contentLength) })
+	}
+
+	err := push()
+	cancelProgress()
+	group.Wait() // wait for progress printer to stop before reporting the error
+	return err
+}
+
 func progressPrinter(ctx context.Context, name string, contentCount func() int64, contentLength int64) {
 	var rateValueFast, rateValueSlow tsrate.Value
 	rateValueFast.HalfLife = 1 * time.Second  // fast response for rate measurement
@@ -412,13 +551,14 @@ func (v *onConflict) Set(s string) error {
 
 var fileGetCmd = &ffcli.Command{
 	Name:       "get",
-	ShortUsage: "file get [--wait] [--verbose] [--conflict=(skip|overwrite|rename)] <target-directory>",
+	ShortUsage: "file get [--wait] [--accept] [--verbose] [--conflict=(skip|overwrite|rename)] <target-directory>",
 	ShortHelp:  "Move files out of the Tailscale file inbox",
 	Exec:       runFileGet,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("get")
 		fs.BoolVar(&getArgs.wait, "wait", false, "wait for a file to arrive if inbox is empty")
 		fs.BoolVar(&getArgs.loop, "loop", false, "run get in a loop, receiving files as they come in")
+		fs.BoolVar(&getArgs.accept, "accept", false, "accept files which are pending confirmation, if tailscaled requires incoming files to be confirmed, and get them too")
 		fs.BoolVar(&getArgs.verbose, "verbose", false, "verbose output")
 		fs.Var(&getArgs.conflict, "conflict", `behavior when a conflicting (same-named) file already exists in the target directory.
 	skip:       skip conflicting files: leave them in the taildrop inbox and print an error. get any non-conflicting files
@@ -431,6 +571,7 @@ func (v *onConflict) Set(s string) error {
 var getArgs = struct {
 	wait     bool
 	loop     bool
+	accept   bool
 	verbose  bool
 	conflict onConflict
 }{conflict: skipOnExist}
@@ -490,7 +631,19 @@ func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targe
 		return "", 0, fmt.Errorf("opening inbox file %q: %w", wf.Name, err)
 	}
 	defer rc.Close()
-	f, err := openFileOrSubstitute(dir, wf.Name, getArgs.conflict)
+	dstDir, base := dir, wf.Name
+	if i := strings.LastIndexByte(wf.Name, '/'); i >= 0 {
+		// A file within a received directory.
+		sub := filepath.FromSlash(wf.Name[:i])
+		if !filepath.IsLocal(sub) {
+			return "", 0, fmt.Errorf("invalid inbox file name %q", wf.Name)
+		}
+		dstDir, base = filepath.Join(dir, sub), wf.Name[i+1:]
+		if err := os.MkdirAll(dstDir, 0755); err != nil {
+			return "", 0, err
+		}
+	}
+	f, err := openFileOrSubstitute(dstDir, base, getArgs.conflict)
 	if err != nil {
 		return "", 0, err
 	}
@@ -498,12 +651,22 @@ func receiveFile(ctx context.Context, wf apitype.WaitingFile, dir string) (targe
 	if err := quarantine.SetOnFile(f); err != nil {
 		return "", 0, fmt.Errorf("failed to apply quarantine attribute to file %v: %v", f.Name(), err)
 	}
-	_, err = io.Copy(f, rc)
+	// Verify the file against the digest of its sender, if any, so that
+	// it is left in the inbox to try again if it was corrupted.
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), rc)
 	if err != nil {
 		f.Close()
 		return "", 0, fmt.Errorf("failed to write %v: %v", f.Name(), err)
 	}
-	return f.Name(), size, f.Close()
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); wf.SHA256 != "" && got != wf.SHA256 {
+		os.Remove(f.Name())
+		return "", 0, fmt.Errorf("inbox file %q has SHA-256 %s, want %s", wf.Name, got, wf.SHA256)
+	}
+	return f.Name(), size, nil
 }
 
 func runFileGetOneBatch(ctx context.Context, dir string) []error {
@@ -511,6 +674,12 @@ func runFileGetOneBatch(ctx context.Context, dir string) []error {
 	var err error
 	var errs []error
 	for len(errs) == 0 {
+		if getArgs.accept {
+			if err := acceptPendingFiles(ctx); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
 		wfs, err = localClient.WaitingFiles(ctx)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("getting WaitingFiles: %w", err))
@@ -541,7 +710,11 @@ func runFileGetOneBatch(ctx context.Context, dir string) []error {
 			continue
 		}
 		if getArgs.verbose {
-			printf("wrote %v as %v (%d bytes)\n", wf.Name, writtenFile, size)
+			if wf.SHA256 != "" {
+				printf("wrote %v as %v (%d bytes, sha256 %s)\n", wf.Name, writtenFile, size, wf.SHA256)
+			} else {
+				printf("wrote %v as %v (%d bytes)\n", wf.Name, writtenFile, size)
+			}
 		}
 		if err = localClient.DeleteWaitingFile(ctx, wf.Name); err != nil {
 			errs = append(errs, fmt.Errorf("deleting %q from inbox: %v", wf.Name, err))
@@ -603,10 +776,38 @@ func runFileGet(ctx context.Context, args []string) error {
 	return errs[len(errs)-1]
 }
 
+// acceptPendingFiles accepts all the files pending confirmation, so that
+// they are waiting in the inbox.
+func acceptPendingFiles(ctx context.Context) error {
+	pfs, err := localClient.PendingFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("getting PendingFiles: %w", err)
+	}
+	for _, pf := range pfs {
+		name, err := localClient.AcceptPendingFile(ctx, pf.Name)
+		if err != nil {
+			return fmt.Errorf("accepting %q: %w", pf.Name, err)
+		}
+		if getArgs.verbose {
+			from := pf.Sender
+			if from == "" {
+				from = "unknown sender"
+			}
+			printf("accepted %v from %v (%d bytes)\n", name, from, pf.Size)
+		}
+	}
+	return nil
+}
+
 func wipeInbox(ctx context.Context) error {
 	if getArgs.wait {
 		return errors.New("can't use --wait with /dev/null target")
 	}
+	if getArgs.accept {
+		if err := acceptPendingFiles(ctx); err != nil {
+			return err
+		}
+	}
 	wfs, err := localClient.WaitingFiles(ctx)
 	if err != nil {
 		return fmt.Errorf("getting WaitingFiles: %w", err)
@@ -627,9 +828,24 @@ func wipeInbox(ctx context.Context) error {
 	return nil
 }
 
+// waitForFile waits for a file to be waiting in the inbox or, with
+// --accept, to be pending confirmation.
 func waitForFile(ctx context.Context) error {
+	if !getArgs.accept {
+		return awaitFiles(ctx, localClient.AwaitWaitingFiles)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, 2)
+	go func() { errc <- awaitFiles(ctx, localClient.AwaitWaitingFiles) }()
+	go func() { errc <- awaitFiles(ctx, localClient.AwaitPendingFiles) }()
+	return <-errc
+}
+
+// awaitFiles calls await until it returns any files, or ctx is done.
+func awaitFiles[T any](ctx context.Context, await func(context.Context, time.Duration) ([]T, error)) error {
 	for {
-		ff, err := localClient.AwaitWaitingFiles(ctx, time.Hour)
+		ff, err := await(ctx, time.Hour)
 		if len(ff) > 0 {
 			return nil
 		}
@@ -40,8 +40,10 @@
 		nlDisableCmd,
 		nlDisablementKDFCmd,
 		nlLogCmd,
+		nlExportCmd,
 		nlLocalDisableCmd,
 		nlRevokeKeysCmd,
+		nlProposeCmd,
 	},
 	Exec: runNetworkLockNoSubcommand,
 }
@@ -235,7 +237,13 @@ func runNetworkLockStatus(ctx context.Context, args []string) error {
 		for _, k := range st.TrustedKeys {
 			var line strings.Builder
 			line.WriteString("\t")
-			line.WriteString(k.Key.CLIString())
+			if len(k.Public) > 0 {
+				line.WriteString(k.Kind)
+				line.WriteString(":")
+				line.WriteString(hex.EncodeToString(k.Public))
+			} else {
+				line.WriteString(k.Key.CLIString())
+			}
 			line.WriteString("\t")
 			line.WriteString(fmt.Sprint(k.Votes))
 			line.WriteString("\t")
@@ -253,6 +261,9 @@ func runNetworkLockStatus(ctx context.Context, args []string) error {
 			}
 			fmt.Println(line.String())
 		}
+		if st.KeyChangeThreshold > 1 {
+			fmt.Printf("Changes to trusted keys require signatures from %d of %d keys.\n", st.KeyChangeThreshold, len(st.TrustedKeys))
+		}
 	}
 
 	if st.Enabled && len(st.FilteredPeers) > 0 {
@@ -388,17 +399,11 @@ func parseNLArgs(args []string, parseKeys, parseDisablements bool) (keys []tka.K
 			return nil, nil, fmt.Errorf("parsing argument %d: expected value with \"disablement:\" or \"disablement-secret:\" prefix, got %q", i+1, a)
 		}
 
-		var nlpk key.NLPublic
 		spl := strings.SplitN(a, "?", 2)
-		if err := nlpk.UnmarshalText([]byte(spl[0])); err != nil {
+		k, err := parseNLKey(spl[0])
+		if err != nil {
 			return nil, nil, fmt.Errorf("parsing key %d: %v", i+1, err)
 		}
-
-		k := tka.Key{
-			Kind:   tka.Key25519,
-			Public: nlpk.Verifier(),
-			Votes:  1,
-		}
 		if len(spl) > 1 {
 			votes, err := strconv.Atoi(spl[1])
 			if err != nil {
@@ -411,6 +416,33 @@ func parseNLArgs(args []string, parseKeys, parseDisablements bool) (keys []tka.K
 	return keys, disablements, nil
 }
 
+// parseNLKey parses a tailnet lock key, which is either a tailnet lock public
+// key (tlpub:<hex>) or an ECDSA P-256 public key as a hex-encoded uncompressed
+// point (p256:<hex>).
+func parseNLKey(s string) (tka.Key, error) {
+	if v, ok := strings.CutPrefix(s, "p256:"); ok {
+		pub, err := hex.DecodeString(v)
+		if err != nil {
+			return tka.Key{}, err
+		}
+		k := tka.Key{Kind: tka.KeyP256, Public: pub, Votes: 1}
+		if _, err := k.ECDSA(); err != nil {
+			return tka.Key{}, err
+		}
+		return k, nil
+	}
+
+	var nlpk key.NLPublic
+	if err := nlpk.UnmarshalText([]byte(s)); err != nil {
+		return tka.Key{}, err
+	}
+	return tka.Key{
+		Kind:   tka.Key25519,
+		Public: nlpk.Verifier(),
+		Votes:  1,
+	}, nil
+}
+
 func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) error {
 	st, err := localClient.NetworkLockStatus(ctx)
 	if err != nil {
@@ -661,6 +693,30 @@ func runNetworkLockLog(ctx context.Context, args []string) error {
 	return nil
 }
 
+var nlExportCmd = &ffcli.Command{
+	Name:       "export",
+	ShortUsage: "export > <file>",
+	ShortHelp:  "Export all changes applied to tailnet lock",
+	LongHelp: `Write every change (AUM) known to tailnet lock on this node to standard
+output, as JSON lines.
+
+The export can be verified offline, without access to this node, by the
+tkaverify command.`,
+	Exec: runNetworkLockExport,
+}
+
+func runNetworkLockExport(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	export, err := localClient.NetworkLockExport(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	_, err = os.Stdout.Write(export)
+	return err
+}
+
 func runTskeyWrapCmd(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: lock tskey-wrap <tailscale pre-auth key>")
@@ -824,3 +880,169 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 
 	return nil
 }
+
+var nlProposeArgs struct {
+	add       string
+	remove    string
+	threshold int
+	cosign    bool
+	finish    bool
+	signerKey string
+	signerCmd string
+	signerArg signerArgs
+}
+
+// signerArgs is a flag.Value which collects each use of a repeated flag.
+type signerArgs []string
+
+func (v *signerArgs) String() string { return strings.Join(*v, " ") }
+
+func (v *signerArgs) Set(s string) error {
+	*v = append(*v, s)
+	return nil
+}
+
+var nlProposeCmd = &ffcli.Command{
+	Name:       "propose",
+	ShortUsage: "propose [--add=<tailnet-lock-key>,...] [--remove=<tailnet-lock-key>,...] [--threshold=<n>]\n  propose [--cosign] [--finish] <proposal-blob>",
+	ShortHelp:  "Propose a change to the trusted tailnet-lock keys",
+	LongHelp: `Propose adding or removing trusted tailnet lock keys (tlpub:abc), or changing
+the number of trusted keys which must sign such changes.
+
+When the threshold is greater than one, changes to the trusted keys must be
+` + "`--cosign`" + `ed by several signing nodes before they take effect.
+
+1. To start, run ` + "`tailscale lock propose`" + ` with the keys to add or remove, and/or a new ` + "`--threshold`" + `.
+2. Re-run the ` + "`--cosign`" + ` command output by ` + "`propose`" + ` on other signing nodes. Use the
+   most recent command output on the next signing node in sequence.
+3. Once the proposal has been signed by as many keys as the current threshold,
+   run the command one final time with ` + "`--finish`" + ` instead of ` + "`--cosign`" + `.
+
+The proposal must be finished before any other change is made to tailnet lock.
+
+Keys held outside of tailscaled, such as in a hardware security module, can
+` + "`--cosign`" + ` by passing ` + "`--signer-key`" + ` and ` + "`--signer-cmd`" + `. The signer command is run
+directly, not through a shell, with any arguments given by repeating
+` + "`--signer-arg`" + `. It is given the hex-encoded digest to sign on its standard
+input, and must write the hex-encoded signature to its standard output.`,
+	Exec: runNetworkLockPropose,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock propose")
+		fs.StringVar(&nlProposeArgs.add, "add", "", "comma-separated tailnet lock keys to trust")
+		fs.StringVar(&nlProposeArgs.remove, "remove", "", "comma-separated tailnet lock keys to stop trusting")
+		fs.IntVar(&nlProposeArgs.threshold, "threshold", -1, "number of trusted keys which must sign changes to trusted keys; negative to leave unchanged")
+		fs.BoolVar(&nlProposeArgs.cosign, "cosign", false, "co-sign the provided proposal blob using the tailnet lock key on this device")
+		fs.BoolVar(&nlProposeArgs.finish, "finish", false, "finish the proposal by transmitting it")
+		fs.StringVar(&nlProposeArgs.signerKey, "signer-key", "", "with --cosign, the key (tlpub:<hex> or p256:<hex>) used by --signer-cmd")
+		fs.StringVar(&nlProposeArgs.signerCmd, "signer-cmd", "", "with --cosign, the path of a program which signs using --signer-key, instead of the tailnet lock key on this device")
+		fs.Var(&nlProposeArgs.signerArg, "signer-arg", "an argument to pass to --signer-cmd; may be repeated")
+		return fs
+	})(),
+}
+
+func runNetworkLockPropose(ctx context.Context, args []string) error {
+	// First step in the process
+	if !nlProposeArgs.cosign && !nlProposeArgs.finish {
+		if len(args) > 0 {
+			return errors.New("unexpected arguments; use --add and --remove to specify keys")
+		}
+		addKeys, _, err := parseNLArgs(splitNonEmpty(nlProposeArgs.add), true, false)
+		if err != nil {
+			return err
+		}
+		removeKeys, _, err := parseNLArgs(splitNonEmpty(nlProposeArgs.remove), true, false)
+		if err != nil {
+			return err
+		}
+		if len(addKeys) == 0 && len(removeKeys) == 0 && nlProposeArgs.threshold < 0 {
+			return errors.New("nothing to propose: specify --add, --remove or --threshold")
+		}
+
+		aumBytes, err := localClient.NetworkLockGenProposal(ctx, addKeys, removeKeys, nlProposeArgs.threshold)
+		if err != nil {
+			return fmt.Errorf("generation of proposal failed: %w", err)
+		}
+
+		fmt.Printf(`Run the following command on another machine with a trusted tailnet lock key:
+	%s lock propose --cosign %X
+
+If this change needs only one signature, complete it by running the following command:
+	%s lock propose --finish %X
+`, os.Args[0], aumBytes, os.Args[0], aumBytes)
+		return nil
+	}
+
+	if len(args) != 1 {
+		return errors.New("expected a single proposal blob")
+	}
+	// If we got this far, we need to co-sign the AUM and/or transmit it for distribution.
+	b, err := hex.DecodeString(args[0])
+	if err != nil {
+		return fmt.Errorf("parsing hex: %v", err)
+	}
+	var proposal tka.AUM
+	if err := proposal.Unserialize(b); err != nil {
+		return fmt.Errorf("decoding proposal: %v", err)
+	}
+
+	if (nlProposeArgs.signerKey == "") != (nlProposeArgs.signerCmd == "") {
+		return errors.New("--signer-key and --signer-cmd must be used together")
+	}
+	if nlProposeArgs.signerCmd != "" && !nlProposeArgs.cosign {
+		return errors.New("--signer-cmd can only be used with --cosign")
+	}
+	if len(nlProposeArgs.signerArg) > 0 && nlProposeArgs.signerCmd == "" {
+		return errors.New("--signer-arg can only be used with --signer-cmd")
+	}
+
+	if nlProposeArgs.cosign {
+		var aumBytes []byte
+		if nlProposeArgs.signerCmd != "" {
+			k, err := parseNLKey(nlProposeArgs.signerKey)
+			if err != nil {
+				return fmt.Errorf("parsing --signer-key: %v", err)
+			}
+			signer := &tka.ExecSigner{Key: k, Command: nlProposeArgs.signerCmd, Args: nlProposeArgs.signerArg}
+			if err := proposal.Sign(signer); err != nil {
+				return fmt.Errorf("co-signing proposal failed: %w", err)
+			}
+			aumBytes = proposal.Serialize()
+		} else {
+			aumBytes, err = localClient.NetworkLockCosignProposal(ctx, proposal)
+			if err != nil {
+				return fmt.Errorf("co-signing proposal failed: %w", err)
+			}
+			if err := proposal.Unserialize(aumBytes); err != nil {
+				return fmt.Errorf("decoding co-signed proposal: %v", err)
+			}
+		}
+
+		fmt.Printf(`Co-signing completed successfully.
+
+To accumulate an additional signature, run the following command on another machine with a trusted tailnet lock key:
+	%s lock propose --cosign %X
+
+Alternatively if you are done with co-signing, complete the change by running the following command:
+	%s lock propose --finish %X
+`, os.Args[0], aumBytes, os.Args[0], aumBytes)
+	}
+
+	if nlProposeArgs.finish {
+		if err := localClient.NetworkLockSubmitProposal(ctx, proposal); err != nil {
+			return fmt.Errorf("submitting proposal failed: %w", err)
+		}
+		fmt.Println("Proposal applied.")
+	}
+	return nil
+}
+
+// splitNonEmpty splits a comma-separated list, ignoring empty elements.
+func splitNonEmpty(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -13,6 +13,7 @@
 	"log"
 	"math"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
@@ -41,9 +42,15 @@ type commandInfo struct {
 }
 
 var serveHelpCommon = strings.TrimSpace(`
-<target> can be a file, directory, text, or most commonly the location to a service running on the
-local machine. The location to the location service can be expressed as a port number (e.g., 3000),
-a partial URL (e.g., localhost:3000), or a full URL including a path (e.g., http://localhost:3000/foo).
+<target> can be a file, directory, text, redirect, status code, or most commonly the location to a
+service running on the local machine. The location to the location service can be expressed as a port
+number (e.g., 3000), a partial URL (e.g., localhost:3000), or a full URL including a path
+(e.g., http://localhost:3000/foo).
+
+A redirect is expressed as redirect:[CODE:]URL, where CODE is one of 301, 302 (the default), 303, 307
+or 308. Unless URL contains one of the variables ${HOST}, ${PATH}, ${QUERY} or ${REQUEST_URI}, the
+request path below the mount point and the query are appended to it. A bare status response is
+expressed as status:CODE[:TEXT].
 
 EXAMPLES
   - Expose an HTTP server running at 127.0.0.1:3000 in the foreground:
@@ -55,6 +62,12 @@ type commandInfo struct {
   - Expose an HTTPS server with invalid or self-signed certificates at https://localhost:8443
     $ tailscale %[1]s https+insecure://localhost:8443
 
+  - Expose a DNS server running at 127.0.0.1:5353 on UDP port 53
+    $ tailscale serve --bg --udp=53 5353
+
+  - Permanently redirect requests under /old to a new location, keeping the rest of the path
+    $ tailscale %[1]s --set-path /old redirect:301:https://new.example.com
+
 For more examples and use cases visit our docs site https://tailscale.com/kb/1247/funnel-serve-use-cases
 `)
 
@@ -72,6 +85,7 @@ type commandInfo struct {
 	serveTypeHTTP
 	serveTypeTCP
 	serveTypeTLSTerminatedTCP
+	serveTypeUDP
 )
 
 var infoMap = map[serveMode]commandInfo{
@@ -127,6 +141,9 @@ func newServeV2Command(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			}
 			fs.UintVar(&e.tcp, "tcp", 0, "Expose a TCP forwarder to forward raw TCP packets at the specified port")
 			fs.UintVar(&e.tlsTerminatedTCP, "tls-terminated-tcp", 0, "Expose a TCP forwarder to forward TLS-terminated TCP packets at the specified port")
+			if subcmd == serve {
+				fs.UintVar(&e.udp, "udp", 0, "Expose a UDP forwarder to forward UDP datagrams at the specified port")
+			}
 			fs.BoolVar(&e.yes, "yes", false, "Update without interactive prompts (default false)")
 		}),
 		UsageFunc: usageFuncNoDefaultValues,
@@ -334,7 +351,7 @@ func (e *serveEnv) runServeCombined(subcmd serveMode) execFunc {
 const backgroundExistsMsg = "background configuration already exists, use `tailscale %s --%s=%d off` to remove the existing configuration"
 
 func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe serveType) error {
-	sc, isFg := findConfig(sc, port)
+	sc, isFg := findConfig(sc, port, wantServe)
 	if sc == nil {
 		return nil
 	}
@@ -344,6 +361,10 @@ func (e *serveEnv) validateConfig(sc *ipn.ServeConfig, port uint16, wantServe se
 	if !e.bg {
 		return fmt.Errorf(backgroundExistsMsg, infoMap[e.subcmd].Name, wantServe.String(), port)
 	}
+	if wantServe == serveTypeUDP {
+		// UDP ports are only ever used for UDP forwarding.
+		return nil
+	}
 	existingServe := serveFromPortHandler(sc.TCP[port])
 	if wantServe != existingServe {
 		return fmt.Errorf("want %q but port is already serving %q", wantServe, existingServe)
@@ -367,17 +388,26 @@ func serveFromPortHandler(tcp *ipn.TCPPortHandler) serveType {
 }
 
 // findConfig finds a config that contains the given port, which can be
-// the top level background config or an inner foreground one. The second
+// the top level background config or an inner foreground one. The port is
+// a UDP port if srvType is serveTypeUDP, and a TCP port otherwise. The second
 // result is true if it's foreground
-func findConfig(sc *ipn.ServeConfig, port uint16) (*ipn.ServeConfig, bool) {
+func findConfig(sc *ipn.ServeConfig, port uint16, srvType serveType) (*ipn.ServeConfig, bool) {
 	if sc == nil {
 		return nil, false
 	}
-	if _, ok := sc.TCP[port]; ok {
+	hasPort := func(sc *ipn.ServeConfig) bool {
+		if srvType == serveTypeUDP {
+			_, ok := sc.UDP[port]
+			return ok
+		}
+		_, ok := sc.TCP[port]
+		return ok
+	}
+	if hasPort(sc) {
 		return sc, false
 	}
 	for _, sc := range sc.Foreground {
-		if _, ok := sc.TCP[port]; ok {
+		if hasPort(sc) {
 			return sc, true
 		}
 	}
@@ -402,6 +432,19 @@ func (e *serveEnv) setServe(sc *ipn.ServeConfig, st *ipnstate.Status, dnsName st
 		if err != nil {
 			return fmt.Errorf("failed to apply TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		if e.setPath != "" {
+			return fmt.Errorf("cannot mount a path for UDP serve")
+		}
+		if allowFunnel {
+			return errors.New("funnel does not support UDP")
+		}
+		if err := e.applyUDPServe(sc, srvPort, target); err != nil {
+			return fmt.Errorf("failed to apply UDP serve: %w", err)
+		}
+		// Funnel is keyed by host:port, which would apply to the TCP
+		// port of the same number.
+		return nil
 	default:
 		return fmt.Errorf("invalid type %q", srvType)
 	}
@@ -427,7 +470,7 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 
 	hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
 
-	if sc.AllowFunnel[hp] == true {
+	if sc.AllowFunnel[hp] == true && srvType != serveTypeUDP {
 		output.WriteString(msgFunnelAvailable)
 	} else {
 		output.WriteString(msgServeAvailable)
@@ -453,11 +496,26 @@ func (e *serveEnv) messageForPort(sc *ipn.ServeConfig, st *ipnstate.Status, dnsN
 			return "proxy", h.Proxy
 		case h.Text != "":
 			return "text", "\"" + elipticallyTruncate(h.Text, 20) + "\""
+		case h.Redirect != "":
+			code := h.Status
+			if code == 0 {
+				code = http.StatusFound
+			}
+			return "redirect", fmt.Sprintf("%d %s", code, h.Redirect)
+		case h.Status != 0:
+			return "status", strconv.Itoa(h.Status)
 		}
 		return "", ""
 	}
 
-	if sc.Web[hp] != nil {
+	if h := sc.UDP[srvPort]; srvType == serveTypeUDP && h != nil {
+		output.WriteString(fmt.Sprintf("udp://%s\n", hp))
+		for _, a := range st.TailscaleIPs {
+			ipp := net.JoinHostPort(a.String(), strconv.Itoa(int(srvPort)))
+			output.WriteString(fmt.Sprintf("|-- udp://%s\n", ipp))
+		}
+		output.WriteString(fmt.Sprintf("|--> udp://%s\n", h.UDPForward))
+	} else if sc.Web[hp] != nil {
 		var mounts []string
 
 		for k := range sc.Web[hp].Handlers {
@@ -515,6 +573,22 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			return errors.New("unable to serve; text cannot be an empty string")
 		}
 		h.Text = text
+	case strings.HasPrefix(target, "redirect:"):
+		code, u, err := parseRedirectTarget(strings.TrimPrefix(target, "redirect:"))
+		if err != nil {
+			return err
+		}
+		h.Redirect = u
+		h.Status = code
+	case strings.HasPrefix(target, "status:"):
+		codeStr, text, _ := strings.Cut(strings.TrimPrefix(target, "status:"), ":")
+		code, err := strconv.Atoi(codeStr)
+		// 1xx codes are informational and can't be a final response.
+		if err != nil || code < 200 || code > 599 {
+			return fmt.Errorf("invalid status code %q; must be between 200 and 599", codeStr)
+		}
+		h.Status = code
+		h.Text = text
 	case filepath.IsAbs(target):
 		if version.IsSandboxedMacOS() {
 			// don't allow path serving for now on macOS (2022-11-15)
@@ -609,6 +683,21 @@ func (e *serveEnv) applyTCPServe(sc *ipn.ServeConfig, dnsName string, srcType se
 	return nil
 }
 
+func (e *serveEnv) applyUDPServe(sc *ipn.ServeConfig, srcPort uint16, target string) error {
+	targetURL, err := expandProxyTargetDev(target, []string{"udp"}, "udp")
+	if err != nil {
+		return fmt.Errorf("unable to expand target: %v", err)
+	}
+
+	dstURL, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("invalid UDP target %q: %v", target, err)
+	}
+
+	mak.Set(&sc.UDP, srcPort, &ipn.UDPPortHandler{UDPForward: dstURL.Host})
+	return nil
+}
+
 func (e *serveEnv) applyFunnel(sc *ipn.ServeConfig, dnsName string, srvPort uint16, allowFunnel bool) {
 	hp := ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
 
@@ -641,6 +730,10 @@ func (e *serveEnv) unsetServe(sc *ipn.ServeConfig, dnsName string, srvType serve
 		if err != nil {
 			return fmt.Errorf("failed to remove TCP serve: %w", err)
 		}
+	case serveTypeUDP:
+		if err := e.removeUDPServe(sc, srvPort); err != nil {
+			return fmt.Errorf("failed to remove UDP serve: %w", err)
+		}
 	default:
 		return fmt.Errorf("invalid type %q", srvType)
 	}
@@ -656,6 +749,7 @@ func srvTypeAndPortFromFlags(e *serveEnv) (srvType serveType, srvPort uint16, er
 		serveTypeHTTPS:            e.https,
 		serveTypeTCP:              e.tcp,
 		serveTypeTLSTerminatedTCP: e.tlsTerminatedTCP,
+		serveTypeUDP:              e.udp,
 	}
 
 	var srcTypeCount int
@@ -865,6 +959,40 @@ func (e *serveEnv) removeTCPServe(sc *ipn.ServeConfig, src uint16) error {
 	return nil
 }
 
+// removeUDPServe removes the UDP forwarding configuration for the
+// given srvPort, or serving port.
+func (e *serveEnv) removeUDPServe(sc *ipn.ServeConfig, src uint16) error {
+	if sc == nil {
+		return nil
+	}
+	if _, ok := sc.UDP[src]; !ok {
+		return errors.New("error: serve config does not exist")
+	}
+	delete(sc.UDP, src)
+	// clear map mostly for testing
+	if len(sc.UDP) == 0 {
+		sc.UDP = nil
+	}
+	return nil
+}
+
+// parseRedirectTarget parses the part of a "redirect:[CODE:]URL" serve target
+// after the "redirect:" prefix. The returned code is zero if none was given.
+func parseRedirectTarget(s string) (code int, target string, err error) {
+	if codeStr, rest, ok := strings.Cut(s, ":"); ok {
+		if c, err := strconv.Atoi(codeStr); err == nil {
+			if !ipn.IsRedirectStatus(c) {
+				return 0, "", fmt.Errorf("invalid redirect status code %d; must be one of 301, 302, 303, 307 or 308", c)
+			}
+			code, s = c, rest
+		}
+	}
+	if !strings.HasPrefix(s, "http://") && !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "/") {
+		return 0, "", fmt.Errorf("invalid redirect URL %q; must start with http://, https:// or /", s)
+	}
+	return code, s, nil
+}
+
 // expandProxyTargetDev expands the supported target values to be proxied
 // allowing for input values to be a port number, a partial URL, or a full URL
 // including a path.
@@ -948,6 +1076,8 @@ func (s serveType) String() string {
 		return "tcp"
 	case serveTypeTLSTerminatedTCP:
 		return "tls-terminated-tcp"
+	case serveTypeUDP:
+		return "udp"
 	default:
 		return "unknownServeType"
 	}
@@ -482,6 +482,65 @@ type group struct {
 				},
 			},
 		},
+		{
+			name: "udp",
+			steps: []step{
+				{
+					command: cmd("serve --udp=53 --bg 5353"),
+					want: &ipn.ServeConfig{
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							53: {UDPForward: "127.0.0.1:5353"},
+						},
+					},
+				},
+				{
+					command: cmd("serve --udp=5000 --bg udp://localhost:5001"),
+					want: &ipn.ServeConfig{
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							53:   {UDPForward: "127.0.0.1:5353"},
+							5000: {UDPForward: "127.0.0.1:5001"},
+						},
+					},
+				},
+				{ // handler doesn't exist
+					command: cmd("serve --udp=54 off"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --udp=53 off"),
+					want: &ipn.ServeConfig{
+						UDP: map[uint16]*ipn.UDPPortHandler{
+							5000: {UDPForward: "127.0.0.1:5001"},
+						},
+					},
+				},
+				{
+					command: cmd("serve --udp=5000 off"),
+					want:    &ipn.ServeConfig{},
+				},
+			},
+		},
+		{
+			name: "udp_invalid_targets",
+			steps: []step{
+				{
+					command: cmd("serve --udp=53 --bg udp://somehost:5353"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --udp=53 --bg tcp://localhost:5353"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --udp=53 --bg --set-path=/foo 5353"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("funnel --udp=53 --bg 5353"),
+					wantErr: anyErr(),
+				},
+			},
+		},
 		{
 			name: "text",
 			steps: []step{{
@@ -496,6 +555,88 @@ type group struct {
 				},
 			}},
 		},
+		{
+			name: "redirect",
+			steps: []step{
+				{
+					command: cmd("serve --https=443 --bg --set-path=/old redirect:https://new.example.com"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/old": {Redirect: "https://new.example.com"},
+							}},
+						},
+					},
+				},
+				{
+					command: cmd("serve --https=443 --bg --set-path=/old redirect:308:https://${HOST}:8443${REQUEST_URI}"),
+					want: &ipn.ServeConfig{
+						TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+						Web: map[ipn.HostPort]*ipn.WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+								"/old": {Redirect: "https://${HOST}:8443${REQUEST_URI}", Status: 308},
+							}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "redirect_bad_code",
+			steps: []step{{
+				command: cmd("serve --https=443 --bg redirect:200:https://new.example.com"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "redirect_bad_url",
+			steps: []step{{
+				command: cmd("serve --https=443 --bg redirect:new.example.com"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "status",
+			steps: []step{{
+				command: []string{"serve", "--https=443", "--bg", "--set-path=/gone", "status:410:This page is gone"},
+				want: &ipn.ServeConfig{
+					TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+					Web: map[ipn.HostPort]*ipn.WebServerConfig{
+						"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+							"/gone": {Status: 410, Text: "This page is gone"},
+						}},
+					},
+				},
+			}},
+		},
+		{
+			name: "status_invalid",
+			steps: []step{{
+				command: cmd("serve --https=443 --bg status:abc"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "status_informational",
+			steps: []step{{
+				command: cmd("serve --https=443 --bg status:101"),
+				wantErr: anyErr(),
+			}},
+		},
+		{
+			name: "status_out_of_range",
+			steps: []step{
+				{
+					command: cmd("serve --https=443 --bg status:199"),
+					wantErr: anyErr(),
+				},
+				{
+					command: cmd("serve --https=443 --bg status:600"),
+					wantErr: anyErr(),
+				},
+			},
+		},
 		{
 			name: "path",
 			steps: []step{
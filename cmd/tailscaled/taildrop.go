@@ -7,10 +7,16 @@
 
 import (
 	"fmt"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"tailscale.com/envknob"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/taildrop"
 	"tailscale.com/types/logger"
 	"tailscale.com/version/distro"
 )
@@ -31,6 +37,64 @@ func configureTaildrop(logf logger.Logf, lb *ipnlocal.LocalBackend) {
 		}
 	}
 
+	p, err := taildropPolicyFromEnv()
+	if err != nil {
+		log.Fatalf("invalid Taildrop policy: %v", err)
+	}
+	lb.SetTaildropPolicy(p)
+}
+
+// taildropPolicyFromEnv returns the policy restricting which files are
+// received with Taildrop, as set by the environment:
+//
+//   - TS_TAILDROP_MAX_BYTES_PER_SENDER_PER_DAY and TS_TAILDROP_MAX_INBOX_BYTES
+//     are sizes in bytes, optionally with a K, M, G or T suffix (in
+//     powers of 1024).
+//   - TS_TAILDROP_ALLOWED_SENDERS is a comma-separated list of tags and
+//     login names.
+//   - TS_TAILDROP_REQUIRE_CONFIRMATION, if true, holds received files
+//     until accepted with "tailscale file get --accept".
+func taildropPolicyFromEnv() (p taildrop.Policy, err error) {
+	if p.MaxBytesPerSenderPerDay, err = parseByteSize(envknob.String("TS_TAILDROP_MAX_BYTES_PER_SENDER_PER_DAY")); err != nil {
+		return p, fmt.Errorf("TS_TAILDROP_MAX_BYTES_PER_SENDER_PER_DAY: %w", err)
+	}
+	if p.MaxInboxBytes, err = parseByteSize(envknob.String("TS_TAILDROP_MAX_INBOX_BYTES")); err != nil {
+		return p, fmt.Errorf("TS_TAILDROP_MAX_INBOX_BYTES: %w", err)
+	}
+	for _, s := range strings.Split(envknob.String("TS_TAILDROP_ALLOWED_SENDERS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			p.AllowedSenders = append(p.AllowedSenders, s)
+		}
+	}
+	p.RequireConfirmation = envknob.Bool("TS_TAILDROP_REQUIRE_CONFIRMATION")
+	return p, nil
+}
+
+// parseByteSize parses a size such as "512", "100M" or "2G". An empty
+// string is zero.
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num, shift := s, 0
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		shift = 10
+	case 'M', 'm':
+		shift = 20
+	case 'G', 'g':
+		shift = 30
+	case 'T', 't':
+		shift = 40
+	}
+	if shift != 0 {
+		num = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
 }
 
 func findTaildropDir(dg distro.Distro) (string, error) {
@@ -510,6 +510,9 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 			return ns.DialContextTCP(ctx, dst)
 		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			return ns.DialContextUDP(ctx, dst)
+		}
 	}
 	if socksListener != nil || httpProxyListener != nil {
 		var addrs []string
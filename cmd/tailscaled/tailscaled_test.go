@@ -32,3 +32,27 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"512", 512, false},
+		{"100M", 100 << 20, false},
+		{"2g", 2 << 30, false},
+		{"1T", 1 << 40, false},
+		{"M", 0, true},
+		{"-1", 0, true},
+		{"1.5G", 0, true},
+		{"9999999999T", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tkaverify command verifies a tailnet lock AUM chain offline, and
+// reports every change made to the trusted keys.
+//
+// The chain is read from an export written by 'tailscale lock export', or
+// directly from a node's tailnet lock state directory with -dir. Every AUM
+// is replayed and its signatures checked, exactly as a node would, so the
+// report does not depend on trusting the node which produced the export.
+//
+// Usage:
+//
+//	tailscale lock export > chain.jsonl
+//	tkaverify -genesis=<hash> chain.jsonl
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/tka"
+	"tailscale.com/types/key"
+)
+
+var (
+	dir         = flag.String("dir", "", "read AUMs from this tailnet lock state directory, rather than from an export")
+	genesis     = flag.String("genesis", "", "if set, the expected hash of the first AUM in the chain")
+	jsonOut     = flag.Bool("json", false, "print the report as JSON")
+	showAllAUMs = flag.Bool("v", false, "also list AUMs which did not change the trusted keys")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: tkaverify [flags] <export-file | ->\n       tkaverify [flags] -dir=<tka-state-dir>\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	export, err := readExport()
+	if err != nil {
+		log.Fatal(err)
+	}
+	exported, aums, err := tka.ReadExport(bytes.NewReader(export))
+	if err != nil {
+		log.Fatalf("reading export: %v", err)
+	}
+
+	if *genesis != "" {
+		want, err := parseHash(*genesis)
+		if err != nil {
+			log.Fatalf("parsing -genesis: %v", err)
+		}
+		if got := exported[0].Hash; got != want {
+			log.Fatalf("chain begins at %v, want %v", got, want)
+		}
+	}
+
+	storage := &tka.Mem{}
+	authority, err := tka.ImportChain(storage, aums)
+	if err != nil {
+		log.Fatalf("verification failed: %v", err)
+	}
+	entries, err := authority.Audit(storage)
+	if err != nil {
+		log.Fatalf("auditing chain: %v", err)
+	}
+	commitTimes := make(map[tka.AUMHash]time.Time, len(exported))
+	for _, e := range exported {
+		if e.CommitTime != nil {
+			commitTimes[e.Hash] = *e.CommitTime
+		}
+	}
+	for i := range entries {
+		entries[i].CommitTime = commitTimes[entries[i].Hash]
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printReport(os.Stdout, authority, aums, entries)
+}
+
+// readExport returns the export to verify, either from the file named on
+// the command line, or generated from the directory given by -dir.
+func readExport() ([]byte, error) {
+	if *dir != "" {
+		if flag.NArg() != 0 {
+			return nil, errors.New("cannot use both -dir and an export file")
+		}
+		chonk, err := tka.ChonkDir(*dir)
+		if err != nil {
+			return nil, err
+		}
+		a, err := tka.Open(chonk)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %v", *dir, err)
+		}
+		exported, err := a.Export(chonk)
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %v", *dir, err)
+		}
+		var buf bytes.Buffer
+		if err := tka.WriteExport(&buf, exported); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	switch flag.NArg() {
+	case 0:
+		flag.Usage()
+		os.Exit(2)
+	case 1:
+	default:
+		return nil, errors.New("too many arguments")
+	}
+	if flag.Arg(0) == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(flag.Arg(0))
+}
+
+// parseHash parses an AUM hash in either base32 (as in exports) or hex (as
+// printed by 'tailscale lock log').
+func parseHash(s string) (tka.AUMHash, error) {
+	var h tka.AUMHash
+	if len(s) == hex.EncodedLen(len(h)) {
+		b, err := hex.DecodeString(s)
+		if err == nil {
+			copy(h[:], b)
+			return h, nil
+		}
+	}
+	err := h.UnmarshalText([]byte(s))
+	return h, err
+}
+
+func printReport(w io.Writer, a *tka.Authority, aums []tka.AUM, entries []tka.AuditEntry) {
+	fmt.Fprintf(w, "Verified %d AUMs: %d in the active chain, %d in abandoned forks.\n", len(aums), len(entries), len(aums)-len(entries))
+	if _, hasParent := entries[0].AUM.Parent(); hasParent {
+		fmt.Fprintf(w, "The chain begins at checkpoint %v; earlier AUMs were compacted away.\n", entries[0].Hash)
+	}
+	fmt.Fprintln(w)
+
+	for _, e := range entries {
+		thresholdChanged := e.PrevKeyChangeThreshold != e.KeyChangeThreshold
+		if len(e.KeyChanges) == 0 && !thresholdChanged && !*showAllAUMs {
+			continue
+		}
+		when := "(time unknown)"
+		if !e.CommitTime.IsZero() {
+			when = e.CommitTime.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s  %v  %v  (%d signatures)\n", when, e.Hash, e.AUM.MessageKind, len(e.AUM.Signatures))
+		for _, c := range e.KeyChanges {
+			switch c.Kind {
+			case tka.KeyAdded, tka.KeyRemoved:
+				fmt.Fprintf(w, "\t%-6s %s votes=%d%s\n", c.Kind, keyString(c.Key), c.Key.Votes, metaString(c.Key.Meta))
+			case tka.KeyUpdated:
+				fmt.Fprintf(w, "\t%-6s %s votes=%d->%d%s\n", c.Kind, keyString(c.Key), c.PrevVotes, c.Key.Votes, metaString(c.Key.Meta))
+			}
+		}
+		if thresholdChanged {
+			fmt.Fprintf(w, "\tkey-change threshold %d->%d\n", e.PrevKeyChangeThreshold, e.KeyChangeThreshold)
+		}
+	}
+
+	fmt.Fprintf(w, "\nHead: %v\n", a.Head())
+	fmt.Fprintf(w, "Changes to trusted keys require %d signature(s).\n", a.KeyChangeThreshold())
+	fmt.Fprintln(w, "Trusted keys:")
+	for _, k := range a.Keys() {
+		fmt.Fprintf(w, "\t%s votes=%d%s\n", keyString(k), k.Votes, metaString(k.Meta))
+	}
+}
+
+func keyString(k tka.Key) string {
+	switch k.Kind {
+	case tka.Key25519:
+		return key.NLPublicFromEd25519Unsafe(k.Public).CLIString()
+	default:
+		return k.Kind.String() + ":" + hex.EncodeToString(k.Public)
+	}
+}
+
+func metaString(meta map[string]string) string {
+	if len(meta) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, meta[k])
+	}
+	return b.String()
+}
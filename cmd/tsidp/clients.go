@@ -0,0 +1,407 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/rands"
+)
+
+// peerCapTSIDPAdmin is the peer capability which allows a tailnet user to
+// manage the registered clients of tsidp.
+const peerCapTSIDPAdmin tailcfg.PeerCapability = "tailscale.com/cap/tsidp-admin"
+
+// oidcClient is a relying party registered with tsidp.
+type oidcClient struct {
+	ID string `json:"client_id"`
+
+	// SecretHash is the hex-encoded SHA-256 of the client secret, or empty
+	// for a public client, which cannot keep a secret and so must use PKCE.
+	// The secret itself is only shown when the client is registered.
+	SecretHash string `json:"client_secret_sha256,omitempty"`
+
+	// Name is a human-readable description of the client, like "Grafana".
+	Name string `json:"name,omitempty"`
+
+	// RedirectURIs are the redirect_uri values the client may use.
+	// They must match exactly.
+	RedirectURIs []string `json:"redirect_uris"`
+
+	// RequirePKCE specifies that the client must use PKCE, even if it is
+	// a confidential client.
+	RequirePKCE bool `json:"require_pkce,omitempty"`
+}
+
+// isPublic reports whether c has no client secret.
+func (c *oidcClient) isPublic() bool {
+	return c.SecretHash == ""
+}
+
+// mustUsePKCE reports whether c must present a code_challenge.
+func (c *oidcClient) mustUsePKCE() bool {
+	return c.isPublic() || c.RequirePKCE
+}
+
+// allowsRedirectURI reports whether uri is registered for c.
+func (c *oidcClient) allowsRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// checkSecret reports whether secret is the secret of c.
+func (c *oidcClient) checkSecret(secret string) bool {
+	if c.isPublic() {
+		return secret == ""
+	}
+	got := hashSecret(secret)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(c.SecretHash)) == 1
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientsStateKey is the key under which the registered clients are
+// stored in the state store.
+const clientsStateKey ipn.StateKey = "tsidp-clients"
+
+// clientRegistry is the set of registered clients, persisted as JSON in
+// an ipn.StateStore, which may be shared by multiple replicas of tsidp.
+// The registry is reloaded from the store on each use, so that clients
+// registered by other replicas are seen.
+type clientRegistry struct {
+	store ipn.StateStore // or nil to not persist
+
+	mu      sync.Mutex
+	clients map[string]*oidcClient // keyed by client ID
+}
+
+// loadClientRegistry returns the registry persisted in store. If there is
+// none, the clients are imported from legacyPath, a JSON file in which
+// older versions kept the registry, if it exists.
+func loadClientRegistry(store ipn.StateStore, legacyPath string) (*clientRegistry, error) {
+	cr := &clientRegistry{
+		store:   store,
+		clients: make(map[string]*oidcClient),
+	}
+	if store == nil {
+		return cr, nil
+	}
+	b, err := store.ReadState(clientsStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) && legacyPath != "" {
+		b, err = os.ReadFile(legacyPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return cr, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := cr.parse(b); err != nil {
+			return nil, fmt.Errorf("importing %s: %w", legacyPath, err)
+		}
+		if err := cr.saveLocked(); err != nil {
+			return nil, err
+		}
+		log.Printf("Imported %d clients from %s", len(cr.clients), legacyPath)
+		return cr, nil
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if err := cr.loadLocked(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// parse replaces the clients with those in the JSON b.
+func (cr *clientRegistry) parse(b []byte) error {
+	var clients []*oidcClient
+	if err := json.Unmarshal(b, &clients); err != nil {
+		return err
+	}
+	m := make(map[string]*oidcClient, len(clients))
+	for _, c := range clients {
+		if c.ID == "" {
+			return errors.New("client with no client_id")
+		}
+		m[c.ID] = c
+	}
+	cr.clients = m
+	return nil
+}
+
+// loadLocked reloads the clients from the store. cr.mu must be held.
+func (cr *clientRegistry) loadLocked() error {
+	if cr.store == nil {
+		return nil
+	}
+	b, err := cr.store.ReadState(clientsStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		cr.clients = make(map[string]*oidcClient)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading clients: %w", err)
+	}
+	if err := cr.parse(b); err != nil {
+		return fmt.Errorf("parsing clients: %w", err)
+	}
+	return nil
+}
+
+// reloadLocked is like loadLocked, but only logs errors, keeping the
+// clients last loaded. cr.mu must be held.
+func (cr *clientRegistry) reloadLocked() {
+	if err := cr.loadLocked(); err != nil {
+		log.Printf("Error reloading clients: %v", err)
+	}
+}
+
+// get returns the client with the given ID, or nil if there is none.
+func (cr *clientRegistry) get(id string) *oidcClient {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.reloadLocked()
+	return cr.clients[id]
+}
+
+// list returns the registered clients, sorted by ID.
+func (cr *clientRegistry) list() []*oidcClient {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	cr.reloadLocked()
+	ret := make([]*oidcClient, 0, len(cr.clients))
+	for _, c := range cr.clients {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// add registers a new client. Unless public is true, it also generates a
+// client secret, which is returned.
+func (cr *clientRegistry) add(name string, redirectURIs []string, public, requirePKCE bool) (_ *oidcClient, secret string, err error) {
+	if len(redirectURIs) == 0 {
+		return nil, "", errors.New("at least one redirect_uri is required")
+	}
+	for _, uri := range redirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return nil, "", err
+		}
+	}
+	c := &oidcClient{
+		ID:           rands.HexString(32),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		RequirePKCE:  requirePKCE,
+	}
+	if !public {
+		secret = rands.HexString(64)
+		c.SecretHash = hashSecret(secret)
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if err := cr.loadLocked(); err != nil {
+		return nil, "", err
+	}
+	cr.clients[c.ID] = c
+	if err := cr.saveLocked(); err != nil {
+		delete(cr.clients, c.ID)
+		return nil, "", err
+	}
+	return c, secret, nil
+}
+
+// remove unregisters the client with the given ID, reporting whether it
+// existed.
+func (cr *clientRegistry) remove(id string) (bool, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if err := cr.loadLocked(); err != nil {
+		return false, err
+	}
+	c, ok := cr.clients[id]
+	if !ok {
+		return false, nil
+	}
+	delete(cr.clients, id)
+	if err := cr.saveLocked(); err != nil {
+		cr.clients[id] = c
+		return false, err
+	}
+	return true, nil
+}
+
+// saveLocked persists the registry. cr.mu must be held.
+func (cr *clientRegistry) saveLocked() error {
+	if cr.store == nil {
+		return nil
+	}
+	clients := make([]*oidcClient, 0, len(cr.clients))
+	for _, c := range cr.clients {
+		clients = append(clients, c)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	b, err := json.MarshalIndent(clients, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := cr.store.WriteState(clientsStateKey, b); err != nil {
+		return fmt.Errorf("writing clients: %w", err)
+	}
+	return nil
+}
+
+// validateRedirectURI reports whether uri may be registered as a redirect
+// URI: an absolute URL without a fragment.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid redirect_uri %q: %w", uri, err)
+	}
+	if !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+		return fmt.Errorf("invalid redirect_uri %q: must be an absolute URL without a fragment", uri)
+	}
+	return nil
+}
+
+// clientCredentials returns the client ID and secret presented in r, with
+// either the client_secret_basic or client_secret_post methods.
+func clientCredentials(r *http.Request) (id, secret string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		// The credentials are form-encoded before being put in the
+		// header; see RFC 6749, section 2.3.1.
+		if v, err := url.QueryUnescape(id); err == nil {
+			id = v
+		}
+		if v, err := url.QueryUnescape(secret); err == nil {
+			secret = v
+		}
+		return id, secret
+	}
+	return r.FormValue("client_id"), r.FormValue("client_secret")
+}
+
+// authenticateClient checks the credentials presented in r by a client
+// redeeming a grant issued to clientID. Unregistered clients present
+// no credentials, and are instead identified by their node.
+func (s *idpServer) authenticateClient(r *http.Request, clientID string) error {
+	id, secret := clientCredentials(r)
+	c := s.clients.get(clientID)
+	if c == nil {
+		if id != "" && id != clientID {
+			return errors.New("tsidp: client_id mismatch")
+		}
+		return nil
+	}
+	if id != clientID || !c.checkSecret(secret) {
+		return errors.New("tsidp: invalid client credentials")
+	}
+	return nil
+}
+
+// canManageClients reports whether r is from someone allowed to manage
+// the client registry: a tailnet user with the peerCapTSIDPAdmin
+// capability, or, if s.localAdmin is set, anyone on the same machine as
+// tsidp.
+func (s *idpServer) canManageClients(r *http.Request) bool {
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil && ap.Addr().IsLoopback() {
+		// Loopback requests may come from any local user, or be proxied
+		// from elsewhere, so are only trusted when explicitly allowed.
+		if s.localAdmin {
+			return true
+		}
+		log.Printf("Denying client management from loopback address %v; see --allow-local-admin", r.RemoteAddr)
+		return false
+	}
+	who, err := s.lc.WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		log.Printf("Error getting WhoIs: %v", err)
+		return false
+	}
+	return who.CapMap.HasCapability(peerCapTSIDPAdmin)
+}
+
+// serveClients manages the client registry:
+//
+//   - GET /clients lists the registered clients.
+//   - POST /clients registers a new client, with form values "name",
+//     "redirect_uri" (one or more), "public" and "require_pkce". The
+//     response includes the client secret, which cannot be retrieved later.
+//   - DELETE /clients/:id unregisters a client, and revokes its tokens.
+func (s *idpServer) serveClients(w http.ResponseWriter, r *http.Request) {
+	if !s.canManageClients(r) {
+		http.Error(w, "tsidp: access denied", http.StatusForbidden)
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/clients"), "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		writeJSON(w, s.clients.list())
+	case id == "" && r.Method == "POST":
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, secret, err := s.clients.add(r.Form.Get("name"), r.Form["redirect_uri"], r.Form.Get("public") == "true", r.Form.Get("require_pkce") == "true")
+		if err != nil {
+			http.Error(w, "tsidp: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Registered client %q (%s)", c.Name, c.ID)
+		writeJSON(w, struct {
+			*oidcClient
+			Secret string `json:"client_secret,omitempty"`
+		}{c, secret})
+	case id != "" && r.Method == "DELETE":
+		if s.clients.get(id) == nil {
+			http.Error(w, "tsidp: client not found", http.StatusNotFound)
+			return
+		}
+		// Revoke the tokens first, as once the client is unregistered
+		// they could be redeemed without its secret.
+		if err := s.revokeClientTokens(id); err != nil {
+			log.Printf("Error revoking tokens of client %s: %v", id, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := s.clients.remove(id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Unregistered client %s", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeJSON writes v as the JSON response to a request.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	je := json.NewEncoder(w)
+	je.SetIndent("", "  ")
+	if err := je.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"tailscale.com/ipn"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/must"
+)
+
+// signingKeysStateKey is the key under which the signing keys are stored
+// in the key store.
+const signingKeysStateKey ipn.StateKey = "tsidp-signing-keys"
+
+const (
+	// keyPublishDelay is how long a new key is published in the JWKS
+	// before it is used for signing, so that relying parties which cache
+	// the JWKS see it before they see tokens signed with it.
+	keyPublishDelay = 24 * time.Hour
+
+	// keyRetention is how long a key is still published in the JWKS after
+	// it is no longer used for signing. It is much longer than the lifetime
+	// of ID tokens, so that they can be verified until they expire.
+	keyRetention = 24 * time.Hour
+
+	// keyReloadInterval is how often the keys are reloaded from the key
+	// store, to pick up keys created by other replicas.
+	keyReloadInterval = time.Minute
+)
+
+// Key types which can be used for signing, and their algorithms.
+var keyTypeAlgorithms = map[string]jose.SignatureAlgorithm{
+	"rsa":     jose.RS256,
+	"ecdsa":   jose.ES256,
+	"ed25519": jose.EdDSA,
+}
+
+// keyManager manages the keys used to sign ID tokens, rotating them
+// periodically. The keys are persisted in an ipn.StateStore, which may be
+// shared by multiple replicas of tsidp.
+//
+// Each key goes through three stages: it is published in the JWKS for
+// keyPublishDelay before it is used for signing, then used for signing
+// until the next key is, and finally kept in the JWKS for keyRetention.
+// Publishing keys before use also makes it safe for replicas to rotate
+// keys concurrently: as the store has no transactions, one replica may
+// overwrite the key created by another, but the lost key was never used.
+type keyManager struct {
+	store      ipn.StateStore
+	keyType    string        // type of new keys, a key of keyTypeAlgorithms
+	rotation   time.Duration // how often to rotate keys, or zero to never
+	legacyPath string        // if non-empty, a file with a key to import
+
+	mu      sync.Mutex
+	keys    []*signingKey          // oldest first
+	signers map[uint64]jose.Signer // keyed by kid
+}
+
+// update reloads the keys from the store, creating, rotating and expiring
+// keys as needed as of now.
+func (km *keyManager) update(now time.Time) error {
+	keys, err := km.readKeys()
+	if err != nil {
+		return err
+	}
+	changed := false
+	if len(keys) == 0 {
+		sk, err := km.initialKey(now)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, sk)
+		changed = true
+	} else if km.rotationDue(keys, now) {
+		sk, err := genSigningKey(km.keyType, now)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created signing key %d, to be used from %v", sk.kid, now.Add(keyPublishDelay).Format(time.RFC3339))
+		keys = append(keys, sk)
+		changed = true
+	}
+	if kept := expireKeys(keys, now); len(kept) != len(keys) {
+		keys = kept
+		changed = true
+	}
+	if changed {
+		if err := km.writeKeys(keys); err != nil {
+			return err
+		}
+	}
+
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.keys = keys
+	return nil
+}
+
+// run calls update periodically until ctx is done.
+func (km *keyManager) run(ctx context.Context) {
+	t := time.NewTicker(keyReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			if err := km.update(now); err != nil {
+				log.Printf("Error updating signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// initialKey returns the first key, imported from km.legacyPath if it
+// exists or generated otherwise.
+func (km *keyManager) initialKey(now time.Time) (*signingKey, error) {
+	if km.legacyPath != "" {
+		b, err := os.ReadFile(km.legacyPath)
+		if err == nil {
+			var sk signingKey
+			if err := sk.UnmarshalJSON(b); err != nil {
+				return nil, fmt.Errorf("importing %s: %w", km.legacyPath, err)
+			}
+			if sk.k != nil {
+				log.Printf("Imported signing key %d from %s", sk.kid, km.legacyPath)
+				return &sk, nil
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	// As no relying party can have seen any other key, the first key is
+	// used immediately.
+	sk, err := genSigningKey(km.keyType, now.Add(-keyPublishDelay))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created signing key %d", sk.kid)
+	return sk, nil
+}
+
+// rotationDue reports whether a new key should be created, so that it is
+// ready to be used when the active key is due for rotation. It is also due
+// if the active key is not of the configured type.
+func (km *keyManager) rotationDue(keys []*signingKey, now time.Time) bool {
+	active := activeKey(keys, now)
+	if keys[len(keys)-1] != active {
+		return false // a new key is already waiting to be used
+	}
+	if active.alg != keyTypeAlgorithms[km.keyType] {
+		return true
+	}
+	return km.rotation > 0 && !now.Before(active.created.Add(km.rotation-keyPublishDelay))
+}
+
+// activeKey returns the key to sign with as of now: the newest key which
+// has been published for at least keyPublishDelay.
+func activeKey(keys []*signingKey, now time.Time) *signingKey {
+	for i := len(keys) - 1; i > 0; i-- {
+		if !now.Before(keys[i].created.Add(keyPublishDelay)) {
+			return keys[i]
+		}
+	}
+	return keys[0]
+}
+
+// expireKeys returns keys without those which have been replaced by a
+// newer active key for longer than keyRetention.
+func expireKeys(keys []*signingKey, now time.Time) []*signingKey {
+	for len(keys) > 1 {
+		retired := keys[1].created.Add(keyPublishDelay)
+		if now.Before(retired.Add(keyRetention)) {
+			break
+		}
+		log.Printf("Removing signing key %d, retired at %v", keys[0].kid, retired.Format(time.RFC3339))
+		keys = keys[1:]
+	}
+	return keys
+}
+
+// signingKeysJSON is the serialization of the keys in the key store.
+type signingKeysJSON struct {
+	Keys []*signingKey
+}
+
+func (km *keyManager) readKeys() ([]*signingKey, error) {
+	b, err := km.store.ReadState(signingKeysStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading signing keys: %w", err)
+	}
+	var sj signingKeysJSON
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return nil, fmt.Errorf("parsing signing keys: %w", err)
+	}
+	keys := make([]*signingKey, 0, len(sj.Keys))
+	for _, sk := range sj.Keys {
+		if sk.k != nil {
+			keys = append(keys, sk)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].created.Before(keys[j].created) })
+	return keys, nil
+}
+
+func (km *keyManager) writeKeys(keys []*signingKey) error {
+	b, err := json.Marshal(signingKeysJSON{Keys: keys})
+	if err != nil {
+		return err
+	}
+	if err := km.store.WriteState(signingKeysStateKey, b); err != nil {
+		return fmt.Errorf("writing signing keys: %w", err)
+	}
+	return nil
+}
+
+// signer returns the signer for the active key as of now.
+func (km *keyManager) signer(now time.Time) (jose.Signer, error) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if len(km.keys) == 0 {
+		return nil, errors.New("tsidp: no signing keys")
+	}
+	sk := activeKey(km.keys, now)
+	if s, ok := km.signers[sk.kid]; ok {
+		return s, nil
+	}
+	s, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: sk.alg,
+		Key:       sk.k,
+	}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
+		jose.HeaderType: "JWT",
+		"kid":           fmt.Sprint(sk.kid),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	mak.Set(&km.signers, sk.kid, s)
+	return s, nil
+}
+
+// jwks returns the public keys of all the published keys.
+func (km *keyManager) jwks() jose.JSONWebKeySet {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	var ks jose.JSONWebKeySet
+	for _, sk := range km.keys {
+		ks.Keys = append(ks.Keys, jose.JSONWebKey{
+			Key:       sk.k.Public(),
+			Algorithm: string(sk.alg),
+			Use:       "sig",
+			KeyID:     fmt.Sprint(sk.kid),
+		})
+	}
+	return ks
+}
+
+// algorithms returns the signing algorithms of the published keys.
+func (km *keyManager) algorithms() []string {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	var algs []string
+	seen := map[jose.SignatureAlgorithm]bool{}
+	for _, sk := range km.keys {
+		if !seen[sk.alg] {
+			seen[sk.alg] = true
+			algs = append(algs, string(sk.alg))
+		}
+	}
+	return algs
+}
+
+const (
+	minimumRSAKeySize = 2048
+)
+
+// genSigningKey generates a new key of the given type, created at the
+// given time.
+func genSigningKey(keyType string, created time.Time) (*signingKey, error) {
+	sk := &signingKey{
+		alg:     keyTypeAlgorithms[keyType],
+		created: created,
+	}
+	var err error
+	switch keyType {
+	case "rsa":
+		sk.kid, sk.k = mustGenRSAKey(minimumRSAKeySize)
+		return sk, nil
+	case "ecdsa":
+		sk.k, err = ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	case "ed25519":
+		_, sk.k, err = ed25519.GenerateKey(crand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sk.kid, err = readUint64(crand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// mustGenRSAKey generates a new RSA key with the provided number of bits. It
+// panics on failure. bits must be at least minimumRSAKeySizeBytes * 8.
+func mustGenRSAKey(bits int) (kid uint64, k *rsa.PrivateKey) {
+	if bits < minimumRSAKeySize {
+		panic("request to generate a too-small RSA key")
+	}
+	kid = must.Get(readUint64(crand.Reader))
+	k = must.Get(rsa.GenerateKey(crand.Reader, bits))
+	return
+}
+
+// signingKeyJSONWrapper is the the JSON serialization
+// format used by signingKey.
+type signingKeyJSONWrapper struct {
+	Key     string
+	ID      uint64
+	Alg     jose.SignatureAlgorithm `json:",omitempty"` // RS256 if empty
+	Created time.Time               `json:",omitempty"`
+}
+
+type signingKey struct {
+	k       crypto.Signer // *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+	kid     uint64
+	alg     jose.SignatureAlgorithm
+	created time.Time
+}
+
+func (sk *signingKey) MarshalJSON() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(sk.k)
+	if err != nil {
+		return nil, err
+	}
+	b := pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}
+	bts := pem.EncodeToMemory(&b)
+	return json.Marshal(signingKeyJSONWrapper{
+		Key:     base64.URLEncoding.EncodeToString(bts),
+		ID:      sk.kid,
+		Alg:     sk.alg,
+		Created: sk.created,
+	})
+}
+
+func (sk *signingKey) UnmarshalJSON(b []byte) error {
+	var wrapper signingKeyJSONWrapper
+	if err := json.Unmarshal(b, &wrapper); err != nil {
+		return err
+	}
+	if len(wrapper.Key) == 0 {
+		return nil
+	}
+	b64dec, err := base64.URLEncoding.DecodeString(wrapper.Key)
+	if err != nil {
+		return err
+	}
+	blk, _ := pem.Decode(b64dec)
+	if blk == nil {
+		return errors.New("invalid PEM in signing key")
+	}
+	var k crypto.Signer
+	switch blk.Type {
+	case "RSA PRIVATE KEY":
+		// The format of keys from before other key types were supported.
+		k, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	default:
+		var pk any
+		pk, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+		if err == nil {
+			var ok bool
+			if k, ok = pk.(crypto.Signer); !ok {
+				err = fmt.Errorf("unsupported signing key type %T", pk)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	sk.k = k
+	sk.kid = wrapper.ID
+	sk.alg = wrapper.Alg
+	if sk.alg == "" {
+		sk.alg = jose.RS256
+	}
+	sk.created = wrapper.Created
+	return nil
+}
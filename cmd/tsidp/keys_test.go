@@ -0,0 +1,137 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/util/must"
+)
+
+func TestKeyRotation(t *testing.T) {
+	const day = 24 * time.Hour
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := new(mem.Store)
+	km := &keyManager{store: st, keyType: "ecdsa", rotation: 7 * day}
+
+	kids := func(km *keyManager) (ret []string) {
+		for _, k := range km.jwks().Keys {
+			ret = append(ret, k.KeyID)
+		}
+		return ret
+	}
+	signerKid := func(km *keyManager, now time.Time) string {
+		s := must.Get(km.signer(now))
+		tok := must.Get(jwt.Signed(s).Claims(jwt.Claims{Subject: "x"}).CompactSerialize())
+		parsed := must.Get(jwt.ParseSigned(tok))
+		return parsed.Headers[0].KeyID
+	}
+
+	must.Do(km.update(t0))
+	first := kids(km)
+	if len(first) != 1 || signerKid(km, t0) != first[0] {
+		t.Fatalf("initial keys = %v; want one key, in use", first)
+	}
+
+	// The new key is published a day before the rotation is due.
+	must.Do(km.update(t0.Add(4 * day)))
+	if got := kids(km); len(got) != 1 {
+		t.Fatalf("keys after 4 days = %v; want no new key yet", got)
+	}
+	must.Do(km.update(t0.Add(5 * day)))
+	keys := kids(km)
+	if len(keys) != 2 || keys[0] != first[0] {
+		t.Fatalf("keys after 5 days = %v; want a new key published", keys)
+	}
+	if got := signerKid(km, t0.Add(5*day)); got != first[0] {
+		t.Errorf("signing with %v after 5 days, want %v", got, first[0])
+	}
+
+	// Another replica sharing the store uses the same keys.
+	km2 := &keyManager{store: st, keyType: "ecdsa", rotation: 7 * day}
+	must.Do(km2.update(t0.Add(6 * day)))
+	if got := kids(km2); len(got) != 2 || got[1] != keys[1] {
+		t.Fatalf("keys of second replica = %v, want %v", got, keys)
+	}
+	if got := signerKid(km2, t0.Add(6*day)); got != keys[1] {
+		t.Errorf("signing with %v after 6 days, want new key %v", got, keys[1])
+	}
+
+	// The old key is kept for a day after it was last used.
+	must.Do(km.update(t0.Add(6*day + day/2)))
+	if got := kids(km); len(got) != 2 {
+		t.Errorf("keys after 6.5 days = %v; want old key kept", got)
+	}
+	must.Do(km.update(t0.Add(7 * day)))
+	if got := kids(km); len(got) != 1 || got[0] != keys[1] {
+		t.Errorf("keys after 7 days = %v; want only %v", got, keys[1])
+	}
+
+	// Changing the key type rotates the key.
+	km3 := &keyManager{store: st, keyType: "ed25519", rotation: 7 * day}
+	must.Do(km3.update(t0.Add(7 * day)))
+	if got := km3.jwks().Keys; len(got) != 2 || got[1].Algorithm != string(jose.EdDSA) {
+		t.Errorf("keys after changing type = %+v; want new EdDSA key", got)
+	}
+	// Only the algorithms of the published keys are advertised.
+	if got, want := km3.algorithms(), []string{string(jose.ES256), string(jose.EdDSA)}; !slices.Equal(got, want) {
+		t.Errorf("algorithms = %v; want %v", got, want)
+	}
+}
+
+func TestSigningKeyJSON(t *testing.T) {
+	for keyType, alg := range keyTypeAlgorithms {
+		t.Run(keyType, func(t *testing.T) {
+			sk := must.Get(genSigningKey(keyType, time.Unix(1700000000, 0)))
+			var got signingKey
+			must.Do(got.UnmarshalJSON(must.Get(sk.MarshalJSON())))
+			if got.kid != sk.kid || got.alg != alg || !got.created.Equal(sk.created) {
+				t.Fatalf("round trip = %v/%v/%v, want %v/%v/%v", got.kid, got.alg, got.created, sk.kid, alg, sk.created)
+			}
+
+			// A token signed with the key verifies with its published key.
+			km := &keyManager{keys: []*signingKey{&got}}
+			tok := must.Get(jwt.Signed(must.Get(km.signer(time.Now()))).Claims(jwt.Claims{Subject: "x"}).CompactSerialize())
+			var claims jwt.Claims
+			if err := must.Get(jwt.ParseSigned(tok)).Claims(km.jwks().Keys[0].Key, &claims); err != nil {
+				t.Fatalf("verifying token: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportLegacyKey(t *testing.T) {
+	kid, k := mustGenRSAKey(minimumRSAKeySize)
+	legacy := must.Get(json.Marshal(map[string]any{
+		"Key": base64.URLEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(k),
+		})),
+		"ID": kid,
+	}))
+	path := filepath.Join(t.TempDir(), "oidc-key.json")
+	must.Do(os.WriteFile(path, legacy, 0600))
+
+	km := &keyManager{store: new(mem.Store), keyType: "rsa", legacyPath: path}
+	must.Do(km.update(time.Now()))
+	keys := km.jwks().Keys
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want the imported key only", len(keys))
+	}
+	if got := km.keys[0]; got.kid != kid || got.alg != jose.RS256 || !got.k.(*rsa.PrivateKey).Equal(k) {
+		t.Errorf("imported key %v/%v does not match legacy key %v", got.kid, got.alg, kid)
+	}
+}
@@ -4,18 +4,29 @@
 // The tsidp command is an OpenID Connect Identity Provider server.
 //
 // See https://github.com/tailscale/tailscale/issues/10263 for background.
+//
+// Relying parties may be registered by POSTing to /clients, by a user with
+// the tailscale.com/cap/tsidp-admin capability, or from the same machine if
+// --allow-local-admin is set.
+// Registered clients are given a client secret (unless public, in which
+// case they must use PKCE) and may only redirect to their registered
+// redirect URIs.
+//
+// ID tokens are signed with keys kept in the --key-store, which replicas
+// may share by using a Kubernetes secret or AWS SSM parameter. The key is
+// rotated every --key-rotation-interval. The client registry, and the
+// authorization codes and tokens issued, are kept in the same store.
 package main
 
 import (
 	"context"
-	crand "crypto/rand"
-	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -24,18 +35,18 @@
 	"net/http"
 	"net/netip"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"gopkg.in/square/go-jose.v2"
 	"gopkg.in/square/go-jose.v2/jwt"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsnet"
 	"tailscale.com/types/key"
@@ -43,7 +54,6 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/views"
 	"tailscale.com/util/mak"
-	"tailscale.com/util/must"
 	"tailscale.com/util/rands"
 )
 
@@ -52,6 +62,12 @@
 	flagPort               = flag.Int("port", 443, "port to listen on")
 	flagLocalPort          = flag.Int("local-port", -1, "allow requests from localhost")
 	flagUseLocalTailscaled = flag.Bool("use-local-tailscaled", false, "use local tailscaled instead of tsnet")
+	flagLocalAdmin         = flag.Bool("allow-local-admin", false, "allow anyone on this machine to manage registered clients; only use if every local user, and anything proxying to localhost, is trusted")
+	flagAllowUnregistered  = flag.Bool("allow-unregistered-clients", true, "allow relying parties which are not registered, identified only by their node")
+	flagRefreshTokenTTL    = flag.Duration("refresh-token-lifetime", 30*24*time.Hour, "how long refresh tokens are valid for")
+	flagKeyStore           = flag.String("key-store", "oidc-keys.json", `where to store signing keys, registered clients and tokens: a file path, or "kube:<secret>" or "arn:<ssm-parameter>" to share them between replicas`)
+	flagKeyType            = flag.String("key-type", "rsa", "type of signing keys to generate: rsa, ecdsa or ed25519")
+	flagKeyRotation        = flag.Duration("key-rotation-interval", 30*24*time.Hour, "how often to rotate the signing key, or 0 to never rotate")
 )
 
 func main() {
@@ -60,6 +76,12 @@ func main() {
 	if !envknob.UseWIPCode() {
 		log.Fatal("cmd/tsidp is a work in progress and has not been security reviewed;\nits use requires TAILSCALE_USE_WIP_CODE=1 be set in the environment for now.")
 	}
+	if _, ok := keyTypeAlgorithms[*flagKeyType]; !ok {
+		log.Fatalf("unknown --key-type %q", *flagKeyType)
+	}
+	if *flagKeyRotation != 0 && *flagKeyRotation <= keyPublishDelay {
+		log.Fatalf("--key-rotation-interval must be longer than %v", keyPublishDelay)
+	}
 
 	var (
 		lc  *tailscale.LocalClient
@@ -113,9 +135,35 @@ func main() {
 		lns = append(lns, ln)
 	}
 
+	keyStore, err := store.New(log.Printf, *flagKeyStore)
+	if err != nil {
+		log.Fatalf("opening key store: %v", err)
+	}
+	clients, err := loadClientRegistry(keyStore, "oidc-clients.json")
+	if err != nil {
+		log.Fatalf("loading clients: %v", err)
+	}
+	keys := &keyManager{
+		store:      keyStore,
+		keyType:    *flagKeyType,
+		rotation:   *flagKeyRotation,
+		legacyPath: "oidc-key.json",
+	}
+	if err := keys.update(time.Now()); err != nil {
+		log.Fatalf("loading signing keys: %v", err)
+	}
+	go keys.run(ctx)
+
 	srv := &idpServer{
-		lc: lc,
+		lc:                lc,
+		keys:              keys,
+		clients:           clients,
+		store:             keyStore,
+		allowUnregistered: *flagAllowUnregistered,
+		localAdmin:        *flagLocalAdmin,
+		refreshTokenTTL:   *flagRefreshTokenTTL,
 	}
+	go srv.runTokenJanitor(ctx, time.Minute)
 	if *flagPort != 443 {
 		srv.serverURL = fmt.Sprintf("https://%s:%d", strings.TrimSuffix(st.Self.DNSName, "."), *flagPort)
 	} else {
@@ -145,13 +193,78 @@ type idpServer struct {
 	loopbackURL string
 	serverURL   string // "https://foo.bar.ts.net"
 
-	lazyMux        lazy.SyncValue[*http.ServeMux]
-	lazySigningKey lazy.SyncValue[*signingKey]
-	lazySigner     lazy.SyncValue[jose.Signer]
+	clients           *clientRegistry
+	allowUnregistered bool          // whether clients need not be in clients
+	localAdmin        bool          // whether loopback requests may manage clients
+	refreshTokenTTL   time.Duration // lifetime of refresh tokens
+
+	lazyMux lazy.SyncValue[*http.ServeMux]
+	keys    *keyManager
+
+	// store is where the codes and tokens are persisted, or nil to not
+	// persist them. It may be shared by multiple replicas, so the codes
+	// and tokens are reloaded from it on each use. As the store has no
+	// transactions, concurrent changes by replicas may be lost.
+	store ipn.StateStore
+
+	mu           sync.Mutex              // guards the fields below
+	code         map[string]*authRequest // keyed by random hex
+	accessToken  map[string]*authRequest // keyed by random hex
+	refreshToken map[string]*authRequest // keyed by random hex
+}
+
+// tokensStateKey is the key under which the codes and tokens are stored
+// in idpServer.store.
+const tokensStateKey ipn.StateKey = "tsidp-tokens"
+
+// tokensJSON is the serialization of the codes and tokens in the store.
+type tokensJSON struct {
+	Codes         map[string]*authRequest `json:",omitempty"`
+	AccessTokens  map[string]*authRequest `json:",omitempty"`
+	RefreshTokens map[string]*authRequest `json:",omitempty"`
+}
 
-	mu          sync.Mutex              // guards the fields below
-	code        map[string]*authRequest // keyed by random hex
-	accessToken map[string]*authRequest // keyed by random hex
+// loadTokensLocked replaces the codes and tokens with those in s.store,
+// which may have been changed by another replica. Errors are logged, and
+// the codes and tokens last loaded are kept. s.mu must be held.
+func (s *idpServer) loadTokensLocked() {
+	if s.store == nil {
+		return
+	}
+	b, err := s.store.ReadState(tokensStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		s.code, s.accessToken, s.refreshToken = nil, nil, nil
+		return
+	}
+	var tj tokensJSON
+	if err == nil {
+		err = json.Unmarshal(b, &tj)
+	}
+	if err != nil {
+		log.Printf("Error loading tokens: %v", err)
+		return
+	}
+	s.code, s.accessToken, s.refreshToken = tj.Codes, tj.AccessTokens, tj.RefreshTokens
+}
+
+// saveTokensLocked persists the codes and tokens to s.store. s.mu must be
+// held.
+func (s *idpServer) saveTokensLocked() error {
+	if s.store == nil {
+		return nil
+	}
+	b, err := json.Marshal(tokensJSON{
+		Codes:         s.code,
+		AccessTokens:  s.accessToken,
+		RefreshTokens: s.refreshToken,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.store.WriteState(tokensStateKey, b); err != nil {
+		return fmt.Errorf("writing tokens: %w", err)
+	}
+	return nil
 }
 
 type authRequest struct {
@@ -173,15 +286,63 @@ type authRequest struct {
 	// redirectURI is the redirect_uri presented in the request.
 	redirectURI string
 
+	// codeChallenge is the PKCE S256 code_challenge presented in the
+	// request, if any.
+	codeChallenge string
+
 	// remoteUser is the user who is being authenticated.
 	remoteUser *apitype.WhoIsResponse
 
 	// validTill is the time until which the token is valid.
-	// As of 2023-11-14, it is 5 minutes.
-	// TODO: add routine to delete expired tokens.
+	// As of 2023-11-14, it is 5 minutes for access tokens; refresh tokens
+	// are valid for idpServer.refreshTokenTTL. Expired tokens are deleted
+	// by idpServer.runTokenJanitor.
 	validTill time.Time
 }
 
+// authRequestJSON is the serialization of an authRequest.
+type authRequestJSON struct {
+	LocalRP       bool                   `json:",omitempty"`
+	RPNodeID      tailcfg.NodeID         `json:",omitempty"`
+	ClientID      string                 `json:",omitempty"`
+	Nonce         string                 `json:",omitempty"`
+	RedirectURI   string                 `json:",omitempty"`
+	CodeChallenge string                 `json:",omitempty"`
+	RemoteUser    *apitype.WhoIsResponse `json:",omitempty"`
+	ValidTill     time.Time
+}
+
+func (ar *authRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(authRequestJSON{
+		LocalRP:       ar.localRP,
+		RPNodeID:      ar.rpNodeID,
+		ClientID:      ar.clientID,
+		Nonce:         ar.nonce,
+		RedirectURI:   ar.redirectURI,
+		CodeChallenge: ar.codeChallenge,
+		RemoteUser:    ar.remoteUser,
+		ValidTill:     ar.validTill,
+	})
+}
+
+func (ar *authRequest) UnmarshalJSON(b []byte) error {
+	var aj authRequestJSON
+	if err := json.Unmarshal(b, &aj); err != nil {
+		return err
+	}
+	*ar = authRequest{
+		localRP:       aj.LocalRP,
+		rpNodeID:      aj.RPNodeID,
+		clientID:      aj.ClientID,
+		nonce:         aj.Nonce,
+		redirectURI:   aj.RedirectURI,
+		codeChallenge: aj.CodeChallenge,
+		remoteUser:    aj.RemoteUser,
+		validTill:     aj.ValidTill,
+	}
+	return nil
+}
+
 func (ar *authRequest) allowRelyingParty(ctx context.Context, remoteAddr string, lc *tailscale.LocalClient) error {
 	if ar.localRP {
 		ra, err := netip.ParseAddrPort(remoteAddr)
@@ -215,10 +376,34 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 
 	code := rands.HexString(32)
 	ar := &authRequest{
-		nonce:       uq.Get("nonce"),
-		remoteUser:  who,
-		redirectURI: uq.Get("redirect_uri"),
-		clientID:    uq.Get("client_id"),
+		nonce:         uq.Get("nonce"),
+		remoteUser:    who,
+		redirectURI:   uq.Get("redirect_uri"),
+		clientID:      uq.Get("client_id"),
+		codeChallenge: uq.Get("code_challenge"),
+	}
+
+	if c := s.clients.get(ar.clientID); c != nil {
+		if !c.allowsRedirectURI(ar.redirectURI) {
+			http.Error(w, "tsidp: redirect_uri not registered for client", http.StatusBadRequest)
+			return
+		}
+		if c.mustUsePKCE() && ar.codeChallenge == "" {
+			http.Error(w, "tsidp: code_challenge is required", http.StatusBadRequest)
+			return
+		}
+	} else if !s.allowUnregistered {
+		http.Error(w, "tsidp: unknown client_id", http.StatusBadRequest)
+		return
+	}
+	// The "plain" method is not supported, as it offers no protection if
+	// the authorization request is intercepted.
+	if method := uq.Get("code_challenge_method"); ar.codeChallenge != "" && method != "S256" {
+		http.Error(w, "tsidp: code_challenge_method must be S256", http.StatusBadRequest)
+		return
+	} else if ar.codeChallenge == "" && method != "" {
+		http.Error(w, "tsidp: code_challenge_method without code_challenge", http.StatusBadRequest)
+		return
 	}
 
 	if r.URL.Path == "/authorize/localhost" {
@@ -233,8 +418,15 @@ func (s *idpServer) authorize(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.mu.Lock()
+	s.loadTokensLocked()
 	mak.Set(&s.code, code, ar)
+	err = s.saveTokensLocked()
 	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Error saving code: %v", err)
+		http.Error(w, "tsidp: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	q := make(url.Values)
 	q.Set("code", code)
@@ -252,6 +444,9 @@ func (s *idpServer) newMux() *http.ServeMux {
 	mux.HandleFunc("/authorize/", s.authorize)
 	mux.HandleFunc("/userinfo", s.serveUserInfo)
 	mux.HandleFunc("/token", s.serveToken)
+	mux.HandleFunc("/revoke", s.serveRevoke)
+	mux.HandleFunc("/clients", s.serveClients)
+	mux.HandleFunc("/clients/", s.serveClients)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" {
 			io.WriteString(w, "<html><body><h1>Tailscale OIDC IdP</h1>")
@@ -279,6 +474,7 @@ func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.mu.Lock()
+	s.loadTokensLocked()
 	ar, ok := s.accessToken[tk]
 	s.mu.Unlock()
 	if !ok {
@@ -293,9 +489,8 @@ func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
 
 	if ar.validTill.Before(time.Now()) {
 		http.Error(w, "tsidp: token expired", http.StatusBadRequest)
-		s.mu.Lock()
-		delete(s.accessToken, tk)
-		s.mu.Unlock()
+		s.deleteTokens(tk)
+		return
 	}
 
 	ui := userInfo{}
@@ -330,25 +525,41 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if r.FormValue("grant_type") != "authorization_code" {
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		s.serveAuthorizationCodeGrant(w, r)
+	case "refresh_token":
+		s.serveRefreshTokenGrant(w, r)
+	default:
 		http.Error(w, "tsidp: grant_type not supported", http.StatusBadRequest)
-		return
 	}
+}
+
+func (s *idpServer) serveAuthorizationCodeGrant(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 	if code == "" {
 		http.Error(w, "tsidp: code is required", http.StatusBadRequest)
 		return
 	}
 	s.mu.Lock()
+	s.loadTokensLocked()
 	ar, ok := s.code[code]
+	var err error
 	if ok {
 		delete(s.code, code)
+		err = s.saveTokensLocked()
 	}
 	s.mu.Unlock()
 	if !ok {
 		http.Error(w, "tsidp: code not found", http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		// Refuse the code, as it could otherwise be redeemed again.
+		log.Printf("Error saving tokens: %v", err)
+		http.Error(w, "tsidp: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	if err := ar.allowRelyingParty(r.Context(), r.RemoteAddr, s.lc); err != nil {
 		log.Printf("Error allowing relying party: %v", err)
 		http.Error(w, err.Error(), http.StatusForbidden)
@@ -358,7 +569,146 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "tsidp: redirect_uri mismatch", http.StatusBadRequest)
 		return
 	}
-	signer, err := s.oidcSigner()
+	if err := s.authenticateClient(r, ar.clientID); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if verifier := r.FormValue("code_verifier"); ar.codeChallenge != "" {
+		if !verifyPKCE(ar.codeChallenge, verifier) {
+			http.Error(w, "tsidp: invalid code_verifier", http.StatusBadRequest)
+			return
+		}
+	} else if verifier != "" {
+		http.Error(w, "tsidp: code_verifier without code_challenge", http.StatusBadRequest)
+		return
+	}
+	s.issueTokens(w, ar)
+}
+
+func (s *idpServer) serveRefreshTokenGrant(w http.ResponseWriter, r *http.Request) {
+	rt := r.FormValue("refresh_token")
+	if rt == "" {
+		http.Error(w, "tsidp: refresh_token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.loadTokensLocked()
+	ar, ok := s.refreshToken[rt]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid refresh_token", http.StatusBadRequest)
+		return
+	}
+	if ar.validTill.Before(time.Now()) {
+		s.deleteTokens(rt)
+		http.Error(w, "tsidp: refresh_token expired", http.StatusBadRequest)
+		return
+	}
+	// The token is only consumed once the client has authenticated, so
+	// that someone who merely knows the token cannot revoke it.
+	if err := s.authenticateClient(r, ar.clientID); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := ar.allowRelyingParty(r.Context(), r.RemoteAddr, s.lc); err != nil {
+		log.Printf("Error allowing relying party: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Look the user up again, so that tokens are not issued for a node
+	// which has since been removed from the tailnet.
+	who, err := s.refreshUser(r.Context(), ar.remoteUser)
+	if err != nil {
+		log.Printf("Error refreshing user: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Refresh tokens are single use: a new one is issued with each
+	// refresh, so that a leaked token is only useful until the client
+	// next refreshes. Check the token is still there, in case it was
+	// redeemed or revoked concurrently.
+	s.mu.Lock()
+	s.loadTokensLocked()
+	_, ok = s.refreshToken[rt]
+	if ok {
+		delete(s.refreshToken, rt)
+		err = s.saveTokensLocked()
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "tsidp: invalid refresh_token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving tokens: %v", err)
+		http.Error(w, "tsidp: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nar := *ar
+	nar.remoteUser = who
+	s.issueTokens(w, &nar)
+}
+
+// expireTokens deletes the access and refresh tokens which expired before
+// now.
+func (s *idpServer) expireTokens(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadTokensLocked()
+	changed := false
+	for _, m := range []map[string]*authRequest{s.accessToken, s.refreshToken} {
+		for k, ar := range m {
+			if ar.validTill.Before(now) {
+				delete(m, k)
+				changed = true
+			}
+		}
+	}
+	if changed {
+		if err := s.saveTokensLocked(); err != nil {
+			log.Printf("Error saving tokens: %v", err)
+		}
+	}
+}
+
+// runTokenJanitor deletes expired tokens every interval until ctx is done.
+// Otherwise, tokens which are never presented again would be kept forever.
+func (s *idpServer) runTokenJanitor(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.expireTokens(now)
+		}
+	}
+}
+
+// refreshUser returns the current WhoIs information of the node
+// authenticated in who.
+func (s *idpServer) refreshUser(ctx context.Context, who *apitype.WhoIsResponse) (*apitype.WhoIsResponse, error) {
+	n := who.Node
+	if len(n.Addresses) == 0 {
+		return nil, errors.New("tsidp: user node has no addresses")
+	}
+	nw, err := s.lc.WhoIs(ctx, n.Addresses[0].Addr().String())
+	if err != nil {
+		return nil, fmt.Errorf("tsidp: user node not found: %w", err)
+	}
+	if nw.Node.ID != n.ID || nw.Node.User != n.User {
+		return nil, errors.New("tsidp: user node has changed")
+	}
+	return nw, nil
+}
+
+// issueTokens responds to a token request for ar with a new ID token,
+// access token and refresh token.
+func (s *idpServer) issueTokens(w http.ResponseWriter, ar *authRequest) {
+	signer, err := s.keys.signer(time.Now())
 	if err != nil {
 		log.Printf("Error getting signer: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -409,28 +759,119 @@ func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	at := rands.HexString(32)
+	at, rt := rands.HexString(32), rands.HexString(32)
+	atr, rtr := *ar, *ar
+	atr.validTill = now.Add(5 * time.Minute)
+	rtr.validTill = now.Add(s.refreshTokenTTL)
 	s.mu.Lock()
-	ar.validTill = now.Add(5 * time.Minute)
-	mak.Set(&s.accessToken, at, ar)
+	s.loadTokensLocked()
+	mak.Set(&s.accessToken, at, &atr)
+	mak.Set(&s.refreshToken, rt, &rtr)
+	err = s.saveTokensLocked()
 	s.mu.Unlock()
+	if err != nil {
+		log.Printf("Error saving tokens: %v", err)
+		http.Error(w, "tsidp: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(oidcTokenResponse{
-		AccessToken: at,
-		TokenType:   "Bearer",
-		ExpiresIn:   5 * 60,
-		IDToken:     token,
+		AccessToken:  at,
+		TokenType:    "Bearer",
+		ExpiresIn:    5 * 60,
+		IDToken:      token,
+		RefreshToken: rt,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// serveRevoke revokes an access or refresh token, as described in RFC 7009.
+func (s *idpServer) serveRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tk := r.FormValue("token")
+	if tk == "" {
+		http.Error(w, "tsidp: token is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.loadTokensLocked()
+	ar, ok := s.refreshToken[tk]
+	if !ok {
+		ar, ok = s.accessToken[tk]
+	}
+	s.mu.Unlock()
+	if !ok {
+		// Unknown tokens are not an error, as they may have already
+		// expired or been revoked.
+		return
+	}
+	if err := s.authenticateClient(r, ar.clientID); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := s.deleteTokens(tk); err != nil {
+		http.Error(w, "tsidp: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// deleteTokens deletes the access or refresh token tk. Errors persisting
+// the deletion are also logged.
+func (s *idpServer) deleteTokens(tk string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadTokensLocked()
+	delete(s.refreshToken, tk)
+	delete(s.accessToken, tk)
+	if err := s.saveTokensLocked(); err != nil {
+		log.Printf("Error saving tokens: %v", err)
+		return err
+	}
+	return nil
+}
+
+// revokeClientTokens revokes all codes and tokens issued to clientID.
+func (s *idpServer) revokeClientTokens(clientID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loadTokensLocked()
+	for _, m := range []map[string]*authRequest{s.code, s.accessToken, s.refreshToken} {
+		for k, ar := range m {
+			if ar.clientID == clientID {
+				delete(m, k)
+			}
+		}
+	}
+	return s.saveTokensLocked()
+}
+
+// verifyPKCE reports whether verifier is a valid PKCE code_verifier for
+// the S256 challenge, as described in RFC 7636.
+func verifyPKCE(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.ContainsRune("-._~", c):
+		default:
+			return false
+		}
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	want := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(challenge)) == 1
+}
+
 type oidcTokenResponse struct {
 	IDToken      string `json:"id_token"`
 	TokenType    string `json:"token_type"`
 	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	ExpiresIn    int    `json:"expires_in"`
 }
 
@@ -439,72 +880,16 @@ type oidcTokenResponse struct {
 	oidcConfigPath = "/.well-known/openid-configuration"
 )
 
-func (s *idpServer) oidcSigner() (jose.Signer, error) {
-	return s.lazySigner.GetErr(func() (jose.Signer, error) {
-		sk, err := s.oidcPrivateKey()
-		if err != nil {
-			return nil, err
-		}
-		return jose.NewSigner(jose.SigningKey{
-			Algorithm: jose.RS256,
-			Key:       sk.k,
-		}, &jose.SignerOptions{EmbedJWK: false, ExtraHeaders: map[jose.HeaderKey]any{
-			jose.HeaderType: "JWT",
-			"kid":           fmt.Sprint(sk.kid),
-		}})
-	})
-}
-
-func (s *idpServer) oidcPrivateKey() (*signingKey, error) {
-	return s.lazySigningKey.GetErr(func() (*signingKey, error) {
-		var sk signingKey
-		b, err := os.ReadFile("oidc-key.json")
-		if err == nil {
-			if err := sk.UnmarshalJSON(b); err == nil {
-				return &sk, nil
-			} else {
-				log.Printf("Error unmarshaling key: %v", err)
-			}
-		}
-		id, k := mustGenRSAKey(2048)
-		sk.k = k
-		sk.kid = id
-		b, err = sk.MarshalJSON()
-		if err != nil {
-			log.Fatalf("Error marshaling key: %v", err)
-		}
-		if err := os.WriteFile("oidc-key.json", b, 0600); err != nil {
-			log.Fatalf("Error writing key: %v", err)
-		}
-		return &sk, nil
-	})
-}
-
 func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != oidcJWKSPath {
 		http.Error(w, "tsidp: not found", http.StatusNotFound)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	sk, err := s.oidcPrivateKey()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
 	// TODO(maisem): maybe only marshal this once and reuse?
-	// TODO(maisem): implement key rotation.
 	je := json.NewEncoder(w)
 	je.SetIndent("", "  ")
-	if err := je.Encode(jose.JSONWebKeySet{
-		Keys: []jose.JSONWebKey{
-			{
-				Key:       sk.k.Public(),
-				Algorithm: string(jose.RS256),
-				Use:       "sig",
-				KeyID:     fmt.Sprint(sk.kid),
-			},
-		},
-	}); err != nil {
+	if err := je.Encode(s.keys.jwks()); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 	return
@@ -513,16 +898,20 @@ func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
 // openIDProviderMetadata is a partial representation of
 // https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata.
 type openIDProviderMetadata struct {
-	Issuer                           string              `json:"issuer"`
-	AuthorizationEndpoint            string              `json:"authorization_endpoint,omitempty"`
-	TokenEndpoint                    string              `json:"token_endpoint,omitempty"`
-	UserInfoEndpoint                 string              `json:"userinfo_endpoint,omitempty"`
-	JWKS_URI                         string              `json:"jwks_uri"`
-	ScopesSupported                  views.Slice[string] `json:"scopes_supported"`
-	ResponseTypesSupported           views.Slice[string] `json:"response_types_supported"`
-	SubjectTypesSupported            views.Slice[string] `json:"subject_types_supported"`
-	ClaimsSupported                  views.Slice[string] `json:"claims_supported"`
-	IDTokenSigningAlgValuesSupported views.Slice[string] `json:"id_token_signing_alg_values_supported"`
+	Issuer                            string              `json:"issuer"`
+	AuthorizationEndpoint             string              `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string              `json:"token_endpoint,omitempty"`
+	UserInfoEndpoint                  string              `json:"userinfo_endpoint,omitempty"`
+	RevocationEndpoint                string              `json:"revocation_endpoint,omitempty"`
+	JWKS_URI                          string              `json:"jwks_uri"`
+	ScopesSupported                   views.Slice[string] `json:"scopes_supported"`
+	ResponseTypesSupported            views.Slice[string] `json:"response_types_supported"`
+	SubjectTypesSupported             views.Slice[string] `json:"subject_types_supported"`
+	ClaimsSupported                   views.Slice[string] `json:"claims_supported"`
+	IDTokenSigningAlgValuesSupported  views.Slice[string] `json:"id_token_signing_alg_values_supported"`
+	GrantTypesSupported               views.Slice[string] `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported     views.Slice[string] `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported views.Slice[string] `json:"token_endpoint_auth_methods_supported"`
 	// TODO(maisem): maybe add other fields?
 	// Currently we fill out the REQUIRED fields, scopes_supported and claims_supported.
 }
@@ -565,11 +954,25 @@ type tailscaleClaims struct {
 	// The other option is "pairwise", which means the identifier is different per receiving 3p.
 	openIDSupportedSubjectTypes = views.SliceOf([]string{"public"})
 
-	// The algo used for signing. The OpenID spec says "The algorithm RS256 MUST be included."
-	// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
-	openIDSupportedSigningAlgos = views.SliceOf([]string{string(jose.RS256)})
+	openIDSupportedGrantTypes = views.SliceOf([]string{"authorization_code", "refresh_token"})
+
+	// Only S256 is supported for PKCE; "plain" offers no protection if the
+	// authorization request is intercepted.
+	openIDSupportedCodeChallengeMethods = views.SliceOf([]string{"S256"})
+
+	// Registered clients authenticate with their client secret. Public
+	// and unregistered clients present no secret.
+	openIDSupportedTokenEndpointAuthMethods = views.SliceOf([]string{"client_secret_basic", "client_secret_post", "none"})
 )
 
+// signingAlgorithms returns the algorithms used to sign ID tokens: those
+// of the published keys. OpenID Connect Discovery requires RS256 to be
+// included, but it's only advertised when there's an RSA key, as clients
+// could otherwise expect tokens signed with it which never come.
+func (s *idpServer) signingAlgorithms() views.Slice[string] {
+	return views.SliceOf(s.keys.algorithms())
+}
+
 func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != oidcConfigPath {
 		http.Error(w, "tsidp: not found", http.StatusNotFound)
@@ -597,36 +1000,25 @@ func (s *idpServer) serveOpenIDConfig(w http.ResponseWriter, r *http.Request) {
 	je := json.NewEncoder(w)
 	je.SetIndent("", "  ")
 	if err := je.Encode(openIDProviderMetadata{
-		AuthorizationEndpoint:            authorizeEndpoint,
-		Issuer:                           rpEndpoint,
-		JWKS_URI:                         rpEndpoint + oidcJWKSPath,
-		UserInfoEndpoint:                 rpEndpoint + "/userinfo",
-		TokenEndpoint:                    rpEndpoint + "/token",
-		ScopesSupported:                  openIDSupportedScopes,
-		ResponseTypesSupported:           openIDSupportedReponseTypes,
-		SubjectTypesSupported:            openIDSupportedSubjectTypes,
-		ClaimsSupported:                  openIDSupportedClaims,
-		IDTokenSigningAlgValuesSupported: openIDSupportedSigningAlgos,
+		AuthorizationEndpoint:             authorizeEndpoint,
+		Issuer:                            rpEndpoint,
+		JWKS_URI:                          rpEndpoint + oidcJWKSPath,
+		UserInfoEndpoint:                  rpEndpoint + "/userinfo",
+		TokenEndpoint:                     rpEndpoint + "/token",
+		RevocationEndpoint:                rpEndpoint + "/revoke",
+		ScopesSupported:                   openIDSupportedScopes,
+		ResponseTypesSupported:            openIDSupportedReponseTypes,
+		SubjectTypesSupported:             openIDSupportedSubjectTypes,
+		ClaimsSupported:                   openIDSupportedClaims,
+		IDTokenSigningAlgValuesSupported:  s.signingAlgorithms(),
+		GrantTypesSupported:               openIDSupportedGrantTypes,
+		CodeChallengeMethodsSupported:     openIDSupportedCodeChallengeMethods,
+		TokenEndpointAuthMethodsSupported: openIDSupportedTokenEndpointAuthMethods,
 	}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-const (
-	minimumRSAKeySize = 2048
-)
-
-// mustGenRSAKey generates a new RSA key with the provided number of bits. It
-// panics on failure. bits must be at least minimumRSAKeySizeBytes * 8.
-func mustGenRSAKey(bits int) (kid uint64, k *rsa.PrivateKey) {
-	if bits < minimumRSAKeySize {
-		panic("request to generate a too-small RSA key")
-	}
-	kid = must.Get(readUint64(crand.Reader))
-	k = must.Get(rsa.GenerateKey(crand.Reader, bits))
-	return
-}
-
 // readUint64 reads from r until 8 bytes represent a non-zero uint64.
 func readUint64(r io.Reader) (uint64, error) {
 	for {
@@ -640,52 +1032,6 @@ func readUint64(r io.Reader) (uint64, error) {
 	}
 }
 
-// rsaPrivateKeyJSONWrapper is the the JSON serialization
-// format used by RSAPrivateKey.
-type rsaPrivateKeyJSONWrapper struct {
-	Key string
-	ID  uint64
-}
-
-type signingKey struct {
-	k   *rsa.PrivateKey
-	kid uint64
-}
-
-func (sk *signingKey) MarshalJSON() ([]byte, error) {
-	b := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(sk.k),
-	}
-	bts := pem.EncodeToMemory(&b)
-	return json.Marshal(rsaPrivateKeyJSONWrapper{
-		Key: base64.URLEncoding.EncodeToString(bts),
-		ID:  sk.kid,
-	})
-}
-
-func (sk *signingKey) UnmarshalJSON(b []byte) error {
-	var wrapper rsaPrivateKeyJSONWrapper
-	if err := json.Unmarshal(b, &wrapper); err != nil {
-		return err
-	}
-	if len(wrapper.Key) == 0 {
-		return nil
-	}
-	b64dec, err := base64.URLEncoding.DecodeString(wrapper.Key)
-	if err != nil {
-		return err
-	}
-	blk, _ := pem.Decode(b64dec)
-	k, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
-	if err != nil {
-		return err
-	}
-	sk.k = k
-	sk.kid = wrapper.ID
-	return nil
-}
-
 // parseID takes a string input and returns a typed IntID T and true, or a zero
 // value and false if the input is unhandled syntax or out of a valid range.
 func parseID[T ~int64](input string) (_ T, ok bool) {
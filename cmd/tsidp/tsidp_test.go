@@ -0,0 +1,321 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	verifier := strings.Repeat("a1-._~", 8)
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{"match", s256(verifier), verifier, true},
+		{"mismatch", s256(verifier), verifier + "x", false},
+		{"plain", verifier, verifier, false},
+		{"too_short", s256("abc"), "abc", false},
+		{"too_long", s256(strings.Repeat("a", 129)), strings.Repeat("a", 129), false},
+		{"bad_chars", s256(verifier + "!"), verifier + "!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyPKCE(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("verifyPKCE = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustAddClient(t *testing.T, cr *clientRegistry, name, redirectURI string, public bool) (*oidcClient, string) {
+	t.Helper()
+	c, secret, err := cr.add(name, []string{redirectURI}, public, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, secret
+}
+
+func TestClientRegistry(t *testing.T) {
+	st := new(mem.Store)
+	cr := must.Get(loadClientRegistry(st, ""))
+
+	if _, _, err := cr.add("no-uris", nil, false, false); err == nil {
+		t.Error("registered client without redirect URIs")
+	}
+	if _, _, err := cr.add("relative", []string{"/callback"}, false, false); err == nil {
+		t.Error("registered client with relative redirect URI")
+	}
+	grafana, secret := mustAddClient(t, cr, "Grafana", "https://grafana.example.ts.net/login/generic_oauth", false)
+	vault, vaultSecret := mustAddClient(t, cr, "Vault", "http://localhost:8250/oidc/callback", true)
+	if secret == "" || vaultSecret != "" {
+		t.Fatalf("secrets = %q, %q; want only a confidential client to have one", secret, vaultSecret)
+	}
+
+	// The registry is persisted, without the secrets themselves.
+	cr = must.Get(loadClientRegistry(st, ""))
+	if got := len(cr.list()); got != 2 {
+		t.Fatalf("reloaded %d clients, want 2", got)
+	}
+	c := cr.get(grafana.ID)
+	if c == nil || c.Name != "Grafana" {
+		t.Fatalf("reloaded client = %+v", c)
+	}
+	if !c.checkSecret(secret) || c.checkSecret("") || c.checkSecret(secret+"x") {
+		t.Error("checkSecret does not match only the client secret")
+	}
+	if c.mustUsePKCE() || !cr.get(vault.ID).mustUsePKCE() {
+		t.Error("only the public client should require PKCE")
+	}
+	if !c.allowsRedirectURI("https://grafana.example.ts.net/login/generic_oauth") || c.allowsRedirectURI("https://grafana.example.ts.net/") {
+		t.Error("allowsRedirectURI does not match only the registered URI")
+	}
+
+	if !must.Get(cr.remove(grafana.ID)) || must.Get(cr.remove(grafana.ID)) {
+		t.Error("remove did not remove the client exactly once")
+	}
+	if cr = must.Get(loadClientRegistry(st, "")); cr.get(grafana.ID) != nil {
+		t.Error("removed client was persisted")
+	}
+
+	// Clients registered by another replica sharing the store are seen.
+	other := must.Get(loadClientRegistry(st, ""))
+	grafana, _ = mustAddClient(t, other, "Grafana", "https://grafana.example.ts.net/login/generic_oauth", false)
+	if cr.get(grafana.ID) == nil {
+		t.Error("client registered by another replica not found")
+	}
+}
+
+func TestImportLegacyClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oidc-clients.json")
+	must.Do(os.WriteFile(path, []byte(`[{"client_id": "abc", "name": "app", "redirect_uris": ["https://app.example/cb"]}]`), 0600))
+	st := new(mem.Store)
+	cr := must.Get(loadClientRegistry(st, path))
+	if c := cr.get("abc"); c == nil || c.Name != "app" {
+		t.Fatalf("imported client = %+v", c)
+	}
+	// Once imported, the store takes precedence over the file.
+	must.Do(os.Remove(path))
+	if c := must.Get(loadClientRegistry(st, path)).get("abc"); c == nil {
+		t.Error("imported client was not persisted in the store")
+	}
+}
+
+func TestTokenClientAuth(t *testing.T) {
+	cr := must.Get(loadClientRegistry(nil, ""))
+	c, secret := mustAddClient(t, cr, "app", "https://app.example/cb", false)
+	verifier := strings.Repeat("v", 43)
+
+	newServer := func() *idpServer {
+		s := &idpServer{clients: cr, refreshTokenTTL: time.Hour}
+		s.code = map[string]*authRequest{
+			"code": {localRP: true, clientID: c.ID, redirectURI: "https://app.example/cb", codeChallenge: s256(verifier)},
+		}
+		s.refreshToken = map[string]*authRequest{
+			"refresh": {localRP: true, clientID: c.ID, validTill: time.Now().Add(time.Hour)},
+			"expired": {localRP: true, clientID: c.ID, validTill: time.Now().Add(-time.Hour)},
+		}
+		return s
+	}
+	post := func(s *idpServer, path string, form url.Values, basicAuth bool) *httptest.ResponseRecorder {
+		if basicAuth {
+			form.Del("client_secret")
+		}
+		r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "127.0.0.1:1234"
+		if basicAuth {
+			r.SetBasicAuth(c.ID, secret)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{
+			name: "wrong_secret",
+			form: url.Values{"grant_type": {"authorization_code"}, "code": {"code"}, "redirect_uri": {"https://app.example/cb"}, "client_id": {c.ID}, "client_secret": {"nope"}, "code_verifier": {verifier}},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "missing_verifier",
+			form: url.Values{"grant_type": {"authorization_code"}, "code": {"code"}, "redirect_uri": {"https://app.example/cb"}, "client_id": {c.ID}, "client_secret": {secret}},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "wrong_verifier",
+			form: url.Values{"grant_type": {"authorization_code"}, "code": {"code"}, "redirect_uri": {"https://app.example/cb"}, "client_id": {c.ID}, "client_secret": {secret}, "code_verifier": {verifier + "w"}},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "refresh_wrong_secret",
+			form: url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh"}, "client_id": {c.ID}, "client_secret": {"nope"}},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh_missing_secret",
+			form: url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"refresh"}, "client_id": {c.ID}},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "refresh_expired",
+			form: url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"expired"}, "client_id": {c.ID}, "client_secret": {secret}},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "refresh_unknown",
+			form: url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"other"}, "client_id": {c.ID}, "client_secret": {secret}},
+			want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer()
+			if rec := post(s, "/token", tt.form, false); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d; body: %s", rec.Code, tt.want, rec.Body)
+			}
+			// Codes may only be presented once, even if the request
+			// fails, but a refresh token is only consumed by a client
+			// that authenticates.
+			if len(s.code) == 1 && tt.form.Get("code") != "" {
+				t.Error("code was not consumed")
+			}
+			if _, ok := s.refreshToken["refresh"]; !ok {
+				t.Error("refresh token was consumed by a failed request")
+			}
+			if _, ok := s.refreshToken["expired"]; ok && tt.form.Get("refresh_token") == "expired" {
+				t.Error("expired refresh token was not deleted")
+			}
+		})
+	}
+
+	t.Run("revoke", func(t *testing.T) {
+		s := newServer()
+		if rec := post(s, "/revoke", url.Values{"token": {"refresh"}, "client_id": {c.ID}, "client_secret": {"nope"}}, false); rec.Code != http.StatusUnauthorized {
+			t.Errorf("revoke with wrong secret: status = %d", rec.Code)
+		}
+		if rec := post(s, "/revoke", url.Values{"token": {"refresh"}}, true); rec.Code != http.StatusOK {
+			t.Errorf("revoke: status = %d; body: %s", rec.Code, rec.Body)
+		}
+		if _, ok := s.refreshToken["refresh"]; ok {
+			t.Error("refresh token was not revoked")
+		}
+		if rec := post(s, "/revoke", url.Values{"token": {"refresh"}}, true); rec.Code != http.StatusOK {
+			t.Errorf("revoke of unknown token: status = %d", rec.Code)
+		}
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		s := newServer()
+		must.Do(s.revokeClientTokens(c.ID))
+		if len(s.code) != 0 || len(s.refreshToken) != 0 {
+			t.Error("tokens of unregistered client were not revoked")
+		}
+	})
+}
+
+func TestExpireTokens(t *testing.T) {
+	now := time.Now()
+	s := &idpServer{
+		accessToken: map[string]*authRequest{
+			"old": {validTill: now.Add(-time.Minute)},
+			"new": {validTill: now.Add(time.Minute)},
+		},
+		refreshToken: map[string]*authRequest{
+			"old": {validTill: now.Add(-time.Minute)},
+			"new": {validTill: now.Add(time.Hour)},
+		},
+	}
+	s.expireTokens(now)
+	for _, m := range []map[string]*authRequest{s.accessToken, s.refreshToken} {
+		if _, ok := m["old"]; ok {
+			t.Error("expired token was kept")
+		}
+		if _, ok := m["new"]; !ok {
+			t.Error("valid token was deleted")
+		}
+	}
+}
+
+func TestTokensPersisted(t *testing.T) {
+	st := new(mem.Store)
+	who := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ID: 1, Name: "foo.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: "foo@example.com"},
+	}
+	s1 := &idpServer{store: st}
+	s1.mu.Lock()
+	s1.code = map[string]*authRequest{"code": {localRP: true, clientID: "app", codeChallenge: "challenge"}}
+	s1.refreshToken = map[string]*authRequest{"refresh": {rpNodeID: 2, remoteUser: who, validTill: time.Now().Add(time.Hour)}}
+	must.Do(s1.saveTokensLocked())
+	s1.mu.Unlock()
+
+	// Another replica, or tsidp after a restart, sees the same tokens.
+	s2 := &idpServer{store: st}
+	s2.mu.Lock()
+	s2.loadTokensLocked()
+	s2.mu.Unlock()
+	if got, want := s2.code["code"], s1.code["code"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("code = %+v, want %+v", got, want)
+	}
+	if got, want := s2.refreshToken["refresh"], s1.refreshToken["refresh"]; got == nil || got.rpNodeID != want.rpNodeID || !got.validTill.Equal(want.validTill) ||
+		got.remoteUser.Node.Name != who.Node.Name || got.remoteUser.UserProfile.LoginName != who.UserProfile.LoginName {
+		t.Errorf("refresh token = %+v, want %+v", got, want)
+	}
+
+	must.Do(s2.deleteTokens("refresh"))
+	s1.mu.Lock()
+	s1.loadTokensLocked()
+	_, ok := s1.refreshToken["refresh"]
+	s1.mu.Unlock()
+	if ok {
+		t.Error("refresh token deleted by another replica is still valid")
+	}
+}
+
+func TestServeClientsLocalAdmin(t *testing.T) {
+	cr := must.Get(loadClientRegistry(nil, ""))
+	for _, localAdmin := range []bool{false, true} {
+		s := &idpServer{clients: cr, localAdmin: localAdmin}
+		r := httptest.NewRequest("GET", "/clients", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		want := http.StatusForbidden
+		if localAdmin {
+			want = http.StatusOK
+		}
+		if rec.Code != want {
+			t.Errorf("localAdmin=%v: status = %d, want %d", localAdmin, rec.Code, want)
+		}
+	}
+}
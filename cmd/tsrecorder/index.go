@@ -0,0 +1,244 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// castHeader is the first line of a recording uploaded by tailssh, in
+// asciinema's cast v2 format. It holds the fields of tailssh.CastHeader
+// which are indexed.
+type castHeader struct {
+	Version       int                  `json:"version"`
+	Width         int                  `json:"width"`
+	Height        int                  `json:"height"`
+	Timestamp     int64                `json:"timestamp"`
+	Command       string               `json:"command,omitempty"`
+	Subsystem     string               `json:"subsystem,omitempty"`
+	SrcNode       string               `json:"srcNode"`
+	SrcNodeID     tailcfg.StableNodeID `json:"srcNodeID"`
+	SrcNodeTags   []string             `json:"srcNodeTags,omitempty"`
+	SrcNodeUser   string               `json:"srcNodeUser,omitempty"`
+	SSHUser       string               `json:"sshUser"`
+	LocalUser     string               `json:"localUser"`
+	ConnectionID  string               `json:"connectionID"`
+	SrcNodeUserID tailcfg.UserID       `json:"srcNodeUserID,omitempty"`
+}
+
+// session is the metadata of a recorded SSH session. It is stored
+// alongside the recording, so that the index can be rebuilt from the
+// store.
+type session struct {
+	// ID identifies the recording. It is the name of the recording in
+	// the store, without the ".cast" extension.
+	ID string `json:"id"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// DstNode is the name of the node running the SSH server, which
+	// uploaded the recording.
+	DstNode   string               `json:"dstNode"`
+	DstNodeID tailcfg.StableNodeID `json:"dstNodeID"`
+
+	SrcNode     string               `json:"srcNode"`
+	SrcNodeID   tailcfg.StableNodeID `json:"srcNodeID"`
+	SrcNodeUser string               `json:"srcNodeUser,omitempty"`
+	SrcNodeTags []string             `json:"srcNodeTags,omitempty"`
+
+	// SrcNodeUserID is the ID of SrcNodeUser. It is zero for tagged nodes,
+	// and for recordings indexed before it was added.
+	SrcNodeUserID tailcfg.UserID `json:"srcNodeUserID,omitempty"`
+
+	SSHUser      string `json:"sshUser"`
+	LocalUser    string `json:"localUser"`
+	Command      string `json:"command,omitempty"`
+	Subsystem    string `json:"subsystem,omitempty"` // such as "sftp"; empty for shells and commands
+	ConnectionID string `json:"connectionID"`
+
+	// Size is the size of the recording in bytes.
+	Size int64 `json:"size"`
+
+	// Complete is whether the upload completed, rather than the
+	// connection from the SSH server being lost.
+	Complete bool `json:"complete"`
+}
+
+// who returns the user or tags that connected, for display.
+func (s *session) who() string {
+	if s.SrcNodeUser != "" {
+		return s.SrcNodeUser
+	}
+	return strings.Join(s.SrcNodeTags, ",")
+}
+
+// sessionFilter selects sessions from the index. Zero fields match all
+// sessions.
+type sessionFilter struct {
+	User  string    // matches the connecting user or tag, or the SSH or local user
+	Node  string    // matches the source or destination node name or ID
+	Since time.Time // matches sessions which ended at or after Since
+	Until time.Time // matches sessions which started before Until
+}
+
+func (f *sessionFilter) match(s *session) bool {
+	if f.User != "" && !strings.EqualFold(f.User, s.SrcNodeUser) && f.User != s.SSHUser && f.User != s.LocalUser && !containsFold(s.SrcNodeTags, f.User) {
+		return false
+	}
+	if f.Node != "" && !nodeMatches(f.Node, s.SrcNode, s.SrcNodeID) && !nodeMatches(f.Node, s.DstNode, s.DstNodeID) {
+		return false
+	}
+	if !f.Since.IsZero() && s.End.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !s.Start.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// nodeMatches reports whether q is the stable ID of a node, its FQDN, or
+// the first label of its FQDN.
+func nodeMatches(q, name string, id tailcfg.StableNodeID) bool {
+	if q == string(id) || strings.EqualFold(q, name) {
+		return true
+	}
+	host, _, _ := strings.Cut(name, ".")
+	return strings.EqualFold(q, host)
+}
+
+func containsFold(ss []string, s string) bool {
+	for _, v := range ss {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// index is the in-memory index of recorded sessions.
+type index struct {
+	mu       sync.Mutex
+	sessions map[string]*session // keyed by ID
+}
+
+func newIndex() *index {
+	return &index{sessions: make(map[string]*session)}
+}
+
+func (ix *index) add(s *session) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.sessions[s.ID] = s
+}
+
+func (ix *index) remove(id string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	delete(ix.sessions, id)
+}
+
+func (ix *index) get(id string) (*session, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	s, ok := ix.sessions[id]
+	return s, ok
+}
+
+// find returns the sessions matching f, most recent first.
+func (ix *index) find(f sessionFilter) []*session {
+	ix.mu.Lock()
+	var ret []*session
+	for _, s := range ix.sessions {
+		if f.match(s) {
+			ret = append(ret, s)
+		}
+	}
+	ix.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Start.Equal(ret[j].Start) {
+			return ret[i].Start.After(ret[j].Start)
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// startedBefore returns the sessions which started before t.
+func (ix *index) startedBefore(t time.Time) []*session {
+	return ix.find(sessionFilter{Until: t})
+}
+
+// maxCastLine is the longest line of a recording which castParser parses.
+// Longer lines are skipped.
+const maxCastLine = 1 << 20
+
+// castParser is an io.Writer which parses a recording as it is written,
+// to find its header and the time of its last event.
+type castParser struct {
+	header    *castHeader // nil until the header is parsed
+	lastEvent float64     // seconds after the start of the last event
+	lines     int
+	buf       []byte
+	skipping  bool // whether the current line is too long to parse
+}
+
+func (p *castParser) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			p.appendLine(b)
+			break
+		}
+		p.appendLine(b[:i])
+		if !p.skipping {
+			p.parseLine(p.buf)
+		}
+		p.lines++
+		p.buf = p.buf[:0]
+		p.skipping = false
+		b = b[i+1:]
+	}
+	return n, nil
+}
+
+func (p *castParser) appendLine(b []byte) {
+	if p.skipping {
+		return
+	}
+	if len(p.buf)+len(b) > maxCastLine {
+		p.skipping = true
+		p.buf = p.buf[:0]
+		return
+	}
+	p.buf = append(p.buf, b...)
+}
+
+func (p *castParser) parseLine(line []byte) {
+	if p.lines == 0 {
+		var h castHeader
+		if json.Unmarshal(line, &h) == nil {
+			p.header = &h
+		}
+		return
+	}
+	// Events are of the form [time, code, data].
+	var ev []json.RawMessage
+	if json.Unmarshal(line, &ev) != nil || len(ev) == 0 {
+		return
+	}
+	var t float64
+	if json.Unmarshal(ev[0], &t) == nil && t > p.lastEvent {
+		p.lastEvent = t
+	}
+}
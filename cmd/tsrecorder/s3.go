@@ -0,0 +1,193 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// s3Store is a store in an S3-compatible bucket, accessed with path-style
+// requests so that it works with other implementations, such as MinIO.
+//
+// Rather than using the full AWS SDK, it makes the few requests it needs
+// itself, signed with the SDK's request signer.
+type s3Store struct {
+	endpoint *url.URL // like "https://s3.us-east-1.amazonaws.com"
+	region   string
+	bucket   string
+	prefix   string // prepended to object names, if non-empty
+	creds    aws.CredentialsProvider
+	signer   *v4.Signer
+	hc       *http.Client
+}
+
+// newS3Store returns a store for the objects under prefix in bucket. If
+// endpoint is empty, the AWS endpoint for the region is used. Credentials
+// (and, if region is empty, the region) are loaded as by the AWS CLI.
+func newS3Store(ctx context.Context, endpoint, region, bucket, prefix string) (*s3Store, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured for S3")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &s3Store{
+		endpoint: u,
+		region:   cfg.Region,
+		bucket:   bucket,
+		prefix:   strings.Trim(prefix, "/"),
+		creds:    cfg.Credentials,
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			// S3 object keys are not escaped twice.
+			o.DisableURIPathEscaping = true
+		}),
+		hc: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// key returns the object key of name.
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// do makes a request to the object key (or to the bucket, if key is
+// empty), returning the response if its status is 2xx.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		var segs []string
+		for _, seg := range strings.Split(key, "/") {
+			segs = append(segs, url.PathEscape(seg))
+		}
+		u.RawPath = u.Path + "/" + strings.Join(segs, "/")
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	creds, err := s.creds.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting AWS credentials: %w", err)
+	}
+	if err := s.signer.SignHTTP(ctx, creds, req, payloadHash, "s3", s.region, time.Now()); err != nil {
+		return nil, err
+	}
+	res, err := s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		err := fmt.Errorf("s3: %s %s: %s: %s", method, key, res.Status, strings.TrimSpace(string(msg)))
+		if res.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+		}
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	res, err := s.do(ctx, "PUT", s.key(name), nil, r, size)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	res, err := s.do(ctx, "GET", s.key(name), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, name string) error {
+	res, err := s.do(ctx, "DELETE", s.key(name), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// listBucketResult is the response to a ListObjectsV2 request.
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) List(ctx context.Context, suffix string) ([]string, error) {
+	var ret []string
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	q := url.Values{"list-type": {"2"}}
+	if prefix != "" {
+		q.Set("prefix", prefix)
+	}
+	for {
+		res, err := s.do(ctx, "GET", "", q, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var lr listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&lr)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: parsing object list: %w", err)
+		}
+		for _, c := range lr.Contents {
+			if name, ok := strings.CutPrefix(c.Key, prefix); ok && strings.HasSuffix(name, suffix) {
+				ret = append(ret, name)
+			}
+		}
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return ret, nil
+		}
+		q.Set("continuation-token", lr.NextContinuationToken)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A store holds recordings and their metadata, as named objects. Names
+// are slash-separated paths.
+type store interface {
+	// Put stores the object name, with contents read from r, which is
+	// size bytes long.
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+
+	// Get returns the contents of the object name. The error wraps
+	// fs.ErrNotExist if there is no such object.
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Delete deletes the object name. It is not an error for it not to
+	// exist.
+	Delete(ctx context.Context, name string) error
+
+	// List returns the names of all objects whose names end with suffix.
+	List(ctx context.Context, suffix string) ([]string, error)
+}
+
+// newStore returns the store for dst, which is either a local directory
+// or an S3 bucket as "s3://bucket/prefix".
+func newStore(ctx context.Context, dst, s3Endpoint, s3Region string) (store, error) {
+	if rest, ok := strings.CutPrefix(dst, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 destination %q: no bucket", dst)
+		}
+		return newS3Store(ctx, s3Endpoint, s3Region, bucket, prefix)
+	}
+	if err := os.MkdirAll(dst, 0700); err != nil {
+		return nil, err
+	}
+	return &diskStore{dir: dst}, nil
+}
+
+// diskStore is a store in a local directory.
+type diskStore struct {
+	dir string
+}
+
+// path returns the local path of the object name.
+func (s *diskStore) path(name string) (string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
+
+func (s *diskStore) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (s *diskStore) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (s *diskStore) Delete(ctx context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// Remove empty parent directories, ignoring errors for those which
+	// are not empty.
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(filepath.Join(s.dir, filepath.FromSlash(dir))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *diskStore) List(ctx context.Context, suffix string) (ret []string, err error) {
+	err = filepath.WalkDir(s.dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !de.Type().IsRegular() || !strings.HasSuffix(p, suffix) || strings.HasPrefix(de.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, filepath.ToSlash(rel))
+		return nil
+	})
+	return ret, err
+}
@@ -0,0 +1,435 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tsrecorder command is a server for Tailscale SSH session recordings.
+//
+// It joins the tailnet with tsnet, and accepts the recordings that
+// Tailscale SSH servers upload to the recorders listed in the "recorder"
+// field of SSH rules. Recordings are stored in asciinema's cast format,
+// either in a local directory or in an S3-compatible bucket, and may be
+// deleted after a retention period. Uploads are spooled to a local
+// directory until they are complete, and those left there if tsrecorder
+// stops are stored when it starts again.
+//
+// Recordings are indexed by user, node and time, and may be searched and
+// played back with a web UI served over HTTPS. Access to both the
+// recording endpoint and the UI is controlled by the tailnet policy file.
+// Recordings are only accepted from tagged nodes: the identities of the
+// users connecting to the SSH server are taken from the recording, and
+// only servers controlled by the tailnet's admins are trusted with them.
+// Additionally, users of the UI need the tailscale.com/cap/tsrecorder-ui
+// capability to play back their own sessions, or the
+// tailscale.com/cap/tsrecorder-admin capability to play back everyone's.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/rands"
+)
+
+var (
+	flagHostname   = flag.String("hostname", "recorder", "hostname to use on the tailnet")
+	flagStateDir   = flag.String("state-dir", "", "directory for tsnet state; if empty, a default is used")
+	flagPort       = flag.Int("port", 80, "port to accept recordings on")
+	flagUI         = flag.Bool("ui", true, "serve the web UI over HTTPS on port 443")
+	flagDst        = flag.String("dst", "recordings", `where to store recordings: a local directory, or "s3://bucket/prefix"`)
+	flagS3Endpoint = flag.String("s3-endpoint", "", "URL of an S3-compatible service; if empty, AWS S3 is used")
+	flagS3Region   = flag.String("s3-region", "", "region of the S3 bucket; if empty, the AWS default region is used")
+	flagRetention  = flag.Duration("retention", 0, "how long to keep recordings for, or 0 to keep them forever")
+	flagSpoolDir   = flag.String("spool-dir", "", "directory for recordings being uploaded, which are stored on restart if tsrecorder stops; if empty, a directory in the system temporary directory is used")
+	flagMaxSize    = flag.Int64("max-recording-size", 1<<30, "maximum size of a recording in bytes; longer sessions are truncated and ended")
+	flagVerbose    = flag.Bool("verbose", false, "be verbose")
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+
+	st, err := newStore(ctx, *flagDst, *flagS3Endpoint, *flagS3Region)
+	if err != nil {
+		log.Fatalf("opening recording store: %v", err)
+	}
+
+	ts := &tsnet.Server{
+		Hostname: *flagHostname,
+		Dir:      *flagStateDir,
+	}
+	if !*flagVerbose {
+		ts.Logf = logger.Discard
+	}
+	if _, err := ts.Up(ctx); err != nil {
+		log.Fatal(err)
+	}
+	lc, err := ts.LocalClient()
+	if err != nil {
+		log.Fatalf("getting local client: %v", err)
+	}
+
+	spoolDir := *flagSpoolDir
+	if spoolDir == "" {
+		spoolDir = filepath.Join(os.TempDir(), "tsrecorder-"+*flagHostname)
+	}
+	if err := os.MkdirAll(spoolDir, 0700); err != nil {
+		log.Fatalf("creating spool directory: %v", err)
+	}
+	rec := &recorder{
+		store:     st,
+		index:     newIndex(),
+		whoIs:     lc.WhoIs,
+		spoolDir:  spoolDir,
+		maxSize:   *flagMaxSize,
+		retention: *flagRetention,
+	}
+	if err := rec.loadIndex(ctx); err != nil {
+		log.Fatalf("loading recordings: %v", err)
+	}
+	if err := rec.recoverSpool(ctx); err != nil {
+		log.Fatalf("recovering interrupted recordings: %v", err)
+	}
+	if rec.retention > 0 {
+		go rec.runRetention(ctx)
+	}
+
+	ln, err := ts.Listen("tcp", fmt.Sprintf(":%d", *flagPort))
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/record", rec.serveRecord)
+	go serve(ln, mux)
+
+	if *flagUI {
+		ln, err := ts.ListenTLS("tcp", ":443")
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(ln, rec.uiHandler())
+	}
+	log.Printf("Running tsrecorder as %q, storing recordings in %s ...", *flagHostname, *flagDst)
+	select {}
+}
+
+func serve(ln net.Listener, h http.Handler) {
+	log.Fatal(http.Serve(ln, h))
+}
+
+// recorder accepts, stores and serves SSH session recordings.
+type recorder struct {
+	store     store
+	index     *index
+	whoIs     func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+	spoolDir  string        // directory for uploads in progress
+	maxSize   int64         // maximum size of a recording in bytes
+	retention time.Duration // how long to keep recordings, or zero for forever
+}
+
+// Recordings are stored as "<id>.cast", with their metadata, a JSON
+// session, in "<id>.json".
+const (
+	castSuffix = ".cast"
+	metaSuffix = ".json"
+)
+
+// spoolPrefix is the prefix of the names of uploads in the spool directory.
+// Each upload is spooled to "<spoolPrefix><random><castSuffix>", and the
+// spooledUpload it is from to the same name with metaSuffix.
+const spoolPrefix = "upload-"
+
+// spooledUpload is the metadata of an upload in the spool directory which
+// is not in the recording itself, so that the upload can be stored if
+// tsrecorder stops before it is complete.
+type spooledUpload struct {
+	DstNode   string               `json:"dstNode"`
+	DstNodeID tailcfg.StableNodeID `json:"dstNodeID"`
+}
+
+// newSession returns the session of a recording of n bytes uploaded by
+// up, parsed by p, which must have parsed the header.
+func newSession(p *castParser, up spooledUpload, n int64, complete bool) *session {
+	h := p.header
+	start := time.Unix(h.Timestamp, 0)
+	return &session{
+		ID:            start.UTC().Format("2006/01/02/150405") + "-" + rands.HexString(8),
+		Start:         start,
+		End:           start.Add(time.Duration(p.lastEvent * float64(time.Second))),
+		DstNode:       up.DstNode,
+		DstNodeID:     up.DstNodeID,
+		SrcNode:       h.SrcNode,
+		SrcNodeID:     h.SrcNodeID,
+		SrcNodeUser:   h.SrcNodeUser,
+		SrcNodeUserID: h.SrcNodeUserID,
+		SrcNodeTags:   h.SrcNodeTags,
+		SSHUser:       h.SSHUser,
+		LocalUser:     h.LocalUser,
+		Command:       h.Command,
+		Subsystem:     h.Subsystem,
+		ConnectionID:  h.ConnectionID,
+		Size:          n,
+		Complete:      complete,
+	}
+}
+
+// serveRecord accepts a recording uploaded by a Tailscale SSH server. The
+// recording is streamed in the request body for the length of the session.
+func (rec *recorder) serveRecord(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	who, err := rec.whoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		log.Printf("recording from %v: WhoIs: %v", r.RemoteAddr, err)
+		http.Error(w, "unknown peer", http.StatusForbidden)
+		return
+	}
+	// The source node and user of the session can't be checked, as
+	// they're only known to the SSH server. A user's own node could
+	// attribute sessions to anyone, so only tagged nodes may upload.
+	if !who.Node.IsTagged() {
+		log.Printf("recording from %v: rejecting upload from untagged node %s", r.RemoteAddr, who.Node.Name)
+		http.Error(w, "recordings are only accepted from tagged nodes", http.StatusForbidden)
+		return
+	}
+	up := spooledUpload{
+		DstNode:   strings.TrimSuffix(who.Node.Name, "."),
+		DstNodeID: who.Node.StableID,
+	}
+
+	// The upload is spooled to a local file, as S3 needs to know its size.
+	// The file is kept if tsrecorder stops, and stored by recoverSpool
+	// when it starts again.
+	f, err := os.CreateTemp(rec.spoolDir, spoolPrefix+"*"+castSuffix)
+	if err != nil {
+		log.Printf("recording from %v: %v", r.RemoteAddr, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	metaPath := strings.TrimSuffix(f.Name(), castSuffix) + metaSuffix
+	meta, err := json.Marshal(up)
+	if err == nil {
+		err = os.WriteFile(metaPath, meta, 0600)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("recording from %v: %v", r.RemoteAddr, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// If the recording can't be stored, the upload is left for
+	// recoverSpool to try again.
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(f.Name())
+			os.Remove(metaPath)
+		}
+	}()
+
+	// Reading the body sends the 100 Continue response which the SSH
+	// server waits for before starting the session. Recordings larger
+	// than the maximum size are truncated.
+	var p castParser
+	n, copyErr := io.Copy(io.MultiWriter(f, &p), io.LimitReader(r.Body, rec.maxSize+1))
+	tooLarge := n > rec.maxSize
+	if tooLarge {
+		n = rec.maxSize
+		copyErr = f.Truncate(n)
+	}
+	if p.header == nil {
+		log.Printf("recording from %v: invalid recording (%d bytes, err=%v)", r.RemoteAddr, n, copyErr)
+		http.Error(w, "invalid recording", http.StatusBadRequest)
+		return
+	}
+
+	s := newSession(&p, up, n, copyErr == nil && !tooLarge)
+	if tooLarge {
+		log.Printf("recording %s from %v: truncated at maximum size of %d bytes", s.ID, s.DstNode, n)
+	} else if copyErr != nil {
+		log.Printf("recording %s from %v: upload interrupted after %d bytes: %v", s.ID, s.DstNode, n, copyErr)
+	}
+
+	// Store what was received, even if the upload was interrupted.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Minute)
+	defer cancel()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.Printf("recording %s: %v", s.ID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := rec.save(ctx, s, f); err != nil {
+		keep = true
+		log.Printf("recording %s: saving: %v", s.ID, err)
+		http.Error(w, "error saving recording", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("recorded session %s: %s as %s@%s (%d bytes)", s.ID, s.who(), s.LocalUser, s.DstNode, s.Size)
+	if tooLarge {
+		http.Error(w, "recording too large", http.StatusRequestEntityTooLarge)
+	}
+}
+
+// recoverSpool stores the uploads left in the spool directory by
+// tsrecorder stopping before they were complete.
+func (rec *recorder) recoverSpool(ctx context.Context) error {
+	metas, err := filepath.Glob(filepath.Join(rec.spoolDir, spoolPrefix+"*"+metaSuffix))
+	if err != nil {
+		return err
+	}
+	for _, metaPath := range metas {
+		castPath := strings.TrimSuffix(metaPath, metaSuffix) + castSuffix
+		if err := rec.recoverUpload(ctx, metaPath, castPath); err != nil {
+			// Leave the upload to be tried again, unless it can never
+			// be stored.
+			log.Printf("recovering %s: %v", castPath, err)
+			continue
+		}
+		os.Remove(castPath)
+		os.Remove(metaPath)
+	}
+	return nil
+}
+
+// recoverUpload stores the spooled upload at castPath, with its
+// spooledUpload at metaPath. Uploads which are not valid recordings are
+// discarded.
+func (rec *recorder) recoverUpload(ctx context.Context, metaPath, castPath string) error {
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		return err
+	}
+	var up spooledUpload
+	if err := json.Unmarshal(meta, &up); err != nil {
+		log.Printf("discarding %s: %v", castPath, err)
+		return nil
+	}
+	f, err := os.Open(castPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	var p castParser
+	n, err := io.Copy(&p, f)
+	if err != nil {
+		return err
+	}
+	if p.header == nil {
+		log.Printf("discarding %s: invalid recording (%d bytes)", castPath, n)
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s := newSession(&p, up, n, false)
+	if err := rec.save(ctx, s, f); err != nil {
+		return err
+	}
+	log.Printf("recovered interrupted recording %s: %s as %s@%s (%d bytes)", s.ID, s.who(), s.LocalUser, s.DstNode, s.Size)
+	return nil
+}
+
+// save stores the recording of s, read from r, and its metadata, and
+// adds it to the index.
+func (rec *recorder) save(ctx context.Context, s *session, r io.Reader) error {
+	if err := rec.store.Put(ctx, s.ID+castSuffix, r, s.Size); err != nil {
+		return err
+	}
+	meta, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := rec.store.Put(ctx, s.ID+metaSuffix, strings.NewReader(string(meta)), int64(len(meta))); err != nil {
+		return err
+	}
+	rec.index.add(s)
+	return nil
+}
+
+// loadIndex indexes the recordings in the store.
+func (rec *recorder) loadIndex(ctx context.Context) error {
+	names, err := rec.store.List(ctx, metaSuffix)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		s, err := rec.loadSession(ctx, name)
+		if err != nil {
+			log.Printf("skipping %s: %v", name, err)
+			continue
+		}
+		rec.index.add(s)
+	}
+	log.Printf("indexed %d recordings", len(names))
+	return nil
+}
+
+func (rec *recorder) loadSession(ctx context.Context, name string) (*session, error) {
+	rc, err := rec.store.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var s session
+	if err := json.NewDecoder(rc).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.ID+metaSuffix != name {
+		return nil, fmt.Errorf("metadata is for recording %q", s.ID)
+	}
+	return &s, nil
+}
+
+// runRetention deletes expired recordings periodically.
+func (rec *recorder) runRetention(ctx context.Context) {
+	for {
+		if err := rec.deleteExpired(ctx, time.Now()); err != nil {
+			log.Printf("deleting expired recordings: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Hour):
+		}
+	}
+}
+
+// deleteExpired deletes the recordings of sessions which started more
+// than the retention period before now.
+func (rec *recorder) deleteExpired(ctx context.Context, now time.Time) error {
+	var errs []error
+	for _, s := range rec.index.startedBefore(now.Add(-rec.retention)) {
+		// Delete the metadata last, so that the recording is still
+		// indexed on restart if deleting it fails.
+		if err := rec.store.Delete(ctx, s.ID+castSuffix); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := rec.store.Delete(ctx, s.ID+metaSuffix); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rec.index.remove(s.ID)
+		log.Printf("deleted expired recording %s", s.ID)
+	}
+	return errors.Join(errs...)
+}
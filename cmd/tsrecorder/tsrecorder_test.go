@@ -0,0 +1,502 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/must"
+)
+
+var t0 = time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+
+// testCast returns a recording of a session starting at start, as
+// uploaded by tailssh.
+func testCast(start time.Time, user string, events ...string) string {
+	h := must.Get(json.Marshal(map[string]any{
+		"version":      2,
+		"width":        80,
+		"height":       24,
+		"timestamp":    start.Unix(),
+		"srcNode":      "laptop.example.ts.net",
+		"srcNodeID":    "nLAPTOP",
+		"srcNodeUser":  user,
+		"sshUser":      "root",
+		"localUser":    "root",
+		"connectionID": "conn1",
+	}))
+	var b strings.Builder
+	b.Write(h)
+	b.WriteByte('\n')
+	for i, e := range events {
+		fmt.Fprintf(&b, "[%d.5,\"o\",%q]\n", i, e)
+	}
+	return b.String()
+}
+
+func TestCastParser(t *testing.T) {
+	cast := testCast(t0, "alice@example.com", "hello\r\n", "world\r\n")
+	// Long lines are skipped.
+	cast += "[9.0,\"o\",\"" + strings.Repeat("x", maxCastLine) + "\"]\n" + "[3.25,\"o\",\"\"]\n"
+
+	// Write in small pieces, as uploads arrive.
+	var p castParser
+	for b := []byte(cast); len(b) > 0; {
+		n := min(len(b), 7)
+		must.Get(p.Write(b[:n]))
+		b = b[n:]
+	}
+	if p.header == nil {
+		t.Fatal("header not parsed")
+	}
+	if p.header.SrcNodeUser != "alice@example.com" || p.header.SrcNodeID != "nLAPTOP" || p.header.Timestamp != t0.Unix() {
+		t.Errorf("header = %+v", p.header)
+	}
+	if p.lastEvent != 3.25 {
+		t.Errorf("lastEvent = %v, want 3.25", p.lastEvent)
+	}
+
+	var bad castParser
+	bad.Write([]byte("not json\n[1.0, \"o\", \"x\"]\n"))
+	if bad.header != nil {
+		t.Errorf("parsed header %+v from invalid recording", bad.header)
+	}
+}
+
+func TestSessionFilter(t *testing.T) {
+	ix := newIndex()
+	add := func(id string, start time.Time, user string, tags []string, dst string) {
+		ix.add(&session{
+			ID:          id,
+			Start:       start,
+			End:         start.Add(time.Hour),
+			SrcNode:     "laptop.example.ts.net",
+			SrcNodeID:   "nLAPTOP",
+			SrcNodeUser: user,
+			SrcNodeTags: tags,
+			DstNode:     dst,
+			DstNodeID:   tailcfg.StableNodeID("n" + strings.ToUpper(strings.Split(dst, ".")[0])),
+			SSHUser:     "root",
+			LocalUser:   "root",
+		})
+	}
+	add("a", t0, "alice@example.com", nil, "web.example.ts.net")
+	add("b", t0.Add(24*time.Hour), "bob@example.com", nil, "db.example.ts.net")
+	add("c", t0.Add(48*time.Hour), "", []string{"tag:ci"}, "web.example.ts.net")
+
+	ids := func(ss []*session) (ret []string) {
+		for _, s := range ss {
+			ret = append(ret, s.ID)
+		}
+		return ret
+	}
+	tests := []struct {
+		name string
+		f    sessionFilter
+		want []string
+	}{
+		{"all", sessionFilter{}, []string{"c", "b", "a"}},
+		{"user", sessionFilter{User: "Alice@example.com"}, []string{"a"}},
+		{"tag", sessionFilter{User: "tag:ci"}, []string{"c"}},
+		{"local_user", sessionFilter{User: "root"}, []string{"c", "b", "a"}},
+		{"node_host", sessionFilter{Node: "web"}, []string{"c", "a"}},
+		{"node_fqdn", sessionFilter{Node: "db.example.ts.net"}, []string{"b"}},
+		{"node_id", sessionFilter{Node: "nDB"}, []string{"b"}},
+		{"src_node_id", sessionFilter{Node: "nLAPTOP"}, []string{"c", "b", "a"}},
+		{"since", sessionFilter{Since: t0.Add(24*time.Hour + 30*time.Minute)}, []string{"c", "b"}},
+		{"until", sessionFilter{Until: t0.Add(24 * time.Hour)}, []string{"a"}},
+		{"range", sessionFilter{Since: t0.Add(2 * time.Hour), Until: t0.Add(30 * time.Hour), Node: "db"}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(ix.find(tt.f)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testUsers are the users of the UI in tests, by address.
+var testUsers = map[string]struct {
+	id    tailcfg.UserID
+	login string
+	caps  tailcfg.PeerCapMap
+}{
+	"100.64.0.2": {1, "alice@example.com", tailcfg.PeerCapMap{peerCapRecorderUI: nil}},
+	"100.64.0.3": {2, "admin@example.com", tailcfg.PeerCapMap{peerCapRecorderAdmin: nil}},
+	"100.64.0.4": {3, "bob@example.com", nil},
+}
+
+// newTestRecorder returns a recorder which accepts recordings from the
+// tagged node at 100.64.0.1, and UI requests from testUsers.
+func newTestRecorder(t *testing.T) *recorder {
+	return &recorder{
+		store: &diskStore{dir: t.TempDir()},
+		index: newIndex(),
+		whoIs: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			ip, _, _ := strings.Cut(remoteAddr, ":")
+			if ip == "100.64.0.1" {
+				return &apitype.WhoIsResponse{
+					Node:        &tailcfg.Node{Name: "web.example.ts.net.", StableID: "nWEB", Tags: []string{"tag:web"}},
+					UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+				}, nil
+			}
+			u, ok := testUsers[ip]
+			if !ok {
+				return nil, errors.New("not a peer")
+			}
+			return &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{Name: "laptop.example.ts.net.", User: u.id},
+				UserProfile: &tailcfg.UserProfile{ID: u.id, LoginName: u.login},
+				CapMap:      u.caps,
+			}, nil
+		},
+		spoolDir: t.TempDir(),
+		maxSize:  1 << 20,
+	}
+}
+
+// upload posts a recording to rec, as the SSH server at 100.64.0.1 would.
+func upload(rec *recorder, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/record", body)
+	req.RemoteAddr = "100.64.0.1:1234"
+	w := httptest.NewRecorder()
+	rec.serveRecord(w, req)
+	return w
+}
+
+// errReader returns its contents, followed by an error, like a connection
+// which was lost.
+type errReader struct {
+	r io.Reader
+}
+
+func (e errReader) Read(b []byte) (int, error) {
+	n, err := e.r.Read(b)
+	if err == io.EOF {
+		err = errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestServeRecord(t *testing.T) {
+	rec := newTestRecorder(t)
+	ctx := context.Background()
+
+	cast := testCast(t0, "alice@example.com", "$ ls\r\n", "file\r\n")
+	if w := upload(rec, strings.NewReader(cast)); w.Code != 200 {
+		t.Fatalf("upload: %v %s", w.Code, w.Body)
+	}
+	ss := rec.index.find(sessionFilter{})
+	if len(ss) != 1 {
+		t.Fatalf("indexed %d sessions, want 1", len(ss))
+	}
+	s := ss[0]
+	if !strings.HasPrefix(s.ID, "2024/01/31/120000-") {
+		t.Errorf("ID = %q", s.ID)
+	}
+	if s.DstNode != "web.example.ts.net" || s.DstNodeID != "nWEB" || s.SrcNodeUser != "alice@example.com" || !s.Complete || s.Size != int64(len(cast)) {
+		t.Errorf("session = %+v", s)
+	}
+	if want := t0.Add(1500 * time.Millisecond); !s.End.Equal(want) {
+		t.Errorf("End = %v, want %v", s.End, want)
+	}
+	got := must.Get(io.ReadAll(must.Get(rec.store.Get(ctx, s.ID+castSuffix))))
+	if string(got) != cast {
+		t.Errorf("stored recording = %q, want %q", got, cast)
+	}
+
+	// An interrupted upload is stored and marked incomplete.
+	cast2 := testCast(t0.Add(time.Hour), "bob@example.com", "partial")
+	upload(rec, errReader{strings.NewReader(cast2)})
+	ss = rec.index.find(sessionFilter{User: "bob@example.com"})
+	if len(ss) != 1 || ss[0].Complete || ss[0].Size != int64(len(cast2)) {
+		t.Fatalf("interrupted upload indexed as %+v", ss)
+	}
+
+	// Uploads from unknown peers or untagged nodes, or which are not
+	// recordings, are rejected.
+	req := httptest.NewRequest("POST", "/record", strings.NewReader(cast))
+	req.RemoteAddr = "192.168.0.1:1234"
+	w := httptest.NewRecorder()
+	rec.serveRecord(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("upload from unknown peer: %v, want 403", w.Code)
+	}
+	req = httptest.NewRequest("POST", "/record", strings.NewReader(cast))
+	req.RemoteAddr = "100.64.0.2:1234"
+	w = httptest.NewRecorder()
+	rec.serveRecord(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("upload from untagged node: %v, want 403", w.Code)
+	}
+	if w := upload(rec, strings.NewReader("hello\n")); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid upload: %v, want 400", w.Code)
+	}
+
+	// Recordings larger than the maximum size are truncated.
+	rec.maxSize = 500
+	big := testCast(t0.Add(2*time.Hour), "carol@example.com", strings.Repeat("x", 1000))
+	if w := upload(rec, strings.NewReader(big)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload: %v, want 413", w.Code)
+	}
+	ss = rec.index.find(sessionFilter{User: "carol@example.com"})
+	if len(ss) != 1 || ss[0].Complete || ss[0].Size != 500 {
+		t.Fatalf("large upload indexed as %+v", ss)
+	}
+	if spooled := must.Get(os.ReadDir(rec.spoolDir)); len(spooled) != 0 {
+		t.Errorf("spool not cleaned up: %v", spooled)
+	}
+
+	// The index is rebuilt from the store.
+	rec2 := &recorder{store: rec.store, index: newIndex()}
+	must.Do(rec2.loadIndex(ctx))
+	got = must.Get(json.Marshal(rec2.index.find(sessionFilter{})))
+	if want := must.Get(json.Marshal(rec.index.find(sessionFilter{}))); string(got) != string(want) {
+		t.Errorf("loaded index = %s, want %s", got, want)
+	}
+}
+
+func TestRecoverSpool(t *testing.T) {
+	rec := newTestRecorder(t)
+	ctx := context.Background()
+
+	// An upload in progress when tsrecorder stopped, and one which never
+	// got as far as the header.
+	cast := testCast(t0, "alice@example.com", "$ ls\r\n")
+	writeSpool := func(name, cast string) {
+		must.Do(os.WriteFile(filepath.Join(rec.spoolDir, name+castSuffix), []byte(cast), 0600))
+		must.Do(os.WriteFile(filepath.Join(rec.spoolDir, name+metaSuffix), []byte(`{"dstNode":"web.example.ts.net","dstNodeID":"nWEB"}`), 0600))
+	}
+	writeSpool(spoolPrefix+"1", cast)
+	writeSpool(spoolPrefix+"2", "")
+
+	must.Do(rec.recoverSpool(ctx))
+	ss := rec.index.find(sessionFilter{})
+	if len(ss) != 1 {
+		t.Fatalf("recovered %d sessions, want 1", len(ss))
+	}
+	if s := ss[0]; s.DstNode != "web.example.ts.net" || s.SrcNodeUser != "alice@example.com" || s.Complete || s.Size != int64(len(cast)) {
+		t.Errorf("recovered session = %+v", s)
+	}
+	if spooled := must.Get(os.ReadDir(rec.spoolDir)); len(spooled) != 0 {
+		t.Errorf("spool not cleaned up: %v", spooled)
+	}
+}
+
+func TestUI(t *testing.T) {
+	rec := newTestRecorder(t)
+	cast := testCast(t0, "alice@example.com", "<b>hi</b>")
+	upload(rec, strings.NewReader(cast))
+	upload(rec, strings.NewReader(testCast(t0.AddDate(0, 0, 2), "bob@example.com")))
+	id := rec.index.find(sessionFilter{User: "alice@example.com"})[0].ID
+	h := rec.uiHandler()
+
+	getAs := func(ip, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":1234"
+		h.ServeHTTP(w, r)
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		return getAs("100.64.0.3", path) // as an admin
+	}
+
+	w := get("/api/sessions?until=2024-01-31")
+	var ss []*session
+	must.Do(json.Unmarshal(w.Body.Bytes(), &ss))
+	if len(ss) != 1 || ss[0].ID != id {
+		t.Errorf("/api/sessions?until=2024-01-31 = %s", w.Body)
+	}
+	if w := get("/api/sessions?since=yesterday"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid since: %v, want 400", w.Code)
+	}
+	if w := get("/api/sessions?user=carol@example.com"); strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("no sessions: %s, want []", w.Body)
+	}
+
+	w = get("/?node=web")
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "/play/"+id) || !strings.Contains(w.Body.String(), "bob@example.com") {
+		t.Errorf("list = %v %s", w.Code, w.Body)
+	}
+
+	w = get("/sessions/" + id + ".cast")
+	if w.Code != 200 || w.Body.String() != cast {
+		t.Errorf("cast = %v %q", w.Code, w.Body)
+	}
+	if w := get("/sessions/2024/01/31/nope.cast"); w.Code != 404 {
+		t.Errorf("unknown cast: %v, want 404", w.Code)
+	}
+
+	w = get("/play/" + id)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "alice@example.com as root@web.example.ts.net") {
+		t.Errorf("play = %v %s", w.Code, w.Body)
+	}
+
+	// Users without a capability are denied, and UI users only see their
+	// own sessions.
+	for _, ip := range []string{"100.64.0.4", "100.64.0.1", "192.168.0.1"} {
+		if w := getAs(ip, "/api/sessions"); w.Code != http.StatusForbidden {
+			t.Errorf("list as %s: %v, want 403", ip, w.Code)
+		}
+	}
+	w = getAs("100.64.0.2", "/api/sessions")
+	ss = nil
+	must.Do(json.Unmarshal(w.Body.Bytes(), &ss))
+	if len(ss) != 1 || ss[0].ID != id {
+		t.Errorf("list as alice = %s", w.Body)
+	}
+	if w := getAs("100.64.0.2", "/sessions/"+id+".cast"); w.Code != 200 {
+		t.Errorf("own cast as alice: %v, want 200", w.Code)
+	}
+	bobID := rec.index.find(sessionFilter{User: "bob@example.com"})[0].ID
+	if w := getAs("100.64.0.2", "/sessions/"+bobID+".cast"); w.Code != 404 {
+		t.Errorf("bob's cast as alice: %v, want 404", w.Code)
+	}
+	if w := getAs("100.64.0.2", "/play/"+bobID); w.Code != 404 {
+		t.Errorf("play bob's session as alice: %v, want 404", w.Code)
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	rec := newTestRecorder(t)
+	rec.retention = 24 * time.Hour
+	ctx := context.Background()
+	upload(rec, strings.NewReader(testCast(t0, "alice@example.com", "old")))
+	upload(rec, strings.NewReader(testCast(t0.Add(36*time.Hour), "bob@example.com", "new")))
+
+	must.Do(rec.deleteExpired(ctx, t0.Add(48*time.Hour)))
+	ss := rec.index.find(sessionFilter{})
+	if len(ss) != 1 || ss[0].SrcNodeUser != "bob@example.com" {
+		t.Fatalf("sessions after expiry = %+v", ss)
+	}
+	names := must.Get(rec.store.List(ctx, ""))
+	sort.Strings(names)
+	want := []string{ss[0].ID + castSuffix, ss[0].ID + metaSuffix}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("stored objects = %q, want %q", names, want)
+	}
+}
+
+// fakeS3 is a minimal S3 server, which checks that requests are signed.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string // keyed by bucket/key
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		http.Error(w, "unsigned request", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case "PUT":
+		f.objects[p] = string(must.Get(io.ReadAll(r.Body)))
+	case "GET":
+		if bucket, ok := strings.CutSuffix(p, "/"); ok || !strings.Contains(p, "/") {
+			if !ok {
+				bucket = p
+			}
+			f.list(w, bucket, r.URL.Query())
+			return
+		}
+		v, ok := f.objects[p]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, v)
+	case "DELETE":
+		delete(f.objects, p)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list lists the objects in bucket, one per page.
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, q url.Values) {
+	var keys []string
+	for k := range f.objects {
+		if key, ok := strings.CutPrefix(k, bucket+"/"); ok && strings.HasPrefix(key, q.Get("prefix")) && key > q.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var res listBucketResult
+	if len(keys) > 0 {
+		res.Contents = append(res.Contents, struct{ Key string }{keys[0]})
+	}
+	if len(keys) > 1 {
+		res.IsTruncated = true
+		res.NextContinuationToken = keys[0]
+	}
+	xml.NewEncoder(w).Encode(res)
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{
+		"bucket/other/x.json": "{}",
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s := &s3Store{
+		endpoint: must.Get(url.Parse(srv.URL)),
+		region:   "us-east-1",
+		bucket:   "bucket",
+		prefix:   "recs",
+		creds: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+		}),
+		signer: v4.NewSigner(),
+		hc:     srv.Client(),
+	}
+	ctx := context.Background()
+	for _, name := range []string{"2024/01/31/a.cast", "2024/01/31/a.json", "2024/02/01/b c.json"} {
+		must.Do(s.Put(ctx, name, strings.NewReader(name), int64(len(name))))
+	}
+	if got := fake.objects["bucket/recs/2024/02/01/b c.json"]; got != "2024/02/01/b c.json" {
+		t.Errorf("stored object = %q", got)
+	}
+	got := must.Get(io.ReadAll(must.Get(s.Get(ctx, "2024/01/31/a.cast"))))
+	if string(got) != "2024/01/31/a.cast" {
+		t.Errorf("Get = %q", got)
+	}
+	if _, err := s.Get(ctx, "nope.cast"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get of missing object: %v, want fs.ErrNotExist", err)
+	}
+
+	names := must.Get(s.List(ctx, metaSuffix))
+	if want := []string{"2024/01/31/a.json", "2024/02/01/b c.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List = %q, want %q", names, want)
+	}
+
+	must.Do(s.Delete(ctx, "2024/01/31/a.json"))
+	if _, ok := fake.objects["bucket/recs/2024/01/31/a.json"]; ok {
+		t.Errorf("object not deleted")
+	}
+}
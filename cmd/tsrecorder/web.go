@@ -0,0 +1,254 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// peerCapRecorderUI is the peer capability which allows a tailnet user
+	// to use the web UI to play back their own sessions.
+	peerCapRecorderUI tailcfg.PeerCapability = "tailscale.com/cap/tsrecorder-ui"
+
+	// peerCapRecorderAdmin is the peer capability which allows a tailnet
+	// user to use the web UI to play back all sessions.
+	peerCapRecorderAdmin tailcfg.PeerCapability = "tailscale.com/cap/tsrecorder-admin"
+)
+
+//go:embed web.tmpl.html
+var webTemplate string
+
+var tmpl = template.Must(template.New("web").Funcs(template.FuncMap{
+	"duration": func(s *session) string {
+		return s.End.Sub(s.Start).Round(time.Second).String()
+	},
+	"who": (*session).who,
+	"time": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04:05 MST")
+	},
+	"castURL": func(s *session) string {
+		return "/sessions/" + s.ID + castSuffix
+	},
+	"playURL": func(s *session) string {
+		return "/play/" + s.ID
+	},
+	// listURL returns the URL of the session list filtered by one
+	// parameter, for links in the list.
+	"listURL": func(key, value string) string {
+		return "/?" + url.Values{key: {value}}.Encode()
+	},
+}).Parse(webTemplate))
+
+// uiHandler returns the handler for the web UI, which lists and plays
+// recorded sessions:
+//
+//	/                    lists sessions, filtered by query parameters
+//	/api/sessions        lists sessions as JSON, with the same parameters
+//	/sessions/<id>.cast  downloads a recording
+//	/play/<id>           plays a recording in the browser
+//
+// The filter parameters are "user", "node", "since" and "until". Times are
+// RFC 3339 or dates in UTC, like "2024-01-31".
+//
+// Users with peerCapRecorderUI may only see the sessions they started;
+// users with peerCapRecorderAdmin may see all sessions. Everyone else is
+// denied access.
+func (rec *recorder) uiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", rec.serveList)
+	mux.HandleFunc("/api/sessions", rec.serveList)
+	mux.HandleFunc("/sessions/", rec.serveCast)
+	mux.HandleFunc("/play/", rec.servePlay)
+	return mux
+}
+
+// viewer is a user of the web UI.
+type viewer struct {
+	admin  bool           // whether the user may see all sessions
+	userID tailcfg.UserID // zero for tagged nodes
+	login  string         // login name of the user, for older recordings
+}
+
+// viewerOf returns the viewer making r, or responds with an error and
+// returns false if they may not use the UI.
+func (rec *recorder) viewerOf(w http.ResponseWriter, r *http.Request) (*viewer, bool) {
+	who, err := rec.whoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		log.Printf("UI request from %v: WhoIs: %v", r.RemoteAddr, err)
+		http.Error(w, "unknown peer", http.StatusForbidden)
+		return nil, false
+	}
+	v := &viewer{admin: who.CapMap.HasCapability(peerCapRecorderAdmin)}
+	if !v.admin && !who.CapMap.HasCapability(peerCapRecorderUI) {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return nil, false
+	}
+	if !who.Node.IsTagged() && who.UserProfile != nil {
+		v.userID = who.Node.User
+		v.login = who.UserProfile.LoginName
+	}
+	return v, true
+}
+
+// canView reports whether v may see the recording of s.
+func (v *viewer) canView(s *session) bool {
+	switch {
+	case v.admin:
+		return true
+	case v.userID.IsZero():
+		// Tagged nodes don't start sessions as a user.
+		return false
+	case !s.SrcNodeUserID.IsZero():
+		return s.SrcNodeUserID == v.userID
+	default:
+		// Recordings made before user IDs were indexed.
+		return s.SrcNodeUser != "" && strings.EqualFold(s.SrcNodeUser, v.login)
+	}
+}
+
+// parseFilter returns the session filter in the query of r.
+func parseFilter(r *http.Request) (sessionFilter, error) {
+	q := r.URL.Query()
+	f := sessionFilter{
+		User: strings.TrimSpace(q.Get("user")),
+		Node: strings.TrimSpace(q.Get("node")),
+	}
+	var err error
+	if f.Since, err = parseFilterTime(q.Get("since"), false); err != nil {
+		return f, fmt.Errorf("invalid since: %w", err)
+	}
+	if f.Until, err = parseFilterTime(q.Get("until"), true); err != nil {
+		return f, fmt.Errorf("invalid until: %w", err)
+	}
+	return f, nil
+}
+
+// parseFilterTime parses an RFC 3339 time or a date. If endOfDay, a date
+// is the end of that day rather than its start, so that "until" includes
+// the sessions on that day.
+func parseFilterTime(v string, endOfDay bool) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func (rec *recorder) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/api/sessions" {
+		http.NotFound(w, r)
+		return
+	}
+	v, ok := rec.viewerOf(w, r)
+	if !ok {
+		return
+	}
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sessions := slices.DeleteFunc(rec.index.find(f), func(s *session) bool {
+		return !v.canView(s)
+	})
+	if r.URL.Path == "/api/sessions" {
+		w.Header().Set("Content-Type", "application/json")
+		if sessions == nil {
+			sessions = []*session{}
+		}
+		json.NewEncoder(w).Encode(sessions)
+		return
+	}
+	q := r.URL.Query()
+	data := struct {
+		User, Node, Since, Until string
+		Sessions                 []*session
+	}{
+		User:     q.Get("user"),
+		Node:     q.Get("node"),
+		Since:    q.Get("since"),
+		Until:    q.Get("until"),
+		Sessions: sessions,
+	}
+	rec.render(w, "list", data)
+}
+
+func (rec *recorder) serveCast(w http.ResponseWriter, r *http.Request) {
+	v, ok := rec.viewerOf(w, r)
+	if !ok {
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/sessions/"), castSuffix)
+	s, found := rec.index.get(id)
+	// Sessions which may not be seen are not found, rather than
+	// forbidden, so as not to reveal that they exist.
+	if !ok || !found || !v.canView(s) {
+		http.NotFound(w, r)
+		return
+	}
+	rc, err := rec.store.Get(r.Context(), s.ID+castSuffix)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("reading recording %s: %v", s.ID, err)
+		http.Error(w, "error reading recording", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	name := strings.ReplaceAll(s.ID, "/", "-") + castSuffix
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Length", strconv.FormatInt(s.Size, 10))
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	}
+	io.Copy(w, rc)
+}
+
+func (rec *recorder) servePlay(w http.ResponseWriter, r *http.Request) {
+	v, ok := rec.viewerOf(w, r)
+	if !ok {
+		return
+	}
+	s, ok := rec.index.get(strings.TrimPrefix(r.URL.Path, "/play/"))
+	if !ok || !v.canView(s) {
+		http.NotFound(w, r)
+		return
+	}
+	rec.render(w, "play", s)
+}
+
+func (rec *recorder) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("rendering %s: %v", name, err)
+	}
+}
@@ -141,6 +141,7 @@ type Server struct {
 	multiForwarderCreated        expvar.Int
 	multiForwarderDeleted        expvar.Int
 	removePktForwardOther        expvar.Int
+	bytesDroppedRateLimited      expvar.Int       // bytes of packets dropped by per-client rate limits
 	avgQueueDuration             *uint64          // In milliseconds; accessed atomically
 	tcpRtt                       metrics.LabelMap // histogram
 
@@ -148,6 +149,13 @@ type Server struct {
 	// known peer in the network, as specified by a running tailscaled's client's LocalAPI.
 	verifyClients bool
 
+	// Per-client send rate limits, set by SetClientRateLimits.
+	// A zero limit means unlimited.
+	clientBytesLimit   rate.Limit
+	clientBytesBurst   int
+	clientPacketsLimit rate.Limit
+	clientPacketsBurst int
+
 	mu       sync.Mutex
 	closed   bool
 	netConns map[Conn]chan struct{} // chan is closed when conn closes
@@ -335,6 +343,7 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		s.packetsDroppedReason.Get("queue_head"),
 		s.packetsDroppedReason.Get("queue_tail"),
 		s.packetsDroppedReason.Get("write_error"),
+		s.packetsDroppedReason.Get("rate_limited"),
 	}
 	s.packetsDroppedTypeDisco = s.packetsDroppedType.Get("disco")
 	s.packetsDroppedTypeOther = s.packetsDroppedType.Get("other")
@@ -356,6 +365,29 @@ func (s *Server) SetVerifyClient(v bool) {
 	s.verifyClients = v
 }
 
+// SetClientRateLimits sets the rate at which each client may send packets
+// through this DERP server. Packets sent beyond either limit are dropped.
+//
+// bytesPerSec and packetsPerSec are the sustained rates, and bytesBurst and
+// packetsBurst are the sizes of the token buckets. A rate of zero or less
+// means unlimited. A burst less than one second's worth of the rate is raised
+// to that, and bytesBurst is at least MaxPacketSize so that any packet may be
+// sent. Mesh peers are not limited.
+//
+// It must be called before serving begins.
+func (s *Server) SetClientRateLimits(bytesPerSec, bytesBurst, packetsPerSec, packetsBurst int) {
+	s.clientBytesLimit, s.clientBytesBurst = 0, 0
+	if bytesPerSec > 0 {
+		s.clientBytesLimit = rate.Limit(bytesPerSec)
+		s.clientBytesBurst = max(bytesBurst, bytesPerSec, MaxPacketSize)
+	}
+	s.clientPacketsLimit, s.clientPacketsBurst = 0, 0
+	if packetsPerSec > 0 {
+		s.clientPacketsLimit = rate.Limit(packetsPerSec)
+		s.clientPacketsBurst = max(packetsBurst, packetsPerSec)
+	}
+}
+
 // HasMeshKey reports whether the server is configured with a mesh key.
 func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
 
@@ -725,6 +757,13 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 
 	if c.canMesh {
 		c.meshUpdate = make(chan struct{})
+	} else {
+		if s.clientBytesLimit > 0 {
+			c.sendBytesLim = rate.NewLimiter(s.clientBytesLimit, s.clientBytesBurst)
+		}
+		if s.clientPacketsLimit > 0 {
+			c.sendPacketsLim = rate.NewLimiter(s.clientPacketsLimit, s.clientPacketsBurst)
+		}
 	}
 	if clientInfo != nil {
 		c.info = *clientInfo
@@ -739,7 +778,7 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 	s.registerClient(c)
 	defer s.unregisterClient(c)
 
-	err = s.sendServerInfo(c.bw, clientKey)
+	err = s.sendServerInfo(c)
 	if err != nil {
 		return fmt.Errorf("send server info: %v", err)
 	}
@@ -964,6 +1003,13 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 		return fmt.Errorf("client %x: recvPacket: %v", c.key, err)
 	}
 
+	if !c.allowSend(len(contents)) {
+		s.bytesDroppedRateLimited.Add(int64(len(contents)))
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping with reason=%s", dstKey.ShortString(), dropReasonRateLimited)
+		return nil
+	}
+
 	var fwd PacketForwarder
 	var dstLen int
 	var dst *sclient
@@ -1030,6 +1076,7 @@ func (c *sclient) debugLogf(format string, v ...any) {
 	dropReasonQueueTail                          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError                         // OS write() failed
 	dropReasonDupClient                          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited                        // the sending client exceeded its rate limit
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1202,13 +1249,22 @@ type serverInfo struct {
 	TokenBucketBytesBurst     int `json:",omitempty"`
 }
 
-func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion})
+// sendServerInfo sends the serverInfo frame to c. If c has a bytes rate
+// limit, it is included so that the client can pace its sends rather than
+// have them dropped.
+func (s *Server) sendServerInfo(c *sclient) error {
+	si := serverInfo{Version: ProtocolVersion}
+	if c.sendBytesLim != nil {
+		si.TokenBucketBytesPerSecond = int(s.clientBytesLimit)
+		si.TokenBucketBytesBurst = s.clientBytesBurst
+	}
+	msg, err := json.Marshal(si)
 	if err != nil {
 		return err
 	}
 
-	msgbox := s.privateKey.SealTo(clientKey, msg)
+	bw := c.bw
+	msgbox := s.privateKey.SealTo(c.key, msg)
 	if err := writeFrameHeader(bw.bw(), frameServerInfo, uint32(len(msgbox))); err != nil {
 		return err
 	}
@@ -1349,6 +1405,28 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// sendBytesLim and sendPacketsLim, if non-nil, limit the rate
+	// at which the client may send packets. See
+	// Server.SetClientRateLimits.
+	sendBytesLim   *rate.Limiter
+	sendPacketsLim *rate.Limiter
+}
+
+// allowSend reports whether the client's rate limits permit it to send a
+// packet of n bytes now. Tokens are only consumed from either limiter if
+// both allow the packet.
+func (c *sclient) allowSend(n int) bool {
+	if c.sendPacketsLim != nil && !c.sendPacketsLim.Allow() {
+		return false
+	}
+	if c.sendBytesLim != nil && !c.sendBytesLim.AllowN(n) {
+		if c.sendPacketsLim != nil {
+			c.sendPacketsLim.CancelN(1)
+		}
+		return false
+	}
+	return true
 }
 
 // peerConnState represents whether a peer is connected to the server
@@ -1830,6 +1908,9 @@ func (s *Server) ExpVar() expvar.Var {
 	m.Set("multiforwarder_created", &s.multiForwarderCreated)
 	m.Set("multiforwarder_deleted", &s.multiForwarderDeleted)
 	m.Set("packet_forwarder_delete_other_value", &s.removePktForwardOther)
+	m.Set("counter_rate_limited_bytes", &s.bytesDroppedRateLimited)
+	m.Set("gauge_client_rate_limit_bytes_per_sec", expvar.Func(func() any { return float64(s.clientBytesLimit) }))
+	m.Set("gauge_client_rate_limit_packets_per_sec", expvar.Func(func() any { return float64(s.clientPacketsLimit) }))
 	m.Set("average_queue_duration_ms", expvar.Func(func() any {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
@@ -28,6 +28,7 @@
 	"tailscale.com/disco"
 	"tailscale.com/net/memnet"
 	"tailscale.com/tstest"
+	tsrate "tailscale.com/tstime/rate"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 )
@@ -1487,6 +1488,79 @@ func TestClientSendRateLimiting(t *testing.T) {
 	}
 }
 
+func TestServerClientRateLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.SetClientRateLimits(100_000, 0, 1, 3)
+
+	// The bytes limit is advertised to clients so they can pace themselves.
+	var si ServerInfoMessage
+	c1 := newTestClient(t, ts, "c1", func(nc net.Conn, priv key.NodePrivate, logf logger.Logf) (*Client, error) {
+		brw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+		c, err := NewClient(priv, nc, brw, logf)
+		if err != nil {
+			return nil, err
+		}
+		m, err := c.Recv()
+		if err != nil {
+			return nil, err
+		}
+		si, _ = m.(ServerInfoMessage)
+		return c, nil
+	})
+	if si.TokenBucketBytesPerSecond != 100_000 || si.TokenBucketBytesBurst != 100_000 {
+		t.Errorf("got server info %+v; want 100000 bytes/sec with burst 100000", si)
+	}
+	c2 := newRegularClient(t, ts, "c2")
+
+	// With a burst of 3 packets and 1 packet/sec, a flood from c1 gets
+	// its first 3 packets, and maybe one more, through to c2.
+	const sent = 10
+	for i := 0; i < sent; i++ {
+		if err := c1.c.Send(c2.pub, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got int
+	for {
+		m, err := c2.c.recvTimeout(time.Second)
+		if err != nil {
+			break
+		}
+		if _, ok := m.(ReceivedPacket); ok {
+			got++
+		}
+	}
+	if got < 3 || got > 4 {
+		t.Errorf("got %d packets; want 3 or 4", got)
+	}
+	if dropped := ts.s.packetsDroppedReasonCounters[dropReasonRateLimited].Value(); dropped != int64(sent-got) {
+		t.Errorf("rate limited drops = %d; want %d", dropped, sent-got)
+	}
+	if got, want := ts.s.bytesDroppedRateLimited.Value(), int64((sent-got)*len("hello")); got != want {
+		t.Errorf("rate limited bytes = %d; want %d", got, want)
+	}
+}
+
+func TestAllowSend(t *testing.T) {
+	c := &sclient{
+		sendBytesLim:   tsrate.NewLimiter(1, 100),
+		sendPacketsLim: tsrate.NewLimiter(1, 2),
+	}
+	// A packet rejected by the bytes limit doesn't use up a packet.
+	if c.allowSend(1000) {
+		t.Fatal("allowSend(1000) = true; want false")
+	}
+	if !c.allowSend(10) || !c.allowSend(10) {
+		t.Fatal("allowSend(10) = false after rejected packet; want true")
+	}
+	if c.allowSend(10) {
+		t.Error("allowSend(10) = true beyond packet burst; want false")
+	}
+}
+
 func TestServerRepliesToPing(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
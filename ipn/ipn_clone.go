@@ -73,6 +73,12 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			dst.TCP[k] = v.Clone()
 		}
 	}
+	if dst.UDP != nil {
+		dst.UDP = map[uint16]*UDPPortHandler{}
+		for k, v := range src.UDP {
+			dst.UDP[k] = v.Clone()
+		}
+	}
 	if dst.Web != nil {
 		dst.Web = map[HostPort]*WebServerConfig{}
 		for k, v := range src.Web {
@@ -92,6 +98,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigCloneNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	UDP         map[uint16]*UDPPortHandler
 	Web         map[HostPort]*WebServerConfig
 	AllowFunnel map[HostPort]bool
 	Foreground  map[string]*ServeConfig
@@ -106,6 +113,7 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	}
 	dst := new(TCPPortHandler)
 	*dst = *src
+	dst.Access = src.Access.Clone()
 	return dst
 }
 
@@ -115,6 +123,26 @@ func (src *TCPPortHandler) Clone() *TCPPortHandler {
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	Access       *ServeAccess
+}{})
+
+// Clone makes a deep copy of UDPPortHandler.
+// The result aliases no memory with the original.
+func (src *UDPPortHandler) Clone() *UDPPortHandler {
+	if src == nil {
+		return nil
+	}
+	dst := new(UDPPortHandler)
+	*dst = *src
+	dst.Access = src.Access.Clone()
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _UDPPortHandlerCloneNeedsRegeneration = UDPPortHandler(struct {
+	UDPForward     string
+	IdleTimeoutSec int
+	Access         *ServeAccess
 }{})
 
 // Clone makes a deep copy of HTTPHandler.
@@ -125,14 +153,26 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.RemoveRequestHeaders = append(src.RemoveRequestHeaders[:0:0], src.RemoveRequestHeaders...)
+	dst.SetRequestHeaders = maps.Clone(src.SetRequestHeaders)
+	dst.RemoveResponseHeaders = append(src.RemoveResponseHeaders[:0:0], src.RemoveResponseHeaders...)
+	dst.SetResponseHeaders = maps.Clone(src.SetResponseHeaders)
+	dst.Access = src.Access.Clone()
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path                  string
+	Proxy                 string
+	Text                  string
+	Redirect              string
+	Status                int
+	RemoveRequestHeaders  []string
+	SetRequestHeaders     map[string]string
+	RemoveResponseHeaders []string
+	SetResponseHeaders    map[string]string
+	Access                *ServeAccess
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
@@ -156,3 +196,24 @@ func (src *WebServerConfig) Clone() *WebServerConfig {
 var _WebServerConfigCloneNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// Clone makes a deep copy of ServeAccess.
+// The result aliases no memory with the original.
+func (src *ServeAccess) Clone() *ServeAccess {
+	if src == nil {
+		return nil
+	}
+	dst := new(ServeAccess)
+	*dst = *src
+	dst.Caps = append(src.Caps[:0:0], src.Caps...)
+	dst.Tags = append(src.Tags[:0:0], src.Tags...)
+	dst.Users = append(src.Users[:0:0], src.Users...)
+	return dst
+}
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessCloneNeedsRegeneration = ServeAccess(struct {
+	Caps  []tailcfg.PeerCapability
+	Tags  []string
+	Users []string
+}{})
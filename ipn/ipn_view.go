@@ -16,7 +16,7 @@
 	"tailscale.com/types/views"
 )
 
-//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,HTTPHandler,WebServerConfig
+//go:generate go run tailscale.com/cmd/cloner  -clonefunc=false -type=Prefs,ServeConfig,TCPPortHandler,UDPPortHandler,HTTPHandler,WebServerConfig,ServeAccess
 
 // View returns a readonly view of Prefs.
 func (p *Prefs) View() PrefsView {
@@ -175,6 +175,12 @@ func (v ServeConfigView) TCP() views.MapFn[uint16, *TCPPortHandler, TCPPortHandl
 	})
 }
 
+func (v ServeConfigView) UDP() views.MapFn[uint16, *UDPPortHandler, UDPPortHandlerView] {
+	return views.MapFnOf(v.ж.UDP, func(t *UDPPortHandler) UDPPortHandlerView {
+		return t.View()
+	})
+}
+
 func (v ServeConfigView) Web() views.MapFn[HostPort, *WebServerConfig, WebServerConfigView] {
 	return views.MapFnOf(v.ж.Web, func(t *WebServerConfig) WebServerConfigView {
 		return t.View()
@@ -195,6 +201,7 @@ func (v ServeConfigView) ETag() string { return v.ж.ETag }
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigViewNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	UDP         map[uint16]*UDPPortHandler
 	Web         map[HostPort]*WebServerConfig
 	AllowFunnel map[HostPort]bool
 	Foreground  map[string]*ServeConfig
@@ -246,10 +253,11 @@ func (v *TCPPortHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v TCPPortHandlerView) HTTPS() bool          { return v.ж.HTTPS }
-func (v TCPPortHandlerView) HTTP() bool           { return v.ж.HTTP }
-func (v TCPPortHandlerView) TCPForward() string   { return v.ж.TCPForward }
-func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) HTTPS() bool             { return v.ж.HTTPS }
+func (v TCPPortHandlerView) HTTP() bool              { return v.ж.HTTP }
+func (v TCPPortHandlerView) TCPForward() string      { return v.ж.TCPForward }
+func (v TCPPortHandlerView) TerminateTLS() string    { return v.ж.TerminateTLS }
+func (v TCPPortHandlerView) Access() ServeAccessView { return v.ж.Access.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _TCPPortHandlerViewNeedsRegeneration = TCPPortHandler(struct {
@@ -257,6 +265,63 @@ func (v TCPPortHandlerView) TerminateTLS() string { return v.ж.TerminateTLS }
 	HTTP         bool
 	TCPForward   string
 	TerminateTLS string
+	Access       *ServeAccess
+}{})
+
+// View returns a readonly view of UDPPortHandler.
+func (p *UDPPortHandler) View() UDPPortHandlerView {
+	return UDPPortHandlerView{ж: p}
+}
+
+// UDPPortHandlerView provides a read-only view over UDPPortHandler.
+//
+// Its methods should only be called if `Valid()` returns true.
+type UDPPortHandlerView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *UDPPortHandler
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v UDPPortHandlerView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v UDPPortHandlerView) AsStruct() *UDPPortHandler {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v UDPPortHandlerView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *UDPPortHandlerView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x UDPPortHandler
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v UDPPortHandlerView) UDPForward() string      { return v.ж.UDPForward }
+func (v UDPPortHandlerView) IdleTimeoutSec() int     { return v.ж.IdleTimeoutSec }
+func (v UDPPortHandlerView) Access() ServeAccessView { return v.ж.Access.View() }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _UDPPortHandlerViewNeedsRegeneration = UDPPortHandler(struct {
+	UDPForward     string
+	IdleTimeoutSec int
+	Access         *ServeAccess
 }{})
 
 // View returns a readonly view of HTTPHandler.
@@ -304,15 +369,39 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string     { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string    { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string     { return v.ж.Text }
+func (v HTTPHandlerView) Redirect() string { return v.ж.Redirect }
+func (v HTTPHandlerView) Status() int      { return v.ж.Status }
+func (v HTTPHandlerView) RemoveRequestHeaders() views.Slice[string] {
+	return views.SliceOf(v.ж.RemoveRequestHeaders)
+}
+
+func (v HTTPHandlerView) SetRequestHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetRequestHeaders)
+}
+func (v HTTPHandlerView) RemoveResponseHeaders() views.Slice[string] {
+	return views.SliceOf(v.ж.RemoveResponseHeaders)
+}
+
+func (v HTTPHandlerView) SetResponseHeaders() views.Map[string, string] {
+	return views.MapOf(v.ж.SetResponseHeaders)
+}
+func (v HTTPHandlerView) Access() ServeAccessView { return v.ж.Access.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path                  string
+	Proxy                 string
+	Text                  string
+	Redirect              string
+	Status                int
+	RemoveRequestHeaders  []string
+	SetRequestHeaders     map[string]string
+	RemoveResponseHeaders []string
+	SetResponseHeaders    map[string]string
+	Access                *ServeAccess
 }{})
 
 // View returns a readonly view of WebServerConfig.
@@ -370,3 +459,59 @@ func (v WebServerConfigView) Handlers() views.MapFn[string, *HTTPHandler, HTTPHa
 var _WebServerConfigViewNeedsRegeneration = WebServerConfig(struct {
 	Handlers map[string]*HTTPHandler
 }{})
+
+// View returns a readonly view of ServeAccess.
+func (p *ServeAccess) View() ServeAccessView {
+	return ServeAccessView{ж: p}
+}
+
+// ServeAccessView provides a read-only view over ServeAccess.
+//
+// Its methods should only be called if `Valid()` returns true.
+type ServeAccessView struct {
+	// ж is the underlying mutable value, named with a hard-to-type
+	// character that looks pointy like a pointer.
+	// It is named distinctively to make you think of how dangerous it is to escape
+	// to callers. You must not let callers be able to mutate it.
+	ж *ServeAccess
+}
+
+// Valid reports whether underlying value is non-nil.
+func (v ServeAccessView) Valid() bool { return v.ж != nil }
+
+// AsStruct returns a clone of the underlying value which aliases no memory with
+// the original.
+func (v ServeAccessView) AsStruct() *ServeAccess {
+	if v.ж == nil {
+		return nil
+	}
+	return v.ж.Clone()
+}
+
+func (v ServeAccessView) MarshalJSON() ([]byte, error) { return json.Marshal(v.ж) }
+
+func (v *ServeAccessView) UnmarshalJSON(b []byte) error {
+	if v.ж != nil {
+		return errors.New("already initialized")
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	var x ServeAccess
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	v.ж = &x
+	return nil
+}
+
+func (v ServeAccessView) Caps() views.Slice[tailcfg.PeerCapability] { return views.SliceOf(v.ж.Caps) }
+func (v ServeAccessView) Tags() views.Slice[string]                 { return views.SliceOf(v.ж.Tags) }
+func (v ServeAccessView) Users() views.Slice[string]                { return views.SliceOf(v.ж.Users) }
+
+// A compilation failure here means this code must be regenerated, with the command at the top of this file.
+var _ServeAccessViewNeedsRegeneration = ServeAccess(struct {
+	Caps  []tailcfg.PeerCapability
+	Tags  []string
+	Users []string
+}{})
@@ -53,6 +53,7 @@
 	"tailscale.com/log/sockstatlog"
 	"tailscale.com/logpolicy"
 	"tailscale.com/net/dns"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/interfaces"
@@ -77,6 +78,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/nettype"
 	"tailscale.com/types/opt"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
@@ -203,6 +205,7 @@ type LocalBackend struct {
 	filterAtomic                 atomic.Pointer[filter.Filter]
 	containsViaIPFuncAtomic      syncs.AtomicValue[func(netip.Addr) bool]
 	shouldInterceptTCPPortAtomic syncs.AtomicValue[func(uint16) bool]
+	shouldInterceptUDPPortAtomic syncs.AtomicValue[func(uint16) bool]
 	numClientStatusCalls         atomic.Uint32
 
 	// The mutex protects the following elements.
@@ -267,6 +270,7 @@ type LocalBackend struct {
 	// *.partial file to its final name on completion.
 	directFileRoot          string
 	directFileDoFinalRename bool // false on macOS, true on several NAS platforms
+	taildropPolicy          taildrop.Policy
 	componentLogUntil       map[string]componentLogState
 	// c2nUpdateStatus is the status of c2n-triggered client update.
 	c2nUpdateStatus     updateStatus
@@ -287,6 +291,7 @@ type LocalBackend struct {
 
 	serveListeners     map[netip.AddrPort]*localListener // listeners for local serve traffic
 	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *reverseProxy
+	udpServeSessions   map[uint16]int                    // UDP serve port => number of active sessions
 
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -401,6 +406,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	b.setFilter(filter.NewAllowNone(logf, &netipx.IPSet{}))
 
 	b.setTCPPortsIntercepted(nil)
+	b.setUDPPortsIntercepted(nil)
 
 	b.statusChanged = sync.NewCond(&b.statusLock)
 	b.e.SetStatusCallback(b.setWgengineStatus)
@@ -551,6 +557,16 @@ func (b *LocalBackend) SetDirectFileDoFinalRename(v bool) {
 	b.directFileDoFinalRename = v
 }
 
+// SetTaildropPolicy sets the policy restricting which files are received
+// with Taildrop.
+//
+// This must be called before the LocalBackend starts being used.
+func (b *LocalBackend) SetTaildropPolicy(p taildrop.Policy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.taildropPolicy = p
+}
+
 // ReloadConfig reloads the backend's config from disk.
 //
 // It returns (false, nil) if not running in declarative mode, (true, nil) on
@@ -2649,6 +2665,18 @@ func (b *LocalBackend) migrateStateLocked(prefs *ipn.Prefs) (err error) {
 // efficient func for ShouldInterceptTCPPort to use, which is called on every
 // incoming packet.
 func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
+	b.shouldInterceptTCPPortAtomic.Store(portMatcher(ports))
+}
+
+// setUDPPortsIntercepted is like setTCPPortsIntercepted, but for
+// b.shouldInterceptUDPPortAtomic and ShouldInterceptUDPPort.
+func (b *LocalBackend) setUDPPortsIntercepted(ports []uint16) {
+	b.shouldInterceptUDPPortAtomic.Store(portMatcher(ports))
+}
+
+// portMatcher returns an efficient func that reports whether a port is one of
+// ports. It may modify ports.
+func portMatcher(ports []uint16) func(uint16) bool {
 	slices.Sort(ports)
 	uniq.ModifySlice(&ports)
 	var f func(uint16) bool
@@ -2679,11 +2707,12 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 			}
 		}
 	}
-	b.shouldInterceptTCPPortAtomic.Store(f)
+	return f
 }
 
-// setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic
-// and shouldInterceptTCPPortAtomic from the prefs p, which may be !Valid().
+// setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic,
+// shouldInterceptTCPPortAtomic and shouldInterceptUDPPortAtomic from the prefs
+// p, which may be !Valid().
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
 	b.setWebClientAtomicBoolLocked(b.netMap, p)
@@ -2691,6 +2720,7 @@ func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(tsaddr.FalseContainsIPFunc())
 		b.setTCPPortsIntercepted(nil)
+		b.setUDPPortsIntercepted(nil)
 		b.lastServeConfJSON = mem.B(nil)
 		b.serveConfig = ipn.ServeConfigView{}
 	} else {
@@ -3317,6 +3347,15 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 	return nil, nil
 }
 
+// UDPHandlerForDst returns a UDP handler for the session from src to dst, or
+// nil if the session isn't handled by tailscaled.
+func (b *LocalBackend) UDPHandlerForDst(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn)) {
+	if !b.ShouldInterceptUDPPort(dst.Port()) || !b.isLocalIP(dst.Addr()) {
+		return nil
+	}
+	return b.udpHandlerForServe(dst.Port(), src)
+}
+
 func (b *LocalBackend) peerAPIServicesLocked() (ret []tailcfg.Service) {
 	for _, pln := range b.peerAPIListeners {
 		proto := tailcfg.PeerAPI4
@@ -3880,6 +3919,7 @@ func (b *LocalBackend) initPeerAPIListener() {
 			DirectFileMode:   b.directFileRoot != "",
 			AvoidFinalRename: !b.directFileDoFinalRename,
 			SendFileNotify:   b.sendFileNotify,
+			Policy:           b.taildropPolicy,
 		}.New(),
 	}
 	if dm, ok := b.sys.DNSManager.GetOK(); ok {
@@ -4662,12 +4702,14 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 	b.serveConfig = conf.View()
 }
 
-// setTCPPortsInterceptedFromNetmapAndPrefsLocked calls setTCPPortsIntercepted with
-// the ports that tailscaled should handle as a function of b.netMap and b.prefs.
+// setTCPPortsInterceptedFromNetmapAndPrefsLocked calls setTCPPortsIntercepted
+// and setUDPPortsIntercepted with the ports that tailscaled should handle as a
+// function of b.netMap and b.prefs.
 //
 // b.mu must be held.
 func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.PrefsView) {
 	handlePorts := make([]uint16, 0, 4)
+	var udpPorts []uint16
 
 	if prefs.Valid() && prefs.RunSSH() && envknob.CanSSHD() {
 		handlePorts = append(handlePorts, 22)
@@ -4692,6 +4734,13 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 		})
 		handlePorts = append(handlePorts, servePorts...)
 
+		b.serveConfig.RangeOverUDPs(func(port uint16, _ ipn.UDPPortHandlerView) bool {
+			if port > 0 {
+				udpPorts = append(udpPorts, port)
+			}
+			return true
+		})
+
 		b.setServeProxyHandlersLocked()
 
 		// don't listen on netmap addresses if we're in userspace mode
@@ -4707,6 +4756,7 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 	}
 
 	b.setTCPPortsIntercepted(handlePorts)
+	b.setUDPPortsIntercepted(udpPorts)
 }
 
 // setServeProxyHandlersLocked ensures there is an http proxy handler for each
@@ -4826,7 +4876,13 @@ func (b *LocalBackend) WaitingFiles() ([]apitype.WaitingFile, error) {
 // On return, exactly one of the results will be non-empty or non-nil,
 // respectively.
 func (b *LocalBackend) AwaitWaitingFiles(ctx context.Context) ([]apitype.WaitingFile, error) {
-	if ff, err := b.WaitingFiles(); err != nil || len(ff) > 0 {
+	return awaitFiles(ctx, b, b.WaitingFiles)
+}
+
+// awaitFiles calls list until it returns an error or a non-empty result,
+// whenever files are received, while ctx is not done.
+func awaitFiles[T any](ctx context.Context, b *LocalBackend, list func() ([]T, error)) ([]T, error) {
+	if ff, err := list(); err != nil || len(ff) > 0 {
 		return ff, err
 	}
 
@@ -4840,13 +4896,13 @@ func (b *LocalBackend) AwaitWaitingFiles(ctx context.Context) ([]apitype.Waiting
 		// Now that we've registered ourselves, check again, in case
 		// of race. Otherwise there's a small window where we could
 		// miss a file arrival and wait forever.
-		if ff, err := b.WaitingFiles(); err != nil || len(ff) > 0 {
+		if ff, err := list(); err != nil || len(ff) > 0 {
 			return ff, err
 		}
 
 		select {
 		case <-gotFile.Done():
-			if ff, err := b.WaitingFiles(); err != nil || len(ff) > 0 {
+			if ff, err := list(); err != nil || len(ff) > 0 {
 				return ff, err
 			}
 		case <-ctx.Done():
@@ -4855,6 +4911,39 @@ func (b *LocalBackend) AwaitWaitingFiles(ctx context.Context) ([]apitype.Waiting
 	}
 }
 
+// PendingFiles returns the received files which must be accepted with
+// AcceptPendingFile before they are waiting, if the Taildrop policy
+// requires confirmation.
+func (b *LocalBackend) PendingFiles() ([]apitype.PendingFile, error) {
+	b.mu.Lock()
+	apiSrv := b.peerAPIServer
+	b.mu.Unlock()
+	return mayDeref(apiSrv).taildrop.PendingFiles()
+}
+
+// AwaitPendingFiles is like PendingFiles but blocks while ctx is not done,
+// waiting for any files to be pending.
+func (b *LocalBackend) AwaitPendingFiles(ctx context.Context) ([]apitype.PendingFile, error) {
+	return awaitFiles(ctx, b, b.PendingFiles)
+}
+
+// AcceptPendingFile accepts the pending file name, returning the name it
+// is waiting as.
+func (b *LocalBackend) AcceptPendingFile(name string) (string, error) {
+	b.mu.Lock()
+	apiSrv := b.peerAPIServer
+	b.mu.Unlock()
+	return mayDeref(apiSrv).taildrop.AcceptFile(name)
+}
+
+// RejectPendingFile deletes the pending file name.
+func (b *LocalBackend) RejectPendingFile(name string) error {
+	b.mu.Lock()
+	apiSrv := b.peerAPIServer
+	b.mu.Unlock()
+	return mayDeref(apiSrv).taildrop.RejectFile(name)
+}
+
 func (b *LocalBackend) DeleteFile(name string) error {
 	b.mu.Lock()
 	apiSrv := b.peerAPIServer
@@ -5491,6 +5580,13 @@ func (b *LocalBackend) ShouldInterceptTCPPort(port uint16) bool {
 	return b.shouldInterceptTCPPortAtomic.Load()(port)
 }
 
+// ShouldInterceptUDPPort reports whether the given UDP port number to a
+// Tailscale IP (not a subnet router, service IP, etc) should be intercepted by
+// Tailscaled and handled in-process.
+func (b *LocalBackend) ShouldInterceptUDPPort(port uint16) bool {
+	return b.shouldInterceptUDPPortAtomic.Load()(port)
+}
+
 // SwitchProfile switches to the profile with the given id.
 // It will restart the backend on success.
 // If the profile is not known, it returns an errProfileNotFound.
@@ -5711,6 +5807,27 @@ func (b *LocalBackend) DebugBreakDERPConns() error {
 	return b.MagicConn().DebugBreakDERPConns()
 }
 
+// DebugDNSCacheStats returns statistics about the MagicDNS resolver's cache
+// of responses from upstream nameservers.
+func (b *LocalBackend) DebugDNSCacheStats() (resolver.CacheStats, error) {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return resolver.CacheStats{}, errors.New("DNS manager not available")
+	}
+	return dm.Resolver().CacheStats(), nil
+}
+
+// DebugFlushDNSCache flushes the MagicDNS resolver's cache of responses from
+// upstream nameservers.
+func (b *LocalBackend) DebugFlushDNSCache() error {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("DNS manager not available")
+	}
+	dm.Resolver().FlushCache()
+	return nil
+}
+
 func (b *LocalBackend) pushSelfUpdateProgress(up ipnstate.UpdateProgress) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -18,6 +18,7 @@
 	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"tailscale.com/health"
@@ -432,9 +433,14 @@ func (b *LocalBackend) NetworkLockStatus() *ipnstate.NetworkLockStatus {
 	outKeys := make([]ipnstate.TKAKey, len(keys))
 	for i, k := range keys {
 		outKeys[i] = ipnstate.TKAKey{
-			Key:      key.NLPublicFromEd25519Unsafe(k.Public),
 			Metadata: k.Meta,
 			Votes:    k.Votes,
+			Kind:     k.Kind.String(),
+		}
+		if k.Kind == tka.Key25519 {
+			outKeys[i].Key = key.NLPublicFromEd25519Unsafe(k.Public)
+		} else {
+			outKeys[i].Public = k.Public
 		}
 	}
 
@@ -454,6 +460,8 @@ func (b *LocalBackend) NetworkLockStatus() *ipnstate.NetworkLockStatus {
 		TrustedKeys:   outKeys,
 		FilteredPeers: filtered,
 		StateID:       stateID1,
+
+		KeyChangeThreshold: b.tka.authority.KeyChangeThreshold(),
 	}
 }
 
@@ -479,6 +487,10 @@ func (b *LocalBackend) NetworkLockInit(keys []tka.Key, disablementValues [][]byt
 		b.mu.Unlock()
 		return errors.New("not permitted to enable tailnet lock")
 	}
+	if err := b.checkPeersSupportKeysLocked(keys); err != nil {
+		b.mu.Unlock()
+		return err
+	}
 
 	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
 		ourNodeKey = p.Persist().PublicNodeKey()
@@ -642,6 +654,35 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 	return nil
 }
 
+// tkaP256CapVer is the capability version from which nodes accept tailnet
+// lock keys of kind tka.KeyP256. Older nodes fail to decode an authority
+// with such a key, and so stop accepting any signature it makes.
+const tkaP256CapVer tailcfg.CapabilityVersion = 86
+
+// checkPeersSupportKeysLocked returns an error if keys include a P-256 key
+// and any peer is too old to accept it. Nodes which aren't in the netmap
+// can't be checked.
+//
+// b.mu must be held.
+func (b *LocalBackend) checkPeersSupportKeysLocked(keys []tka.Key) error {
+	if !slices.ContainsFunc(keys, func(k tka.Key) bool { return k.Kind == tka.KeyP256 }) {
+		return nil
+	}
+	if b.netMap == nil {
+		return errMissingNetmap
+	}
+	var old []string
+	for _, p := range b.netMap.Peers {
+		if p.Cap() < tkaP256CapVer {
+			old = append(old, p.DisplayName(false))
+		}
+	}
+	if len(old) > 0 {
+		return fmt.Errorf("p256 keys are not supported by %d peers running older versions of Tailscale, such as %s; update them first", len(old), old[0])
+	}
+	return nil
+}
+
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
 func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err error) {
 	defer func() {
@@ -674,6 +715,12 @@ func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err err
 	if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
 		return errors.New("this node does not have a trusted tailnet lock key")
 	}
+	if n := b.tka.authority.KeyChangeThreshold(); n > 1 {
+		return fmt.Errorf("changes to trusted keys must be signed by %d trusted keys; use 'tailscale lock propose' instead", n)
+	}
+	if err := b.checkPeersSupportKeysLocked(addKeys); err != nil {
+		return err
+	}
 
 	updater := b.tka.authority.NewUpdater(nlPriv)
 
@@ -791,6 +838,18 @@ func (b *LocalBackend) NetworkLockLog(maxEntries int) ([]ipnstate.NetworkLockUpd
 	return out, nil
 }
 
+// NetworkLockExport returns every AUM known to the tailnet key authority,
+// in a form which can be verified offline. See tka.Authority.Export.
+func (b *LocalBackend) NetworkLockExport() ([]tka.ExportedAUM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	return b.tka.authority.Export(b.tka.storage)
+}
+
 // NetworkLockAffectedSigs returns the signatures which would be invalidated
 // by removing trust in the specified KeyID.
 func (b *LocalBackend) NetworkLockAffectedSigs(keyID tkatype.KeyID) ([]tkatype.MarshaledSignature, error) {
@@ -936,6 +995,124 @@ func (b *LocalBackend) NetworkLockSubmitRecoveryAUM(aum *tka.AUM) error {
 	return err
 }
 
+// NetworkLockGenerateProposal generates an AUM which adds and/or removes
+// the specified keys, and optionally changes the key-change threshold.
+// The AUM is signed by the current node and returned, so it can be
+// co-signed by other trusted keys using NetworkLockCosignProposal.
+//
+// If threshold is negative, the threshold is left unchanged.
+func (b *LocalBackend) NetworkLockGenerateProposal(addKeys, removeKeys []tka.Key, threshold int) (*tka.AUM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	var nlPriv key.NLPrivate
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		nlPriv = p.Persist().NetworkLockKey()
+	}
+	if nlPriv.IsZero() {
+		return nil, errMissingNetmap
+	}
+	if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
+		return nil, errors.New("this node does not have a trusted tailnet lock key")
+	}
+
+	if err := b.checkPeersSupportKeysLocked(addKeys); err != nil {
+		return nil, err
+	}
+
+	updater := b.tka.authority.NewUpdater(nlPriv)
+	for _, addKey := range addKeys {
+		if err := updater.AddKey(addKey); err != nil {
+			return nil, err
+		}
+	}
+	for _, removeKey := range removeKeys {
+		keyID, err := removeKey.ID()
+		if err != nil {
+			return nil, err
+		}
+		if err := updater.RemoveKey(keyID); err != nil {
+			return nil, err
+		}
+	}
+	if threshold >= 0 {
+		if err := updater.SetKeyChangeThreshold(uint(threshold)); err != nil {
+			return nil, err
+		}
+	}
+	return updater.FinalizeProposal()
+}
+
+// NetworkLockCosignProposal co-signs the provided proposal AUM and returns
+// the updated structure.
+//
+// The proposal provided should be the output from a previous call to
+// NetworkLockGenerateProposal or NetworkLockCosignProposal.
+func (b *LocalBackend) NetworkLockCosignProposal(aum *tka.AUM) (*tka.AUM, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	var nlPriv key.NLPrivate
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		nlPriv = p.Persist().NetworkLockKey()
+	}
+	if nlPriv.IsZero() {
+		return nil, errMissingNetmap
+	}
+	if !b.tka.authority.KeyTrusted(nlPriv.KeyID()) {
+		return nil, errors.New("this node does not have a trusted tailnet lock key")
+	}
+	for _, sig := range aum.Signatures {
+		if bytes.Equal(sig.KeyID, nlPriv.KeyID()) {
+			return nil, errors.New("this node has already signed this proposal")
+		}
+	}
+	// Fail early if the proposal was made against a stale head, rather
+	// than collecting signatures which can never be used.
+	if _, _, err := b.tka.authority.ProposalSignatures(*aum); err != nil {
+		return nil, err
+	}
+
+	if err := aum.Sign(nlPriv); err != nil {
+		return nil, err
+	}
+	return aum, nil
+}
+
+// NetworkLockSubmitProposal submits a proposal AUM which has been signed
+// by enough trusted keys to meet the key-change threshold.
+func (b *LocalBackend) NetworkLockSubmitProposal(aum *tka.AUM) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return errNetworkLockNotActive
+	}
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().PrivateNodeKey().IsZero() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	if ourNodeKey.IsZero() {
+		return errors.New("no node-key: is tailscale logged in?")
+	}
+	have, need, err := b.tka.authority.ProposalSignatures(*aum)
+	if err != nil {
+		return err
+	}
+	if have < need {
+		return fmt.Errorf("%w: signed by %d trusted keys, need %d", tka.ErrThresholdNotMet, have, need)
+	}
+
+	head := b.tka.authority.Head()
+	b.mu.Unlock()
+	_, err = b.tkaDoSyncSend(ourNodeKey, head, []tka.AUM{*aum}, true)
+	b.mu.Lock()
+	return err
+}
+
 var tkaSuffixEncoder = base64.RawStdEncoding
 
 // NetworkLockWrapPreauthKey wraps a pre-auth key with information to
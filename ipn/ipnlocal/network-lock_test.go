@@ -1126,3 +1126,27 @@ func TestTKARecoverCompromisedKeyFlow(t *testing.T) {
 		t.Errorf("NetworkLockSubmitRecoveryAUM() failed: %v", err)
 	}
 }
+
+func TestCheckPeersSupportKeys(t *testing.T) {
+	p256 := tka.Key{Kind: tka.KeyP256, Public: make([]byte, 65), Votes: 1}
+	ed := tka.Key{Kind: tka.Key25519, Public: key.NewNLPrivate().Public().Verifier(), Votes: 1}
+
+	b := &LocalBackend{
+		netMap: &netmap.NetworkMap{
+			Peers: nodeViews([]*tailcfg.Node{
+				{ID: 1, Name: "new.example.ts.net.", Cap: tkaP256CapVer},
+				{ID: 2, Name: "old.example.ts.net.", Cap: tkaP256CapVer - 1},
+			}),
+		},
+	}
+	if err := b.checkPeersSupportKeysLocked([]tka.Key{ed}); err != nil {
+		t.Errorf("25519 key with old peer: %v, want nil", err)
+	}
+	if err := b.checkPeersSupportKeysLocked([]tka.Key{ed, p256}); err == nil {
+		t.Error("p256 key with old peer: nil error, want error")
+	}
+	b.netMap.Peers = b.netMap.Peers[:1]
+	if err := b.checkPeersSupportKeysLocked([]tka.Key{p256}); err != nil {
+		t.Errorf("p256 key with new peers: %v, want nil", err)
+	}
+}
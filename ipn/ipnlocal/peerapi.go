@@ -28,6 +28,7 @@
 	"github.com/kortschak/wol"
 	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/net/http/httpguts"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
@@ -41,6 +42,7 @@
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/httphdr"
+	"tailscale.com/util/mak"
 	"tailscale.com/wgengine/filter"
 )
 
@@ -312,6 +314,13 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handlePeerPut(w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, "/v0/put-dir/") {
+		if r.Method == "PUT" {
+			metricPutCalls.Add(1)
+		}
+		h.handlePeerPutDir(w, r)
+		return
+	}
 	if strings.HasPrefix(r.URL.Path, "/dns-query") {
 		metricDNSCalls.Add(1)
 		h.handleDNSQuery(w, r)
@@ -703,26 +712,192 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 			}
 			offset = ranges[0].Start
 		}
-		n, err := h.ps.taildrop.PutFile(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength)
-		switch err {
-		case nil:
-			d := h.ps.b.clock.Since(t0).Round(time.Second / 10)
-			h.logf("got put of %s in %v from %v/%v", approxSize(n), d, h.remoteAddr.Addr(), h.peerNode.ComputedName)
-			io.WriteString(w, "{}\n")
-		case taildrop.ErrNoTaildrop:
-			http.Error(w, err.Error(), http.StatusForbidden)
-		case taildrop.ErrInvalidFileName:
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case taildrop.ErrFileExists:
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		var sum taildrop.Digest
+		if sumHdr := r.Header.Get(apitype.FileSHA256Header); sumHdr != "" {
+			if sum, err = taildrop.ParseDigest(sumHdr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		}
+		if err := h.ps.taildrop.Admit(h.taildropSender(), r.ContentLength); err != nil {
+			writePutError(w, err)
+			return
+		}
+		n, err := h.ps.taildrop.PutFile(taildrop.ClientID(fmt.Sprint(id)), baseName, r.Body, offset, r.ContentLength, sum)
+		if err != nil {
+			if errors.Is(err, taildrop.ErrDigestMismatch) {
+				h.logf("discarded put from %v/%v: %v", h.remoteAddr.Addr(), h.peerNode.ComputedName(), err)
+			}
+			writePutError(w, err)
+			return
+		}
+		d := h.ps.b.clock.Since(t0).Round(time.Second / 10)
+		h.logf("got put of %s in %v from %v/%v", approxSize(n), d, h.remoteAddr.Addr(), h.peerNode.ComputedName())
+		io.WriteString(w, "{}\n")
 	default:
 		http.Error(w, "expected method GET or PUT", http.StatusMethodNotAllowed)
 	}
 }
 
+// handlePeerPutDir handles the transfer of a directory of files:
+//
+//   - POST /v0/put-dir/:dir, with a JSON apitype.DirManifest body, begins
+//     (or resumes) the transfer, returning an apitype.PutDirResponse.
+//   - GET /v0/put-dir/:dir/:path streams the block hashes of a partially
+//     received file, as for /v0/put/.
+//   - PUT /v0/put-dir/:dir/:path sends a file listed in the manifest,
+//     resuming from the offset in the Range header, if any.
+//   - POST /v0/put-dir/:dir?commit=1 completes the transfer, once every
+//     file has been sent.
+//
+// Both :dir and :path are path-escaped, so that :path (which is a
+// slash-separated path within the directory) is a single element.
+func (h *peerAPIHandler) handlePeerPutDir(w http.ResponseWriter, r *http.Request) {
+	if !h.canPutFile() {
+		http.Error(w, taildrop.ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	if !h.ps.b.hasCapFileSharing() {
+		http.Error(w, taildrop.ErrNoTaildrop.Error(), http.StatusForbidden)
+		return
+	}
+	rawPath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/v0/put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured internals", http.StatusForbidden)
+		return
+	}
+	escDir, escPath, hasPath := strings.Cut(rawPath, "/")
+	dirName, err := url.PathUnescape(escDir)
+	if err != nil {
+		http.Error(w, taildrop.ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	relPath, err := url.PathUnescape(escPath)
+	if err != nil || hasPath && relPath == "" {
+		http.Error(w, taildrop.ErrInvalidFileName.Error(), http.StatusBadRequest)
+		return
+	}
+	id := taildrop.ClientID(h.peerNode.StableID())
+
+	switch {
+	case !hasPath && r.Method == "POST" && r.FormValue("commit") != "":
+		if _, err := h.ps.taildrop.CommitDir(id, dirName); err != nil {
+			writePutError(w, err)
+			return
+		}
+		h.logf("got put of directory from %v/%v", h.remoteAddr.Addr(), h.peerNode.ComputedName())
+		io.WriteString(w, "{}\n")
+	case !hasPath && r.Method == "POST":
+		var manifest apitype.DirManifest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&manifest); err != nil {
+			http.Error(w, "invalid manifest: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var size int64
+		for _, f := range manifest.Files {
+			size += f.Size
+		}
+		// A resumed transfer only needs room for what is left to send.
+		size -= h.ps.taildrop.DirBytesReceived(id, dirName, manifest)
+		if err := h.ps.taildrop.Admit(h.taildropSender(), size); err != nil {
+			writePutError(w, err)
+			return
+		}
+		done, err := h.ps.taildrop.BeginDir(id, dirName, manifest)
+		if err != nil {
+			writePutError(w, err)
+			return
+		}
+		mak.NonNilSliceForJSON(&done)
+		json.NewEncoder(w).Encode(apitype.PutDirResponse{Done: done})
+	case hasPath && r.Method == "GET":
+		next, close, err := h.ps.taildrop.HashPartialDirFile(id, dirName, relPath)
+		if err != nil {
+			writePutError(w, err)
+			return
+		}
+		defer close()
+		enc := json.NewEncoder(w)
+		for {
+			switch cs, err := next(); {
+			case err == io.EOF:
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				h.logf("HashPartialDirFile.next error: %v", err)
+				return
+			default:
+				if err := enc.Encode(cs); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					h.logf("json.Encoder.Encode error: %v", err)
+					return
+				}
+			}
+		}
+	case hasPath && r.Method == "PUT":
+		var offset int64
+		if rangeHdr := r.Header.Get("Range"); rangeHdr != "" {
+			ranges, ok := httphdr.ParseRange(rangeHdr)
+			if !ok || len(ranges) != 1 || ranges[0].Length != 0 {
+				http.Error(w, "invalid Range header", http.StatusBadRequest)
+				return
+			}
+			offset = ranges[0].Start
+		}
+		if err := h.ps.taildrop.Admit(h.taildropSender(), r.ContentLength); err != nil {
+			writePutError(w, err)
+			return
+		}
+		if _, err := h.ps.taildrop.PutDirFile(id, dirName, relPath, r.Body, offset, r.ContentLength); err != nil {
+			if errors.Is(err, taildrop.ErrDigestMismatch) {
+				h.logf("discarded put of directory file from %v/%v: %v", h.remoteAddr.Addr(), h.peerNode.ComputedName(), err)
+			}
+			writePutError(w, err)
+			return
+		}
+		io.WriteString(w, "{}\n")
+	default:
+		http.Error(w, "expected method GET, PUT or POST", http.StatusMethodNotAllowed)
+	}
+}
+
+// taildropSender returns the peer making the request, for enforcing the
+// Taildrop receiver policy.
+func (h *peerAPIHandler) taildropSender() taildrop.Sender {
+	s := taildrop.Sender{
+		ID:   taildrop.ClientID(h.peerNode.StableID()),
+		Node: h.peerNode.ComputedName(),
+		Tags: h.peerNode.Tags().AsSlice(),
+	}
+	if len(s.Tags) == 0 {
+		s.LoginName = h.peerUser.LoginName
+	}
+	return s
+}
+
+// writePutError writes err, returned by a taildrop.Manager while receiving
+// a file, as an HTTP error response.
+func writePutError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, taildrop.ErrNoTaildrop), errors.Is(err, taildrop.ErrSenderNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, taildrop.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, taildrop.ErrInboxFull):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, taildrop.ErrInvalidFileName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, taildrop.ErrFileExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, taildrop.ErrNoDirTransfer):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, taildrop.ErrDigestMismatch):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 func approxSize(n int64) string {
 	if n <= 1<<10 {
 		return "<=1KB"
@@ -6,6 +6,8 @@
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
@@ -86,6 +88,15 @@ func fileHasSize(name string, size int) check {
 	}
 }
 
+func fileMissing(name string) check {
+	return func(t *testing.T, e *peerAPITestEnv) {
+		path := filepath.Join(e.ph.ps.taildrop.Dir(), name)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("file %q exists; want it missing (err=%v)", name, err)
+		}
+	}
+}
+
 func fileHasContents(name string, want string) check {
 	return func(t *testing.T, e *peerAPITestEnv) {
 		root := e.ph.ps.taildrop.Dir()
@@ -113,6 +124,16 @@ func hexAll(v string) string {
 	return sb.String()
 }
 
+func withHeader(k, v string, r *http.Request) *http.Request {
+	r.Header.Set(k, v)
+	return r
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
 func TestHandlePeerAPI(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -120,6 +141,7 @@ func TestHandlePeerAPI(t *testing.T) {
 		capSharing bool // self node has file sharing capability
 		debugCap   bool // self node has debug capability
 		omitRoot   bool // don't configure
+		policy     taildrop.Policy
 		reqs       []*http.Request
 		checks     []check
 	}{
@@ -357,6 +379,48 @@ func TestHandlePeerAPI(t *testing.T) {
 				fileHasContents("Foo Bar.dat", "baz"),
 			),
 		},
+		{
+			name:       "put_sha256_match",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				withHeader(apitype.FileSHA256Header, sha256Hex("contents"),
+					httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))),
+			},
+			checks: checks(
+				httpStatus(200),
+				bodyContains("{}"),
+				fileHasContents("foo", "contents"),
+			),
+		},
+		{
+			name:       "put_sha256_mismatch",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				withHeader(apitype.FileSHA256Header, sha256Hex("contents"),
+					httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("corrupts"))),
+			},
+			checks: checks(
+				httpStatus(http.StatusUnprocessableEntity),
+				bodyContains("does not match"),
+				fileMissing("foo"),
+			),
+		},
+		{
+			name:       "put_sha256_invalid",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				withHeader(apitype.FileSHA256Header, "abc",
+					httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))),
+			},
+			checks: checks(
+				httpStatus(400),
+				bodyContains("invalid SHA-256"),
+				fileMissing("foo"),
+			),
+		},
 		{
 			name:       "put_unicode",
 			isSelf:     true,
@@ -460,7 +524,7 @@ func(t *testing.T, env *peerAPITestEnv) {
 					if err != nil {
 						t.Fatalf("WaitingFiles error: %v", err)
 					}
-					want := []apitype.WaitingFile{{Name: "foo", Size: 0}}
+					want := []apitype.WaitingFile{{Name: "foo", Size: 0, SHA256: sha256Hex("")}}
 					if diff := cmp.Diff(got, want); diff != "" {
 						t.Fatalf("WaitingFile mismatch (-got +want):\n%s", diff)
 					}
@@ -482,7 +546,7 @@ func(t *testing.T, env *peerAPITestEnv) {
 					if err != nil {
 						t.Fatalf("WaitingFiles error: %v", err)
 					}
-					want := []apitype.WaitingFile{{Name: "foo", Size: 8}}
+					want := []apitype.WaitingFile{{Name: "foo", Size: 8, SHA256: sha256Hex("contents")}}
 					if diff := cmp.Diff(got, want); diff != "" {
 						t.Fatalf("WaitingFile mismatch (-got +want):\n%s", diff)
 					}
@@ -504,13 +568,134 @@ func(t *testing.T, env *peerAPITestEnv) {
 					if err != nil {
 						t.Fatalf("WaitingFiles error: %v", err)
 					}
-					want := []apitype.WaitingFile{{Name: "foo", Size: 4}, {Name: "foo (1)", Size: 4}}
+					want := []apitype.WaitingFile{
+						{Name: "foo", Size: 4, SHA256: sha256Hex("fizz")},
+						{Name: "foo (1)", Size: 4, SHA256: sha256Hex("buzz")},
+					}
 					if diff := cmp.Diff(got, want); diff != "" {
 						t.Fatalf("WaitingFile mismatch (-got +want):\n%s", diff)
 					}
 				},
 			),
 		},
+		{
+			name:       "put_dir",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				httptest.NewRequest("POST", "/v0/put-dir/photos", strings.NewReader(`{"Files": [{"Path": "a/b.txt", "Size": 3}, {"Path": "c", "Size": 0}]}`)),
+				httptest.NewRequest("PUT", "/v0/put-dir/photos/a%2Fb.txt", strings.NewReader("abc")),
+				httptest.NewRequest("PUT", "/v0/put-dir/photos/c", nil),
+				httptest.NewRequest("POST", "/v0/put-dir/photos?commit=1", nil),
+			},
+			checks: checks(
+				httpStatus(200),
+				fileHasContents("photos/a/b.txt", "abc"),
+				fileHasSize("photos/c", 0),
+			),
+		},
+		{
+			name:       "put_dir_reject_non_owner",
+			isSelf:     false,
+			capSharing: true,
+			reqs:       []*http.Request{httptest.NewRequest("POST", "/v0/put-dir/photos", strings.NewReader(`{"Files": [{"Path": "a", "Size": 1}]}`))},
+			checks:     checks(httpStatus(http.StatusForbidden)),
+		},
+		{
+			name:       "put_dir_traversal_in_manifest",
+			isSelf:     true,
+			capSharing: true,
+			reqs:       []*http.Request{httptest.NewRequest("POST", "/v0/put-dir/photos", strings.NewReader(`{"Files": [{"Path": "../../etc/passwd", "Size": 1}]}`))},
+			checks:     checks(httpStatus(http.StatusBadRequest)),
+		},
+		{
+			name:       "put_dir_traversal_in_dirname",
+			isSelf:     true,
+			capSharing: true,
+			reqs:       []*http.Request{httptest.NewRequest("POST", "/v0/put-dir/"+hexAll("../photos"), strings.NewReader(`{"Files": [{"Path": "a", "Size": 1}]}`))},
+			checks:     checks(httpStatus(http.StatusBadRequest)),
+		},
+		{
+			name:       "put_dir_file_not_begun",
+			isSelf:     true,
+			capSharing: true,
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put-dir/photos/a", strings.NewReader("x"))},
+			checks:     checks(httpStatus(http.StatusPreconditionFailed)),
+		},
+		{
+			name:       "put_dir_commit_incomplete",
+			isSelf:     true,
+			capSharing: true,
+			reqs: []*http.Request{
+				httptest.NewRequest("POST", "/v0/put-dir/photos", strings.NewReader(`{"Files": [{"Path": "a", "Size": 1}]}`)),
+				httptest.NewRequest("POST", "/v0/put-dir/photos?commit=1", nil),
+			},
+			checks: checks(
+				httpStatus(http.StatusInternalServerError),
+				bodyContains("not received"),
+			),
+		},
+		{
+			name:       "put_allowed_sender",
+			isSelf:     true,
+			capSharing: true,
+			policy:     taildrop.Policy{AllowedSenders: []string{"tag:ci", "alice@example.com"}},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))},
+			checks: checks(
+				httpStatus(200),
+				fileHasContents("foo", "contents"),
+			),
+		},
+		{
+			name:       "put_reject_sender_not_allowed",
+			isSelf:     true,
+			capSharing: true,
+			policy:     taildrop.Policy{AllowedSenders: []string{"tag:ci", "bob@example.com"}},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))},
+			checks: checks(
+				httpStatus(http.StatusForbidden),
+				bodyContains("not allowed"),
+				fileMissing("foo"),
+			),
+		},
+		{
+			name:       "put_reject_sender_quota",
+			isSelf:     true,
+			capSharing: true,
+			policy:     taildrop.Policy{MaxBytesPerSenderPerDay: 10},
+			reqs: []*http.Request{
+				httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents")),
+				httptest.NewRequest("PUT", "/v0/put/bar", strings.NewReader("contents")),
+			},
+			checks: checks(
+				httpStatus(http.StatusTooManyRequests),
+				fileHasContents("foo", "contents"),
+				fileMissing("bar"),
+			),
+		},
+		{
+			name:       "put_reject_inbox_full",
+			isSelf:     true,
+			capSharing: true,
+			policy:     taildrop.Policy{MaxInboxBytes: 4},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))},
+			checks: checks(
+				httpStatus(http.StatusInsufficientStorage),
+				fileMissing("foo"),
+			),
+		},
+		{
+			name:       "put_requires_confirmation",
+			isSelf:     true,
+			capSharing: true,
+			policy:     taildrop.Policy{RequireConfirmation: true},
+			reqs:       []*http.Request{httptest.NewRequest("PUT", "/v0/put/foo", strings.NewReader("contents"))},
+			checks: checks(
+				httpStatus(200),
+				fileMissing("foo"),
+				fileHasContents("foo.pending.partial", "contents"),
+			),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -535,6 +720,9 @@ func(t *testing.T, env *peerAPITestEnv) {
 				peerNode: (&tailcfg.Node{
 					ComputedName: "some-peer-name",
 				}).View(),
+				peerUser: tailcfg.UserProfile{
+					LoginName: "alice@example.com",
+				},
 				ps: &peerAPIServer{
 					b: lb,
 				},
@@ -544,8 +732,9 @@ func(t *testing.T, env *peerAPITestEnv) {
 				rootDir = t.TempDir()
 				if e.ph.ps.taildrop == nil {
 					e.ph.ps.taildrop = taildrop.ManagerOptions{
-						Logf: e.logBuf.Logf,
-						Dir:  rootDir,
+						Logf:   e.logBuf.Logf,
+						Dir:    rootDir,
+						Policy: tt.policy,
 					}.New()
 				}
 			}
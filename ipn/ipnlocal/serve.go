@@ -34,6 +34,8 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/nettype"
+	"tailscale.com/types/views"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
 )
@@ -56,6 +58,10 @@ type serveHTTPContext struct {
 	DestPort uint16
 }
 
+// serveHandlerContextKey is the context.Value key for the ipn.HTTPHandlerView
+// that a proxied request matched, so its header rules can be applied.
+type serveHandlerContextKey struct{}
+
 // localListener is the state of host-level net.Listen for a specific (Tailscale IP, port)
 // combination. If there are two TailscaleIPs (v4 and v6) and three ports being served,
 // then there will be six of these active and looping in their Run method.
@@ -248,6 +254,9 @@ func (b *LocalBackend) SetServeConfig(config *ipn.ServeConfig, etag string) erro
 }
 
 func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig, etag string) error {
+	if err := config.CheckValid(); err != nil {
+		return fmt.Errorf("invalid serve config: %w", err)
+	}
 	prefs := b.pm.CurrentPrefs()
 	if config.IsFunnelOn() && prefs.ShieldsUp() {
 		return errors.New("Unable to turn on Funnel while shields-up is enabled")
@@ -429,6 +438,13 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 		return nil
 	}
 
+	if !b.serveAccessAllowed(tcph.Access(), srcAddr) {
+		return func(c net.Conn) error {
+			b.logf("serve: denied connection from %v to port %v", srcAddr, dport)
+			return c.Close()
+		}
+	}
+
 	if tcph.HTTPS() || tcph.HTTP() {
 		hs := &http.Server{
 			Handler: http.HandlerFunc(b.serveWebHandler),
@@ -500,27 +516,156 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 	return nil
 }
 
+const (
+	// udpServeIdleTimeout is how long a UDP serve session may be idle if its
+	// ipn.UDPPortHandler doesn't say otherwise. It matches what netstack
+	// uses when forwarding UDP to subnets.
+	udpServeIdleTimeout = 2 * time.Minute
+
+	// udpServeDNSIdleTimeout is like udpServeIdleTimeout, but for port 53,
+	// where sessions are typically a single query and response.
+	udpServeDNSIdleTimeout = 30 * time.Second
+
+	// udpServeMaxSessions is the maximum number of concurrent UDP serve
+	// sessions per port. Sessions beyond that are dropped.
+	udpServeMaxSessions = 1024
+)
+
+// udpHandlerForServe returns a handler for a UDP session to be served via the
+// ipn.ServeConfig.
+func (b *LocalBackend) udpHandlerForServe(dport uint16, srcAddr netip.AddrPort) (handler func(nettype.ConnPacketConn)) {
+	b.mu.Lock()
+	sc := b.serveConfig
+	b.mu.Unlock()
+
+	if !sc.Valid() {
+		return nil
+	}
+
+	udph, ok := sc.FindUDP(dport)
+	if !ok {
+		return nil
+	}
+
+	backDst := udph.UDPForward()
+	if backDst == "" || !b.serveAccessAllowed(udph.Access(), srcAddr) {
+		return func(c nettype.ConnPacketConn) {
+			b.logf("serve: denied UDP session from %v to port %v", srcAddr, dport)
+			c.Close()
+		}
+	}
+
+	idleTimeout := time.Duration(udph.IdleTimeoutSec()) * time.Second
+	if idleTimeout <= 0 {
+		idleTimeout = udpServeIdleTimeout
+		if dport == 53 {
+			idleTimeout = udpServeDNSIdleTimeout
+		}
+	}
+
+	return func(c nettype.ConnPacketConn) {
+		defer c.Close()
+		if !b.startUDPServeSession(dport) {
+			b.logf("serve: too many UDP sessions to port %v; dropping session from %v", dport, srcAddr)
+			return
+		}
+		defer b.endUDPServeSession(dport)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		backConn, err := b.dialer.SystemDial(ctx, "udp", backDst)
+		cancel()
+		if err != nil {
+			b.logf("localbackend: failed to UDP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
+			return
+		}
+		defer backConn.Close()
+		proxyUDPSession(c, backConn, idleTimeout)
+	}
+}
+
+// startUDPServeSession records the start of a UDP serve session to port. It
+// reports false if there are already udpServeMaxSessions sessions to port.
+func (b *LocalBackend) startUDPServeSession(port uint16) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.udpServeSessions[port] >= udpServeMaxSessions {
+		return false
+	}
+	mak.Set(&b.udpServeSessions, port, b.udpServeSessions[port]+1)
+	return true
+}
+
+// endUDPServeSession records the end of a UDP serve session to port that was
+// started with startUDPServeSession.
+func (b *LocalBackend) endUDPServeSession(port uint16) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := b.udpServeSessions[port] - 1; n > 0 {
+		b.udpServeSessions[port] = n
+	} else {
+		delete(b.udpServeSessions, port)
+	}
+}
+
+// proxyUDPSession copies datagrams between client and backend until either
+// fails, or neither has sent a datagram for idleTimeout. It closes both.
+func proxyUDPSession(client, backend net.Conn, idleTimeout time.Duration) {
+	closeBoth := func() {
+		client.Close()
+		backend.Close()
+	}
+	timer := time.AfterFunc(idleTimeout, closeBoth)
+	defer timer.Stop()
+	defer closeBoth()
+
+	errc := make(chan error, 2)
+	copyDatagrams := func(dst, src net.Conn) {
+		buf := make([]byte, 64<<10)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				errc <- err
+				return
+			}
+			timer.Reset(idleTimeout)
+			if _, err := dst.Write(buf[:n]); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}
+	go copyDatagrams(backend, client)
+	go copyDatagrams(client, backend)
+	<-errc
+}
+
 func getServeHTTPContext(r *http.Request) (c *serveHTTPContext, ok bool) {
 	c, ok = r.Context().Value(serveHTTPContextKey{}).(*serveHTTPContext)
 	return c, ok
 }
 
-func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
-	var z ipn.HTTPHandlerView // zero value
-
+// serveHostname returns the hostname under which r is looked up in the
+// Web entries of the ServeConfig: the TLS server name, or for plain HTTP
+// the Host header qualified with the tailnet's MagicDNS suffix.
+func (b *LocalBackend) serveHostname(r *http.Request) string {
+	if r.TLS != nil {
+		return r.TLS.ServerName
+	}
 	hostname := r.Host
-	if r.TLS == nil {
-		tcd := "." + b.Status().CurrentTailnet.MagicDNSSuffix
-		if host, _, err := net.SplitHostPort(hostname); err == nil {
-			hostname = host
-		}
-		if !strings.HasSuffix(hostname, tcd) {
-			hostname += tcd
-		}
-	} else {
-		hostname = r.TLS.ServerName
+	tcd := "." + b.Status().CurrentTailnet.MagicDNSSuffix
+	if host, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = host
 	}
+	if !strings.HasSuffix(hostname, tcd) {
+		hostname += tcd
+	}
+	return hostname
+}
 
+func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
+	var z ipn.HTTPHandlerView // zero value
+
+	hostname := b.serveHostname(r)
 	sctx, ok := getServeHTTPContext(r)
 	if !ok {
 		b.logf("[unexpected] localbackend: no serveHTTPContext in request")
@@ -609,12 +754,32 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "proxy is closed", http.StatusServiceUnavailable)
 		return
 	}
+	h, _ := r.Context().Value(serveHandlerContextKey{}).(ipn.HTTPHandlerView)
+	var vars *serveHeaderVars // lazily populated by expand
+	expand := func(v string) string {
+		if !strings.Contains(v, "${") {
+			return v
+		}
+		if vars == nil {
+			vars = rp.lb.serveHeaderVarsForRequest(r)
+		}
+		return vars.expand(v)
+	}
 	p := &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
 		r.SetURL(rp.url)
 		r.Out.Host = r.In.Host
 		addProxyForwardedHeaders(r)
 		rp.lb.addTailscaleIdentityHeaders(r)
+		if h.Valid() {
+			applyHeaderRules(r.Out.Header, h.RemoveRequestHeaders(), h.SetRequestHeaders(), expand)
+		}
 	}}
+	if h.Valid() && (h.RemoveResponseHeaders().Len() > 0 || h.SetResponseHeaders().Len() > 0) {
+		p.ModifyResponse = func(res *http.Response) error {
+			applyHeaderRules(res.Header, h.RemoveResponseHeaders(), h.SetResponseHeaders(), expand)
+			return nil
+		}
+	}
 
 	// There is no way to autodetect h2c as per RFC 9113
 	// https://datatracker.ietf.org/doc/html/rfc9113#name-starting-http-2.
@@ -715,6 +880,100 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 	r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
 }
 
+// serveAccessAllowed reports whether the node at src may use a serve handler
+// with the given access restrictions. See ipn.ServeAccess.
+func (b *LocalBackend) serveAccessAllowed(ac ipn.ServeAccessView, src netip.AddrPort) bool {
+	if !ac.Valid() {
+		return true
+	}
+	node, user, ok := b.WhoIs(src)
+	if !ok {
+		return false // traffic from outside of Tailnet (funneled)
+	}
+	tags := node.Tags()
+	for i := 0; i < tags.Len(); i++ {
+		if views.SliceContains(ac.Tags(), tags.At(i)) {
+			return true
+		}
+	}
+	if !node.IsTagged() && views.SliceContains(ac.Users(), user.LoginName) {
+		return true
+	}
+	if ac.Caps().Len() > 0 {
+		caps := b.PeerCaps(src.Addr())
+		for i := 0; i < ac.Caps().Len(); i++ {
+			if caps.HasCapability(ac.Caps().At(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveHeaderVars are the values of the variables that may be used in the
+// header rules of an ipn.HTTPHandler, for a particular request.
+type serveHeaderVars struct {
+	nodeName, nodeID, nodeIP string
+	tags                     string
+	userLogin, userName      string
+	caps                     string
+}
+
+// serveHeaderVarsForRequest returns the header rule variables for the node
+// that sent r. They're all empty if r didn't come from a node in the tailnet.
+func (b *LocalBackend) serveHeaderVarsForRequest(r *http.Request) *serveHeaderVars {
+	vars := new(serveHeaderVars)
+	c, ok := getServeHTTPContext(r)
+	if !ok {
+		return vars
+	}
+	node, user, ok := b.WhoIs(c.SrcAddr)
+	if !ok {
+		return vars // traffic from outside of Tailnet (funneled)
+	}
+	vars.nodeName = strings.TrimSuffix(node.Name(), ".")
+	vars.nodeID = string(node.StableID())
+	vars.nodeIP = c.SrcAddr.Addr().String()
+	vars.tags = strings.Join(node.Tags().AsSlice(), ",")
+	if !node.IsTagged() {
+		vars.userLogin = user.LoginName
+		vars.userName = user.DisplayName
+	}
+	var caps []string
+	for pc := range b.PeerCaps(c.SrcAddr.Addr()) {
+		caps = append(caps, string(pc))
+	}
+	slices.Sort(caps)
+	vars.caps = strings.Join(caps, ",")
+	return vars
+}
+
+// expand returns v with the variables documented at
+// ipn.HTTPHandler.SetRequestHeaders replaced by their values.
+func (vars *serveHeaderVars) expand(v string) string {
+	return strings.NewReplacer(
+		"${NODE_NAME}", vars.nodeName,
+		"${NODE_ID}", vars.nodeID,
+		"${NODE_IP}", vars.nodeIP,
+		"${TAGS}", vars.tags,
+		"${USER_LOGIN}", vars.userLogin,
+		"${USER_NAME}", vars.userName,
+		"${CAPS}", vars.caps,
+	).Replace(v)
+}
+
+// applyHeaderRules removes the headers named in remove from hdr, then sets the
+// headers in set, with their values passed through expand.
+func applyHeaderRules(hdr http.Header, remove views.Slice[string], set views.Map[string, string], expand func(string) string) {
+	for i := 0; i < remove.Len(); i++ {
+		hdr.Del(remove.At(i))
+	}
+	set.Range(func(k, v string) bool {
+		hdr.Set(k, expand(v))
+		return true
+	})
+}
+
 // serveWebHandler is an http.HandlerFunc that maps incoming requests to the
 // correct *http.
 func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
@@ -723,11 +982,28 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if c, ok := getServeHTTPContext(r); !ok || !b.serveAccessAllowed(h.Access(), c.SrcAddr) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if code := h.Status(); code != 0 {
+			w.WriteHeader(code)
+		}
 		io.WriteString(w, s)
 		return
 	}
+	if v := h.Redirect(); v != "" {
+		code := h.Status()
+		if !ipn.IsRedirectStatus(code) {
+			code = http.StatusFound
+		}
+		// Having found a handler, the hostname is one configured in
+		// Web, unlike an arbitrary Host header.
+		http.Redirect(w, r, expandRedirectTarget(v, b.serveHostname(r), r, mountPoint), code)
+		return
+	}
 	if v := h.Path(); v != "" {
 		b.serveFileOrDirectory(w, r, v, mountPoint)
 		return
@@ -738,6 +1014,7 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
+		r = r.WithContext(context.WithValue(r.Context(), serveHandlerContextKey{}, h))
 		h := p.(http.Handler)
 		// Trim the mount point from the URL path before proxying. (#6571)
 		if r.URL.Path != "/" {
@@ -746,10 +1023,42 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		h.ServeHTTP(w, r)
 		return
 	}
+	if code := h.Status(); code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
 
 	http.Error(w, "empty handler", 500)
 }
 
+// expandRedirectTarget returns the URL to redirect r to, given the target of
+// a Redirect handler mounted at mountPoint and served at hostname. See
+// ipn.HTTPHandler.Redirect for the supported variables.
+func expandRedirectTarget(target, hostname string, r *http.Request, mountPoint string) string {
+	relPath := r.URL.Path
+	if mountPoint != "/" {
+		relPath = strings.TrimPrefix(relPath, strings.TrimSuffix(mountPoint, "/"))
+	}
+	if !strings.HasPrefix(relPath, "/") {
+		relPath = "/" + relPath
+	}
+	if strings.Contains(target, "${") {
+		return strings.NewReplacer(
+			"${HOST}", hostname,
+			"${PATH}", relPath,
+			"${QUERY}", r.URL.RawQuery,
+			"${REQUEST_URI}", r.URL.RequestURI(),
+		).Replace(target)
+	}
+	if relPath != "/" || strings.HasSuffix(r.URL.Path, "/") {
+		target = strings.TrimSuffix(target, "/") + relPath
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	return target
+}
+
 func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
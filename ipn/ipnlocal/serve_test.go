@@ -12,6 +12,8 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -447,6 +449,284 @@ type headerCheck struct {
 	}
 }
 
+func TestServeHTTPProxyHeaderRules(t *testing.T) {
+	b := newTestBackend(t)
+	b.peers[152] = (&tailcfg.Node{
+		ID:       152,
+		StableID: "n152",
+		Name:     "some-peer.example.ts.net.",
+		User:     tailcfg.UserID(1),
+	}).View()
+
+	// Start test serve endpoint that echoes the request headers and sets
+	// some response headers.
+	testServ := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			for key, val := range r.Header {
+				w.Header().Add("Echo-"+key, strings.Join(val, ","))
+			}
+			w.Header().Set("Server", "backend/1.0")
+			w.Header().Set("X-Internal", "secret")
+		},
+	))
+	defer testServ.Close()
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy:                testServ.URL,
+					RemoveRequestHeaders: []string{"Tailscale-User-Profile-Pic", "Cookie"},
+					SetRequestHeaders: map[string]string{
+						"X-Node":   "${NODE_NAME}/${NODE_ID}/${NODE_IP}",
+						"X-User":   "${USER_LOGIN}",
+						"X-Tags":   "${TAGS}",
+						"X-Static": "static",
+					},
+					RemoveResponseHeaders: []string{"X-Internal"},
+					SetResponseHeaders: map[string]string{
+						"Server":       "tailscale",
+						"X-Served-For": "${NODE_IP}",
+					},
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		srcIP       string
+		wantHeaders map[string]string
+	}{
+		{
+			name:  "user-node",
+			srcIP: "100.150.151.152",
+			wantHeaders: map[string]string{
+				"Echo-X-Node":                     "some-peer.example.ts.net/n152/100.150.151.152",
+				"Echo-X-User":                     "someone@example.com",
+				"Echo-X-Tags":                     "",
+				"Echo-X-Static":                   "static",
+				"Echo-X-Spoofed":                  "",
+				"Echo-Cookie":                     "",
+				"Echo-Tailscale-User-Login":       "someone@example.com",
+				"Echo-Tailscale-User-Profile-Pic": "",
+				"Server":                          "tailscale",
+				"X-Served-For":                    "100.150.151.152",
+				"X-Internal":                      "",
+			},
+		},
+		{
+			name:  "tagged-node",
+			srcIP: "100.150.151.153",
+			wantHeaders: map[string]string{
+				"Echo-X-Node":   "//100.150.151.153",
+				"Echo-X-User":   "",
+				"Echo-X-Tags":   "tag:server,tag:test",
+				"Echo-X-Static": "static",
+				"X-Served-For":  "100.150.151.153",
+			},
+		},
+		{
+			name:  "outside-tailnet",
+			srcIP: "100.160.161.162",
+			wantHeaders: map[string]string{
+				"Echo-X-Node":   "//",
+				"Echo-X-User":   "",
+				"Echo-X-Static": "static",
+				"X-Served-For":  "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{
+				URL:    &url.URL{Path: "/"},
+				Header: http.Header{"Cookie": {"a=b"}},
+				TLS:    &tls.ConnectionState{ServerName: "example.ts.net"},
+			}
+			req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort(tt.srcIP + ":1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			h := w.Result().Header
+			for k, want := range tt.wantHeaders {
+				if got := h.Get(k); got != want {
+					t.Errorf("header %q = %q; want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestServeAccess(t *testing.T) {
+	b := newTestBackend(t)
+
+	conf := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			443: {HTTPS: true},
+			5432: {
+				TCPForward: "127.0.0.1:5432",
+				Access:     &ipn.ServeAccess{Users: []string{"someone@example.com"}},
+			},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":       {Text: "public"},
+				"/admin/": {Text: "admin", Access: &ipn.ServeAccess{Tags: []string{"tag:server"}}},
+				"/me/":    {Text: "me", Access: &ipn.ServeAccess{Users: []string{"someone@example.com"}}},
+				"/none/":  {Text: "none", Access: &ipn.ServeAccess{}},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		userNode   = "100.150.151.152"
+		taggedNode = "100.150.151.153"
+		funnel     = "100.160.161.162"
+	)
+	tests := []struct {
+		path     string
+		srcIP    string
+		wantCode int
+	}{
+		{"/", userNode, 200},
+		{"/", funnel, 200},
+		{"/admin/", taggedNode, 200},
+		{"/admin/", userNode, 403},
+		{"/admin/", funnel, 403},
+		{"/me/", userNode, 200},
+		{"/me/", taggedNode, 403}, // tagged nodes never match Users
+		{"/none/", userNode, 403},
+	}
+	for _, tt := range tests {
+		req := &http.Request{
+			URL: &url.URL{Path: tt.path},
+			TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort(tt.srcIP + ":1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		if got := w.Code; got != tt.wantCode {
+			t.Errorf("%s from %s: status = %d; want %d", tt.path, tt.srcIP, got, tt.wantCode)
+		}
+	}
+
+	// A denied TCP connection is closed without dialing the backend.
+	h := b.tcpHandlerForServe(5432, netip.MustParseAddrPort(taggedNode+":1234"))
+	if h == nil {
+		t.Fatal("no handler for denied TCP connection")
+	}
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	if err := h(c1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from denied conn = %v; want EOF", err)
+	}
+}
+
+func TestServeRedirectAndStatus(t *testing.T) {
+	b := newTestBackend(t)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/old":  {Redirect: "https://new.example.com/"},
+				"/perm": {Redirect: "https://new.example.com/base", Status: 301},
+				"/tmpl": {Redirect: "https://${HOST}:8443/x${PATH}?${QUERY}", Status: 307},
+				"/gone": {Status: http.StatusGone},
+				"/down": {Text: "down for maintenance", Status: http.StatusServiceUnavailable},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path         string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{"/old", 302, "https://new.example.com/", ""},
+		{"/old/", 302, "https://new.example.com/", ""},
+		{"/old/a/b?c=d", 302, "https://new.example.com/a/b?c=d", ""},
+		{"/perm/foo", 301, "https://new.example.com/base/foo", ""},
+		{"/tmpl/foo?q=1", 307, "https://example.ts.net:8443/x/foo?q=1", ""},
+		{"/gone/foo", 410, "", "Gone\n"},
+		{"/down", 503, "", "down for maintenance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://example.ts.net"+tt.path, nil)
+			req.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+			req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+				DestPort: 443,
+				SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+			}))
+
+			w := httptest.NewRecorder()
+			b.serveWebHandler(w, req)
+
+			res := w.Result()
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("status = %d; want %d", res.StatusCode, tt.wantCode)
+			}
+			if got := res.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q; want %q", got, tt.wantLocation)
+			}
+			if tt.wantBody != "" {
+				if got := w.Body.String(); got != tt.wantBody {
+					t.Errorf("body = %q; want %q", got, tt.wantBody)
+				}
+			}
+		})
+	}
+
+	// ${HOST} is the configured hostname, not the client's Host header.
+	req := httptest.NewRequest("GET", "https://evil.example.com/tmpl/foo", nil)
+	req.TLS = &tls.ConnectionState{ServerName: "example.ts.net"}
+	req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+		DestPort: 443,
+		SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+	}))
+	w := httptest.NewRecorder()
+	b.serveWebHandler(w, req)
+	if got, want := w.Result().Header.Get("Location"), "https://example.ts.net:8443/x/foo?"; got != want {
+		t.Errorf("Location with forged Host = %q; want %q", got, want)
+	}
+
+	// Status codes which can't be used are rejected.
+	for _, h := range []*ipn.HTTPHandler{
+		{Redirect: "https://new.example.com/", Status: 200},
+		{Proxy: "http://127.0.0.1:3000", Status: 301},
+	} {
+		conf := &ipn.ServeConfig{
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{"/": h}},
+			},
+		}
+		if err := b.SetServeConfig(conf, ""); err == nil {
+			t.Errorf("SetServeConfig with %+v succeeded; want error", h)
+		}
+	}
+}
+
 func Test_reverseProxyConfiguration(t *testing.T) {
 	b := newTestBackend(t)
 	type test struct {
@@ -721,3 +1001,122 @@ func Test_isGRPCContentType(t *testing.T) {
 		}
 	}
 }
+
+func TestServeUDP(t *testing.T) {
+	b := newTestBackend(t)
+
+	// Start a UDP echo server as the backend.
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(append([]byte("echo: "), buf[:n]...), addr)
+		}
+	}()
+
+	conf := &ipn.ServeConfig{
+		UDP: map[uint16]*ipn.UDPPortHandler{
+			53: {UDPForward: backend.LocalAddr().String()},
+			54: {
+				UDPForward: backend.LocalAddr().String(),
+				Access:     &ipn.ServeAccess{Tags: []string{"tag:server"}},
+			},
+		},
+	}
+	if err := b.SetServeConfig(conf, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !b.ShouldInterceptUDPPort(53) || b.ShouldInterceptUDPPort(55) {
+		t.Error("wrong UDP ports intercepted")
+	}
+	if b.ShouldInterceptTCPPort(53) {
+		t.Error("UDP serve port intercepted for TCP")
+	}
+
+	if h := b.udpHandlerForServe(55, netip.MustParseAddrPort("100.150.151.152:1234")); h != nil {
+		t.Error("got handler for unconfigured port")
+	}
+
+	// newSession returns a socket for a client and the handler's end of
+	// the session, as netstack would provide.
+	newSession := func() (client net.PacketConn, server *net.UDPConn) {
+		t.Helper()
+		client, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		server, err = net.DialUDP("udp", nil, client.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, server
+	}
+
+	client, server := newSession()
+	h := b.udpHandlerForServe(53, netip.MustParseAddrPort("100.150.151.152:1234"))
+	if h == nil {
+		t.Fatal("no handler for UDP port 53")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(server)
+	}()
+	for _, msg := range []string{"one", "two"} {
+		if _, err := client.WriteTo([]byte(msg), server.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1500)
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(buf[:n]), "echo: "+msg; got != want {
+			t.Errorf("got %q; want %q", got, want)
+		}
+	}
+	server.Close()
+	<-done
+
+	// A session from a node without access is closed immediately.
+	_, server = newSession()
+	h = b.udpHandlerForServe(54, netip.MustParseAddrPort("100.150.151.152:1234"))
+	if h == nil {
+		t.Fatal("no handler for UDP port 54")
+	}
+	h(server)
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Error("session from denied node not closed")
+	}
+}
+
+func TestProxyUDPSessionIdleTimeout(t *testing.T) {
+	c1, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proxyUDPSession(c1, c2, 50*time.Millisecond)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session not closed")
+	}
+}
@@ -88,6 +88,14 @@ type TKAKey struct {
 	Key      key.NLPublic
 	Metadata map[string]string
 	Votes    uint
+
+	// Kind is the kind of key, as returned by tka.KeyKind.String.
+	Kind string `json:",omitempty"`
+
+	// Public is the encoded public key, for keys which cannot be
+	// represented by Key (such as ECDSA P-256 keys). If Public is
+	// set, Key is zero.
+	Public []byte `json:",omitempty"`
 }
 
 // TKAFilteredPeer describes a peer which was removed from the netmap
@@ -127,6 +135,11 @@ type NetworkLockStatus struct {
 	// to network-lock.
 	TrustedKeys []TKAKey
 
+	// KeyChangeThreshold is the number of distinct trusted keys which
+	// must sign a change to the set of trusted keys. This field is not
+	// populated if the network lock is disabled.
+	KeyChangeThreshold int `json:",omitempty"`
+
 	// FilteredPeers describes peers which were removed from the netmap
 	// (i.e. no connectivity) because they failed tailnet lock
 	// checks.
@@ -36,6 +36,7 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
@@ -66,10 +67,12 @@
 // then it's a prefix match.
 var handler = map[string]localAPIHandler{
 	// The prefix match handlers end with a slash:
-	"cert/":     (*Handler).serveCert,
-	"file-put/": (*Handler).serveFilePut,
-	"files/":    (*Handler).serveFiles,
-	"profiles/": (*Handler).serveProfiles,
+	"cert/":         (*Handler).serveCert,
+	"file-pending/": (*Handler).serveFilePending,
+	"file-put/":     (*Handler).serveFilePut,
+	"file-put-dir/": (*Handler).serveFilePutDir,
+	"files/":        (*Handler).serveFiles,
+	"profiles/":     (*Handler).serveProfiles,
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
@@ -109,6 +112,7 @@
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
 	"tka/init":                    (*Handler).serveTKAInit,
+	"tka/export":                  (*Handler).serveTKAExport,
 	"tka/log":                     (*Handler).serveTKALog,
 	"tka/modify":                  (*Handler).serveTKAModify,
 	"tka/sign":                    (*Handler).serveTKASign,
@@ -121,6 +125,9 @@
 	"tka/generate-recovery-aum":   (*Handler).serveTKAGenerateRecoveryAUM,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
+	"tka/generate-proposal":       (*Handler).serveTKAGenerateProposal,
+	"tka/cosign-proposal":         (*Handler).serveTKACosignProposal,
+	"tka/submit-proposal":         (*Handler).serveTKASubmitProposal,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
@@ -607,6 +614,19 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		}
 	case "pick-new-derp":
 		err = h.b.DebugPickNewDERP()
+	case "dns-cache-stats":
+		var st resolver.CacheStats
+		st, err = h.b.DebugDNSCacheStats()
+		if err != nil {
+			break
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(st)
+		if err == nil {
+			return
+		}
+	case "dns-cache-flush":
+		err = h.b.DebugFlushDNSCache()
 	case "":
 		err = fmt.Errorf("missing parameter 'action'")
 	default:
@@ -1377,6 +1397,71 @@ func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, rc)
 }
 
+// serveFilePending lists the received files which are pending because
+// the Taildrop policy requires confirmation, with GET (optionally waiting
+// for one with waitsec), accepts one with POST file-pending/<name>, and
+// rejects one with DELETE file-pending/<name>.
+func (h *Handler) serveFilePending(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	suffix, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-pending/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	if suffix == "" {
+		if r.Method != "GET" {
+			http.Error(w, "want GET to list pending files", http.StatusBadRequest)
+			return
+		}
+		ctx := r.Context()
+		if s := r.FormValue("waitsec"); s != "" && s != "0" {
+			d, err := strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "invalid waitsec", http.StatusBadRequest)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(d)*time.Second)
+			defer cancel()
+		}
+		pfs, err := h.b.AwaitPendingFiles(ctx)
+		if err != nil && ctx.Err() == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mak.NonNilSliceForJSON(&pfs)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pfs)
+		return
+	}
+	name, err := url.PathUnescape(suffix)
+	if err != nil {
+		http.Error(w, "bad filename", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "POST":
+		newName, err := h.b.AcceptPendingFile(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Name string }{newName})
+	case "DELETE":
+		if err := h.b.RejectPendingFile(name); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "want POST or DELETE", http.StatusMethodNotAllowed)
+	}
+}
+
 func writeErrorJSON(w http.ResponseWriter, err error) {
 	if err == nil {
 		err = errors.New("unexpected nil error")
@@ -1427,6 +1512,9 @@ func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
 // URL format:
 //
 //   - PUT /localapi/v0/file-put/:stableID/:escaped-filename
+//
+// The SHA-256 digest of the file, if known, may be given in the
+// Tailscale-File-Sha256 header, and is verified by the receiver.
 func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 	metricFilePutCalls.Add(1)
 
@@ -1438,12 +1526,6 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "want PUT to put file", http.StatusBadRequest)
 		return
 	}
-	fts, err := h.b.FileTargets()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
 	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put/")
 	if !ok {
 		http.Error(w, "misconfigured", http.StatusInternalServerError)
@@ -1454,8 +1536,81 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bogus URL", http.StatusBadRequest)
 		return
 	}
-	stableID := tailcfg.StableNodeID(stableIDStr)
+	dstURL, ok := h.fileTargetPeerAPIURL(w, tailcfg.StableNodeID(stableIDStr))
+	if !ok {
+		return
+	}
+	h.proxyFilePut(w, r, dstURL, "/v0/put/"+filenameEscaped)
+}
+
+// serveFilePutDir sends a directory of files to another node, by proxying
+// to the peer's /v0/put-dir/ PeerAPI handler. Files are resumed as for
+// serveFilePut.
+//
+// URL format:
+//
+//   - POST /localapi/v0/file-put-dir/:stableID/:escaped-dirname, with a
+//     JSON apitype.DirManifest body, begins sending the directory and
+//     returns an apitype.PutDirResponse.
+//   - PUT /localapi/v0/file-put-dir/:stableID/:escaped-dirname/:escaped-path
+//     sends one of the files in the manifest.
+//   - POST /localapi/v0/file-put-dir/:stableID/:escaped-dirname?commit=1
+//     completes sending the directory.
+func (h *Handler) serveFilePutDir(w http.ResponseWriter, r *http.Request) {
+	metricFilePutCalls.Add(1)
+
+	if !h.PermitWrite {
+		http.Error(w, "file access denied", http.StatusForbidden)
+		return
+	}
+	upath, ok := strings.CutPrefix(r.URL.EscapedPath(), "/localapi/v0/file-put-dir/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	stableIDStr, rest, ok := strings.Cut(upath, "/")
+	if !ok || rest == "" {
+		http.Error(w, "bogus URL", http.StatusBadRequest)
+		return
+	}
+	_, _, isFile := strings.Cut(rest, "/")
+	switch {
+	case isFile && r.Method != "PUT":
+		http.Error(w, "want PUT to put file", http.StatusBadRequest)
+		return
+	case !isFile && r.Method != "POST":
+		http.Error(w, "want POST to begin or commit directory", http.StatusBadRequest)
+		return
+	}
+	dstURL, ok := h.fileTargetPeerAPIURL(w, tailcfg.StableNodeID(stableIDStr))
+	if !ok {
+		return
+	}
+	peerPath := "/v0/put-dir/" + rest
+	if isFile {
+		h.proxyFilePut(w, r, dstURL, peerPath)
+		return
+	}
+	outReq, err := http.NewRequestWithContext(r.Context(), "POST", "http://peer"+peerPath+"?"+r.URL.RawQuery, r.Body)
+	if err != nil {
+		http.Error(w, "bogus outreq", http.StatusInternalServerError)
+		return
+	}
+	outReq.ContentLength = r.ContentLength
+	rp := httputil.NewSingleHostReverseProxy(dstURL)
+	rp.Transport = h.b.Dialer().PeerAPITransport()
+	rp.ServeHTTP(w, outReq)
+}
 
+// fileTargetPeerAPIURL returns the PeerAPI URL of the file target with
+// the given stableID. If there is no such target, it writes an error to
+// w and returns false.
+func (h *Handler) fileTargetPeerAPIURL(w http.ResponseWriter, stableID tailcfg.StableNodeID) (_ *url.URL, ok bool) {
+	fts, err := h.b.FileTargets()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
 	var ft *apitype.FileTarget
 	for _, x := range fts {
 		if x.Node.StableID == stableID {
@@ -1465,14 +1620,19 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 	}
 	if ft == nil {
 		http.Error(w, "node not found", http.StatusNotFound)
-		return
+		return nil, false
 	}
 	dstURL, err := url.Parse(ft.PeerAPIURL)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
+	return dstURL, true
+}
 
+// proxyFilePut PUTs the body of r to peerPath on the PeerAPI at dstURL,
+// first resuming any partial copy of the file the peer already has.
+func (h *Handler) proxyFilePut(w http.ResponseWriter, r *http.Request, dstURL *url.URL, peerPath string) {
 	// Before we PUT a file we check to see if there are any existing partial file and if so,
 	// we resume the upload from where we left off by sending the remaining file instead of
 	// the full file.
@@ -1483,7 +1643,7 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		Transport: h.b.Dialer().PeerAPITransport(),
 		Timeout:   10 * time.Second,
 	}
-	req, err := http.NewRequestWithContext(r.Context(), "GET", dstURL.String()+"/v0/put/"+filenameEscaped, nil)
+	req, err := http.NewRequestWithContext(r.Context(), "GET", dstURL.String()+peerPath, nil)
 	if err != nil {
 		http.Error(w, "bogus peer URL", http.StatusInternalServerError)
 		return
@@ -1508,12 +1668,15 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 		resumeDuration = time.Since(resumeStart).Round(time.Millisecond)
 	}
 
-	outReq, err := http.NewRequestWithContext(r.Context(), "PUT", "http://peer/v0/put/"+filenameEscaped, remainingBody)
+	outReq, err := http.NewRequestWithContext(r.Context(), "PUT", "http://peer"+peerPath, remainingBody)
 	if err != nil {
 		http.Error(w, "bogus outreq", http.StatusInternalServerError)
 		return
 	}
 	outReq.ContentLength = r.ContentLength
+	if sum := r.Header.Get(apitype.FileSHA256Header); sum != "" {
+		outReq.Header.Set(apitype.FileSHA256Header, sum)
+	}
 	if offset > 0 {
 		h.logf("resuming put at offset %d after %v", offset, resumeDuration)
 		rangeHdr, _ := httphdr.FormatRange([]httphdr.Range{{Start: offset, Length: 0}})
@@ -2032,6 +2195,26 @@ func (h *Handler) serveTKALog(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+func (h *Handler) serveTKAExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	aums, err := h.b.NetworkLockExport()
+	if err != nil {
+		http.Error(w, "export failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := tka.WriteExport(&buf, aums); err != nil {
+		http.Error(w, "JSON encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
 func (h *Handler) serveTKAAffectedSigs(w http.ResponseWriter, r *http.Request) {
 	if r.Method != httpm.POST {
 		http.Error(w, "use POST", http.StatusMethodNotAllowed)
@@ -2155,6 +2338,96 @@ func (h *Handler) serveTKASubmitRecoveryAUM(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKAGenerateProposal(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type proposalRequest struct {
+		AddKeys    []tka.Key
+		RemoveKeys []tka.Key
+		Threshold  int // negative to leave unchanged
+	}
+	var req proposalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON for proposalRequest body", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.b.NetworkLockGenerateProposal(req.AddKeys, req.RemoveKeys, req.Threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(res.Serialize())
+}
+
+func (h *Handler) serveTKACosignProposal(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.LimitReader(r.Body, 1024*1024)
+	aumBytes, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, "reading AUM", http.StatusBadRequest)
+		return
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(aumBytes); err != nil {
+		http.Error(w, "decoding AUM", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.b.NetworkLockCosignProposal(&aum)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(res.Serialize())
+}
+
+func (h *Handler) serveTKASubmitProposal(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.LimitReader(r.Body, 1024*1024)
+	aumBytes, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, "reading AUM", http.StatusBadRequest)
+		return
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(aumBytes); err != nil {
+		http.Error(w, "decoding AUM", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.b.NetworkLockSubmitProposal(&aum); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // serveProfiles serves profile switching-related endpoints. Supported methods
 // and paths are:
 //   - GET /profiles/: list all profiles (JSON-encoded array of ipn.LoginProfiles)
@@ -7,6 +7,7 @@
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/netip"
 	"net/url"
 	"strconv"
@@ -29,6 +30,10 @@ type ServeConfig struct {
 	// the Tailscale IP addresses. (not subnet routers, etc)
 	TCP map[uint16]*TCPPortHandler `json:",omitempty"`
 
+	// UDP are the list of UDP port numbers that tailscaled should handle
+	// for the Tailscale IP addresses. (not subnet routers, etc)
+	UDP map[uint16]*UDPPortHandler `json:",omitempty"`
+
 	// Web maps from "$SNI_NAME:$PORT" to a set of HTTP handlers
 	// keyed by mount point ("/", "/foo", etc)
 	Web map[HostPort]*WebServerConfig `json:",omitempty"`
@@ -123,19 +128,135 @@ type TCPPortHandler struct {
 	// SNI name with this value. It is only used if TCPForward is non-empty.
 	// (the HTTPS mode uses ServeConfig.Web)
 	TerminateTLS string `json:",omitempty"`
+
+	// Access, if non-nil, restricts which nodes may connect to this port.
+	// Connections from other nodes are dropped. For HTTP and HTTPS ports,
+	// it applies in addition to the Access of the HTTPHandler.
+	Access *ServeAccess `json:",omitempty"`
+}
+
+// UDPPortHandler describes what to do when handling UDP datagrams.
+//
+// Each client IP:port sending to the port is a separate session, with its
+// own socket to UDPForward, so that replies are routed back to the right
+// client.
+type UDPPortHandler struct {
+	// UDPForward is the IP:port to forward UDP datagrams to.
+	UDPForward string `json:",omitempty"`
+
+	// IdleTimeoutSec, if positive, is how long in seconds a session may go
+	// without any datagrams in either direction before it's closed. If
+	// zero, it defaults to 2 minutes, or 30 seconds for port 53 (DNS).
+	IdleTimeoutSec int `json:",omitempty"`
+
+	// Access, if non-nil, restricts which nodes may send to this port.
+	// Datagrams from other nodes are dropped.
+	Access *ServeAccess `json:",omitempty"`
 }
 
-// HTTPHandler is either a path or a proxy to serve.
+// HTTPHandler is either a path, a proxy, text, a redirect or a bare status
+// code to serve.
 type HTTPHandler struct {
-	// Exactly one of the following may be set.
+	// At most one of Path, Proxy, Text and Redirect may be set.
 
 	Path  string `json:",omitempty"` // absolute path to directory or file to serve
 	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
+	// Redirect, if non-empty, is the URL to redirect requests to.
+	//
+	// It may contain the variables ${HOST} (the hostname of the Web entry
+	// that served the request, without the port), ${PATH} (the request
+	// path with the mount point stripped, starting with a slash), ${QUERY}
+	// (the raw query, without the '?') and ${REQUEST_URI} (the original
+	// request path and query). ${HOST} is never taken from the client's
+	// Host header, so it can't be used to redirect elsewhere. If it contains
+	// no variables, the request path relative to the mount point and the
+	// query are appended to the URL, so that old links keep working.
+	Redirect string `json:",omitempty"`
+
+	// Status is the HTTP status code to respond with.
+	//
+	// With Redirect, it's the redirect status code: one of 301, 302, 303,
+	// 307 or 308. It defaults to 302 (Found).
+	//
+	// It can't be set with Path or Proxy.
+	//
+	// With Text, it's the status code of the text response. It defaults
+	// to 200.
+	//
+	// If none of the other fields are set, the handler responds with only
+	// this status code and its standard status text.
+	Status int `json:",omitempty"`
+
+	// RemoveRequestHeaders are the names of headers to remove from
+	// requests before they're proxied to Proxy.
+	RemoveRequestHeaders []string `json:",omitempty"`
+
+	// SetRequestHeaders maps from header name to the value to set it to in
+	// requests proxied to Proxy, replacing any values sent by the client.
+	// It's applied after RemoveRequestHeaders.
+	//
+	// Values may contain the following variables, which are expanded
+	// using the identity of the requesting node:
+	//
+	//   - ${NODE_NAME}: the node's MagicDNS name, without the trailing dot
+	//   - ${NODE_ID}: the node's stable ID
+	//   - ${NODE_IP}: the node's Tailscale IP address
+	//   - ${TAGS}: the node's ACL tags, comma-separated
+	//   - ${USER_LOGIN}: the login name of the node's user
+	//   - ${USER_NAME}: the display name of the node's user
+	//   - ${CAPS}: the peer capabilities granted to the node, comma-separated
+	//
+	// The variables expand to the empty string for requests from outside
+	// the tailnet (via Funnel). The user variables also expand to the empty
+	// string for tagged nodes.
+	SetRequestHeaders map[string]string `json:",omitempty"`
+
+	// RemoveResponseHeaders are the names of headers to remove from
+	// responses from Proxy.
+	RemoveResponseHeaders []string `json:",omitempty"`
+
+	// SetResponseHeaders is like SetRequestHeaders, but for responses from
+	// Proxy. It's applied after RemoveResponseHeaders.
+	SetResponseHeaders map[string]string `json:",omitempty"`
+
+	// Access, if non-nil, restricts which nodes may use this handler.
+	// Requests from other nodes get a 403 Forbidden response.
+	Access *ServeAccess `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
-	// temporary ones? Error codes? Redirects?
+	// temporary ones?
+}
+
+// ServeAccess restricts which nodes may use a serve handler.
+//
+// A node is allowed if it matches any of the criteria below. Traffic from
+// outside the tailnet (via Funnel) never matches, so an empty ServeAccess
+// denies everyone.
+type ServeAccess struct {
+	// Caps are peer capabilities, at least one of which must have been
+	// granted to the node by the tailnet policy.
+	Caps []tailcfg.PeerCapability `json:",omitempty"`
+
+	// Tags are ACL tags, at least one of which the node must have.
+	Tags []string `json:",omitempty"`
+
+	// Users are login names of users, one of which must own the node.
+	// Tagged nodes never match.
+	Users []string `json:",omitempty"`
+}
+
+// IsRedirectStatus reports whether code is a valid HTTPHandler.Status for a
+// Redirect handler.
+func IsRedirectStatus(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
 }
 
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
@@ -187,6 +308,49 @@ func (sc *ServeConfig) HasPathHandler() bool {
 	return false
 }
 
+// CheckValid reports whether the HTTP handlers of sc, including those of
+// foreground configs, are valid. In particular, Status is only allowed
+// where it is used, and must be a redirect status code for a Redirect.
+func (sc *ServeConfig) CheckValid() error {
+	if sc == nil {
+		return nil
+	}
+	for hp, wsc := range sc.Web {
+		if wsc == nil {
+			continue
+		}
+		for mount, h := range wsc.Handlers {
+			if h == nil {
+				continue
+			}
+			if err := h.checkValid(); err != nil {
+				return fmt.Errorf("handler for %s%s: %w", hp, mount, err)
+			}
+		}
+	}
+	for _, fg := range sc.Foreground {
+		if err := fg.CheckValid(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HTTPHandler) checkValid() error {
+	switch {
+	case h.Status == 0:
+		return nil
+	case h.Path != "" || h.Proxy != "":
+		return fmt.Errorf("status %d can't be used with Path or Proxy", h.Status)
+	case h.Redirect != "" && !IsRedirectStatus(h.Status):
+		return fmt.Errorf("invalid redirect status %d; must be one of 301, 302, 303, 307 or 308", h.Status)
+	case h.Status < 200 || h.Status > 599:
+		// 1xx codes are informational and can't be a final response.
+		return fmt.Errorf("invalid status %d; must be between 200 and 599", h.Status)
+	}
+	return nil
+}
+
 // IsTCPForwardingAny reports whether ServeConfig is currently forwarding in
 // TCPForward mode on any port. This is exclusive of Web/HTTPS serving.
 func (sc *ServeConfig) IsTCPForwardingAny() bool {
@@ -376,6 +540,27 @@ func (v ServeConfigView) RangeOverTCPs(f func(port uint16, _ TCPPortHandlerView)
 	})
 }
 
+// RangeOverUDPs ranges over both background and foreground UDPs.
+// If the returned bool from the given f is false, then this function stops
+// iterating immediately and does not check other foreground configs.
+func (v ServeConfigView) RangeOverUDPs(f func(port uint16, _ UDPPortHandlerView) bool) {
+	parentCont := true
+	v.UDP().Range(func(k uint16, v UDPPortHandlerView) (cont bool) {
+		parentCont = f(k, v)
+		return parentCont
+	})
+	v.Foreground().Range(func(k string, v ServeConfigView) (cont bool) {
+		if !parentCont {
+			return false
+		}
+		v.UDP().Range(func(k uint16, v UDPPortHandlerView) (cont bool) {
+			parentCont = f(k, v)
+			return parentCont
+		})
+		return parentCont
+	})
+}
+
 // RangeOverWebs ranges over both background and foreground Webs.
 // If the returned bool from the given f is false, then this function stops
 // iterating immediately and does not check other foreground configs.
@@ -411,6 +596,20 @@ func (v ServeConfigView) FindTCP(port uint16) (res TCPPortHandlerView, ok bool)
 	return v.TCP().GetOk(port)
 }
 
+// FindUDP returns the first UDP that matches with the given port. It
+// prefers a foreground match first followed by a background search if none
+// existed.
+func (v ServeConfigView) FindUDP(port uint16) (res UDPPortHandlerView, ok bool) {
+	v.Foreground().Range(func(_ string, v ServeConfigView) (cont bool) {
+		res, ok = v.UDP().GetOk(port)
+		return !ok
+	})
+	if ok {
+		return res, ok
+	}
+	return v.UDP().GetOk(port)
+}
+
 // FindWeb returns the first Web that matches with the given HostPort. It
 // prefers a foreground match first followed by a background search if none
 // existed.
@@ -126,3 +126,39 @@ func TestHasPathHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestServeConfigCheckValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       HTTPHandler
+		wantErr bool
+	}{
+		{"redirect", HTTPHandler{Redirect: "https://example.com/"}, false},
+		{"redirect-301", HTTPHandler{Redirect: "https://example.com/", Status: 301}, false},
+		{"redirect-200", HTTPHandler{Redirect: "https://example.com/", Status: 200}, true},
+		{"text-503", HTTPHandler{Text: "down", Status: 503}, false},
+		{"text-1000", HTTPHandler{Text: "down", Status: 1000}, true},
+		{"status-410", HTTPHandler{Status: 410}, false},
+		{"status-100", HTTPHandler{Status: 100}, true},
+		{"path-404", HTTPHandler{Path: "/tmp", Status: 404}, true},
+		{"proxy-301", HTTPHandler{Proxy: "http://127.0.0.1:3000", Status: 301}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.h
+			sc := &ServeConfig{
+				Foreground: map[string]*ServeConfig{
+					"abc123": {
+						Web: map[HostPort]*WebServerConfig{
+							"foo.test.ts.net:443": {Handlers: map[string]*HTTPHandler{"/": &h}},
+						},
+					},
+				},
+			}
+			err := sc.CheckValid()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckValid() = %v; want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -47,6 +47,12 @@ type ConnectorSpec struct {
 	// the cluster. If unset no subnet router will be deployed.
 	// https://tailscale.com/kb/1019/subnets/
 	SubnetRouter *SubnetRouter `json:"subnetRouter"`
+	// ProxyClass is the name of the ProxyClass custom resource that
+	// contains configuration options that should be applied to the
+	// resources created for this Connector. If unset, the operator will
+	// create resources with the default configuration.
+	// +optional
+	ProxyClass string `json:"proxyClass,omitempty"`
 }
 
 // SubnetRouter describes a subnet router.
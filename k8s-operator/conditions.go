@@ -10,6 +10,7 @@
 
 	"go.uber.org/zap"
 	xslices "golang.org/x/exp/slices"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	tsapi "tailscale.com/k8s-operator/apis/v1alpha1"
 	"tailscale.com/tstime"
@@ -52,9 +53,37 @@ func SetConnectorCondition(cn *tsapi.Connector, conditionType tsapi.ConnectorCon
 	cn.Status.Conditions[idx] = newCondition
 }
 
+// SetProxyClassCondition ensures that ProxyClass status has a condition with the
+// given attributes. LastTransitionTime gets set every time condition's status
+// changes
+func SetProxyClassCondition(pc *tsapi.ProxyClass, conditionType tsapi.ProxyClassConditionType, status metav1.ConditionStatus, reason, message string, gen int64, clock tstime.Clock, logger *zap.SugaredLogger) {
+	if cond := apimeta.FindStatusCondition(pc.Status.Conditions, string(conditionType)); cond != nil && cond.Status != status {
+		logger.Infof("Status change for condition %s from %s to %s", conditionType, cond.Status, status)
+	}
+	apimeta.SetStatusCondition(&pc.Status.Conditions, metav1.Condition{
+		Type:               string(conditionType),
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: gen,
+		LastTransitionTime: metav1.NewTime(clock.Now()),
+	})
+}
+
 // RemoveConnectorCondition will remove condition of the given type
 func RemoveConnectorCondition(conn *tsapi.Connector, conditionType tsapi.ConnectorConditionType) {
 	conn.Status.Conditions = slices.DeleteFunc(conn.Status.Conditions, func(cond tsapi.ConnectorCondition) bool {
 		return cond.Type == conditionType
 	})
 }
+
+// ProxyClassIsReady reports whether pc has been validated by the operator and
+// its configuration can be applied to proxies. A ProxyClass is only considered
+// ready if its ready condition was set for its current generation.
+func ProxyClassIsReady(pc *tsapi.ProxyClass) bool {
+	cond := apimeta.FindStatusCondition(pc.Status.Conditions, string(tsapi.ProxyClassReady))
+	if cond == nil {
+		return false
+	}
+	return cond.Status == metav1.ConditionTrue && cond.ObservedGeneration == pc.Generation
+}
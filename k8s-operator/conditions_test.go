@@ -100,3 +100,23 @@ func TestSetConnectorCondition(t *testing.T) {
 	})
 
 }
+
+func TestProxyClassIsReady(t *testing.T) {
+	pc := &tsapi.ProxyClass{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+	clock := tstest.NewClock(tstest.ClockOpts{})
+	zl, err := zap.NewDevelopment()
+	assert.Nil(t, err)
+
+	assert.False(t, ProxyClassIsReady(pc))
+
+	SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionFalse, "invalid", "invalid", 2, clock, zl.Sugar())
+	assert.False(t, ProxyClassIsReady(pc))
+
+	SetProxyClassCondition(pc, tsapi.ProxyClassReady, metav1.ConditionTrue, "valid", "valid", 2, clock, zl.Sugar())
+	assert.True(t, ProxyClassIsReady(pc))
+
+	// A ProxyClass that has been modified since it was validated is not
+	// ready.
+	pc.Generation = 3
+	assert.False(t, ProxyClassIsReady(pc))
+}
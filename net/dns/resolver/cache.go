@@ -0,0 +1,337 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/lru"
+)
+
+const (
+	// cacheMaxEntries is the maximum number of responses kept in the
+	// response cache.
+	cacheMaxEntries = 1000
+
+	// cacheMaxTTL caps how long a positive response is cached, regardless
+	// of the TTLs in it.
+	cacheMaxTTL = 24 * time.Hour
+
+	// cacheMaxNegativeTTL caps how long a negative (NXDOMAIN or NODATA)
+	// response is cached. RFC 2308 section 5 notes that "values of one to
+	// three hours have been found to work well".
+	cacheMaxNegativeTTL = 3 * time.Hour
+)
+
+// CacheStats are statistics about the Resolver's cache of responses from
+// upstream resolvers.
+type CacheStats struct {
+	Entries      int   // current number of cached responses
+	MaxEntries   int   // maximum number of cached responses
+	Hits         int64 // queries answered from a cached positive response
+	NegativeHits int64 // queries answered from a cached NXDOMAIN or NODATA response
+	Misses       int64 // cacheable queries that had to be forwarded
+	Evictions    int64 // responses dropped to stay under MaxEntries
+	Flushes      int64 // times the entire cache was flushed
+}
+
+// cacheKey is the key of a cached response.
+type cacheKey struct {
+	// route is the suffix of the route the query was forwarded via, so
+	// that answers from different split DNS resolvers stay isolated.
+	route dnsname.FQDN
+	name  string // lowercase
+	typ   dns.Type
+
+	// dnssecOK is whether the query's EDNS DO bit was set, as that
+	// changes which records upstreams include.
+	dnssecOK bool
+
+	// tcp is whether the query was sent over TCP. If not, udpSize is the
+	// largest UDP response the client accepts: the size it advertised
+	// with EDNS, or 512 bytes. Upstreams truncate or trim responses to
+	// fit, so responses are only reused for queries with the same limit.
+	tcp     bool
+	udpSize uint16
+}
+
+// cacheEntry is a cached response. It's immutable once in the cache.
+type cacheEntry struct {
+	msg      dns.Message
+	added    time.Time
+	expires  time.Time
+	negative bool
+}
+
+// responseCache is a TTL-respecting, size-bounded cache of upstream DNS
+// responses, including negative responses as described in RFC 2308.
+//
+// It's safe for concurrent use. The zero value is ready for use.
+type responseCache struct {
+	// now, if non-nil, is used instead of time.Now. It's for tests.
+	now func() time.Time
+
+	mu    sync.Mutex
+	lru   lru.Cache[cacheKey, *cacheEntry]
+	stats CacheStats
+	// gen is incremented whenever responses are flushed, so that
+	// responses to queries forwarded before a flush, possibly via a
+	// route which has since changed, aren't cached after it.
+	gen uint64
+}
+
+func (c *responseCache) timeNow() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+// queryCacheKey returns the cache key for query, received over family ("tcp"
+// or "udp"), when forwarded via the route with the given suffix. It reports
+// false if the query isn't cacheable.
+func queryCacheKey(route dnsname.FQDN, query []byte, family string) (_ cacheKey, ok bool) {
+	var p dns.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response || h.OpCode != 0 {
+		return cacheKey{}, false
+	}
+	q, err := p.Question()
+	if err != nil || q.Class != dns.ClassINET {
+		return cacheKey{}, false
+	}
+	if _, err := p.Question(); err != dns.ErrSectionDone {
+		// More than one question; nobody does this.
+		return cacheKey{}, false
+	}
+	key := cacheKey{
+		route: route,
+		name:  rawNameToLower(q.Name.Data[:q.Name.Length]),
+		typ:   q.Type,
+		tcp:   family == "tcp",
+	}
+	udpSize := uint16(512)
+	if err := p.SkipAllAnswers(); err != nil {
+		return cacheKey{}, false
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return cacheKey{}, false
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err == dns.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return cacheKey{}, false
+		}
+		if rh.Type == dns.TypeOPT {
+			key.dnssecOK = rh.DNSSECAllowed()
+			// The class of an OPT record is the UDP payload size.
+			udpSize = max(uint16(rh.Class), 512)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return cacheKey{}, false
+		}
+	}
+	if !key.tcp {
+		key.udpSize = udpSize
+	}
+	return key, true
+}
+
+// get returns a response to query from the cache, if present and not
+// expired. The response has query's ID and question, and its TTLs are
+// reduced by the time the response spent in the cache.
+func (c *responseCache) get(key cacheKey, query []byte) (res []byte, ok bool) {
+	now := c.timeNow()
+	c.mu.Lock()
+	e, ok := c.lru.GetOk(key)
+	if ok && !now.Before(e.expires) {
+		c.lru.Delete(key)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	if e.negative {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	c.mu.Unlock()
+
+	var qp dns.Parser
+	qh, err := qp.Start(query)
+	if err != nil {
+		return nil, false
+	}
+	q, err := qp.Question()
+	if err != nil {
+		return nil, false
+	}
+
+	age := uint32(now.Sub(e.added) / time.Second)
+	m := e.msg
+	m.ID = qh.ID
+	m.RecursionDesired = qh.RecursionDesired
+	// Echo the question exactly as asked, as some clients randomize its
+	// case and check that the response matches.
+	m.Questions = []dns.Question{q}
+	m.Answers = agedResources(m.Answers, age)
+	m.Authorities = agedResources(m.Authorities, age)
+	m.Additionals = agedResources(m.Additionals, age)
+	res, err = m.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return res, true
+}
+
+// agedResources returns a copy of rrs with age seconds subtracted from their
+// TTLs. EDNS OPT pseudo-records, whose TTL field holds flags, are unchanged.
+func agedResources(rrs []dns.Resource, age uint32) []dns.Resource {
+	rrs = slices.Clone(rrs)
+	for i := range rrs {
+		h := &rrs[i].Header
+		if h.Type == dns.TypeOPT {
+			continue
+		}
+		if h.TTL > age {
+			h.TTL -= age
+		} else {
+			h.TTL = 0
+		}
+	}
+	return rrs
+}
+
+// generation returns the current generation of the cache, to be passed to
+// add with the response to a query which is about to be forwarded.
+func (c *responseCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// add caches res, the response to a query with the given key, if it's
+// cacheable. gen is the generation of the cache from before the query was
+// routed: if the cache has been flushed since, res is dropped, as it may
+// have come from a route which no longer applies.
+func (c *responseCache) add(key cacheKey, gen uint64, res []byte) {
+	if len(res) > maxResponseBytes {
+		return
+	}
+	var m dns.Message
+	if err := m.Unpack(res); err != nil {
+		return
+	}
+	ttl, negative, ok := cacheTTL(&m)
+	if !ok || ttl <= 0 {
+		return
+	}
+	if len(m.Questions) != 1 || m.Questions[0].Type != key.typ ||
+		rawNameToLower(m.Questions[0].Name.Data[:m.Questions[0].Name.Length]) != key.name {
+		return
+	}
+	now := c.timeNow()
+	e := &cacheEntry{
+		msg:      m,
+		added:    now,
+		expires:  now.Add(ttl),
+		negative: negative,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	c.lru.MaxEntries = cacheMaxEntries
+	n := c.lru.Len()
+	_, replaced := c.lru.PeekOk(key)
+	c.lru.Set(key, e)
+	if !replaced && c.lru.Len() == n {
+		c.stats.Evictions++
+	}
+}
+
+// cacheTTL returns how long the response m may be cached for, and whether
+// it's a negative response. It reports false if m mustn't be cached.
+func cacheTTL(m *dns.Message) (ttl time.Duration, negative, ok bool) {
+	if !m.Response || m.Truncated {
+		return 0, false, false
+	}
+	if m.RCode == dns.RCodeSuccess && len(m.Answers) > 0 {
+		minTTL := uint32(1<<32 - 1)
+		for _, sec := range [][]dns.Resource{m.Answers, m.Authorities, m.Additionals} {
+			for _, rr := range sec {
+				if rr.Header.Type != dns.TypeOPT && rr.Header.TTL < minTTL {
+					minTTL = rr.Header.TTL
+				}
+			}
+		}
+		return min(time.Duration(minTTL)*time.Second, cacheMaxTTL), false, true
+	}
+	if m.RCode != dns.RCodeSuccess && m.RCode != dns.RCodeNameError {
+		return 0, false, false
+	}
+	// NXDOMAIN or NODATA. RFC 2308 section 5: "Negative responses without
+	// SOA records SHOULD NOT be cached", and the TTL is the minimum of the
+	// SOA record's TTL and its MINIMUM field.
+	for _, rr := range m.Authorities {
+		soa, isSOA := rr.Body.(*dns.SOAResource)
+		if !isSOA {
+			continue
+		}
+		ttl := min(rr.Header.TTL, soa.MinTTL)
+		return min(time.Duration(ttl)*time.Second, cacheMaxNegativeTTL), true, true
+	}
+	return 0, false, false
+}
+
+// flush removes all cached responses.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru = lru.Cache[cacheKey, *cacheEntry]{}
+	c.stats.Flushes++
+	c.gen++
+}
+
+// flushRoutes removes all responses that were cached for the routes with the
+// given suffixes.
+func (c *responseCache) flushRoutes(suffixes []dnsname.FQDN) {
+	if len(suffixes) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	var del []cacheKey
+	c.lru.ForEach(func(k cacheKey, _ *cacheEntry) {
+		if slices.Contains(suffixes, k.route) {
+			del = append(del, k)
+		}
+	})
+	for _, k := range del {
+		c.lru.Delete(k)
+	}
+}
+
+// getStats returns the cache's current statistics.
+func (c *responseCache) getStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stats
+	st.Entries = c.lru.Len()
+	st.MaxEntries = cacheMaxEntries
+	return st
+}
@@ -0,0 +1,267 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/util/dnsname"
+)
+
+func cacheTestQuery(tb testing.TB, id uint16, name string) []byte {
+	return cacheTestQueryEDNS(tb, id, name, 0)
+}
+
+// cacheTestQueryEDNS is like cacheTestQuery, but if udpSize is non-zero,
+// the query advertises it as its EDNS UDP payload size.
+func cacheTestQueryEDNS(tb testing.TB, id uint16, name string, udpSize int) []byte {
+	tb.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{
+		Name:  dns.MustNewName(name),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	})
+	if udpSize != 0 {
+		b.StartAdditionals()
+		var h dns.ResourceHeader
+		if err := h.SetEDNS0(udpSize, dns.RCodeSuccess, false); err != nil {
+			tb.Fatal(err)
+		}
+		b.OPTResource(h, dns.OPTResource{})
+	}
+	q, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return q
+}
+
+// cacheTestResponse returns a response to a query for name. If ttl is
+// non-zero, it contains an A record with that TTL. If soaTTL is non-zero, it
+// contains an SOA record in the authority section with that TTL and MINIMUM.
+func cacheTestResponse(tb testing.TB, id uint16, name string, rcode dns.RCode, ttl, soaTTL uint32) []byte {
+	tb.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: id, Response: true, RCode: rcode})
+	b.StartQuestions()
+	b.Question(dns.Question{
+		Name:  dns.MustNewName(name),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	})
+	b.StartAnswers()
+	if ttl != 0 {
+		b.AResource(dns.ResourceHeader{
+			Name:  dns.MustNewName(name),
+			Class: dns.ClassINET,
+			TTL:   ttl,
+		}, dns.AResource{A: [4]byte{192, 0, 2, 1}})
+	}
+	b.StartAuthorities()
+	if soaTTL != 0 {
+		b.SOAResource(dns.ResourceHeader{
+			Name:  dns.MustNewName("example.com."),
+			Class: dns.ClassINET,
+			TTL:   soaTTL,
+		}, dns.SOAResource{
+			NS:     dns.MustNewName("ns.example.com."),
+			MBox:   dns.MustNewName("hostmaster.example.com."),
+			MinTTL: soaTTL,
+		})
+	}
+	res, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return res
+}
+
+func TestResponseCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := &responseCache{now: func() time.Time { return now }}
+
+	const name = "foo.example.com."
+	q := cacheTestQuery(t, 1, name)
+	key, ok := queryCacheKey(".", q, "udp")
+	if !ok {
+		t.Fatal("query not cacheable")
+	}
+	if _, ok := c.get(key, q); ok {
+		t.Fatal("unexpected hit on empty cache")
+	}
+	c.add(key, c.generation(), cacheTestResponse(t, 1, name, dns.RCodeSuccess, 60, 0))
+
+	// A later query with a different ID and case hits the cache, with its
+	// own ID and question echoed and the TTL aged.
+	now = now.Add(10 * time.Second)
+	q2 := cacheTestQuery(t, 2, "FOO.example.com.")
+	key2, _ := queryCacheKey(".", q2, "udp")
+	res, ok := c.get(key2, q2)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	var m dns.Message
+	if err := m.Unpack(res); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 2 {
+		t.Errorf("ID = %v; want 2", m.ID)
+	}
+	if got := m.Questions[0].Name.String(); got != "FOO.example.com." {
+		t.Errorf("question = %q; want FOO.example.com.", got)
+	}
+	if len(m.Answers) != 1 || m.Answers[0].Header.TTL != 50 {
+		t.Errorf("answers = %+v; want one with TTL 50", m.Answers)
+	}
+
+	// The same name on another route is a separate entry.
+	keyOtherRoute, _ := queryCacheKey(dnsname.FQDN("example.com."), q, "udp")
+	if _, ok := c.get(keyOtherRoute, q); ok {
+		t.Error("unexpected hit for other route")
+	}
+
+	// Expired entries are dropped.
+	now = now.Add(time.Minute)
+	if _, ok := c.get(key, q); ok {
+		t.Error("unexpected hit after expiry")
+	}
+
+	st := c.getStats()
+	if st.Hits != 1 || st.Misses != 3 || st.Entries != 0 {
+		t.Errorf("stats = %+v; want 1 hit, 3 misses, 0 entries", st)
+	}
+}
+
+func TestResponseCacheNegative(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := &responseCache{now: func() time.Time { return now }}
+
+	tests := []struct {
+		name     string
+		res      []byte
+		wantHit  bool
+		lifetime time.Duration
+	}{
+		{
+			name:     "nxdomain-with-soa",
+			res:      cacheTestResponse(t, 1, "nx.example.com.", dns.RCodeNameError, 0, 30),
+			wantHit:  true,
+			lifetime: 30 * time.Second,
+		},
+		{
+			name:     "nodata-with-soa",
+			res:      cacheTestResponse(t, 1, "nodata.example.com.", dns.RCodeSuccess, 0, 20),
+			wantHit:  true,
+			lifetime: 20 * time.Second,
+		},
+		{
+			name:    "nxdomain-without-soa",
+			res:     cacheTestResponse(t, 1, "nosoa.example.com.", dns.RCodeNameError, 0, 0),
+			wantHit: false,
+		},
+		{
+			name:    "servfail",
+			res:     cacheTestResponse(t, 1, "fail.example.com.", dns.RCodeServerFailure, 0, 30),
+			wantHit: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m dns.Message
+			if err := m.Unpack(tt.res); err != nil {
+				t.Fatal(err)
+			}
+			q := cacheTestQuery(t, 1, m.Questions[0].Name.String())
+			key, _ := queryCacheKey(".", q, "udp")
+			c.add(key, c.generation(), tt.res)
+			if _, ok := c.get(key, q); ok != tt.wantHit {
+				t.Fatalf("hit = %v; want %v", ok, tt.wantHit)
+			}
+			if !tt.wantHit {
+				return
+			}
+			start := now
+			defer func() { now = start }()
+			now = now.Add(tt.lifetime - time.Second)
+			if _, ok := c.get(key, q); !ok {
+				t.Errorf("miss before expiry")
+			}
+			now = start.Add(tt.lifetime)
+			if _, ok := c.get(key, q); ok {
+				t.Errorf("hit after expiry")
+			}
+		})
+	}
+	if st := c.getStats(); st.NegativeHits != 4 {
+		t.Errorf("NegativeHits = %v; want 4", st.NegativeHits)
+	}
+}
+
+func TestResponseCacheFlushRoutes(t *testing.T) {
+	var c responseCache
+	q := cacheTestQuery(t, 1, "foo.corp.example.")
+	res := cacheTestResponse(t, 1, "foo.corp.example.", dns.RCodeSuccess, 60, 0)
+	keyRoot, _ := queryCacheKey(".", q, "udp")
+	keyCorp, _ := queryCacheKey("corp.example.", q, "udp")
+	c.add(keyRoot, c.generation(), res)
+	c.add(keyCorp, c.generation(), res)
+
+	c.flushRoutes([]dnsname.FQDN{"corp.example."})
+	if _, ok := c.get(keyCorp, q); ok {
+		t.Error("flushed route still cached")
+	}
+	if _, ok := c.get(keyRoot, q); !ok {
+		t.Error("unrelated route was flushed")
+	}
+
+	c.flush()
+	if _, ok := c.get(keyRoot, q); ok {
+		t.Error("entry survived flush")
+	}
+	if st := c.getStats(); st.Flushes != 1 {
+		t.Errorf("Flushes = %v; want 1", st.Flushes)
+	}
+
+	// Responses to queries forwarded before a flush aren't cached after it.
+	gen := c.generation()
+	c.flushRoutes([]dnsname.FQDN{"corp.example."})
+	c.add(keyCorp, gen, res)
+	if _, ok := c.get(keyCorp, q); ok {
+		t.Error("response from before flushRoutes was cached")
+	}
+}
+
+func TestResponseCacheTransport(t *testing.T) {
+	var c responseCache
+	const name = "big.example.com."
+	res := cacheTestResponse(t, 1, name, dns.RCodeSuccess, 60, 0)
+
+	udp := cacheTestQuery(t, 1, name)
+	udpEDNS := cacheTestQueryEDNS(t, 1, name, 4096)
+	smallEDNS := cacheTestQueryEDNS(t, 1, name, 100)
+	keyUDP, _ := queryCacheKey(".", udp, "udp")
+	keyTCP, _ := queryCacheKey(".", udp, "tcp")
+	keyEDNS, _ := queryCacheKey(".", udpEDNS, "udp")
+	keySmallEDNS, _ := queryCacheKey(".", smallEDNS, "udp")
+	keyTCPEDNS, _ := queryCacheKey(".", udpEDNS, "tcp")
+
+	// Responses to TCP or large EDNS queries aren't served to plain UDP
+	// clients, which might not be able to receive them.
+	c.add(keyTCP, c.generation(), res)
+	c.add(keyEDNS, c.generation(), res)
+	if _, ok := c.get(keyUDP, udp); ok {
+		t.Error("response to TCP or EDNS query served over UDP")
+	}
+	// The advertised size doesn't matter over TCP, and sizes below 512
+	// bytes mean 512.
+	if _, ok := c.get(keyTCPEDNS, udpEDNS); !ok {
+		t.Error("TCP query with EDNS missed")
+	}
+	if keySmallEDNS != keyUDP {
+		t.Errorf("key for EDNS size 100 = %+v, want %+v", keySmallEDNS, keyUDP)
+	}
+}
@@ -5,12 +5,24 @@
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
 	"flag"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"sync"
 	"testing"
 
 	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/net/dns/publicdns"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/dnsname"
 )
 
 var testDoH = flag.Bool("test-doh", false, "do real DoH tests against the network")
@@ -97,3 +109,109 @@ func TestDoHV6Fallback(t *testing.T) {
 		}
 	}
 }
+
+func TestArbitraryDoH(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		sawMethods []string
+	)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sawMethods = append(sawMethods, r.Method)
+		mu.Unlock()
+		var q []byte
+		switch r.Method {
+		case "GET":
+			var err error
+			q, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if id := binary.BigEndian.Uint16(q); id != 0 {
+				t.Errorf("GET query ID = %v; want 0", id)
+			}
+		case "POST":
+			if ct := r.Header.Get("Content-Type"); ct != dohType {
+				t.Errorf("POST Content-Type = %q; want %q", ct, dohType)
+			}
+			q, _ = io.ReadAll(r.Body)
+		}
+		// Echo the query back as the response.
+		q[2] |= 0x80 // QR bit
+		w.Header().Set("Content-Type", dohType)
+		w.Write(q)
+		if r.URL.Path == "/too-large" {
+			w.Write(make([]byte, dohMaxResponseBytes))
+		}
+	}))
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	old := testRootCAs
+	testRootCAs = pool
+	defer func() { testRootCAs = old }()
+
+	fwd := newTestForwarder(t)
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	bootstrap := []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+
+	for _, addr := range []string{
+		fmt.Sprintf("https://example.com:%d/dns-query", port),
+		fmt.Sprintf("https://example.com:%d/dns-query{?dns}", port),
+		fmt.Sprintf("https://127.0.0.1:%d/dns-query", port),
+	} {
+		t.Run(addr, func(t *testing.T) {
+			q := someDNSQuestion(t)
+			fq := &forwardQuery{
+				txid:           getTxID(q),
+				packet:         q,
+				family:         "udp",
+				closeOnCtxDone: new(closePool),
+			}
+			defer fq.closeOnCtxDone.Close()
+			rr := resolverAndDelay{name: &dnstype.Resolver{
+				Addr:                addr,
+				BootstrapResolution: bootstrap,
+			}}
+			res, err := fwd.send(context.Background(), fq, rr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := getTxID(res); got != fq.txid {
+				t.Errorf("response txid = %v; want %v", got, fq.txid)
+			}
+		})
+	}
+	mu.Lock()
+	if want := []string{"POST", "GET", "POST"}; !slices.Equal(sawMethods, want) {
+		t.Errorf("server saw methods %q; want %q", sawMethods, want)
+	}
+	mu.Unlock()
+
+	// Responses larger than a DNS message are rejected, not truncated.
+	q := someDNSQuestion(t)
+	fq := &forwardQuery{txid: getTxID(q), packet: q, family: "udp", closeOnCtxDone: new(closePool)}
+	defer fq.closeOnCtxDone.Close()
+	big := &dnstype.Resolver{Addr: fmt.Sprintf("https://127.0.0.1:%d/too-large", port)}
+	if res, err := fwd.send(context.Background(), fq, resolverAndDelay{name: big}); err == nil {
+		t.Errorf("oversized response: got %d bytes, want error", len(res))
+	}
+
+	// Clients of resolvers which are no longer used are dropped.
+	keep := &dnstype.Resolver{
+		Addr:                fmt.Sprintf("https://example.com:%d/dns-query", port),
+		BootstrapResolution: bootstrap,
+	}
+	fwd.setRoutes(map[dnsname.FQDN][]*dnstype.Resolver{".": {keep}})
+	fwd.mu.Lock()
+	var keys []string
+	for k := range fwd.dohClient {
+		keys = append(keys, k)
+	}
+	fwd.mu.Unlock()
+	if want := []string{dohClientKey(keep)}; !slices.Equal(keys, want) {
+		t.Errorf("DoH clients after setRoutes = %q; want %q", keys, want)
+	}
+}
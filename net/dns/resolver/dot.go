@@ -0,0 +1,422 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/dnscache"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/sockstats"
+	"tailscale.com/types/dnstype"
+	"tailscale.com/util/mak"
+)
+
+const (
+	// dotDefaultPort is the default port for DNS-over-TLS, per RFC 7858
+	// section 3.1.
+	dotDefaultPort = "853"
+
+	// dotIdleTimeout is how long an idle DNS-over-TLS connection is kept
+	// open for reuse before we close it. RFC 7766 section 6.2.3 recommends
+	// that clients close idle connections rather than wait for the server
+	// to do it.
+	dotIdleTimeout = 30 * time.Second
+
+	// dotMaxInFlight is the maximum number of queries pipelined on a
+	// single DNS-over-TLS connection. Queries beyond this open a new
+	// connection instead.
+	dotMaxInFlight = 256
+)
+
+// testRootCAs, if non-nil, is used in place of the system roots when
+// verifying DoT and DoH server certificates. It's only set by tests.
+var testRootCAs *x509.CertPool
+
+var errDoTConnClosed = errors.New("DNS-over-TLS connection closed")
+
+// parseDoTAddr parses a "tls://host[:port]" resolver address into the
+// host (a hostname or IP address, without brackets) and port to dial.
+func parseDoTAddr(addr string) (host, port string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "tls" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS resolver %q", addr)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS resolver %q: unexpected path, query or userinfo", addr)
+	}
+	host, port = u.Hostname(), u.Port()
+	if port == "" {
+		port = dotDefaultPort
+	}
+	return host, port, nil
+}
+
+// dotConn is a persistent DNS-over-TLS (RFC 7858) connection to an upstream
+// resolver, shared by all queries forwarded to that resolver.
+//
+// Queries are pipelined over the connection as permitted by RFC 7766 section
+// 6.2.1.1: each outgoing query is rewritten with a DNS ID that's unique on the
+// connection, and a single reader goroutine dispatches responses (which may
+// arrive out of order) back to their waiters, restoring the original ID.
+type dotConn struct {
+	conn net.Conn
+
+	// onClose is called once, without mu held, when the connection is
+	// closed for any reason.
+	onClose func(*dotConn)
+
+	writeMu sync.Mutex // serializes writes of length-prefixed messages
+
+	mu      sync.Mutex // guards following
+	closed  bool
+	err     error // why the conn was closed; non-nil once closed
+	nextID  uint16
+	waiting map[uint16]chan []byte // connection-local DNS ID -> waiter
+	idle    *time.Timer            // non-nil when no queries are in flight
+}
+
+func newDoTConn(conn net.Conn, onClose func(*dotConn)) *dotConn {
+	dc := &dotConn{
+		conn:    conn,
+		onClose: onClose,
+		waiting: make(map[uint16]chan []byte),
+	}
+	dc.mu.Lock()
+	dc.startIdleTimerLocked()
+	dc.mu.Unlock()
+	go dc.readLoop()
+	return dc
+}
+
+// startIdleTimerLocked arranges for dc to be closed if it stays idle for
+// dotIdleTimeout. dc.mu must be held.
+func (dc *dotConn) startIdleTimerLocked() {
+	if dc.idle != nil {
+		dc.idle.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(dotIdleTimeout, func() {
+		dc.mu.Lock()
+		stillIdle := dc.idle == t && len(dc.waiting) == 0
+		dc.mu.Unlock()
+		if stillIdle {
+			dc.closeWithError(errDoTConnClosed)
+		}
+	})
+	dc.idle = t
+}
+
+// full reports whether dc has reached its pipelining limit or is closed.
+func (dc *dotConn) full() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.closed || len(dc.waiting) >= dotMaxInFlight
+}
+
+// closeWithError closes dc, failing any in-flight queries with err.
+func (dc *dotConn) closeWithError(err error) {
+	dc.mu.Lock()
+	if dc.closed {
+		dc.mu.Unlock()
+		return
+	}
+	dc.closed = true
+	dc.err = err
+	if dc.idle != nil {
+		dc.idle.Stop()
+		dc.idle = nil
+	}
+	for id, ch := range dc.waiting {
+		close(ch)
+		delete(dc.waiting, id)
+	}
+	dc.mu.Unlock()
+
+	dc.conn.Close()
+	if dc.onClose != nil {
+		dc.onClose(dc)
+	}
+}
+
+func (dc *dotConn) readLoop() {
+	for {
+		var length uint16
+		if err := binary.Read(dc.conn, binary.BigEndian, &length); err != nil {
+			dc.closeWithError(err)
+			return
+		}
+		msg := make([]byte, length)
+		if _, err := io.ReadFull(dc.conn, msg); err != nil {
+			dc.closeWithError(err)
+			return
+		}
+		if len(msg) < headerBytes {
+			metricDNSFwdDoTErrorRead.Add(1)
+			dc.closeWithError(fmt.Errorf("DNS-over-TLS response too small (%d bytes)", len(msg)))
+			return
+		}
+		id := binary.BigEndian.Uint16(msg[:2])
+		dc.mu.Lock()
+		ch, ok := dc.waiting[id]
+		if ok {
+			delete(dc.waiting, id)
+			if len(dc.waiting) == 0 {
+				dc.startIdleTimerLocked()
+			}
+		}
+		dc.mu.Unlock()
+		if !ok {
+			// Either a response to a query that was abandoned (its
+			// context expired), or a bogus ID from the server.
+			metricDNSFwdDoTErrorTxID.Add(1)
+			continue
+		}
+		ch <- msg // buffered; never blocks
+	}
+}
+
+// query sends packet over dc and waits for its response.
+//
+// The returned response has the same DNS ID as packet.
+func (dc *dotConn) query(ctx context.Context, packet []byte) ([]byte, error) {
+	if len(packet) < headerBytes {
+		return nil, errors.New("DNS query too small")
+	}
+	origID := binary.BigEndian.Uint16(packet[:2])
+
+	ch := make(chan []byte, 1)
+	dc.mu.Lock()
+	if dc.closed {
+		err := dc.err
+		dc.mu.Unlock()
+		return nil, err
+	}
+	if len(dc.waiting) >= dotMaxInFlight {
+		dc.mu.Unlock()
+		return nil, errors.New("too many in-flight DNS-over-TLS queries")
+	}
+	id := dc.nextID
+	for {
+		if _, ok := dc.waiting[id]; !ok {
+			break
+		}
+		id++
+	}
+	dc.nextID = id + 1
+	dc.waiting[id] = ch
+	if dc.idle != nil {
+		dc.idle.Stop()
+		dc.idle = nil
+	}
+	dc.mu.Unlock()
+
+	// abandon unregisters the query if we give up before its response.
+	abandon := func() {
+		dc.mu.Lock()
+		defer dc.mu.Unlock()
+		if dc.waiting[id] == ch {
+			delete(dc.waiting, id)
+			if len(dc.waiting) == 0 && !dc.closed {
+				dc.startIdleTimerLocked()
+			}
+		}
+	}
+
+	msg := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(msg, uint16(len(packet)))
+	copy(msg[2:], packet)
+	binary.BigEndian.PutUint16(msg[2:4], id)
+
+	dc.writeMu.Lock()
+	if dl, ok := ctx.Deadline(); ok {
+		dc.conn.SetWriteDeadline(dl)
+	} else {
+		dc.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := dc.conn.Write(msg)
+	dc.writeMu.Unlock()
+	if err != nil {
+		metricDNSFwdDoTErrorWrite.Add(1)
+		abandon()
+		// A partial write leaves the stream in an unknown state.
+		dc.closeWithError(err)
+		return nil, err
+	}
+	metricDNSFwdDoTWrote.Add(1)
+
+	select {
+	case res, ok := <-ch:
+		if !ok {
+			dc.mu.Lock()
+			err := dc.err
+			dc.mu.Unlock()
+			metricDNSFwdDoTErrorRead.Add(1)
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(res[:2], origID)
+		return res, nil
+	case <-ctx.Done():
+		abandon()
+		return nil, ctx.Err()
+	}
+}
+
+// getDoTConn returns a DNS-over-TLS connection to r, reusing an existing
+// connection if one is open and has room for more pipelined queries.
+//
+// The reused result reports whether the returned connection was already
+// open (and so might have been silently closed by the server).
+func (f *forwarder) getDoTConn(ctx context.Context, r *dnstype.Resolver) (dc *dotConn, reused bool, err error) {
+	f.mu.Lock()
+	if dc := f.dotConns[r.Addr]; dc != nil && !dc.full() {
+		f.mu.Unlock()
+		return dc, true, nil
+	}
+	f.mu.Unlock()
+
+	dc, err = f.dialDoT(ctx, r)
+	if err != nil {
+		return nil, false, err
+	}
+	f.mu.Lock()
+	if f.ctx.Err() != nil {
+		f.mu.Unlock()
+		dc.closeWithError(errDoTConnClosed)
+		return nil, false, net.ErrClosed
+	}
+	if old := f.dotConns[r.Addr]; old == nil || old.full() {
+		mak.Set(&f.dotConns, r.Addr, dc)
+	}
+	f.mu.Unlock()
+	return dc, false, nil
+}
+
+// dialDoT dials a new DNS-over-TLS connection to r and performs the TLS
+// handshake, verifying the server certificate against the host named in
+// r.Addr.
+//
+// If r.Addr names a hostname rather than an IP address, the IPs in
+// r.BootstrapResolution are dialed if present. Otherwise the hostname is
+// resolved using the system resolver.
+func (f *forwarder) dialDoT(ctx context.Context, r *dnstype.Resolver) (*dotConn, error) {
+	host, port, err := parseDoTAddr(r.Addr)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoT, f.logf)
+
+	nsDialer := netns.NewDialer(f.logf, f.netMon)
+	var conn net.Conn
+	if ip, err := netip.ParseAddr(host); err == nil {
+		conn, err = nsDialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err != nil {
+			metricDNSFwdDoTErrorDial.Add(1)
+			return nil, err
+		}
+	} else {
+		dialer := dnscache.Dialer(nsDialer.DialContext, &dnscache.Resolver{
+			SingleHost:             host,
+			SingleHostStaticResult: r.BootstrapResolution,
+			Logf:                   f.logf,
+			NetMon:                 f.netMon,
+		})
+		conn, err = dialer(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			metricDNSFwdDoTErrorDial.Add(1)
+			return nil, err
+		}
+	}
+
+	tc := tls.Client(conn, &tls.Config{
+		ServerName: host,
+		RootCAs:    testRootCAs,
+		MinVersion: tls.VersionTLS12,
+	})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		metricDNSFwdDoTErrorDial.Add(1)
+		return nil, fmt.Errorf("DNS-over-TLS handshake with %v: %w", r.Addr, err)
+	}
+	addr := r.Addr
+	return newDoTConn(tc, func(dc *dotConn) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.dotConns[addr] == dc {
+			delete(f.dotConns, addr)
+		}
+	}), nil
+}
+
+// sendDoT sends fq to the DNS-over-TLS resolver rr, reusing (and pipelining
+// over) an existing connection to it if possible.
+func (f *forwarder) sendDoT(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) ([]byte, error) {
+	metricDNSFwdDoT.Add(1)
+
+	ctx, cancel := context.WithTimeout(ctx, tcpQueryTimeout)
+	defer cancel()
+
+	var res []byte
+	for attempt := 0; ; attempt++ {
+		dc, reused, err := f.getDoTConn(ctx, rr.name)
+		if err != nil {
+			return nil, err
+		}
+		res, err = dc.query(ctx, fq.packet)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// A reused connection may have been closed by the server while it
+		// sat idle; retry once on a fresh connection.
+		if reused && attempt == 0 {
+			continue
+		}
+		return nil, err
+	}
+
+	if getTxID(res) != fq.txid {
+		metricDNSFwdDoTErrorTxID.Add(1)
+		return nil, errTxIDMismatch
+	}
+	// don't forward transient errors back to the client when the server fails
+	if rcode := getRCode(res); rcode == dns.RCodeServerFailure {
+		f.logf("sendDoT: response code indicating server failure: %d", rcode)
+		metricDNSFwdDoTErrorServer.Add(1)
+		return nil, errServerFailure
+	}
+	metricDNSFwdDoTSuccess.Add(1)
+	return res, nil
+}
+
+// closeDoTConns closes all open DNS-over-TLS connections.
+func (f *forwarder) closeDoTConns() {
+	f.mu.Lock()
+	conns := make([]*dotConn, 0, len(f.dotConns))
+	for _, dc := range f.dotConns {
+		conns = append(conns, dc)
+	}
+	f.mu.Unlock()
+	for _, dc := range conns {
+		dc.closeWithError(errDoTConnClosed)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/netmon"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/types/dnstype"
+)
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		in       string
+		wantHost string
+		wantPort string
+		wantErr  bool
+	}{
+		{in: "tls://dns.example.com", wantHost: "dns.example.com", wantPort: "853"},
+		{in: "tls://dns.example.com/", wantHost: "dns.example.com", wantPort: "853"},
+		{in: "tls://dns.example.com:8853", wantHost: "dns.example.com", wantPort: "8853"},
+		{in: "tls://1.2.3.4", wantHost: "1.2.3.4", wantPort: "853"},
+		{in: "tls://[2001:db8::1]:853", wantHost: "2001:db8::1", wantPort: "853"},
+		{in: "tls://", wantErr: true},
+		{in: "tls://dns.example.com/dns-query", wantErr: true},
+		{in: "tls://user@dns.example.com", wantErr: true},
+		{in: "https://dns.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := parseDoTAddr(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoTAddr(%q) err = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if host != tt.wantHost || port != tt.wantPort {
+			t.Errorf("parseDoTAddr(%q) = %q, %q; want %q, %q", tt.in, host, port, tt.wantHost, tt.wantPort)
+		}
+	}
+}
+
+// testDoTCert returns a self-signed certificate valid for 127.0.0.1 and
+// "dot.test", along with a pool containing it.
+func testDoTCert(tb testing.TB) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dot.test"},
+		DNSNames:              []string{"dot.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// runDoTServer runs a DNS-over-TLS server on localhost that answers every
+// query with an A record for 127.0.0.1. Once batch queries have arrived on a
+// connection, it answers them in reverse order, to exercise pipelining.
+//
+// It returns the server's port and a counter of accepted connections.
+func runDoTServer(tb testing.TB, batch int) (port uint16, conns *atomic.Int32) {
+	cert, pool := testDoTCert(tb)
+	old := testRootCAs
+	testRootCAs = pool
+	tb.Cleanup(func() { testRootCAs = old })
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	conns = new(atomic.Int32)
+	var wg sync.WaitGroup
+	tb.Cleanup(wg.Wait)
+	tb.Cleanup(func() { ln.Close() })
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.Close()
+				serveDoTConn(tb, c, batch)
+			}()
+		}
+	}()
+	return uint16(ln.Addr().(*net.TCPAddr).Port), conns
+}
+
+func serveDoTConn(tb testing.TB, c net.Conn, batch int) {
+	var pending [][]byte
+	for {
+		var length uint16
+		if err := binary.Read(c, binary.BigEndian, &length); err != nil {
+			return
+		}
+		q := make([]byte, length)
+		if _, err := io.ReadFull(c, q); err != nil {
+			return
+		}
+		var p dns.Parser
+		h, err := p.Start(q)
+		if err != nil {
+			tb.Errorf("parsing query: %v", err)
+			return
+		}
+		question, err := p.Question()
+		if err != nil {
+			tb.Errorf("parsing question: %v", err)
+			return
+		}
+		h.Response = true
+		b := dns.NewBuilder(nil, h)
+		b.StartQuestions()
+		b.Question(question)
+		b.StartAnswers()
+		b.AResource(dns.ResourceHeader{
+			Name:  question.Name,
+			Type:  dns.TypeA,
+			Class: dns.ClassINET,
+			TTL:   60,
+		}, dns.AResource{A: [4]byte{127, 0, 0, 1}})
+		res, err := b.Finish()
+		if err != nil {
+			tb.Errorf("building response: %v", err)
+			return
+		}
+		pending = append(pending, res)
+		if len(pending) < batch {
+			continue
+		}
+		for i := len(pending) - 1; i >= 0; i-- {
+			out := binary.BigEndian.AppendUint16(nil, uint16(len(pending[i])))
+			if _, err := c.Write(append(out, pending[i]...)); err != nil {
+				return
+			}
+		}
+		pending = pending[:0]
+	}
+}
+
+func newTestForwarder(tb testing.TB) *forwarder {
+	netMon, err := netmon.New(tb.Logf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var dialer tsdial.Dialer
+	dialer.SetNetMon(netMon)
+	fwd := newForwarder(tb.Logf, netMon, nil, &dialer, nil)
+	tb.Cleanup(func() { fwd.Close() })
+	return fwd
+}
+
+func dotQuery(tb testing.TB, id uint16, name string) []byte {
+	b := dns.NewBuilder(nil, dns.Header{ID: id, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{
+		Name:  dns.MustNewName(name),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	})
+	q, err := b.Finish()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return q
+}
+
+func TestForwarderDoTPipelining(t *testing.T) {
+	const numQueries = 4
+	port, conns := runDoTServer(t, numQueries)
+	fwd := newTestForwarder(t)
+
+	// Open the connection first so all the queries below share it.
+	addr := fmt.Sprintf("tls://127.0.0.1:%d", port)
+	if _, _, err := fwd.getDoTConn(context.Background(), &dnstype.Resolver{Addr: addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < numQueries; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// All queries deliberately use the same ID, as can happen
+			// with independent clients.
+			q := dotQuery(t, 42, fmt.Sprintf("host%d.example.com.", i))
+			fq := &forwardQuery{
+				txid:           getTxID(q),
+				packet:         q,
+				family:         "udp",
+				closeOnCtxDone: new(closePool),
+			}
+			defer fq.closeOnCtxDone.Close()
+			res, err := fwd.send(context.Background(), fq, resolverAndDelay{name: &dnstype.Resolver{Addr: addr}})
+			if err != nil {
+				t.Errorf("query %d: %v", i, err)
+				return
+			}
+			if got := getTxID(res); got != 42 {
+				t.Errorf("query %d: response ID = %v; want 42", i, got)
+			}
+			name, err := nameFromResponse(res)
+			if err != nil {
+				t.Errorf("query %d: %v", i, err)
+				return
+			}
+			if want := fmt.Sprintf("host%d.example.com.", i); name != want {
+				t.Errorf("query %d: response for %q; want %q", i, name, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := conns.Load(); got != 1 {
+		t.Errorf("server saw %d connections; want 1", got)
+	}
+}
+
+func nameFromResponse(res []byte) (string, error) {
+	var p dns.Parser
+	if _, err := p.Start(res); err != nil {
+		return "", err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return "", err
+	}
+	return q.Name.String(), nil
+}
+
+func TestForwarderDoTBootstrap(t *testing.T) {
+	port, _ := runDoTServer(t, 1)
+	fwd := newTestForwarder(t)
+
+	q := dotQuery(t, 7, "example.com.")
+	fq := &forwardQuery{
+		txid:           getTxID(q),
+		packet:         q,
+		family:         "udp",
+		closeOnCtxDone: new(closePool),
+	}
+	defer fq.closeOnCtxDone.Close()
+
+	// The hostname must match the certificate, and is dialed using the
+	// bootstrap IP rather than being resolved.
+	rr := resolverAndDelay{name: &dnstype.Resolver{
+		Addr:                fmt.Sprintf("tls://dot.test:%d", port),
+		BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+	}}
+	if _, err := fwd.send(context.Background(), fq, rr); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	// A hostname the certificate isn't valid for must fail verification.
+	rr = resolverAndDelay{name: &dnstype.Resolver{
+		Addr:                fmt.Sprintf("tls://wrong.test:%d", port),
+		BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+	}}
+	if _, err := fwd.send(context.Background(), fq, rr); err == nil {
+		t.Fatal("send to mismatched hostname succeeded; want certificate error")
+	}
+}
+
+func TestForwarderDoTReconnect(t *testing.T) {
+	port, conns := runDoTServer(t, 1)
+	fwd := newTestForwarder(t)
+	rr := resolverAndDelay{name: &dnstype.Resolver{Addr: fmt.Sprintf("tls://127.0.0.1:%d", port)}}
+
+	query := func() {
+		t.Helper()
+		q := dotQuery(t, 1, "example.com.")
+		fq := &forwardQuery{
+			txid:           getTxID(q),
+			packet:         q,
+			family:         "udp",
+			closeOnCtxDone: new(closePool),
+		}
+		defer fq.closeOnCtxDone.Close()
+		if _, err := fwd.send(context.Background(), fq, rr); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	query()
+
+	// Simulate the server closing the idle connection behind our back.
+	fwd.mu.Lock()
+	dc := fwd.dotConns[rr.name.Addr]
+	fwd.mu.Unlock()
+	if dc == nil {
+		t.Fatal("no cached DoT connection")
+	}
+	dc.conn.Close()
+
+	query()
+	if got := conns.Load(); got != 2 {
+		t.Errorf("server saw %d connections; want 2", got)
+	}
+}
@@ -6,6 +6,8 @@
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -36,6 +38,7 @@
 	"tailscale.com/types/nettype"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
 	"tailscale.com/util/race"
 	"tailscale.com/version"
 )
@@ -62,6 +65,10 @@ func truncatedFlagSet(pkt []byte) bool {
 	// arbitrary.
 	dohTransportTimeout = 30 * time.Second
 
+	// dohMaxResponseBytes is the maximum size of a DNS-over-HTTPS
+	// response body we'll read. DNS messages are limited to 64KiB.
+	dohMaxResponseBytes = 65535
+
 	// dohTransportTimeout is how much of a head start to give a DoH query
 	// that was upgraded from a well-known public DNS provider's IP before
 	// normal UDP mode is attempted as a fallback.
@@ -198,7 +205,8 @@ type forwarder struct {
 
 	mu sync.Mutex // guards following
 
-	dohClient map[string]*http.Client // urlBase -> client
+	dohClient map[string]*http.Client // urlBase (plus any bootstrap IPs) -> client
+	dotConns  map[string]*dotConn     // "tls://" resolver Addr -> open conn
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -233,6 +241,7 @@ func newForwarder(logf logger.Logf, netMon *netmon.Monitor, linkSel ForwardLinkS
 
 func (f *forwarder) Close() error {
 	f.ctxCancel()
+	f.closeDoTConns()
 	return nil
 }
 
@@ -356,6 +365,21 @@ func (f *forwarder) setRoutes(routesBySuffix map[dnsname.FQDN][]*dnstype.Resolve
 	defer f.mu.Unlock()
 	f.routes = routes
 	f.cloudHostFallback = cloudHostFallback
+
+	// Drop the DoH clients of resolvers which are no longer used.
+	inUse := map[string]bool{}
+	for _, r := range routes {
+		for _, rr := range r.Resolvers {
+			inUse[rr.name.Addr] = true
+			inUse[dohClientKey(rr.name)] = true
+		}
+	}
+	for key, c := range f.dohClient {
+		if !inUse[key] {
+			c.CloseIdleConnections()
+			delete(f.dohClient, key)
+		}
+	}
 }
 
 var stdNetPacketListener nettype.PacketListenerWithNetIP = nettype.MakePacketListenerWithNetIP(new(net.ListenConfig))
@@ -394,17 +418,75 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 	if err != nil {
 		return nil, false
 	}
+	c = f.newDoHClient(dohURL.Hostname(), allIPs)
+	mak.Set(&f.dohClient, urlBase, c)
+	return c, true
+}
+
+// getDoHClient returns an HTTP client for the DoH resolver r, whose Addr is
+// an https:// URL.
+//
+// Well-known providers use getKnownDoHClientForProvider. For any other DoH
+// server, the returned client dials the IPs in r.BootstrapResolution if
+// present, so no recursive lookup of the DoH server's name is required.
+// Otherwise the URL's host is resolved using the system resolver.
+func (f *forwarder) getDoHClient(r *dnstype.Resolver) (*http.Client, error) {
+	urlBase := r.Addr
+	if c, ok := f.getKnownDoHClientForProvider(urlBase); ok {
+		return c, nil
+	}
+	dohURL, err := url.Parse(strings.TrimSuffix(urlBase, dohGETTemplate))
+	if err != nil {
+		return nil, err
+	}
+	if dohURL.Scheme != "https" || dohURL.Host == "" {
+		return nil, fmt.Errorf("invalid DoH resolver %q", urlBase)
+	}
+
+	key := dohClientKey(r)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dohClient[key]; ok {
+		return c, nil
+	}
+	host := dohURL.Hostname()
+	ips := r.BootstrapResolution
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	}
+	c := f.newDoHClient(host, ips)
+	mak.Set(&f.dohClient, key, c)
+	return c, nil
+}
+
+// dohClientKey returns the key in forwarder.dohClient of the client for
+// the DoH resolver r, when it's not a well-known provider. The client is
+// keyed by its bootstrap IPs too, so a reconfiguration that only changes
+// BootstrapResolution takes effect.
+func dohClientKey(r *dnstype.Resolver) string {
+	key := r.Addr
+	for _, ip := range r.BootstrapResolution {
+		key += " " + ip.String()
+	}
+	return key
+}
+
+// newDoHClient returns a new HTTP client for DoH requests to host, which
+// race/Happy Eyeballs dials ips. If ips is empty, host is resolved using the
+// system resolver.
+func (f *forwarder) newDoHClient(host string, ips []netip.Addr) *http.Client {
 	nsDialer := netns.NewDialer(f.logf, f.netMon)
 	dialer := dnscache.Dialer(nsDialer.DialContext, &dnscache.Resolver{
-		SingleHost:             dohURL.Hostname(),
-		SingleHostStaticResult: allIPs,
+		SingleHost:             host,
+		SingleHostStaticResult: ips,
 		Logf:                   f.logf,
 		NetMon:                 f.netMon,
 	})
-	c = &http.Client{
+	return &http.Client{
 		Transport: &http.Transport{
 			ForceAttemptHTTP2: true,
 			IdleConnTimeout:   dohTransportTimeout,
+			TLSClientConfig:   &tls.Config{RootCAs: testRootCAs},
 			DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
 				if !strings.HasPrefix(netw, "tcp") {
 					return nil, fmt.Errorf("unexpected network %q", netw)
@@ -413,23 +495,48 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 			},
 		},
 	}
-	if f.dohClient == nil {
-		f.dohClient = map[string]*http.Client{}
-	}
-	f.dohClient[urlBase] = c
-	return c, true
 }
 
 const dohType = "application/dns-message"
 
+// dohGETTemplate is the RFC 6570 URI template variable that, when it
+// suffixes a DoH resolver URL (as in "https://example.com/dns-query{?dns}"),
+// selects the GET method of RFC 8484 section 4.1 instead of POST.
+const dohGETTemplate = "{?dns}"
+
 func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte) ([]byte, error) {
 	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoH, f.logf)
 	metricDNSFwdDoH.Add(1)
-	req, err := http.NewRequestWithContext(ctx, "POST", urlBase, bytes.NewReader(packet))
+
+	var req *http.Request
+	var err error
+	useGET := strings.HasSuffix(urlBase, dohGETTemplate)
+	if useGET {
+		metricDNSFwdDoHGET.Add(1)
+		// RFC 8484 section 4.1: "In order to maximize HTTP cache
+		// friendliness, DoH clients using media formats that include the ID
+		// field from the DNS message header, such as "application/dns-message",
+		// SHOULD use a DNS ID of 0 in every DNS request."
+		q := bytes.Clone(packet)
+		if len(q) >= 2 {
+			q[0], q[1] = 0, 0
+		}
+		u := strings.TrimSuffix(urlBase, dohGETTemplate)
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + "dns=" + base64.RawURLEncoding.EncodeToString(q)
+		req, err = http.NewRequestWithContext(ctx, "GET", u, nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, "POST", urlBase, bytes.NewReader(packet))
+		if err == nil {
+			req.Header.Set("Content-Type", dohType)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", dohType)
 	req.Header.Set("Accept", dohType)
 	req.Header.Set("User-Agent", "tailscaled/"+version.Long())
 
@@ -447,9 +554,17 @@ func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client,
 		metricDNSFwdDoHErrorCT.Add(1)
 		return nil, fmt.Errorf("unexpected response Content-Type %q", ct)
 	}
-	res, err := io.ReadAll(hres.Body)
+	res, err := io.ReadAll(io.LimitReader(hres.Body, dohMaxResponseBytes+1))
+	if err == nil && len(res) > dohMaxResponseBytes {
+		err = fmt.Errorf("response larger than %d bytes", dohMaxResponseBytes)
+	}
 	if err != nil {
 		metricDNSFwdDoHErrorBody.Add(1)
+		return nil, err
+	}
+	if useGET && len(res) >= 2 && len(packet) >= 2 {
+		// Restore the ID we zeroed above.
+		copy(res[:2], packet[:2])
 	}
 	if truncatedFlagSet(res) {
 		metricDNSFwdTruncated.Add(1)
@@ -476,22 +591,18 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		return f.sendDoH(ctx, rr.name.Addr, f.dialer.PeerAPIHTTPClient(), fq.packet)
 	}
 	if strings.HasPrefix(rr.name.Addr, "https://") {
-		// Only known DoH providers are supported currently. Specifically, we
-		// only support DoH providers where we can TCP connect to them on port
-		// 443 at the same IP address they serve normal UDP DNS from (1.1.1.1,
-		// 8.8.8.8, 9.9.9.9, etc.) That's why OpenDNS and custom DoH providers
-		// aren't currently supported. There's no backup DNS resolution path for
-		// them.
-		urlBase := rr.name.Addr
-		if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
-			return f.sendDoH(ctx, urlBase, hc, fq.packet)
+		// Known DoH providers are dialed at the same IP addresses they serve
+		// normal UDP DNS from (1.1.1.1, 8.8.8.8, 9.9.9.9, etc.). Other DoH
+		// servers are dialed at their BootstrapResolution addresses, if any.
+		hc, err := f.getDoHClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
 		}
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
+		return f.sendDoH(ctx, rr.name.Addr, hc, fq.packet)
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		return f.sendDoT(ctx, fq, rr)
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -771,16 +882,24 @@ func (f *forwarder) sendTCP(ctx context.Context, fq *forwardQuery, rr resolverAn
 
 // resolvers returns the resolvers to use for domain.
 func (f *forwarder) resolvers(domain dnsname.FQDN) []resolverAndDelay {
+	_, rs := f.route(domain)
+	return rs
+}
+
+// route returns the suffix of the route to use for domain and its resolvers.
+// If no route matches, the suffix is empty and the resolvers are the cloud
+// host fallback ones, if any.
+func (f *forwarder) route(domain dnsname.FQDN) (suffix dnsname.FQDN, _ []resolverAndDelay) {
 	f.mu.Lock()
 	routes := f.routes
 	cloudHostFallback := f.cloudHostFallback
 	f.mu.Unlock()
 	for _, route := range routes {
 		if route.Suffix == "." || route.Suffix.Contains(domain) {
-			return route.Resolvers
+			return route.Suffix, route.Resolvers
 		}
 	}
-	return cloudHostFallback // or nil if no fallback
+	return "", cloudHostFallback // or nil if no fallback
 }
 
 // forwardQuery is information and state about a forwarded DNS query that's
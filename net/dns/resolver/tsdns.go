@@ -16,6 +16,7 @@
 	"net/netip"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -186,6 +187,8 @@ type Resolver struct {
 	saveConfigForTests func(cfg Config) // used in tests to capture resolver config
 	// forwarder forwards requests to upstream nameservers.
 	forwarder *forwarder
+	// cache caches responses from upstream nameservers.
+	cache responseCache
 
 	// closed signals all goroutines to stop.
 	closed chan struct{}
@@ -195,6 +198,7 @@ type Resolver struct {
 	localDomains []dnsname.FQDN
 	hostToIP     map[dnsname.FQDN][]netip.Addr
 	ipToHost     map[netip.Addr]dnsname.FQDN
+	routes       map[dnsname.FQDN][]*dnstype.Resolver
 }
 
 type ForwardLinkSelector interface {
@@ -241,12 +245,67 @@ func (r *Resolver) SetConfig(cfg Config) error {
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.cache.flushRoutes(changedRoutes(r.routes, cfg.Routes))
 	r.localDomains = cfg.LocalDomains
 	r.hostToIP = cfg.Hosts
 	r.ipToHost = reverse
+	r.routes = cfg.Routes
 	return nil
 }
 
+// changedRoutes returns the suffixes of routes that were added, removed or
+// whose resolvers changed between old and new.
+func changedRoutes(old, new map[dnsname.FQDN][]*dnstype.Resolver) []dnsname.FQDN {
+	var changed []dnsname.FQDN
+	for suffix, rs := range old {
+		if newRS, ok := new[suffix]; !ok || !slices.EqualFunc(rs, newRS, (*dnstype.Resolver).Equal) {
+			changed = append(changed, suffix)
+		}
+	}
+	for suffix := range new {
+		if _, ok := old[suffix]; !ok {
+			changed = append(changed, suffix)
+		}
+	}
+	return changed
+}
+
+// CacheStats returns statistics about the cache of responses from upstream
+// nameservers.
+func (r *Resolver) CacheStats() CacheStats {
+	return r.cache.getStats()
+}
+
+// FlushCache removes all cached responses from upstream nameservers.
+func (r *Resolver) FlushCache() {
+	r.cache.flush()
+}
+
+var disableCache = envknob.RegisterBool("TS_DEBUG_DNS_DISABLE_CACHE")
+
+// cacheKeyForQuery returns the response cache key for the query bs, received
+// over family, which is about to be forwarded upstream, and the cache
+// generation to add its response with. It reports false if the response to
+// bs shouldn't be cached.
+func (r *Resolver) cacheKeyForQuery(bs []byte, family string) (_ cacheKey, gen uint64, ok bool) {
+	if disableCache() {
+		return cacheKey{}, 0, false
+	}
+	domain, err := nameFromQuery(bs)
+	if err != nil {
+		return cacheKey{}, 0, false
+	}
+	// Get the generation before the route, so that if the route changes
+	// in between, the response is dropped rather than cached.
+	gen = r.cache.generation()
+	route, rs := r.forwarder.route(domain)
+	if len(rs) == 0 {
+		return cacheKey{}, 0, false
+	}
+	key, ok := queryCacheKey(route, bs, family)
+	return key, gen, ok
+}
+
 // Close shuts down the resolver and ensures poll goroutines have exited.
 // The Resolver cannot be used again after Close is called.
 func (r *Resolver) Close() {
@@ -277,6 +336,13 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 
 	out, err := r.respond(bs)
 	if err == errNotOurName {
+		key, gen, cacheable := r.cacheKeyForQuery(bs, family)
+		if cacheable {
+			if res, ok := r.cache.get(key, bs); ok {
+				metricDNSFwdCacheHit.Add(1)
+				return res, nil
+			}
+		}
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
@@ -293,7 +359,11 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, family string, from net
 				return nil, err
 			}
 		}
-		return (<-responses).bs, nil
+		res := (<-responses).bs
+		if cacheable {
+			r.cache.add(key, gen, res)
+		}
+		return res, nil
 	}
 
 	return out, err
@@ -1329,6 +1399,18 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorCT        = clientmetric.NewCounter("dns_query_fwd_doh_error_content_type")
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
+	metricDNSFwdDoHGET            = clientmetric.NewCounter("dns_query_fwd_doh_get")
+
+	metricDNSFwdCacheHit = clientmetric.NewCounter("dns_query_fwd_cache_hit")
+
+	metricDNSFwdDoT            = clientmetric.NewCounter("dns_query_fwd_dot")       // on entry
+	metricDNSFwdDoTWrote       = clientmetric.NewCounter("dns_query_fwd_dot_wrote") // sent query on a DoT conn
+	metricDNSFwdDoTErrorDial   = clientmetric.NewCounter("dns_query_fwd_dot_error_dial")
+	metricDNSFwdDoTErrorWrite  = clientmetric.NewCounter("dns_query_fwd_dot_error_write")
+	metricDNSFwdDoTErrorServer = clientmetric.NewCounter("dns_query_fwd_dot_error_server")
+	metricDNSFwdDoTErrorTxID   = clientmetric.NewCounter("dns_query_fwd_dot_error_txid")
+	metricDNSFwdDoTErrorRead   = clientmetric.NewCounter("dns_query_fwd_dot_error_read")
+	metricDNSFwdDoTSuccess     = clientmetric.NewCounter("dns_query_fwd_dot_success")
 
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
@@ -13,15 +13,21 @@
 package socks5
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/netip"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/logger"
 )
 
@@ -86,6 +92,8 @@ type Server struct {
 	Logf logger.Logf
 
 	// Dialer optionally specifies the dialer to use for outgoing connections.
+	// It is called with network "tcp" for CONNECT requests and "udp" for
+	// each destination of a UDP ASSOCIATE session.
 	// If nil, the net package's standard dialer is used.
 	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
 
@@ -172,52 +180,42 @@ func (c *Conn) Run() error {
 func (c *Conn) handleRequest() error {
 	req, err := parseClientRequest(c.clientConn)
 	if err != nil {
-		res := &response{reply: generalFailure}
-		buf, _ := res.marshal()
-		c.clientConn.Write(buf)
+		c.writeReply(generalFailure)
 		return err
 	}
-	if req.command != connect {
-		res := &response{reply: commandNotSupported}
-		buf, _ := res.marshal()
-		c.clientConn.Write(buf)
+	c.request = req
+	switch req.command {
+	case connect:
+		return c.handleTCP()
+	case udpAssociate:
+		return c.handleUDP()
+	default:
+		c.writeReply(commandNotSupported)
 		return fmt.Errorf("unsupported command %v", req.command)
 	}
-	c.request = req
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	srv, err := c.srv.dial(
-		ctx,
-		"tcp",
-		net.JoinHostPort(c.request.destination, strconv.Itoa(int(c.request.port))),
-	)
-	if err != nil {
-		res := &response{reply: generalFailure}
-		buf, _ := res.marshal()
-		c.clientConn.Write(buf)
-		return err
-	}
-	defer srv.Close()
-	serverAddr, serverPortStr, err := net.SplitHostPort(srv.LocalAddr().String())
+// writeReply writes a reply with no bound address to the client.
+// It is used for failures.
+func (c *Conn) writeReply(code replyCode) {
+	res := &response{reply: code}
+	buf, _ := res.marshal()
+	c.clientConn.Write(buf)
+}
+
+// writeSuccess writes a successful reply to the client, with bound as
+// the bound address.
+func (c *Conn) writeSuccess(bound net.Addr) error {
+	serverAddr, serverPortStr, err := net.SplitHostPort(bound.String())
 	if err != nil {
+		c.writeReply(generalFailure)
 		return err
 	}
 	serverPort, _ := strconv.Atoi(serverPortStr)
 
-	var bindAddrType addrType
-	if ip := net.ParseIP(serverAddr); ip != nil {
-		if ip.To4() != nil {
-			bindAddrType = ipv4
-		} else {
-			bindAddrType = ipv6
-		}
-	} else {
-		bindAddrType = domainName
-	}
 	res := &response{
 		reply:        success,
-		bindAddrType: bindAddrType,
+		bindAddrType: addrTypeOf(serverAddr),
 		bindAddr:     serverAddr,
 		bindPort:     uint16(serverPort),
 	}
@@ -227,6 +225,27 @@ func (c *Conn) handleRequest() error {
 		buf, _ = res.marshal()
 	}
 	c.clientConn.Write(buf)
+	return nil
+}
+
+// handleTCP handles a CONNECT request, proxying the client connection
+// to the requested destination.
+func (c *Conn) handleTCP() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv, err := c.srv.dial(
+		ctx,
+		"tcp",
+		net.JoinHostPort(c.request.destination, strconv.Itoa(int(c.request.port))),
+	)
+	if err != nil {
+		c.writeReply(generalFailure)
+		return err
+	}
+	defer srv.Close()
+	if err := c.writeSuccess(srv.LocalAddr()); err != nil {
+		return err
+	}
 
 	errc := make(chan error, 2)
 	go func() {
@@ -246,6 +265,240 @@ func (c *Conn) handleRequest() error {
 	return <-errc
 }
 
+// handleUDP handles a UDP ASSOCIATE request. It opens a UDP relay socket
+// for the client and forwards datagrams between it and their
+// destinations until the client closes its TCP control connection.
+func (c *Conn) handleUDP() error {
+	// The request's DST.ADDR and DST.PORT are where the client intends to
+	// send datagrams from, but clients commonly leave them zero as they
+	// don't know yet. Instead, only datagrams from the IP of the control
+	// connection are accepted, and the first one fixes the client's port.
+	clientIP, err := ipOfAddr(c.clientConn.RemoteAddr())
+	if err != nil {
+		c.writeReply(generalFailure)
+		return err
+	}
+	host, _, err := net.SplitHostPort(c.clientConn.LocalAddr().String())
+	if err != nil {
+		c.writeReply(generalFailure)
+		return err
+	}
+	relay, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		c.writeReply(generalFailure)
+		return err
+	}
+	defer relay.Close()
+	if err := c.writeSuccess(relay.LocalAddr()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// The association ends when the control connection does.
+		io.Copy(io.Discard, c.clientConn)
+		cancel()
+		relay.Close()
+	}()
+
+	a := &udpAssociation{
+		srv:         c.srv,
+		relay:       relay,
+		clientIP:    clientIP,
+		idleTimeout: udpTargetIdleTimeout,
+		maxTargets:  maxUDPTargets,
+		targets:     make(map[string]*udpTarget),
+	}
+	defer a.close()
+	if err := a.run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// udpAssociation is the state of a single UDP ASSOCIATE session.
+type udpAssociation struct {
+	srv      *Server
+	relay    net.PacketConn // the socket the client sends datagrams to
+	clientIP netip.Addr
+
+	idleTimeout time.Duration // how long a target may go unused before it is closed
+	maxTargets  int           // maximum number of targets open at once
+
+	// clientAddr is the client's UDP address, learned from its first
+	// datagram. It is only written by run before any goroutine
+	// reading it is started.
+	clientAddr net.Addr
+
+	mu sync.Mutex
+	// targets are the connections to each destination the client
+	// has sent datagrams to, keyed by "host:port". Targets are only
+	// added by run, and removed once idle.
+	targets map[string]*udpTarget // guarded by mu
+}
+
+// udpTarget is a connection to a destination of a UDP association.
+type udpTarget struct {
+	conn       net.Conn
+	lastActive mono.Time // of the last datagram to or from conn, accessed atomically
+}
+
+// errTooManyUDPTargets is returned when a client sends datagrams to more
+// destinations at once than a UDP association allows.
+var errTooManyUDPTargets = errors.New("too many UDP destinations")
+
+// run relays datagrams from the client to their destinations until
+// reading from the relay socket fails.
+func (a *udpAssociation) run(ctx context.Context) error {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, from, err := a.relay.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if ip, err := ipOfAddr(from); err != nil || ip != a.clientIP {
+			continue // not from our client
+		}
+		if a.clientAddr == nil {
+			a.clientAddr = from
+		} else if from.String() != a.clientAddr.String() {
+			continue
+		}
+		req, data, err := parseUDPRequest(buf[:n])
+		if err != nil {
+			a.srv.logf("dropping malformed UDP datagram: %v", err)
+			continue
+		}
+		if req.frag != 0 {
+			continue // fragmentation is not supported; RFC 1928 allows dropping these
+		}
+		target, err := a.target(ctx, req)
+		if err != nil {
+			a.srv.logf("UDP dial to %s:%d: %v", req.addr, req.port, err)
+			continue
+		}
+		if _, err := target.Write(data); err != nil {
+			a.srv.logf("UDP write to %s:%d: %v", req.addr, req.port, err)
+		}
+	}
+}
+
+// target returns the connection to the destination of req, dialing it
+// and starting to relay its replies to the client if needed.
+func (a *udpAssociation) target(ctx context.Context, req *udpRequest) (net.Conn, error) {
+	key := net.JoinHostPort(req.addr, strconv.Itoa(int(req.port)))
+	a.mu.Lock()
+	t, ok := a.targets[key]
+	n := len(a.targets)
+	a.mu.Unlock()
+	if ok {
+		t.lastActive.StoreAtomic(mono.Now())
+		return t.conn, nil
+	}
+	if n >= a.maxTargets {
+		return nil, errTooManyUDPTargets
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	c, err := a.srv.dial(ctx, "udp", key)
+	if err != nil {
+		return nil, err
+	}
+	t = &udpTarget{conn: c}
+	t.lastActive.StoreAtomic(mono.Now())
+	a.mu.Lock()
+	a.targets[key] = t
+	a.mu.Unlock()
+
+	// Replies carry the destination in the form the client used, so
+	// that it can match them to what it sent.
+	hdr := &udpRequest{addrType: req.addrType, addr: req.addr, port: req.port}
+	go a.relayReplies(key, t, hdr)
+	return c, nil
+}
+
+// relayReplies copies datagrams from a destination's connection back to
+// the client, prefixed with hdr, until reading fails or the target has
+// been idle for idleTimeout, and then closes the target.
+func (a *udpAssociation) relayReplies(key string, t *udpTarget, hdr *udpRequest) {
+	defer a.removeTarget(key, t)
+	pkt, err := hdr.marshal()
+	if err != nil {
+		return
+	}
+	hdrLen := len(pkt)
+	pkt = append(pkt, make([]byte, maxUDPPacketSize)...)
+	for {
+		t.conn.SetReadDeadline(time.Now().Add(a.idleTimeout))
+		n, err := t.conn.Read(pkt[hdrLen:])
+		if errors.Is(err, os.ErrDeadlineExceeded) && mono.Since(t.lastActive.LoadAtomic()) < a.idleTimeout {
+			continue // datagrams were sent to the target since the read began
+		}
+		if err != nil {
+			return
+		}
+		t.lastActive.StoreAtomic(mono.Now())
+		if _, err := a.relay.WriteTo(pkt[:hdrLen+n], a.clientAddr); err != nil {
+			return
+		}
+	}
+}
+
+// removeTarget closes t, and removes it from the targets as key.
+func (a *udpAssociation) removeTarget(key string, t *udpTarget) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.targets[key] == t {
+		delete(a.targets, key)
+	}
+	t.conn.Close()
+}
+
+// close closes all connections to destinations.
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range a.targets {
+		t.conn.Close()
+	}
+}
+
+const (
+	// udpTargetIdleTimeout is how long a destination of a UDP association
+	// may go without datagrams in either direction before it is closed,
+	// like a NAT mapping.
+	udpTargetIdleTimeout = 2 * time.Minute
+
+	// maxUDPTargets is the most destinations a UDP association may have
+	// at once. Datagrams to further destinations are dropped.
+	maxUDPTargets = 256
+)
+
+// maxUDPPacketSize is the largest UDP datagram relayed.
+const maxUDPPacketSize = 1 << 16
+
+// ipOfAddr returns the IP address of a TCP or UDP net.Addr.
+func ipOfAddr(a net.Addr) (netip.Addr, error) {
+	ap, err := netip.ParseAddrPort(a.String())
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return ap.Addr().Unmap(), nil
+}
+
+// addrTypeOf returns the address type to use for addr, which is either an
+// IP address or a domain name.
+func addrTypeOf(addr string) addrType {
+	if ip := net.ParseIP(addr); ip != nil {
+		if ip.To4() != nil {
+			return ipv4
+		}
+		return ipv6
+	}
+	return domainName
+}
+
 // parseClientGreeting parses a request initiation packet.
 func parseClientGreeting(r io.Reader, authMethod byte) error {
 	var hdr [2]byte
@@ -315,52 +568,94 @@ func parseClientRequest(r io.Reader) (*request, error) {
 	cmd := hdr[1]
 	destAddrType := addrType(hdr[3])
 
-	var destination string
-	var port uint16
+	destination, port, err := parseAddr(r, destAddrType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &request{
+		command:      commandType(cmd),
+		destination:  destination,
+		port:         port,
+		destAddrType: destAddrType,
+	}, nil
+}
 
-	if destAddrType == ipv4 {
+// parseAddr reads an address of type at and a port, in the format used by
+// SOCKS5 requests.
+func parseAddr(r io.Reader, at addrType) (addr string, port uint16, err error) {
+	if at == ipv4 {
 		var ip [4]byte
 		_, err = io.ReadFull(r, ip[:])
 		if err != nil {
-			return nil, fmt.Errorf("could not read IPv4 address")
+			return "", 0, fmt.Errorf("could not read IPv4 address")
 		}
-		destination = net.IP(ip[:]).String()
-	} else if destAddrType == domainName {
+		addr = net.IP(ip[:]).String()
+	} else if at == domainName {
 		var dstSizeByte [1]byte
 		_, err = io.ReadFull(r, dstSizeByte[:])
 		if err != nil {
-			return nil, fmt.Errorf("could not read domain name size")
+			return "", 0, fmt.Errorf("could not read domain name size")
 		}
 		dstSize := int(dstSizeByte[0])
 		domainName := make([]byte, dstSize)
 		_, err = io.ReadFull(r, domainName)
 		if err != nil {
-			return nil, fmt.Errorf("could not read domain name")
+			return "", 0, fmt.Errorf("could not read domain name")
 		}
-		destination = string(domainName)
-	} else if destAddrType == ipv6 {
+		addr = string(domainName)
+	} else if at == ipv6 {
 		var ip [16]byte
 		_, err = io.ReadFull(r, ip[:])
 		if err != nil {
-			return nil, fmt.Errorf("could not read IPv6 address")
+			return "", 0, fmt.Errorf("could not read IPv6 address")
 		}
-		destination = net.IP(ip[:]).String()
+		addr = net.IP(ip[:]).String()
 	} else {
-		return nil, fmt.Errorf("unsupported address type")
+		return "", 0, fmt.Errorf("unsupported address type")
 	}
 	var portBytes [2]byte
 	_, err = io.ReadFull(r, portBytes[:])
 	if err != nil {
-		return nil, fmt.Errorf("could not read port")
+		return "", 0, fmt.Errorf("could not read port")
 	}
-	port = binary.BigEndian.Uint16(portBytes[:])
+	return addr, binary.BigEndian.Uint16(portBytes[:]), nil
+}
 
-	return &request{
-		command:      commandType(cmd),
-		destination:  destination,
-		port:         port,
-		destAddrType: destAddrType,
-	}, nil
+// udpRequest is the header of a UDP datagram relayed through a
+// UDP ASSOCIATE session, as described in RFC 1928, section 7.
+type udpRequest struct {
+	frag     byte
+	addrType addrType
+	addr     string
+	port     uint16
+}
+
+// parseUDPRequest parses the header of a UDP datagram from the client,
+// returning it and the datagram's payload.
+func parseUDPRequest(pkt []byte) (_ *udpRequest, data []byte, err error) {
+	if len(pkt) < 4 {
+		return nil, nil, fmt.Errorf("short UDP datagram")
+	}
+	if pkt[0] != 0 || pkt[1] != 0 {
+		return nil, nil, fmt.Errorf("non-zero reserved field")
+	}
+	req := &udpRequest{
+		frag:     pkt[2],
+		addrType: addrType(pkt[3]),
+	}
+	r := bytes.NewReader(pkt[4:])
+	req.addr, req.port, err = parseAddr(r, req.addrType)
+	if err != nil {
+		return nil, nil, err
+	}
+	return req, pkt[len(pkt)-r.Len():], nil
+}
+
+// marshal converts a udpRequest into a UDP datagram header.
+func (u *udpRequest) marshal() ([]byte, error) {
+	pkt := []byte{0, 0, u.frag, byte(u.addrType)}
+	return appendAddr(pkt, u.addrType, u.addr, u.port)
 }
 
 // response contains the contents of
@@ -387,31 +682,37 @@ func (res *response) marshal() ([]byte, error) {
 		return pkt, nil
 	}
 
-	var addr []byte
-	switch res.bindAddrType {
+	return appendAddr(pkt, res.bindAddrType, res.bindAddr, res.bindPort)
+}
+
+// appendAddr appends addr, of type at, and port to pkt in the format used
+// by SOCKS5 replies and UDP datagram headers.
+func appendAddr(pkt []byte, at addrType, addr string, port uint16) ([]byte, error) {
+	var b []byte
+	switch at {
 	case ipv4:
-		addr = net.ParseIP(res.bindAddr).To4()
-		if addr == nil {
-			return nil, fmt.Errorf("invalid IPv4 address for binding")
+		b = net.ParseIP(addr).To4()
+		if b == nil {
+			return nil, fmt.Errorf("invalid IPv4 address")
 		}
 	case domainName:
-		if len(res.bindAddr) > 255 {
-			return nil, fmt.Errorf("invalid domain name for binding")
+		if len(addr) > 255 {
+			return nil, fmt.Errorf("invalid domain name")
 		}
-		addr = make([]byte, 0, len(res.bindAddr)+1)
-		addr = append(addr, byte(len(res.bindAddr)))
-		addr = append(addr, []byte(res.bindAddr)...)
+		b = make([]byte, 0, len(addr)+1)
+		b = append(b, byte(len(addr)))
+		b = append(b, []byte(addr)...)
 	case ipv6:
-		addr = net.ParseIP(res.bindAddr).To16()
-		if addr == nil {
-			return nil, fmt.Errorf("invalid IPv6 address for binding")
+		b = net.ParseIP(addr).To16()
+		if b == nil {
+			return nil, fmt.Errorf("invalid IPv6 address")
 		}
 	default:
 		return nil, fmt.Errorf("unsupported address type")
 	}
 
-	pkt = append(pkt, addr...)
-	pkt = binary.BigEndian.AppendUint16(pkt, uint16(res.bindPort))
+	pkt = append(pkt, b...)
+	pkt = binary.BigEndian.AppendUint16(pkt, port)
 
 	return pkt, nil
 }
@@ -4,11 +4,14 @@
 package socks5
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"testing"
+	"time"
 
 	"golang.org/x/net/proxy"
 )
@@ -152,3 +155,212 @@ func TestReadPassword(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func udpEchoServer(conn net.PacketConn) {
+	var buf [1024]byte
+	for {
+		n, addr, err := conn.ReadFrom(buf[:])
+		if err != nil {
+			return
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
+
+func TestUDPAssociate(t *testing.T) {
+	// backend UDP server which we'll use SOCKS5 to send datagrams to
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	backendPort := uint16(backend.LocalAddr().(*net.UDPAddr).Port)
+	go udpEchoServer(backend)
+
+	socks5ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socks5ln.Close()
+	go func() {
+		var s Server
+		err := s.Serve(socks5ln)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			panic(err)
+		}
+	}()
+
+	// Set up the association over the control connection.
+	ctrl, err := net.Dial("tcp", socks5ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctrl.Close()
+	if _, err := ctrl.Write([]byte{socks5Version, 1, noAuthRequired}); err != nil {
+		t.Fatal(err)
+	}
+	var greeting [2]byte
+	if _, err := io.ReadFull(ctrl, greeting[:]); err != nil {
+		t.Fatal(err)
+	}
+	if greeting != [2]byte{socks5Version, noAuthRequired} {
+		t.Fatalf("got greeting %v", greeting)
+	}
+	if _, err := ctrl.Write([]byte{socks5Version, byte(udpAssociate), 0, byte(ipv4), 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	var hdr [4]byte
+	if _, err := io.ReadFull(ctrl, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if replyCode(hdr[1]) != success {
+		t.Fatalf("got reply %v; want success", hdr[1])
+	}
+	relayHost, relayPort, err := parseAddr(ctrl, addrType(hdr[3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	relayAddr := &net.UDPAddr{IP: net.ParseIP(relayHost), Port: int(relayPort)}
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dst := &udpRequest{addrType: ipv4, addr: "127.0.0.1", port: backendPort}
+	pkt, err := dst.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		if _, err := client.WriteTo(append(pkt, msg...), relayAddr); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, _, err := client.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, data, err := parseUDPRequest(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.addr != "127.0.0.1" || res.port != backendPort {
+			t.Errorf("reply from %v:%v; want 127.0.0.1:%v", res.addr, res.port, backendPort)
+		}
+		if string(data) != msg {
+			t.Errorf("got %q; want %q", data, msg)
+		}
+	}
+}
+
+func TestUDPAssociationTargets(t *testing.T) {
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+	a := &udpAssociation{
+		srv:         &Server{Logf: t.Logf},
+		relay:       relay,
+		clientAddr:  relay.LocalAddr(),
+		idleTimeout: 100 * time.Millisecond,
+		maxTargets:  2,
+		targets:     make(map[string]*udpTarget),
+	}
+	defer a.close()
+	numTargets := func() int {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return len(a.targets)
+	}
+
+	ctx := context.Background()
+	for _, port := range []uint16{1001, 1002} {
+		if _, err := a.target(ctx, &udpRequest{addrType: ipv4, addr: "127.0.0.1", port: port}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.target(ctx, &udpRequest{addrType: ipv4, addr: "127.0.0.1", port: 1003}); !errors.Is(err, errTooManyUDPTargets) {
+		t.Fatalf("third target: got %v, want %v", err, errTooManyUDPTargets)
+	}
+	// Existing targets are still usable.
+	if _, err := a.target(ctx, &udpRequest{addrType: ipv4, addr: "127.0.0.1", port: 1001}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Idle targets are closed, making room for new ones.
+	for deadline := time.Now().Add(5 * time.Second); numTargets() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle targets not closed", numTargets())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := a.target(ctx, &udpRequest{addrType: ipv4, addr: "127.0.0.1", port: 1003}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseUDPRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		pkt      []byte
+		want     *udpRequest
+		wantData string
+		wantErr  bool
+	}{
+		{
+			name:     "ipv4",
+			pkt:      []byte{0, 0, 0, byte(ipv4), 100, 64, 0, 1, 0, 53, 'h', 'i'},
+			want:     &udpRequest{addrType: ipv4, addr: "100.64.0.1", port: 53},
+			wantData: "hi",
+		},
+		{
+			name:     "domain",
+			pkt:      append([]byte{0, 0, 1, byte(domainName), 3, 'f', 'o', 'o', 1, 0}, "data"...),
+			want:     &udpRequest{frag: 1, addrType: domainName, addr: "foo", port: 256},
+			wantData: "data",
+		},
+		{
+			name:    "short",
+			pkt:     []byte{0, 0, 0},
+			wantErr: true,
+		},
+		{
+			name:    "reserved",
+			pkt:     []byte{0, 1, 0, byte(ipv4), 100, 64, 0, 1, 0, 53},
+			wantErr: true,
+		},
+		{
+			name:    "truncated-addr",
+			pkt:     []byte{0, 0, 0, byte(ipv6), 0xfd, 0x7a},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, data, err := parseUDPRequest(tt.pkt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if *got != *tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("data = %q; want %q", data, tt.wantData)
+			}
+			pkt, err := got.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.pkt[:len(tt.pkt)-len(data)]; !bytes.Equal(pkt, want) {
+				t.Errorf("marshal = %v; want %v", pkt, want)
+			}
+		})
+	}
+}
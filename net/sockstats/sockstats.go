@@ -52,6 +52,7 @@ type SockStat struct {
 	LabelNetlogLogger        Label = 10 // wgengine/netlog/logger.go
 	LabelSockstatlogLogger   Label = 11 // log/sockstatlog/logger.go
 	LabelDNSForwarderTCP     Label = 12 // net/dns/resolver/forwarder.go
+	LabelDNSForwarderDoT     Label = 13 // net/dns/resolver/dot.go
 )
 
 // WithSockStats instruments a context so that sockets created with it will
@@ -33,14 +33,18 @@
 // Extension, none), user-selected route acceptance prefs, etc.
 type Dialer struct {
 	Logf logger.Logf
-	// UseNetstackForIP if non-nil is whether NetstackDialTCP (if
-	// it's non-nil) should be used to dial the provided IP.
+	// UseNetstackForIP if non-nil is whether NetstackDialTCP or
+	// NetstackDialUDP (if non-nil) should be used to dial the provided IP.
 	UseNetstackForIP func(netip.Addr) bool
 
 	// NetstackDialTCP dials the provided IPPort using netstack.
 	// If nil, it's not used.
 	NetstackDialTCP func(context.Context, netip.AddrPort) (net.Conn, error)
 
+	// NetstackDialUDP dials the provided IPPort using netstack.
+	// If nil, it's not used.
+	NetstackDialUDP func(context.Context, netip.AddrPort) (net.Conn, error)
+
 	peerClientOnce sync.Once
 	peerClient     *http.Client
 
@@ -332,6 +336,12 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 		return nil, err
 	}
 	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
+		if strings.HasPrefix(network, "udp") {
+			if d.NetstackDialUDP == nil {
+				return nil, errors.New("Dialer not initialized correctly")
+			}
+			return d.NetstackDialUDP(ctx, ipp)
+		}
 		if d.NetstackDialTCP == nil {
 			return nil, errors.New("Dialer not initialized correctly")
 		}
@@ -0,0 +1,315 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	alerterAlertSent   = expvar.NewInt("alerter_alert_sent")
+	alerterAlertFailed = expvar.NewInt("alerter_alert_failed")
+)
+
+// Alert is a notification that a probe has started failing, or has
+// recovered.
+type Alert struct {
+	// Probe is the name of the probe.
+	Probe string `json:"probe"`
+	// Labels are the labels the probe was run with.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Firing is true when the probe has started failing, and false when
+	// it has recovered.
+	Firing bool `json:"firing"`
+	// Error is the error from the most recent failed run of the probe.
+	Error string `json:"error,omitempty"`
+	// Count is the number of consecutive failures (if Firing) or
+	// successes (if not) which caused the alert.
+	Count int `json:"count"`
+	// Time is when the alert was generated.
+	Time time.Time `json:"time"`
+}
+
+// Summary returns a one-line description of the alert.
+func (a Alert) Summary() string {
+	if a.Firing {
+		return fmt.Sprintf("probe %s is failing: %s", a.Probe, a.Error)
+	}
+	return fmt.Sprintf("probe %s has recovered", a.Probe)
+}
+
+// Details returns a multi-line description of the alert, including its
+// labels.
+func (a Alert) Details() string {
+	var b strings.Builder
+	b.WriteString(a.Summary())
+	b.WriteString("\n\n")
+	if a.Firing {
+		fmt.Fprintf(&b, "Failed %d consecutive times", a.Count)
+	} else {
+		fmt.Fprintf(&b, "Succeeded %d consecutive times", a.Count)
+	}
+	fmt.Fprintf(&b, " as of %s.\n", a.Time.UTC().Format(time.RFC3339))
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, a.Labels[k])
+	}
+	return b.String()
+}
+
+// AlertSink delivers alerts to a notification system.
+type AlertSink interface {
+	SendAlert(context.Context, Alert) error
+}
+
+// WebhookSink is an AlertSink which POSTs each Alert, encoded as JSON, to
+// a URL. Any 2xx response is considered a success.
+type WebhookSink struct {
+	URL string
+	// Header contains additional headers to send, such as
+	// Authorization.
+	Header http.Header
+	// Client is the HTTP client to use. If nil, a client with a
+	// 10 second timeout is used.
+	Client *http.Client
+}
+
+// SendAlert implements AlertSink.
+func (s *WebhookSink) SendAlert(ctx context.Context, a Alert) error {
+	_, err := postJSON(ctx, s.Client, s.URL, s.Header, a, isStatus2xx)
+	return err
+}
+
+// SlackSink is an AlertSink which posts alerts to a Slack incoming
+// webhook, or any service accepting the same JSON payload.
+type SlackSink struct {
+	URL string
+	// Client is the HTTP client to use. If nil, a client with a
+	// 10 second timeout is used.
+	Client *http.Client
+}
+
+// SendAlert implements AlertSink.
+func (s *SlackSink) SendAlert(ctx context.Context, a Alert) error {
+	icon := ":large_green_circle:"
+	if a.Firing {
+		icon = ":red_circle:"
+	}
+	body := struct {
+		Text string `json:"text"`
+	}{
+		Text: icon + " " + a.Summary(),
+	}
+	_, err := postJSON(ctx, s.Client, s.URL, nil, body, isStatus2xx)
+	return err
+}
+
+// SMTPSink is an AlertSink which sends each alert as an email.
+//
+// STARTTLS is used if the server supports it.
+type SMTPSink struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// Auth, if non-nil, is used to authenticate to the server.
+	Auth smtp.Auth
+	// From is the envelope and header sender address.
+	From string
+	// To are the recipient addresses.
+	To []string
+}
+
+// SendAlert implements AlertSink.
+func (s *SMTPSink) SendAlert(ctx context.Context, a Alert) error {
+	if len(s.To) == 0 {
+		return errors.New("no recipients")
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Auth != nil {
+		if err := c.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "From: %s\r\n", s.From)
+	fmt.Fprintf(w, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(w, "Subject: [prober] %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(a.Summary()))
+	fmt.Fprintf(w, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(w, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprint(w, strings.ReplaceAll(a.Details(), "\n", "\r\n"))
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Alerter sends alerts to a set of AlertSinks when the probes of a Prober
+// start failing, and when they recover.
+//
+// To avoid flapping, an alert fires only after FailuresToAlert consecutive
+// failures, and is resolved only after SuccessesToResolve consecutive
+// successes. Delivery to a sink which fails is retried on the next Check.
+//
+// Sinks may be appended to between calls to Check. A new sink is sent the
+// alerts of the probes which are failing.
+type Alerter struct {
+	Prober *Prober
+	Sinks  []AlertSink
+
+	// FailuresToAlert is the number of consecutive failures of a probe
+	// after which an alert fires. If zero, 1 is used.
+	FailuresToAlert int
+	// SuccessesToResolve is the number of consecutive successes of a
+	// probe after which a firing alert is resolved. If zero, 1 is used.
+	SuccessesToResolve int
+
+	mu     sync.Mutex // serializes Check
+	alerts map[string]*alertState
+}
+
+// alertState is the alert state of a single probe.
+type alertState struct {
+	firing bool
+	alert  Alert // the alert generated by the most recent state change
+	// notified records, for each sink, whether the sink was last told
+	// that the alert is firing.
+	notified []bool
+}
+
+// Run calls Check every interval until ctx is done.
+func (a *Alerter) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if err := a.Check(ctx); err != nil {
+				log.Printf("sending alerts: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check updates the alert state of every probe from its most recent
+// results, and sends any alerts which have not yet been delivered.
+func (a *Alerter) Check(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.alerts == nil {
+		a.alerts = make(map[string]*alertState)
+	}
+	failuresToAlert := max(a.FailuresToAlert, 1)
+	successesToResolve := max(a.SuccessesToResolve, 1)
+
+	infos := a.Prober.ProbeInfo()
+	for name := range a.alerts {
+		if _, ok := infos[name]; !ok {
+			// The probe was removed, so there is nothing more to say
+			// about it.
+			delete(a.alerts, name)
+		}
+	}
+	names := make([]string, 0, len(infos))
+	for name, info := range infos {
+		names = append(names, name)
+		st := a.alerts[name]
+		if st == nil {
+			st = new(alertState)
+			a.alerts[name] = st
+		}
+		if n := len(a.Sinks) - len(st.notified); n > 0 {
+			st.notified = append(st.notified, make([]bool, n)...)
+		}
+		switch {
+		case !st.firing && info.ConsecutiveFailures >= failuresToAlert:
+			st.firing = true
+			st.alert = Alert{
+				Probe:  name,
+				Labels: info.Labels,
+				Firing: true,
+				Error:  info.Error,
+				Count:  info.ConsecutiveFailures,
+				Time:   a.Prober.now(),
+			}
+		case st.firing && info.ConsecutiveSuccesses >= successesToResolve:
+			st.firing = false
+			st.alert = Alert{
+				Probe:  name,
+				Labels: info.Labels,
+				Count:  info.ConsecutiveSuccesses,
+				Time:   a.Prober.now(),
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		st := a.alerts[name]
+		for i, sink := range a.Sinks {
+			if st.notified[i] == st.firing {
+				continue
+			}
+			if err := sink.SendAlert(ctx, st.alert); err != nil {
+				alerterAlertFailed.Add(1)
+				errs = append(errs, fmt.Errorf("sink %d: %s: %w", i, st.alert.Summary(), err))
+				continue
+			}
+			alerterAlertSent.Add(1)
+			st.notified[i] = st.firing
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,331 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"tailscale.com/tstest"
+)
+
+// recordingSink is an AlertSink which records the alerts it is sent.
+type recordingSink struct {
+	mu     sync.Mutex
+	fail   bool
+	alerts []Alert
+}
+
+func (s *recordingSink) SendAlert(_ context.Context, a Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail {
+		return errors.New("sink failed")
+	}
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+func (s *recordingSink) take() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := s.alerts
+	s.alerts = nil
+	return ret
+}
+
+func TestAlerter(t *testing.T) {
+	clk := newFakeTime()
+	p := newForTest(clk.Now, clk.NewTicker)
+
+	var succeed atomic.Bool
+	succeed.Store(true)
+	p.Run("flappy", probeInterval, map[string]string{"region": "sfo"}, func(context.Context) error {
+		if succeed.Load() {
+			return nil
+		}
+		return errors.New("connection refused")
+	})
+	waitActiveProbes(t, p, clk, 1)
+
+	// waitRun waits for the probe to finish running at the current time.
+	waitRun := func() {
+		t.Helper()
+		err := tstest.WaitFor(convergenceTimeout, func() error {
+			if got, want := p.ProbeInfo()["flappy"].End, clk.Now(); !got.Equal(want) {
+				return fmt.Errorf("probe end time is %v, want %v", got, want)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// run waits for the probe to run once more with the given result.
+	run := func(ok bool) {
+		t.Helper()
+		succeed.Store(ok)
+		clk.Advance(probeInterval)
+		waitRun()
+	}
+	waitRun()
+	clk.Advance(halfProbeInterval)
+
+	good, bad := &recordingSink{}, &recordingSink{fail: true}
+	a := &Alerter{
+		Prober:             p,
+		Sinks:              []AlertSink{good, bad},
+		FailuresToAlert:    3,
+		SuccessesToResolve: 2,
+	}
+	check := func(wantFiring ...bool) {
+		t.Helper()
+		a.Check(context.Background())
+		got := good.take()
+		if len(got) != len(wantFiring) {
+			t.Fatalf("got %d alerts, want %d: %+v", len(got), len(wantFiring), got)
+		}
+		for i, alert := range got {
+			if alert.Firing != wantFiring[i] {
+				t.Errorf("alert %d: Firing = %v, want %v", i, alert.Firing, wantFiring[i])
+			}
+			if alert.Probe != "flappy" || alert.Labels["region"] != "sfo" {
+				t.Errorf("alert %d: wrong probe or labels: %+v", i, alert)
+			}
+		}
+	}
+
+	run(true)
+	check()
+
+	// Two failures in a row are not enough to alert, and a single
+	// success resets the count.
+	run(false)
+	run(false)
+	check()
+	run(true)
+	run(false)
+	run(false)
+	check()
+
+	run(false)
+	check(true)
+	run(false)
+	check()
+
+	// A single success does not resolve the alert.
+	run(true)
+	check()
+	run(false)
+	run(true)
+	run(true)
+	check(false)
+
+	// The failing sink never learned about the alert, so it has nothing to
+	// resolve. When it recovers, it catches up with the next alert.
+	bad.mu.Lock()
+	bad.fail = false
+	bad.mu.Unlock()
+	check()
+	if got := bad.take(); len(got) != 0 {
+		t.Fatalf("failing sink got %d alerts after recovering, want 0", len(got))
+	}
+	run(false)
+	run(false)
+	run(false)
+	check(true)
+	if got := bad.take(); len(got) != 1 || !got[0].Firing || got[0].Count != 3 || got[0].Error != "connection refused" {
+		t.Fatalf("recovered sink got %+v, want one firing alert", got)
+	}
+
+	// A sink added later is told about the failing probe.
+	late := &recordingSink{}
+	a.Sinks = append(a.Sinks, late)
+	sent, failed := alerterAlertSent.Value(), alerterAlertFailed.Value()
+	check()
+	if got := late.take(); len(got) != 1 || !got[0].Firing {
+		t.Fatalf("added sink got %+v, want one firing alert", got)
+	}
+	if got := alerterAlertSent.Value() - sent; got != 1 {
+		t.Errorf("alerter_alert_sent increased by %d, want 1", got)
+	}
+	if got := alerterAlertFailed.Value() - failed; got != 0 {
+		t.Errorf("alerter_alert_failed increased by %d, want 0", got)
+	}
+}
+
+func TestWebhookSinks(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		got  map[string]any
+		auth string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth = r.Header.Get("Authorization")
+		got = nil
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+
+	alert := Alert{Probe: "www", Firing: true, Error: "timeout", Count: 2}
+	ctx := context.Background()
+
+	webhook := &WebhookSink{URL: ts.URL, Header: http.Header{"Authorization": {"Bearer secret"}}}
+	if err := webhook.SendAlert(ctx, alert); err != nil {
+		t.Fatalf("webhook: %v", err)
+	}
+	mu.Lock()
+	if got["probe"] != "www" || got["firing"] != true || got["error"] != "timeout" {
+		t.Errorf("webhook payload = %v", got)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("webhook Authorization = %q, want %q", auth, "Bearer secret")
+	}
+	mu.Unlock()
+
+	slack := &SlackSink{URL: ts.URL}
+	if err := slack.SendAlert(ctx, alert); err != nil {
+		t.Fatalf("slack: %v", err)
+	}
+	mu.Lock()
+	if text, _ := got["text"].(string); !strings.Contains(text, "probe www is failing: timeout") {
+		t.Errorf("slack text = %q", text)
+	}
+	mu.Unlock()
+
+	if err := (&WebhookSink{URL: ts.URL + "/fail"}).SendAlert(ctx, alert); err == nil {
+		t.Error("webhook to failing server succeeded")
+	}
+}
+
+func TestSMTPSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	msg := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		msg <- fakeSMTP(c)
+	}()
+
+	s := &SMTPSink{
+		Addr: ln.Addr().String(),
+		From: "prober@example.com",
+		To:   []string{"oncall@example.com"},
+	}
+	alert := Alert{Probe: "www", Labels: map[string]string{"env": "prod"}, Firing: true, Error: "timeout", Count: 2}
+	if err := s.SendAlert(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	got := <-msg
+	for _, want := range []string{
+		"MAIL FROM:<prober@example.com>",
+		"RCPT TO:<oncall@example.com>",
+		"Subject: [prober] probe www is failing: timeout",
+		"env: prod",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("SMTP session does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+// fakeSMTP serves a single SMTP session on c, returning everything the
+// client sent.
+func fakeSMTP(c net.Conn) string {
+	var transcript strings.Builder
+	r := bufio.NewReader(c)
+	fmt.Fprintf(c, "220 localhost ESMTP\r\n")
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return transcript.String()
+		}
+		transcript.WriteString(line)
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case inData:
+			if cmd == "." {
+				inData = false
+				fmt.Fprintf(c, "250 queued\r\n")
+			}
+		case strings.HasPrefix(cmd, "EHLO"):
+			fmt.Fprintf(c, "250 localhost\r\n")
+		case cmd == "DATA":
+			inData = true
+			fmt.Fprintf(c, "354 go ahead\r\n")
+		case cmd == "QUIT":
+			fmt.Fprintf(c, "221 bye\r\n")
+			return transcript.String()
+		default:
+			fmt.Fprintf(c, "250 ok\r\n")
+		}
+	}
+}
+
+func TestSendAlertMetrics(t *testing.T) {
+	t.Setenv("SQUADCAST_WEBHOOK", "")
+	before := warningFailed.Value()
+	if err := SendAlert("summary", "details"); err == nil {
+		t.Error("SendAlert without a webhook succeeded")
+	}
+	if got := warningFailed.Value() - before; got != 1 {
+		t.Errorf("warning_failed increased by %d, want 1", got)
+	}
+
+	var code atomic.Int32
+	code.Store(http.StatusAccepted)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(code.Load()))
+		io.WriteString(w, "ok")
+	}))
+	defer ts.Close()
+	t.Setenv("SQUADCAST_WEBHOOK", ts.URL)
+
+	before = alertFailed.Value()
+	if err := SendAlert("summary", "details"); err == nil {
+		t.Error("SendAlert succeeded with a 202 response")
+	}
+	if got := alertFailed.Value() - before; got != 1 {
+		t.Errorf("alert_failed increased by %d, want 1", got)
+	}
+
+	code.Store(http.StatusOK)
+	before = alertGenerated.Value()
+	if err := SendAlert("summary", "details"); err != nil {
+		t.Errorf("SendAlert: %v", err)
+	}
+	if got := alertGenerated.Value() - before; got != 1 {
+		t.Errorf("alert_generated increased by %d, want 1", got)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxDNSResponseSize is the size of the buffer used to read DNS
+// responses. Queries don't advertise EDNS, so responses are at most 512
+// bytes, but we allow for misbehaving servers.
+const maxDNSResponseSize = 4096
+
+// DNS returns a Probe that healthchecks a DNS server.
+//
+// The ProbeFunc sends a query for name of type qtype to server (host:port)
+// over UDP, and verifies that the response code is wantRCode. Each value in
+// want must be present in the answer section of the response: IP addresses
+// for A and AAAA queries, domain names for CNAME, NS, PTR and MX queries,
+// the target host for SRV queries, the primary name server for SOA
+// queries, and text for TXT queries.
+//
+// As with other probes, the probe's latency is the time taken for the
+// server to respond. Callers should label DNS probes with the "server" they
+// query, as DERP probes are labeled with their "hostname".
+func DNS(server, name string, qtype dnsmessage.Type, wantRCode dnsmessage.RCode, want ...string) ProbeFunc {
+	return func(ctx context.Context) error {
+		return probeDNS(ctx, server, name, qtype, wantRCode, want)
+	}
+}
+
+func probeDNS(ctx context.Context, server, name string, qtype dnsmessage.Type, wantRCode dnsmessage.RCode, want []string) error {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return fmt.Errorf("invalid name %q: %w", name, err)
+	}
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		return err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return fmt.Errorf("dialing %q: %w", server, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return fmt.Errorf("sending query to %q: %w", server, err)
+	}
+
+	buf := make([]byte, maxDNSResponseSize)
+	var p dnsmessage.Parser
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("reading response from %q: %w", server, err)
+		}
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			return fmt.Errorf("parsing response from %q: %w", server, err)
+		}
+		// Ignore stray responses, such as to an earlier timed-out query.
+		if h.ID == id && h.Response {
+			if h.RCode != wantRCode {
+				return fmt.Errorf("query for %s %v to %q: got rcode %v, want %v", name, qtype, server, h.RCode, wantRCode)
+			}
+			break
+		}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return fmt.Errorf("parsing response from %q: %w", server, err)
+	}
+	answers, err := dnsAnswers(&p)
+	if err != nil {
+		return fmt.Errorf("parsing answers from %q: %w", server, err)
+	}
+	for _, w := range want {
+		if !answers[normalizeDNSAnswer(qtype, w)] {
+			return fmt.Errorf("query for %s %v to %q: answer %q not found (got: %q)", name, qtype, server, w, keys(answers))
+		}
+	}
+	return nil
+}
+
+// dnsAnswers returns the set of answers in the response, formatted as
+// described in DNS and normalized with normalizeDNSAnswer.
+func dnsAnswers(p *dnsmessage.Parser) (map[string]bool, error) {
+	out := make(map[string]bool)
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var v string
+		switch h.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, err
+			}
+			v = netip.AddrFrom4(r.A).String()
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			v = netip.AddrFrom16(r.AAAA).String()
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.CNAME.String()
+		case dnsmessage.TypeNS:
+			r, err := p.NSResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.NS.String()
+		case dnsmessage.TypePTR:
+			r, err := p.PTRResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.PTR.String()
+		case dnsmessage.TypeMX:
+			r, err := p.MXResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.MX.String()
+		case dnsmessage.TypeSRV:
+			r, err := p.SRVResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.Target.String()
+		case dnsmessage.TypeSOA:
+			r, err := p.SOAResource()
+			if err != nil {
+				return nil, err
+			}
+			v = r.NS.String()
+		case dnsmessage.TypeTXT:
+			r, err := p.TXTResource()
+			if err != nil {
+				return nil, err
+			}
+			v = strings.Join(r.TXT, "")
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		out[normalizeDNSAnswer(h.Type, v)] = true
+	}
+}
+
+// normalizeDNSAnswer returns a canonical form of an answer of type t, so
+// that expected and actual answers can be compared.
+func normalizeDNSAnswer(t dnsmessage.Type, v string) string {
+	switch t {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		if ip, err := netip.ParseAddr(v); err == nil {
+			return ip.Unmap().String()
+		}
+	case dnsmessage.TypeCNAME, dnsmessage.TypeNS, dnsmessage.TypePTR, dnsmessage.TypeMX,
+		dnsmessage.TypeSRV, dnsmessage.TypeSOA:
+		return strings.ToLower(strings.TrimSuffix(v, "."))
+	}
+	return v
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
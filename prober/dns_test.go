@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDNS runs a DNS server on localhost which answers A queries for
+// "example.com." with 192.0.2.1 and 192.0.2.2, SRV queries with
+// "srv.example.com.", SOA queries with primary name server
+// "ns1.example.com.", and all other queries with NXDOMAIN. It returns the
+// server's address.
+func serveDNS(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			rh := dnsmessage.Header{ID: h.ID, Response: true, RCode: dnsmessage.RCodeNameError}
+			found := q.Name.String() == "example.com." &&
+				(q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeSOA)
+			if found {
+				rh.RCode = dnsmessage.RCodeSuccess
+			}
+			b := dnsmessage.NewBuilder(nil, rh)
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			rrh := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60}
+			switch {
+			case !found:
+			case q.Type == dnsmessage.TypeA:
+				b.AResource(rrh, dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})
+				b.AResource(rrh, dnsmessage.AResource{A: [4]byte{192, 0, 2, 2}})
+			case q.Type == dnsmessage.TypeSRV:
+				b.SRVResource(rrh, dnsmessage.SRVResource{Port: 443, Target: dnsmessage.MustNewName("srv.example.com.")})
+			case q.Type == dnsmessage.TypeSOA:
+				b.SOAResource(rrh, dnsmessage.SOAResource{
+					NS:   dnsmessage.MustNewName("ns1.example.com."),
+					MBox: dnsmessage.MustNewName("hostmaster.example.com."),
+				})
+			}
+			resp, err := b.Finish()
+			if err != nil {
+				t.Errorf("building response: %v", err)
+				return
+			}
+			pc.WriteTo(resp, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestDNS(t *testing.T) {
+	server := serveDNS(t)
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   dnsmessage.Type // or zero for A
+		rcode   dnsmessage.RCode
+		want    []string
+		wantErr bool
+	}{
+		{name: "no_answers_checked", qname: "example.com", rcode: dnsmessage.RCodeSuccess},
+		{name: "answers_match", qname: "example.com.", rcode: dnsmessage.RCodeSuccess, want: []string{"192.0.2.2", "192.0.2.1"}},
+		{name: "answer_missing", qname: "example.com", rcode: dnsmessage.RCodeSuccess, want: []string{"192.0.2.3"}, wantErr: true},
+		{name: "wrong_rcode", qname: "missing.example.com", rcode: dnsmessage.RCodeSuccess, wantErr: true},
+		{name: "expected_nxdomain", qname: "missing.example.com", rcode: dnsmessage.RCodeNameError},
+		{name: "srv", qname: "example.com", qtype: dnsmessage.TypeSRV, rcode: dnsmessage.RCodeSuccess, want: []string{"SRV.example.com."}},
+		{name: "srv_missing", qname: "example.com", qtype: dnsmessage.TypeSRV, rcode: dnsmessage.RCodeSuccess, want: []string{"other.example.com"}, wantErr: true},
+		{name: "soa", qname: "example.com", qtype: dnsmessage.TypeSOA, rcode: dnsmessage.RCodeSuccess, want: []string{"ns1.example.com"}},
+		{name: "soa_missing", qname: "example.com", qtype: dnsmessage.TypeSOA, rcode: dnsmessage.RCodeSuccess, want: []string{"ns2.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			qtype := tt.qtype
+			if qtype == 0 {
+				qtype = dnsmessage.TypeA
+			}
+			err := DNS(server, tt.qname, qtype, tt.rcode, tt.want...)(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DNS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
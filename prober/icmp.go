@@ -0,0 +1,43 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"tailscale.com/net/ping"
+)
+
+// ICMP returns a Probe that checks a host is reachable with ICMP echo
+// requests.
+//
+// The ProbeFunc resolves host, sends an ICMP echo request to its first
+// address, and waits for a matching echo reply. Sending ICMP requests
+// requires raw sockets, which on most systems require elevated
+// privileges (such as CAP_NET_RAW on Linux).
+func ICMP(host string) ProbeFunc {
+	return func(ctx context.Context) error {
+		return probeICMP(ctx, host)
+	}
+}
+
+func probeICMP(ctx context.Context, host string) error {
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolving %q: %w", host, err)
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("resolving %q: no addresses", host)
+	}
+
+	p := ping.New(ctx, log.Printf, &net.ListenConfig{})
+	defer p.Close()
+	if _, err := p.Send(ctx, &ips[0], []byte("tailscale-prober")); err != nil {
+		return fmt.Errorf("pinging %q (%v): %w", host, ips[0].IP, err)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestICMP(t *testing.T) {
+	// Sending ICMP requires a raw socket, which may fail for lack of
+	// privileges or of support in the sandbox running the tests.
+	c, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		t.Skipf("can't create ICMP socket: %v", err)
+	}
+	c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ICMP("127.0.0.1")(ctx); err != nil {
+		t.Errorf("ICMP() = %v, want nil", err)
+	}
+}
@@ -5,6 +5,7 @@
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -30,6 +31,9 @@
 // page a human responder immediately.
 // summary should be short and state the nature of the emergency.
 // details can be longer, up to 29 KBytes.
+//
+// To send alerts elsewhere, or only when probes fail repeatedly, use an
+// Alerter with one or more AlertSinks.
 func SendAlert(summary, details string) error {
 	type squadcastAlert struct {
 		Message     string            `json:"message"`
@@ -47,40 +51,17 @@ type squadcastAlert struct {
 		EventId:     uuid.New().String(),
 	}
 
-	sqBody, err := json.Marshal(sqa)
-	if err != nil {
-		alertFailed.Add(1)
-		return fmt.Errorf("encoding alert payload: %w", err)
-	}
-
 	webhookUrl := os.Getenv("SQUADCAST_WEBHOOK")
 	if webhookUrl == "" {
 		warningFailed.Add(1)
 		return errors.New("no SQUADCAST_WEBHOOK configured")
 	}
 
-	req, err := http.NewRequest(httpm.POST, webhookUrl, bytes.NewBuffer(sqBody))
-	if err != nil {
-		alertFailed.Add(1)
-		return err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+	body, err := postJSON(context.Background(), nil, webhookUrl, nil, sqa, isStatusOK)
 	if err != nil {
 		alertFailed.Add(1)
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		alertFailed.Add(1)
-		return errors.New(resp.Status)
-	}
-
-	body, _ := io.ReadAll(resp.Body)
 	if string(body) != "ok" {
 		alertFailed.Add(1)
 		return errors.New("non-ok response returned from Squadcast")
@@ -103,37 +84,55 @@ type slackRequestBody struct {
 		Text string `json:"text"`
 	}
 
-	slackBody, err := json.Marshal(slackRequestBody{Text: details})
+	body, err := postJSON(context.Background(), nil, webhookUrl, nil, slackRequestBody{Text: details}, isStatusOK)
 	if err != nil {
 		warningFailed.Add(1)
 		return err
 	}
+	if s := strings.TrimSpace(string(body)); s != "ok" {
+		warningFailed.Add(1)
+		return errors.New("non-ok response returned from Slack")
+	}
+	warningGenerated.Add(1)
+	return nil
+}
 
-	req, err := http.NewRequest("POST", webhookUrl, bytes.NewReader(slackBody))
+// postJSON POSTs v, encoded as JSON, to url, and returns the response body.
+// Responses whose status code is not accepted by ok are returned as errors.
+//
+// If client is nil, a client with a 10 second timeout is used.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, v any, ok func(code int) bool) ([]byte, error) {
+	b, err := json.Marshal(v)
 	if err != nil {
-		warningFailed.Add(1)
-		return err
+		return nil, fmt.Errorf("encoding payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, httpm.POST, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	for k, vv := range header {
+		req.Header[k] = vv
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		warningFailed.Add(1)
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		warningFailed.Add(1)
-		return errors.New(resp.Status)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
 	}
-
-	body, _ := io.ReadAll(resp.Body)
-	if s := strings.TrimSpace(string(body)); s != "ok" {
-		warningFailed.Add(1)
-		return errors.New("non-ok response returned from Slack")
+	if !ok(resp.StatusCode) {
+		return nil, errors.New(resp.Status)
 	}
-	warningGenerated.Add(1)
-	return nil
+	return body, nil
 }
+
+func isStatusOK(code int) bool { return code == http.StatusOK }
+
+func isStatus2xx(code int) bool { return code >= 200 && code <= 299 }
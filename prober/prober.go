@@ -12,6 +12,7 @@
 	"fmt"
 	"hash/fnv"
 	"log"
+	"maps"
 	"math/rand"
 	"sync"
 	"time"
@@ -84,6 +85,7 @@ func (p *Prober) Run(name string, interval time.Duration, labels map[string]stri
 		stopped: make(chan struct{}),
 
 		name:         name,
+		labels:       maps.Clone(labels),
 		doProbe:      fun,
 		interval:     interval,
 		initialDelay: initialDelay(name, interval),
@@ -171,6 +173,7 @@ type Probe struct {
 	stopped chan struct{}      // closed when shutdown is complete
 
 	name         string
+	labels       map[string]string
 	doProbe      ProbeFunc
 	interval     time.Duration
 	initialDelay time.Duration
@@ -192,6 +195,8 @@ type Probe struct {
 	latency   time.Duration // last successful probe latency
 	succeeded bool          // whether the last doProbe call succeeded
 	lastErr   error
+	successes int // number of consecutive successful doProbe calls
+	failures  int // number of consecutive failed doProbe calls
 }
 
 // Close shuts down the Probe and unregisters it from its Prober.
@@ -284,18 +289,28 @@ func (p *Probe) recordEnd(start time.Time, err error) {
 	p.lastErr = err
 	if p.succeeded {
 		p.latency = end.Sub(p.start)
+		p.successes++
+		p.failures = 0
 	} else {
 		p.latency = 0
+		p.successes = 0
+		p.failures++
 	}
 }
 
 // ProbeInfo is the state of a Probe.
 type ProbeInfo struct {
+	Labels  map[string]string
 	Start   time.Time
 	End     time.Time
 	Latency string
 	Result  bool
 	Error   string
+
+	// ConsecutiveSuccesses and ConsecutiveFailures count the most recent
+	// run of identical results. At most one of them is non-zero.
+	ConsecutiveSuccesses int
+	ConsecutiveFailures  int
 }
 
 func (p *Prober) ProbeInfo() map[string]ProbeInfo {
@@ -311,9 +326,12 @@ func (p *Prober) ProbeInfo() map[string]ProbeInfo {
 	for _, probe := range probes {
 		probe.mu.Lock()
 		inf := ProbeInfo{
-			Start:  probe.start,
-			End:    probe.end,
-			Result: probe.succeeded,
+			Labels:               probe.labels,
+			Start:                probe.start,
+			End:                  probe.end,
+			Result:               probe.succeeded,
+			ConsecutiveSuccesses: probe.successes,
+			ConsecutiveFailures:  probe.failures,
 		}
 		if probe.lastErr != nil {
 			inf.Error = probe.lastErr.Error()
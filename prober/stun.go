@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"tailscale.com/net/stun"
+)
+
+// stunRetransmitInterval is how often STUN requests are resent while
+// waiting for a response.
+const stunRetransmitInterval = 500 * time.Millisecond
+
+// STUN returns a Probe that healthchecks a STUN server.
+//
+// The ProbeFunc sends STUN binding requests to addr (host:port) over UDP,
+// and verifies that a successful binding response with the same
+// transaction ID is received before the context expires. Requests are
+// resent periodically, so a single lost packet does not fail the probe.
+func STUN(addr string) ProbeFunc {
+	return func(ctx context.Context) error {
+		return probeSTUN(ctx, addr)
+	}
+}
+
+func probeSTUN(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return fmt.Errorf("dialing %q: %w", addr, err)
+	}
+	defer conn.Close()
+
+	tx := stun.NewTxID()
+	req := stun.Request(tx)
+	// Binding responses are small (~40 bytes), but in practice a STUN
+	// response can be up to the size of the path MTU.
+	buf := make([]byte, 9000)
+	for {
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("sending to %q: %w", addr, err)
+		}
+		deadline := time.Now().Add(stunRetransmitInterval)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("no response from %q: %w", addr, ctx.Err())
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue // retransmit
+			}
+			return fmt.Errorf("reading from %q: %w", addr, err)
+		}
+		txBack, mapped, err := stun.ParseResponse(buf[:n])
+		if err != nil {
+			return fmt.Errorf("parsing STUN response from %q: %w", addr, err)
+		}
+		if txBack != tx {
+			// A response to an earlier request; keep waiting.
+			continue
+		}
+		if !mapped.IsValid() {
+			return fmt.Errorf("STUN response from %q has no mapped address", addr)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"tailscale.com/net/stun/stuntest"
+)
+
+func TestSTUN(t *testing.T) {
+	addr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := STUN(addr.String())(ctx); err != nil {
+		t.Errorf("STUN() = %v, want nil", err)
+	}
+
+	// A UDP listener which never responds must fail once the context expires.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 1200*time.Millisecond)
+	defer cancel()
+	if err := STUN(pc.LocalAddr().String())(ctx); err == nil {
+		t.Error("STUN() to silent server succeeded, want error")
+	}
+}
@@ -35,6 +35,7 @@
 	"golang.org/x/sys/unix"
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/hostinfo"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tempfork/gliderlabs/ssh"
 	"tailscale.com/types/logger"
 	"tailscale.com/version/distro"
@@ -56,6 +57,24 @@ func init() {
 	return nil, nil
 }
 
+// newSessionCgroup creates a cgroup for the processes of the session with
+// the given ID, with the resource limits in l, and returns its path.
+// See newSessionCgroupLinux.
+var newSessionCgroup = func(id string, l *tailcfg.SSHSessionLimits) (path string, err error) {
+	return "", fmt.Errorf("resource limits are not supported on %s", runtime.GOOS)
+}
+
+// removeSessionCgroup kills any processes left in the cgroup at path, and
+// removes it.
+var removeSessionCgroup = func(path string) error {
+	return nil
+}
+
+// joinCgroup moves the current process into the cgroup at path.
+var joinCgroup = func(path string) error {
+	return fmt.Errorf("cgroups are not supported on %s", runtime.GOOS)
+}
+
 // newIncubatorCommand returns a new exec.Cmd configured with
 // `tailscaled be-child ssh` as the entrypoint.
 //
@@ -114,9 +133,17 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 		"--has-tty=false", // updated in-place by startWithPTY
 		"--tty-name=",     // updated in-place by startWithPTY
 	}
+	if ss.cgroup != "" {
+		incubatorArgs = append(incubatorArgs, "--cgroup="+ss.cgroup)
+	}
 
 	if isSFTP {
 		incubatorArgs = append(incubatorArgs, "--sftp")
+		if ss.conn.finalAction.SFTP == tailcfg.SSHSFTPReadOnly {
+			// The session's sftpFilter denies writes, but the SFTP
+			// server also enforces it, should anything get past.
+			incubatorArgs = append(incubatorArgs, "--sftp-read-only")
+		}
 	} else {
 		if isShell {
 			incubatorArgs = append(incubatorArgs, "--shell")
@@ -132,6 +159,11 @@ func (ss *sshSession) newIncubatorCommand() (cmd *exec.Cmd) {
 			// See http://github.com/tailscale/tailscale/issues/4908.
 			shouldUseLoginCmd = false
 		}
+		if ss.cgroup != "" {
+			// The login command starts a login session, which moves the
+			// shell out of the session's cgroup.
+			shouldUseLoginCmd = false
+		}
 		if shouldUseLoginCmd {
 			if lp, err := exec.LookPath("login"); err == nil {
 				incubatorArgs = append(incubatorArgs, "--login-cmd="+lp)
@@ -174,9 +206,11 @@ type incubatorArgs struct {
 	hasTTY       bool
 	cmdName      string
 	isSFTP       bool
+	sftpReadOnly bool
 	isShell      bool
 	loginCmdPath string
 	cmdArgs      []string
+	cgroup       string
 }
 
 func parseIncubatorArgs(args []string) (a incubatorArgs) {
@@ -192,7 +226,9 @@ func parseIncubatorArgs(args []string) (a incubatorArgs) {
 	flags.StringVar(&a.cmdName, "cmd", "", "the cmd to launch (ignored in sftp mode)")
 	flags.BoolVar(&a.isShell, "shell", false, "is launching a shell (with no cmds)")
 	flags.BoolVar(&a.isSFTP, "sftp", false, "run sftp server (cmd is ignored)")
+	flags.BoolVar(&a.sftpReadOnly, "sftp-read-only", false, "run the sftp server in read-only mode")
 	flags.StringVar(&a.loginCmdPath, "login-cmd", "", "the path to `login` cmd")
+	flags.StringVar(&a.cgroup, "cgroup", "", "the path of the cgroup to run in, to limit resources")
 	flags.Parse(args)
 	a.cmdArgs = flags.Args()
 	return a
@@ -231,6 +267,14 @@ func beIncubator(args []string) error {
 		}
 	}
 
+	if ia.cgroup != "" {
+		// Join the cgroup before starting any other processes, so that
+		// they are all limited.
+		if err := joinCgroup(ia.cgroup); err != nil {
+			return fmt.Errorf("joining cgroup: %w", err)
+		}
+	}
+
 	euid := os.Geteuid()
 	runningAsRoot := euid == 0
 	if runningAsRoot && ia.loginCmdPath != "" {
@@ -245,9 +289,14 @@ func beIncubator(args []string) error {
 	// We can only do this if we are running as root.
 	// This is best effort to still allow running on machines where
 	// we don't support starting sessions, e.g. darwin.
-	sessionCloser, err := maybeStartLoginSession(logf, ia)
-	if err == nil && sessionCloser != nil {
-		defer sessionCloser()
+	//
+	// Sessions with resource limits don't start login sessions, as
+	// systemd-logind would move them out of their cgroup.
+	if ia.cgroup == "" {
+		sessionCloser, err := maybeStartLoginSession(logf, ia)
+		if err == nil && sessionCloser != nil {
+			defer sessionCloser()
+		}
 	}
 
 	var groupIDs []int
@@ -266,7 +315,11 @@ func beIncubator(args []string) error {
 	if ia.isSFTP {
 		logf("handling sftp")
 
-		server, err := sftp.NewServer(stdRWC{})
+		var opts []sftp.ServerOption
+		if ia.sftpReadOnly {
+			opts = append(opts, sftp.ReadOnly())
+		}
+		server, err := sftp.NewServer(stdRWC{}, opts...)
 		if err != nil {
 			return err
 		}
@@ -296,7 +349,7 @@ func beIncubator(args []string) error {
 			Foreground: true,
 		}
 	}
-	err = cmd.Run()
+	err := cmd.Run()
 	if ee, ok := err.(*exec.ExitError); ok {
 		ps := ee.ProcessState
 		code := ps.ExitCode()
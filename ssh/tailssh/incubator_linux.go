@@ -7,19 +7,30 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/godbus/dbus/v5"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/lazy"
 	"tailscale.com/types/logger"
 )
 
 func init() {
 	ptyName = ptyNameLinux
 	maybeStartLoginSession = maybeStartLoginSessionLinux
+	newSessionCgroup = newSessionCgroupLinux
+	removeSessionCgroup = removeSessionCgroupLinux
+	joinCgroup = joinCgroupLinux
 }
 
 func ptyNameLinux(f *os.File) (string, error) {
@@ -171,3 +182,188 @@ func maybeStartLoginSessionLinux(logf logger.Logf, ia incubatorArgs) (func() err
 	}
 	return nil, nil
 }
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// daemonCgroup is the child of tailscaled's own cgroup into which
+// initSessionCgroups moves tailscaled.
+const daemonCgroup = "tailscaled"
+
+// sessionCgroupControllers are the cgroup controllers which limit the
+// resources of sessions.
+var sessionCgroupControllers = []string{"cpu", "memory", "pids"}
+
+// sessionCgroups is the cgroup in which the cgroups of sessions with
+// resource limits are created, as returned by initSessionCgroups.
+var sessionCgroups lazy.SyncValue[string]
+
+// cgroupCPUPeriod is the period, in microseconds, over which CPU limits
+// are enforced.
+const cgroupCPUPeriod = 100_000
+
+// cgroupLimits returns the contents of the cgroup v2 interface files which
+// limit the processes in a cgroup to l, by file name.
+func cgroupLimits(l *tailcfg.SSHSessionLimits) map[string]string {
+	m := make(map[string]string)
+	if l.CPUMillis > 0 {
+		// The kernel's minimum quota is 1ms.
+		quota := max(l.CPUMillis*cgroupCPUPeriod/1000, 1000)
+		m["cpu.max"] = fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)
+	}
+	if l.MemoryBytes > 0 {
+		m["memory.max"] = strconv.FormatInt(l.MemoryBytes, 10)
+		// Otherwise, memory over the limit is swapped out.
+		m["memory.swap.max"] = "0"
+	}
+	if l.MaxProcesses > 0 {
+		m["pids.max"] = strconv.FormatInt(l.MaxProcesses, 10)
+	}
+	return m
+}
+
+// parseOwnCgroup returns the path, relative to cgroupRoot, of the cgroup v2
+// listed in b, the contents of /proc/self/cgroup.
+func parseOwnCgroup(b []byte) (string, error) {
+	for _, line := range strings.Split(string(b), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("not in a cgroup v2 hierarchy")
+}
+
+// initSessionCgroups prepares tailscaled's own cgroup to hold the cgroups
+// of sessions, and returns its path. The cgroup must be delegated to
+// tailscaled, as with Delegate=yes in its systemd unit: the cgroups above
+// it belong to systemd, and are left alone.
+//
+// As cgroup v2 doesn't allow enabling controllers for the children of
+// cgroups which have processes, the processes in tailscaled's cgroup are
+// first moved into a child cgroup, daemonCgroup. This is only done once.
+func initSessionCgroups() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroupRoot)
+	}
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	own, err := parseOwnCgroup(b)
+	if err != nil {
+		return "", err
+	}
+	if own == "/" {
+		return "", errors.New("tailscaled is in the root cgroup; run it in a cgroup delegated to it, as with Delegate=yes in its systemd unit")
+	}
+	dir := filepath.Join(cgroupRoot, own)
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	var controllers []string
+	for _, c := range sessionCgroupControllers {
+		if slices.Contains(strings.Fields(string(available)), c) {
+			controllers = append(controllers, "+"+c)
+		}
+	}
+	if len(controllers) == 0 {
+		return "", fmt.Errorf("no cgroup controllers are available in %s; is it delegated to tailscaled, as with Delegate=yes in its systemd unit?", own)
+	}
+
+	daemon := filepath.Join(dir, daemonCgroup)
+	if err := os.Mkdir(daemon, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return "", err
+	}
+	// Processes forked while the others are being moved are left
+	// behind, in which case enabling the controllers fails, so retry.
+	for i := 0; ; i++ {
+		procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		if err != nil {
+			return "", err
+		}
+		for _, pid := range strings.Fields(string(procs)) {
+			err := os.WriteFile(filepath.Join(daemon, "cgroup.procs"), []byte(pid), 0644)
+			if err != nil && !errors.Is(err, syscall.ESRCH) {
+				return "", fmt.Errorf("moving tailscaled out of %s: %w", own, err)
+			}
+		}
+		err = os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644)
+		if err == nil {
+			return dir, nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || i == 10 {
+			return "", fmt.Errorf("enabling cgroup controllers: %w", err)
+		}
+	}
+}
+
+// newSessionCgroupLinux is the linux implementation of newSessionCgroup.
+func newSessionCgroupLinux(id string, l *tailcfg.SSHSessionLimits) (string, error) {
+	parent, err := sessionCgroups.GetErr(initSessionCgroups)
+	if err != nil {
+		return "", err
+	}
+	enabled, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+	if err != nil {
+		return "", err
+	}
+	limits := cgroupLimits(l)
+	var files []string
+	for name := range limits {
+		controller, _, _ := strings.Cut(name, ".")
+		if !slices.Contains(strings.Fields(string(enabled)), controller) {
+			return "", fmt.Errorf("cgroup controller %s is not available", controller)
+		}
+		files = append(files, name)
+	}
+	slices.Sort(files)
+
+	dir := filepath.Join(parent, id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	for _, name := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(limits[name]), 0644)
+		if name == "memory.swap.max" && errors.Is(err, fs.ErrNotExist) {
+			// Swap accounting is disabled, so there's no swap to limit.
+			continue
+		}
+		if err != nil {
+			os.Remove(dir)
+			return "", fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return dir, nil
+}
+
+// removeSessionCgroupLinux is the linux implementation of
+// removeSessionCgroup.
+func removeSessionCgroupLinux(dir string) error {
+	// A cgroup can only be removed once its processes have exited, so
+	// retry for a while after killing them.
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = os.Remove(dir); err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		// cgroup.kill needs Linux 5.14 or later. Before that, the
+		// processes are killed one at a time, which races with forks,
+		// hence the retries.
+		if os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644) != nil {
+			procs, _ := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+			for _, f := range strings.Fields(string(procs)) {
+				if pid, err := strconv.Atoi(f); err == nil {
+					syscall.Kill(pid, syscall.SIGKILL)
+				}
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// joinCgroupLinux is the linux implementation of joinCgroup.
+func joinCgroupLinux(dir string) error {
+	return os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tailssh
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	tests := []struct {
+		limits tailcfg.SSHSessionLimits
+		want   map[string]string
+	}{
+		{tailcfg.SSHSessionLimits{}, map[string]string{}},
+		{tailcfg.SSHSessionLimits{CPUMillis: 500}, map[string]string{"cpu.max": "50000 100000"}},
+		{tailcfg.SSHSessionLimits{CPUMillis: 2000}, map[string]string{"cpu.max": "200000 100000"}},
+		{tailcfg.SSHSessionLimits{CPUMillis: 1}, map[string]string{"cpu.max": "1000 100000"}},
+		{
+			tailcfg.SSHSessionLimits{MemoryBytes: 1 << 30, MaxProcesses: 64},
+			map[string]string{"memory.max": "1073741824", "memory.swap.max": "0", "pids.max": "64"},
+		},
+	}
+	for _, tt := range tests {
+		if got := cgroupLimits(&tt.limits); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("cgroupLimits(%+v) = %v, want %v", tt.limits, got, tt.want)
+		}
+	}
+}
+
+func TestParseOwnCgroup(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0::/system.slice/tailscaled.service\n", want: "/system.slice/tailscaled.service"},
+		{in: "1:name=systemd:/init.scope\n0::/init.scope\n", want: "/init.scope"},
+		{in: "0::/\n", want: "/"},
+		{in: "12:memory:/user.slice\n1:name=systemd:/user.slice\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOwnCgroup([]byte(tt.in))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOwnCgroup(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
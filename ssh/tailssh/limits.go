@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstime/mono"
+)
+
+var errTooManySessions = errors.New("too many sessions")
+
+// limits returns the session limits of the final action of c, or nil if
+// sessions are unlimited.
+func (c *conn) limits() *tailcfg.SSHSessionLimits {
+	if c.finalAction == nil {
+		return nil
+	}
+	return c.finalAction.Limits
+}
+
+// remoteUser returns the Tailscale user that connected, or for tagged
+// nodes, their tags, for limiting the sessions of each user.
+func (ci *sshConnInfo) remoteUser() string {
+	if ci.node.IsTagged() {
+		return strings.Join(ci.node.Tags().AsSlice(), ",")
+	}
+	return ci.uprof.LoginName
+}
+
+// userSessionsLocked returns the number of active sessions of the remote
+// user on all connections. srv.mu must be held.
+func (srv *server) userSessionsLocked(remoteUser string) int {
+	n := 0
+	for c := range srv.activeConns {
+		if c.info == nil || c.info.remoteUser() != remoteUser {
+			continue
+		}
+		c.mu.Lock()
+		n += len(c.sessions)
+		c.mu.Unlock()
+	}
+	return n
+}
+
+// hasResourceLimits reports whether l limits the resources of the
+// processes of sessions.
+func hasResourceLimits(l *tailcfg.SSHSessionLimits) bool {
+	return l != nil && (l.CPUMillis > 0 || l.MemoryBytes > 0 || l.MaxProcesses > 0)
+}
+
+// newCgroup creates a cgroup limiting the resources of the session's
+// processes to l, and returns its path. Sessions only join the cgroup when
+// started by the incubator.
+func (ss *sshSession) newCgroup(l *tailcfg.SSHSessionLimits) (string, error) {
+	if ss.conn.srv.tailscaledPath == "" {
+		return "", errors.New("no incubator")
+	}
+	return newSessionCgroup(ss.sharedID, l)
+}
+
+// idleTimer calls a func when a session has been idle, with no input or
+// output, for a timeout.
+type idleTimer struct {
+	timeout time.Duration
+	onIdle  func()
+
+	last mono.Time // of last activity, accessed atomically
+
+	mu sync.Mutex  // guards t
+	t  *time.Timer // nil once stopped
+}
+
+// newIdleTimer returns a started idleTimer which calls onIdle once there
+// has been no activity for timeout.
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	it := &idleTimer{timeout: timeout, onIdle: onIdle}
+	it.last.StoreAtomic(mono.Now())
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	it.t = time.AfterFunc(timeout, it.check)
+	return it
+}
+
+// check calls onIdle if the timeout has elapsed since the last activity,
+// and otherwise waits for the rest of it. Rather than resetting the timer
+// on every read and write, activity is noted and checked here.
+func (it *idleTimer) check() {
+	idle := mono.Since(it.last.LoadAtomic())
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.t == nil {
+		return // stopped
+	}
+	if idle < it.timeout {
+		it.t.Reset(it.timeout - idle)
+		return
+	}
+	it.t = nil
+	go it.onIdle()
+}
+
+// stop stops the timer.
+func (it *idleTimer) stop() {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	if it.t != nil {
+		it.t.Stop()
+		it.t = nil
+	}
+}
+
+// writer returns a writer around w which notes activity on each write.
+func (it *idleTimer) writer(w io.Writer) io.Writer {
+	return idleWriter{it, w}
+}
+
+type idleWriter struct {
+	it *idleTimer
+	w  io.Writer
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	w.it.last.StoreAtomic(mono.Now())
+	return w.w.Write(p)
+}
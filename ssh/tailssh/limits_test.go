@@ -0,0 +1,90 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+)
+
+func TestIdleTimer(t *testing.T) {
+	idle := make(chan bool, 1)
+	it := newIdleTimer(200*time.Millisecond, func() { idle <- true })
+	defer it.stop()
+
+	// Activity keeps the session alive past the timeout.
+	w := it.writer(io.Discard)
+	for i := 0; i < 15; i++ {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("x"))
+	}
+	select {
+	case <-idle:
+		t.Fatal("idle despite activity")
+	default:
+	}
+
+	select {
+	case <-idle:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not idle after timeout")
+	}
+}
+
+func TestIdleTimerStop(t *testing.T) {
+	idle := make(chan bool, 1)
+	it := newIdleTimer(10*time.Millisecond, func() { idle <- true })
+	it.stop()
+	select {
+	case <-idle:
+		t.Fatal("idle after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	srv := &server{}
+	newConn := func(login string, tags []string, sessions int) {
+		node := &tailcfg.Node{Tags: tags}
+		c := &conn{
+			srv: srv,
+			info: &sshConnInfo{
+				node:  node.View(),
+				uprof: tailcfg.UserProfile{LoginName: login},
+			},
+		}
+		for i := 0; i < sessions; i++ {
+			c.sessions = append(c.sessions, &sshSession{})
+		}
+		mak.Set(&srv.activeConns, c, true)
+	}
+	newConn("alice@example.com", nil, 2)
+	newConn("alice@example.com", nil, 1)
+	newConn("bob@example.com", nil, 1)
+	newConn("tagged-devices", []string{"tag:ci", "tag:prod"}, 3)
+
+	tests := []struct {
+		user string
+		want int
+	}{
+		{"alice@example.com", 3},
+		{"bob@example.com", 1},
+		{"tag:ci,tag:prod", 3},
+		{"tagged-devices", 0},
+		{"carol@example.com", 0},
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for _, tt := range tests {
+		if got := srv.userSessionsLocked(tt.user); got != tt.want {
+			t.Errorf("userSessionsLocked(%q) = %d, want %d", tt.user, got, tt.want)
+		}
+	}
+}
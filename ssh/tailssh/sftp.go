@@ -0,0 +1,491 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || (darwin && !ios) || freebsd || openbsd
+
+package tailssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"tailscale.com/tailcfg"
+)
+
+// SFTP packet types, from draft-ietf-secsh-filexfer-02 (version 3 of the
+// protocol, which is the version OpenSSH and github.com/pkg/sftp speak).
+const (
+	sftpInit     = 1
+	sftpVersion  = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpLstat    = 7
+	sftpFstat    = 8
+	sftpSetstat  = 9
+	sftpFsetstat = 10
+	sftpOpendir  = 11
+	sftpReaddir  = 12
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpRmdir    = 15
+	sftpRealpath = 16
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpReadlink = 19
+	sftpSymlink  = 20
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpExtended = 200
+)
+
+// SFTP open flags.
+const (
+	sftpFlagRead   = 0x01
+	sftpFlagWrite  = 0x02
+	sftpFlagAppend = 0x04
+	sftpFlagCreat  = 0x08
+	sftpFlagTrunc  = 0x10
+	sftpFlagExcl   = 0x20
+)
+
+// SFTP status codes.
+const (
+	sftpStatusOK               = 0
+	sftpStatusPermissionDenied = 3
+)
+
+// maxSFTPPacket is the largest SFTP packet the filter accepts. Clients and
+// servers limit packets to 256KiB, so this leaves plenty of room.
+const maxSFTPPacket = 1 << 20
+
+// sftpReadOnlyExtensions are the extended requests which are allowed in
+// read-only SFTP sessions.
+var sftpReadOnlyExtensions = map[string]bool{
+	"statvfs@openssh.com":  true,
+	"fstatvfs@openssh.com": true,
+}
+
+// SFTPEvent is an audit event for a file operation in an SFTP session. It
+// is written to the session recording as the data of an event of type
+// "f", JSON-encoded.
+type SFTPEvent struct {
+	// Op is the operation: "open", "close", "remove", "rename", "link",
+	// "symlink", "mkdir", "rmdir" or "setstat".
+	Op string `json:"op"`
+
+	// Path is the path operated on, as sent by the client. Relative paths
+	// are relative to the local user's home directory.
+	Path string `json:"path"`
+
+	// NewPath is, for "rename", the new path, and for "link" and
+	// "symlink", the path of the new link.
+	NewPath string `json:"newPath,omitempty"`
+
+	// Flags are, for "open", the flags the file was opened with, such as
+	// "read" or "write,create,truncate".
+	Flags string `json:"flags,omitempty"`
+
+	// BytesRead and BytesWritten are, for "close", the number of bytes
+	// read from and written to the file while it was open.
+	BytesRead    int64 `json:"bytesRead,omitempty"`
+	BytesWritten int64 `json:"bytesWritten,omitempty"`
+
+	// Denied is whether the operation was denied by the SFTP policy of
+	// the session.
+	Denied bool `json:"denied,omitempty"`
+
+	// Error is the error the operation failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// sftpFilter sits between an SSH channel and an SFTP server, parsing the
+// packets in each direction. It denies requests which modify the
+// filesystem if the session is read-only, and reports the file operations
+// of the session to record.
+type sftpFilter struct {
+	readOnly bool
+	record   func(SFTPEvent) error // or nil to not record events
+
+	mu       sync.Mutex // guards the following, and writes to toClient
+	toClient io.Writer
+	pending  map[uint32]*sftpRequest // requests awaiting responses, by ID
+	files    map[string]*sftpFile    // open files, by handle
+}
+
+// sftpRequest is a request awaiting a response from the SFTP server.
+type sftpRequest struct {
+	ev     *SFTPEvent // event to record once the result is known, or nil
+	handle string     // for reads, writes and closes, the file handle
+	n      int64      // for writes, the number of bytes written
+	typ    byte
+}
+
+// sftpFile is a file opened by the SFTP client.
+type sftpFile struct {
+	path         string
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// newSFTPFilter returns a filter which applies policy to the SFTP session
+// with the client on toClient. If record is non-nil, it is called with
+// each file operation.
+func newSFTPFilter(policy tailcfg.SSHSFTPPolicy, toClient io.Writer, record func(SFTPEvent) error) *sftpFilter {
+	return &sftpFilter{
+		readOnly: policy == tailcfg.SSHSFTPReadOnly,
+		record:   record,
+		toClient: toClient,
+		pending:  make(map[uint32]*sftpRequest),
+		files:    make(map[string]*sftpFile),
+	}
+}
+
+// fromClient returns a writer for the packets from the client, which
+// forwards the allowed packets to server.
+func (f *sftpFilter) fromClient(server io.Writer) io.Writer {
+	return &sftpPacketWriter{handle: func(p []byte) error {
+		return f.handleRequest(p, server)
+	}}
+}
+
+// fromServer returns a writer for the packets from the server, which
+// forwards them to the client.
+func (f *sftpFilter) fromServer() io.Writer {
+	return &sftpPacketWriter{handle: f.handleResponse}
+}
+
+func (f *sftpFilter) handleRequest(p []byte, server io.Writer) error {
+	// The lock is not held while writing to the server, which may be
+	// blocked writing a response.
+	f.mu.Lock()
+	forward, err := f.filterRequestLocked(p)
+	f.mu.Unlock()
+	if !forward || err != nil {
+		return err
+	}
+	_, err = server.Write(p)
+	return err
+}
+
+// filterRequestLocked applies the policy to the request p, and notes it
+// to observe its response. It reports whether to forward it to the server.
+func (f *sftpFilter) filterRequestLocked(p []byte) (forward bool, err error) {
+	typ := p[4]
+	if typ == sftpInit {
+		return true, nil
+	}
+	b := sftpBuf(p[5:])
+	id, ok := b.uint32()
+	if !ok {
+		return false, errors.New("sftp: short request")
+	}
+
+	req := &sftpRequest{typ: typ}
+	denied := false
+	switch typ {
+	case sftpOpen:
+		name, _ := b.string()
+		flags, _ := b.uint32()
+		req.ev = &SFTPEvent{Op: "open", Path: name, Flags: sftpFlagNames(flags)}
+		denied = f.readOnly && flags&^sftpFlagRead != 0
+	case sftpRead:
+		req.handle, _ = b.string()
+	case sftpWrite:
+		req.handle, _ = b.string()
+		b.uint64() // offset
+		data, _ := b.string()
+		req.n = int64(len(data))
+		denied = f.readOnly
+	case sftpClose:
+		req.handle, _ = b.string()
+		if fi, ok := f.files[req.handle]; ok {
+			req.ev = &SFTPEvent{Op: "close", Path: fi.path}
+		}
+	case sftpRemove, sftpMkdir, sftpRmdir, sftpSetstat:
+		name, _ := b.string()
+		req.ev = &SFTPEvent{Op: sftpOpNames[typ], Path: name}
+		denied = f.readOnly
+	case sftpFsetstat:
+		req.handle, _ = b.string()
+		if fi, ok := f.files[req.handle]; ok {
+			req.ev = &SFTPEvent{Op: "setstat", Path: fi.path}
+		}
+		denied = f.readOnly
+	case sftpRename:
+		oldPath, _ := b.string()
+		newPath, _ := b.string()
+		req.ev = &SFTPEvent{Op: "rename", Path: oldPath, NewPath: newPath}
+		denied = f.readOnly
+	case sftpSymlink:
+		// OpenSSH, and so everyone else, sends the target before the
+		// link path, contrary to the draft.
+		target, _ := b.string()
+		link, _ := b.string()
+		req.ev = &SFTPEvent{Op: "symlink", Path: target, NewPath: link}
+		denied = f.readOnly
+	case sftpExtended:
+		name, _ := b.string()
+		switch name {
+		case "posix-rename@openssh.com", "hardlink@openssh.com":
+			oldPath, _ := b.string()
+			newPath, _ := b.string()
+			op := "rename"
+			if name == "hardlink@openssh.com" {
+				op = "link"
+			}
+			req.ev = &SFTPEvent{Op: op, Path: oldPath, NewPath: newPath}
+		}
+		denied = f.readOnly && !sftpReadOnlyExtensions[name]
+	case sftpFstat, sftpLstat, sftpStat, sftpOpendir, sftpReaddir, sftpRealpath, sftpReadlink:
+		// Read-only requests, which are not recorded.
+	default:
+		// Unknown requests are passed to the server, which rejects
+		// them, unless the session is read-only.
+		denied = f.readOnly
+	}
+
+	if denied {
+		metricSFTPDenied.Add(1)
+		if req.ev != nil {
+			req.ev.Denied = true
+			if err := f.recordLocked(*req.ev); err != nil {
+				return false, err
+			}
+		}
+		return false, f.writeToClientLocked(sftpStatusPacket(id, sftpStatusPermissionDenied, "denied by Tailscale SSH policy"))
+	}
+	if req.ev != nil || req.handle != "" {
+		f.pending[id] = req
+	}
+	return true, nil
+}
+
+func (f *sftpFilter) handleResponse(p []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.observeResponseLocked(p); err != nil {
+		return err
+	}
+	return f.writeToClientLocked(p)
+}
+
+// observeResponseLocked updates the state of the filter with the response
+// p, recording the event for the request it is a response to.
+func (f *sftpFilter) observeResponseLocked(p []byte) error {
+	typ := p[4]
+	if typ == sftpVersion {
+		return nil
+	}
+	b := sftpBuf(p[5:])
+	id, ok := b.uint32()
+	if !ok {
+		return nil
+	}
+	req, ok := f.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(f.pending, id)
+
+	var errMsg string
+	switch typ {
+	case sftpStatus:
+		code, _ := b.uint32()
+		if code != sftpStatusOK {
+			msg, _ := b.string()
+			if msg == "" {
+				msg = fmt.Sprintf("status %d", code)
+			}
+			errMsg = msg
+		}
+	case sftpHandle:
+		if req.typ == sftpOpen {
+			h, _ := b.string()
+			f.files[h] = &sftpFile{path: req.ev.Path}
+		}
+	case sftpData:
+		if fi, ok := f.files[req.handle]; ok {
+			data, _ := b.string()
+			fi.bytesRead += int64(len(data))
+		}
+	}
+
+	switch req.typ {
+	case sftpWrite:
+		if fi, ok := f.files[req.handle]; ok && errMsg == "" {
+			fi.bytesWritten += req.n
+		}
+	case sftpClose:
+		if fi, ok := f.files[req.handle]; ok {
+			req.ev.BytesRead = fi.bytesRead
+			req.ev.BytesWritten = fi.bytesWritten
+			delete(f.files, req.handle)
+		}
+	}
+	if req.ev == nil {
+		return nil
+	}
+	req.ev.Error = errMsg
+	return f.recordLocked(*req.ev)
+}
+
+// close records the files which were not closed by the client, as closed
+// when the session ended.
+func (f *sftpFilter) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for h, fi := range f.files {
+		errs = append(errs, f.recordLocked(SFTPEvent{
+			Op:           "close",
+			Path:         fi.path,
+			BytesRead:    fi.bytesRead,
+			BytesWritten: fi.bytesWritten,
+			Error:        "session ended",
+		}))
+		delete(f.files, h)
+	}
+	return errors.Join(errs...)
+}
+
+func (f *sftpFilter) recordLocked(ev SFTPEvent) error {
+	if f.record == nil {
+		return nil
+	}
+	return f.record(ev)
+}
+
+func (f *sftpFilter) writeToClientLocked(p []byte) error {
+	_, err := f.toClient.Write(p)
+	return err
+}
+
+var sftpOpNames = map[byte]string{
+	sftpRemove:  "remove",
+	sftpMkdir:   "mkdir",
+	sftpRmdir:   "rmdir",
+	sftpSetstat: "setstat",
+}
+
+// sftpFlagNames returns the names of the SFTP open flags in flags.
+func sftpFlagNames(flags uint32) string {
+	var names []string
+	for _, fl := range []struct {
+		flag uint32
+		name string
+	}{
+		{sftpFlagRead, "read"},
+		{sftpFlagWrite, "write"},
+		{sftpFlagAppend, "append"},
+		{sftpFlagCreat, "create"},
+		{sftpFlagTrunc, "truncate"},
+		{sftpFlagExcl, "excl"},
+	} {
+		if flags&fl.flag != 0 {
+			names = append(names, fl.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// sftpStatusPacket returns an SSH_FXP_STATUS packet.
+func sftpStatusPacket(id, code uint32, msg string) []byte {
+	p := make([]byte, 0, 4+1+4+4+4+len(msg)+4)
+	p = binary.BigEndian.AppendUint32(p, uint32(1+4+4+4+len(msg)+4))
+	p = append(p, sftpStatus)
+	p = binary.BigEndian.AppendUint32(p, id)
+	p = binary.BigEndian.AppendUint32(p, code)
+	p = binary.BigEndian.AppendUint32(p, uint32(len(msg)))
+	p = append(p, msg...)
+	p = binary.BigEndian.AppendUint32(p, 0) // language tag
+	return p
+}
+
+// sftpPacketWriter is an io.Writer which splits the stream written to it
+// into SFTP packets, calling handle with each whole packet, including its
+// length prefix.
+type sftpPacketWriter struct {
+	handle func(p []byte) error
+	buf    []byte
+}
+
+func (w *sftpPacketWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= 4 {
+		n := binary.BigEndian.Uint32(w.buf)
+		if n < 1 || n > maxSFTPPacket {
+			return 0, fmt.Errorf("sftp: invalid packet length %d", n)
+		}
+		if len(w.buf) < 4+int(n) {
+			break
+		}
+		if err := w.handle(w.buf[:4+n]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[4+n:]
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil // release large packets
+	}
+	return len(p), nil
+}
+
+// sftpBuf is the remainder of an SFTP packet being parsed.
+type sftpBuf []byte
+
+func (b *sftpBuf) uint32() (uint32, bool) {
+	if len(*b) < 4 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v, true
+}
+
+func (b *sftpBuf) uint64() (uint64, bool) {
+	if len(*b) < 8 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint64(*b)
+	*b = (*b)[8:]
+	return v, true
+}
+
+func (b *sftpBuf) string() (string, bool) {
+	n, ok := b.uint32()
+	if !ok || uint32(len(*b)) < n {
+		return "", false
+	}
+	s := string((*b)[:n])
+	*b = (*b)[n:]
+	return s, true
+}
+
+// isSCPCommand reports whether cmd runs scp in the server mode of the
+// legacy SCP protocol, and if so, whether it receives files (with -t)
+// rather than sending them (with -f).
+func isSCPCommand(cmd []string) (isSCP, sink bool) {
+	if len(cmd) == 0 || path.Base(cmd[0]) != "scp" {
+		return false, false
+	}
+	for _, arg := range cmd[1:] {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		if strings.Contains(arg, "t") {
+			return true, true
+		}
+		if strings.Contains(arg, "f") {
+			isSCP = true
+		}
+	}
+	return isSCP, false
+}
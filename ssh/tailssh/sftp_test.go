@@ -0,0 +1,227 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux || darwin
+
+package tailssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+// sftpPacket returns an SFTP packet of type typ, with the given fields,
+// which are encoded as SFTP strings, uint32s and uint64s.
+func sftpPacket(typ byte, fields ...any) []byte {
+	body := []byte{typ}
+	for _, f := range fields {
+		switch f := f.(type) {
+		case string:
+			body = binary.BigEndian.AppendUint32(body, uint32(len(f)))
+			body = append(body, f...)
+		case int:
+			body = binary.BigEndian.AppendUint32(body, uint32(f))
+		case uint64:
+			body = binary.BigEndian.AppendUint64(body, f)
+		default:
+			panic(fmt.Sprintf("unknown field type %T", f))
+		}
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+// testSFTPFilter is an sftpFilter between a fake client and server.
+type testSFTPFilter struct {
+	t        *testing.T
+	f        *sftpFilter
+	toClient bytes.Buffer // packets sent to the client
+	toServer bytes.Buffer // packets forwarded to the server
+	events   []SFTPEvent
+}
+
+func newTestSFTPFilter(t *testing.T, policy tailcfg.SSHSFTPPolicy) *testSFTPFilter {
+	tf := &testSFTPFilter{t: t}
+	tf.f = newSFTPFilter(policy, &tf.toClient, func(ev SFTPEvent) error {
+		tf.events = append(tf.events, ev)
+		return nil
+	})
+	return tf
+}
+
+// request sends the request p from the client, and reports whether it was
+// forwarded to the server. Requests are written a byte at a time, to check
+// that they are reassembled.
+func (tf *testSFTPFilter) request(p []byte) (forwarded bool) {
+	tf.t.Helper()
+	tf.toServer.Reset()
+	w := tf.f.fromClient(&tf.toServer)
+	for i := range p {
+		if _, err := w.Write(p[i : i+1]); err != nil {
+			tf.t.Fatalf("request: %v", err)
+		}
+	}
+	if tf.toServer.Len() > 0 && !bytes.Equal(tf.toServer.Bytes(), p) {
+		tf.t.Fatalf("forwarded %x, want %x", tf.toServer.Bytes(), p)
+	}
+	return tf.toServer.Len() > 0
+}
+
+// respond sends the response p from the server, checking that it's
+// forwarded to the client.
+func (tf *testSFTPFilter) respond(p []byte) {
+	tf.t.Helper()
+	tf.toClient.Reset()
+	if _, err := tf.f.fromServer().Write(p); err != nil {
+		tf.t.Fatalf("respond: %v", err)
+	}
+	if !bytes.Equal(tf.toClient.Bytes(), p) {
+		tf.t.Fatalf("sent client %x, want %x", tf.toClient.Bytes(), p)
+	}
+}
+
+// call sends the request p, which must be allowed, and the response res.
+func (tf *testSFTPFilter) call(p, res []byte) {
+	tf.t.Helper()
+	if !tf.request(p) {
+		tf.t.Fatalf("request %x not forwarded", p)
+	}
+	tf.respond(res)
+}
+
+// denied checks that the request p, with ID id, is denied.
+func (tf *testSFTPFilter) denied(id int, p []byte) {
+	tf.t.Helper()
+	tf.toClient.Reset()
+	if tf.request(p) {
+		tf.t.Fatalf("request %x forwarded, want denied", p)
+	}
+	if want := sftpStatusPacket(uint32(id), sftpStatusPermissionDenied, "denied by Tailscale SSH policy"); !bytes.Equal(tf.toClient.Bytes(), want) {
+		tf.t.Fatalf("sent client %x, want permission denied %x", tf.toClient.Bytes(), want)
+	}
+}
+
+func (tf *testSFTPFilter) checkEvents(want ...SFTPEvent) {
+	tf.t.Helper()
+	if !reflect.DeepEqual(tf.events, want) {
+		tf.t.Errorf("events:\n got %+v\nwant %+v", tf.events, want)
+	}
+	tf.events = nil
+}
+
+func statusOK(id int) []byte { return sftpPacket(sftpStatus, id, sftpStatusOK, "", "") }
+
+func TestSFTPFilter(t *testing.T) {
+	tf := newTestSFTPFilter(t, "")
+	tf.call(sftpPacket(sftpInit, 3), sftpPacket(sftpVersion, 3))
+	tf.call(sftpPacket(sftpStat, 1, "."), sftpPacket(sftpStatus, 1, 2, "no such file", ""))
+	tf.checkEvents()
+
+	// Writes are counted, when they succeed.
+	tf.call(sftpPacket(sftpOpen, 2, "up.txt", sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc, 0), sftpPacket(sftpHandle, 2, "h1"))
+	tf.call(sftpPacket(sftpWrite, 3, "h1", uint64(0), "hello"), statusOK(3))
+	tf.call(sftpPacket(sftpWrite, 4, "h1", uint64(5), "abc"), statusOK(4))
+	tf.call(sftpPacket(sftpWrite, 5, "h1", uint64(8), "fail"), sftpPacket(sftpStatus, 5, 4, "disk full", ""))
+	tf.call(sftpPacket(sftpClose, 6, "h1"), statusOK(6))
+	tf.checkEvents(
+		SFTPEvent{Op: "open", Path: "up.txt", Flags: "write,create,truncate"},
+		SFTPEvent{Op: "close", Path: "up.txt", BytesWritten: 8},
+	)
+
+	// Responses may arrive out of order.
+	if !tf.request(sftpPacket(sftpRename, 7, "up.txt", "done.txt")) || !tf.request(sftpPacket(sftpRemove, 8, "gone.txt")) {
+		t.Fatal("requests not forwarded")
+	}
+	tf.respond(sftpPacket(sftpStatus, 8, 2, "", ""))
+	tf.respond(statusOK(7))
+	tf.call(sftpPacket(sftpExtended, 9, "hardlink@openssh.com", "done.txt", "link.txt"), statusOK(9))
+	tf.call(sftpPacket(sftpMkdir, 10, "dir", 0), statusOK(10))
+	tf.checkEvents(
+		SFTPEvent{Op: "remove", Path: "gone.txt", Error: "status 2"},
+		SFTPEvent{Op: "rename", Path: "up.txt", NewPath: "done.txt"},
+		SFTPEvent{Op: "link", Path: "done.txt", NewPath: "link.txt"},
+		SFTPEvent{Op: "mkdir", Path: "dir"},
+	)
+
+	// Files left open are closed at the end of the session.
+	tf.call(sftpPacket(sftpOpen, 11, "down.txt", sftpFlagRead, 0), sftpPacket(sftpHandle, 11, "h2"))
+	tf.call(sftpPacket(sftpRead, 12, "h2", uint64(0), 1024), sftpPacket(sftpData, 12, "0123456789"))
+	if err := tf.f.close(); err != nil {
+		t.Fatal(err)
+	}
+	tf.checkEvents(
+		SFTPEvent{Op: "open", Path: "down.txt", Flags: "read"},
+		SFTPEvent{Op: "close", Path: "down.txt", BytesRead: 10, Error: "session ended"},
+	)
+}
+
+func TestSFTPFilterReadOnly(t *testing.T) {
+	tf := newTestSFTPFilter(t, tailcfg.SSHSFTPReadOnly)
+	tf.call(sftpPacket(sftpInit, 3), sftpPacket(sftpVersion, 3))
+
+	tf.denied(1, sftpPacket(sftpOpen, 1, "up.txt", sftpFlagWrite|sftpFlagCreat, 0))
+	tf.denied(2, sftpPacket(sftpOpen, 2, "log.txt", sftpFlagRead|sftpFlagAppend, 0))
+	tf.denied(3, sftpPacket(sftpRemove, 3, "x"))
+	tf.denied(4, sftpPacket(sftpRename, 4, "x", "y"))
+	tf.denied(5, sftpPacket(sftpExtended, 5, "posix-rename@openssh.com", "x", "y"))
+	tf.denied(6, sftpPacket(sftpSetstat, 6, "x", 0))
+	tf.denied(7, sftpPacket(sftpSymlink, 7, "target", "link"))
+	tf.denied(8, sftpPacket(99, 8))
+	tf.checkEvents(
+		SFTPEvent{Op: "open", Path: "up.txt", Flags: "write,create", Denied: true},
+		SFTPEvent{Op: "open", Path: "log.txt", Flags: "read,append", Denied: true},
+		SFTPEvent{Op: "remove", Path: "x", Denied: true},
+		SFTPEvent{Op: "rename", Path: "x", NewPath: "y", Denied: true},
+		SFTPEvent{Op: "rename", Path: "x", NewPath: "y", Denied: true},
+		SFTPEvent{Op: "setstat", Path: "x", Denied: true},
+		SFTPEvent{Op: "symlink", Path: "target", NewPath: "link", Denied: true},
+	)
+
+	// Reads are allowed.
+	tf.call(sftpPacket(sftpOpendir, 9, "."), sftpPacket(sftpHandle, 9, "d1"))
+	tf.call(sftpPacket(sftpReaddir, 10, "d1"), sftpPacket(sftpStatus, 10, 1, "EOF", ""))
+	tf.call(sftpPacket(sftpExtended, 11, "statvfs@openssh.com", "."), statusOK(11))
+	tf.call(sftpPacket(sftpOpen, 12, "down.txt", sftpFlagRead, 0), sftpPacket(sftpHandle, 12, "h1"))
+	tf.call(sftpPacket(sftpRead, 13, "h1", uint64(0), 4), sftpPacket(sftpData, 13, "abcd"))
+	tf.call(sftpPacket(sftpRead, 14, "h1", uint64(4), 4), sftpPacket(sftpData, 14, "ef"))
+	tf.denied(15, sftpPacket(sftpWrite, 15, "h1", uint64(0), "x"))
+	tf.denied(16, sftpPacket(sftpFsetstat, 16, "h1", 0))
+	tf.call(sftpPacket(sftpClose, 17, "h1"), statusOK(17))
+	tf.checkEvents(
+		SFTPEvent{Op: "open", Path: "down.txt", Flags: "read"},
+		SFTPEvent{Op: "setstat", Path: "down.txt", Denied: true},
+		SFTPEvent{Op: "close", Path: "down.txt", BytesRead: 6},
+	)
+}
+
+func TestSFTPPacketTooLong(t *testing.T) {
+	tf := newTestSFTPFilter(t, "")
+	if _, err := tf.f.fromClient(&tf.toServer).Write([]byte{0xff, 0xff, 0xff, 0xff, sftpInit}); err == nil {
+		t.Error("oversized packet accepted")
+	}
+}
+
+func TestIsSCPCommand(t *testing.T) {
+	tests := []struct {
+		cmd         []string
+		isSCP, sink bool
+	}{
+		{[]string{"scp", "-t", "/tmp"}, true, true},
+		{[]string{"scp", "-v", "-r", "-d", "-t", "--", "/tmp"}, true, true},
+		{[]string{"/usr/bin/scp", "-f", "file"}, true, false},
+		{[]string{"scp", "-pf", "file"}, true, false},
+		{[]string{"scp", "file", "host:"}, false, false},
+		{[]string{"ls", "-t"}, false, false},
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		isSCP, sink := isSCPCommand(tt.cmd)
+		if isSCP != tt.isSCP || sink != tt.sink {
+			t.Errorf("isSCPCommand(%q) = %v, %v; want %v, %v", tt.cmd, isSCP, sink, tt.isSCP, tt.sink)
+		}
+	}
+}
@@ -121,16 +121,26 @@ func init() {
 // attachSessionToConnIfNotShutdown ensures that srv is not shutdown before
 // attaching the session to the conn. This ensures that once Shutdown is called,
 // new sessions are not allowed and existing ones are cleaned up.
-// It reports whether ss was attached to the conn.
-func (srv *server) attachSessionToConnIfNotShutdown(ss *sshSession) bool {
+// It also enforces the per-user session limit of the conn.
+// It returns a userVisibleError if ss was not attached to the conn.
+func (srv *server) attachSessionToConnIfNotShutdown(ss *sshSession) error {
 	srv.mu.Lock()
 	defer srv.mu.Unlock()
 	if srv.shutdownCalled {
 		// Do not start any new sessions.
-		return false
+		return userVisibleError{"Tailscale SSH is shutting down", errors.New("shutting down")}
+	}
+	if l := ss.conn.limits(); l != nil && l.MaxSessionsPerUser > 0 {
+		if n := srv.userSessionsLocked(ss.conn.info.remoteUser()); n >= l.MaxSessionsPerUser {
+			metricSessionLimitRejects.Add(1)
+			return userVisibleError{
+				fmt.Sprintf("Too many sessions: the limit is %d.", l.MaxSessionsPerUser),
+				errTooManySessions,
+			}
+		}
 	}
 	ss.conn.attachSession(ss)
-	return true
+	return nil
 }
 
 func (srv *server) trackActiveConn(c *conn, add bool) {
@@ -849,6 +859,10 @@ type sshSession struct {
 	rdStderr io.ReadCloser // rdStderr is nil for pty sessions
 	ptyReq   *ssh.Pty      // non-nil for pty sessions
 
+	// cgroup is the path of the cgroup limiting the resources of the
+	// session's processes, or empty if they are not limited.
+	cgroup string
+
 	// childPipes is a list of pipes that need to be closed when the process exits.
 	// For pty sessions, this is the tty fd.
 	// For non-pty sessions, this is the stdin, stdout, stderr fds.
@@ -1058,8 +1072,9 @@ func (ss *sshSession) run() {
 	defer metricActiveSessions.Add(-1)
 	defer ss.cancelCtx(errSessionDone)
 
-	if attached := ss.conn.srv.attachSessionToConnIfNotShutdown(ss); !attached {
-		fmt.Fprintf(ss, "Tailscale SSH is shutting down\r\n")
+	if err := ss.conn.srv.attachSessionToConnIfNotShutdown(ss); err != nil {
+		ss.logf("not starting session: %v", err)
+		fmt.Fprintf(ss, "%s\r\n", err.(userVisibleError).SSHTerminationMessage())
 		ss.Exit(1)
 		return
 	}
@@ -1077,6 +1092,24 @@ func (ss *sshSession) run() {
 		})
 		defer t.Stop()
 	}
+	var idle *idleTimer // or nil if there is no idle timeout
+	if l := ss.conn.limits(); l != nil && l.IdleTimeout > 0 {
+		idle = newIdleTimer(l.IdleTimeout, func() {
+			ss.cancelCtx(userVisibleError{
+				fmt.Sprintf("Session idle timeout of %v elapsed.", l.IdleTimeout),
+				context.DeadlineExceeded,
+			})
+		})
+		defer idle.stop()
+	}
+
+	if err := ss.checkSFTPPolicy(); err != nil {
+		metricSFTPDenied.Add(1)
+		ss.logf("%v", err)
+		fmt.Fprintf(ss.Stderr(), "%v\r\n", err)
+		ss.Exit(1)
+		return
+	}
 
 	if euid := os.Geteuid(); euid != 0 {
 		if lu.Uid != fmt.Sprint(euid) {
@@ -1091,7 +1124,6 @@ func (ss *sshSession) run() {
 	// See https://github.com/tailscale/tailscale/issues/4146
 	ss.DisablePTYEmulation()
 
-	var rec *recording // or nil if disabled
 	if ss.Subsystem() != "sftp" {
 		if err := ss.handleSSHAgentForwarding(ss, lu); err != nil {
 			ss.logf("agent forwarding failed: %v", err)
@@ -1099,28 +1131,55 @@ func (ss *sshSession) run() {
 			// TODO(maisem/bradfitz): add a way to close all session resources
 			defer ss.agentListener.Close()
 		}
+	}
 
-		if ss.shouldRecord() {
-			var err error
-			rec, err = ss.startNewRecording()
-			if err != nil {
-				var uve userVisibleError
-				if errors.As(err, &uve) {
-					fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
-				} else {
-					fmt.Fprintf(ss, "can't start new recording\r\n")
-				}
-				ss.logf("startNewRecording: %v", err)
-				ss.Exit(1)
-				return
-			}
-			ss.logf("startNewRecording: <nil>")
-			if rec != nil {
-				defer rec.Close()
+	var rec *recording // or nil if disabled
+	if ss.shouldRecord() {
+		var err error
+		rec, err = ss.startNewRecording()
+		if err != nil {
+			var uve userVisibleError
+			if errors.As(err, &uve) {
+				fmt.Fprintf(ss, "%s\r\n", uve.SSHTerminationMessage())
+			} else {
+				fmt.Fprintf(ss, "can't start new recording\r\n")
 			}
+			ss.logf("startNewRecording: %v", err)
+			ss.Exit(1)
+			return
+		}
+		ss.logf("startNewRecording: <nil>")
+		if rec != nil {
+			defer rec.Close()
 		}
 	}
 
+	// SFTP sessions are recorded as file operations, rather than as
+	// their output.
+	var sftpf *sftpFilter // or nil if not an SFTP session
+	if ss.Subsystem() == "sftp" {
+		sftpf = newSFTPFilter(ss.conn.finalAction.SFTP, ss, rec.sftpRecorder())
+	}
+
+	if l := ss.conn.limits(); hasResourceLimits(l) {
+		// Sessions whose resources can't be limited are rejected, rather
+		// than run unlimited.
+		cg, err := ss.newCgroup(l)
+		if err != nil {
+			metricSessionLimitRejects.Add(1)
+			ss.logf("can't limit session resources: %v", err)
+			fmt.Fprintf(ss.Stderr(), "Session resource limits can't be enforced on this node.\r\n")
+			ss.Exit(1)
+			return
+		}
+		ss.cgroup = cg
+		defer func() {
+			if err := removeSessionCgroup(cg); err != nil {
+				logf("removing session cgroup: %v", err)
+			}
+		}()
+	}
+
 	err := ss.launchProcess()
 	if err != nil {
 		logf("start failed: %v", err.Error())
@@ -1137,9 +1196,16 @@ func (ss *sshSession) run() {
 	go ss.killProcessOnContextDone()
 
 	var processDone atomic.Bool
+	stdin, stdout := rec.writer("i", ss.wrStdin), rec.writer("o", ss)
+	if sftpf != nil {
+		stdin, stdout = sftpf.fromClient(ss.wrStdin), sftpf.fromServer()
+	}
+	if idle != nil {
+		stdin, stdout = idle.writer(stdin), idle.writer(stdout)
+	}
 	go func() {
 		defer ss.wrStdin.Close()
-		if _, err := io.Copy(rec.writer("i", ss.wrStdin), ss); err != nil {
+		if _, err := io.Copy(stdin, ss); err != nil {
 			logf("stdin copy: %v", err)
 			ss.cancelCtx(err)
 		}
@@ -1153,7 +1219,7 @@ func (ss *sshSession) run() {
 	}
 	go func() {
 		defer ss.rdStdout.Close()
-		_, err := io.Copy(rec.writer("o", ss), ss.rdStdout)
+		_, err := io.Copy(stdout, ss.rdStdout)
 		if err != nil && !errors.Is(err, io.EOF) {
 			isErrBecauseProcessExited := processDone.Load() && errors.Is(err, syscall.EIO)
 			if !isErrBecauseProcessExited {
@@ -1168,9 +1234,13 @@ func (ss *sshSession) run() {
 	}()
 	// rdStderr is nil for ptys.
 	if ss.rdStderr != nil {
+		var stderr io.Writer = ss.Stderr()
+		if idle != nil {
+			stderr = idle.writer(stderr)
+		}
 		go func() {
 			defer ss.rdStderr.Close()
-			_, err := io.Copy(ss.Stderr(), ss.rdStderr)
+			_, err := io.Copy(stderr, ss.rdStderr)
 			if err != nil {
 				logf("stderr copy: %v", err)
 			}
@@ -1197,6 +1267,11 @@ func (ss *sshSession) run() {
 	case <-outputDone:
 	case <-ss.ctx.Done():
 	}
+	if sftpf != nil {
+		if err := sftpf.close(); err != nil {
+			logf("recording SFTP files still open: %v", err)
+		}
+	}
 
 	if err == nil {
 		ss.logf("Session complete")
@@ -1231,6 +1306,28 @@ func (ss *sshSession) recorders() ([]netip.AddrPort, *tailcfg.SSHRecorderFailure
 	return ss.conn.action0.Recorders, ss.conn.action0.OnRecordingFailure
 }
 
+// checkSFTPPolicy returns an error if ss is a file transfer which the SFTP
+// policy of the connection does not allow. Transfers in read-only SFTP
+// sessions are checked as they happen, by an sftpFilter.
+func (ss *sshSession) checkSFTPPolicy() error {
+	policy := ss.conn.finalAction.SFTP
+	if policy == "" {
+		return nil
+	}
+	if ss.Subsystem() == "sftp" {
+		if policy == tailcfg.SSHSFTPDeny {
+			return errors.New("sftp denied by Tailscale SSH policy")
+		}
+		return nil
+	}
+	if isSCP, sink := isSCPCommand(ss.Command()); isSCP {
+		if policy == tailcfg.SSHSFTPDeny || sink {
+			return errors.New("scp denied by Tailscale SSH policy")
+		}
+	}
+	return nil
+}
+
 func (ss *sshSession) shouldRecord() bool {
 	recs, _ := ss.recorders()
 	return len(recs) > 0 || recordSSHToLocalDisk()
@@ -1443,6 +1540,10 @@ type CastHeader struct {
 	// Typically empty for shell sessions.
 	Command string `json:"command,omitempty"`
 
+	// Subsystem is the SSH subsystem of the session, such as "sftp".
+	// It is empty for shells and commands.
+	Subsystem string `json:"subsystem,omitempty"`
+
 	// Tailscale-specific fields:
 	// SrcNode is the FQDN of the node originating the connection.
 	// It is also the MagicDNS name for the node.
@@ -1717,6 +1818,7 @@ func (ss *sshSession) startNewRecording() (_ *recording, err error) {
 		Height:    w.Height,
 		Timestamp: now.Unix(),
 		Command:   strings.Join(ss.Command(), " "),
+		Subsystem: ss.Subsystem(),
 		Env: map[string]string{
 			"TERM": term,
 			// TODO(bradfitz): anything else important?
@@ -1852,6 +1954,40 @@ type loggingWriter struct {
 	recordingFailedOpen bool
 }
 
+// sftpRecorder returns a func which writes SFTP file operations to the
+// recording, or nil if r is nil.
+func (r *recording) sftpRecorder() func(SFTPEvent) error {
+	if r == nil {
+		return nil
+	}
+	var failedOpen bool
+	return func(ev SFTPEvent) error {
+		if failedOpen {
+			return nil
+		}
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		j, err := json.Marshal([]any{
+			time.Since(r.start).Seconds(),
+			"f",
+			string(data),
+		})
+		if err != nil {
+			return err
+		}
+		j = append(j, '\n')
+		if err := r.writeCastLine(j); err != nil {
+			if !r.failOpen {
+				return err
+			}
+			failedOpen = true
+		}
+		return nil
+	}
+}
+
 func (w *loggingWriter) Write(p []byte) (n int, err error) {
 	if !w.recordingFailedOpen {
 		j, err := json.Marshal([]any{
@@ -1863,7 +1999,7 @@ func (w *loggingWriter) Write(p []byte) (n int, err error) {
 			return 0, err
 		}
 		j = append(j, '\n')
-		if err := w.writeCastLine(j); err != nil {
+		if err := w.r.writeCastLine(j); err != nil {
 			if !w.r.failOpen {
 				return 0, err
 			}
@@ -1873,13 +2009,13 @@ func (w *loggingWriter) Write(p []byte) (n int, err error) {
 	return w.w.Write(p)
 }
 
-func (w loggingWriter) writeCastLine(j []byte) error {
-	w.r.mu.Lock()
-	defer w.r.mu.Unlock()
-	if w.r.out == nil {
+func (r *recording) writeCastLine(j []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.out == nil {
 		return errors.New("logger closed")
 	}
-	_, err := w.r.out.Write(j)
+	_, err := r.out.Write(j)
 	if err != nil {
 		return fmt.Errorf("logger Write: %w", err)
 	}
@@ -1915,6 +2051,8 @@ func envEq(a, b string) bool {
 	metricHolds               = clientmetric.NewCounter("ssh_holds")
 	metricPolicyChangeKick    = clientmetric.NewCounter("ssh_policy_change_kick")
 	metricSFTP                = clientmetric.NewCounter("ssh_sftp_sessions")
+	metricSFTPDenied          = clientmetric.NewCounter("ssh_sftp_policy_denials")
+	metricSessionLimitRejects = clientmetric.NewCounter("ssh_session_limit_rejects")
 	metricLocalPortForward    = clientmetric.NewCounter("ssh_local_port_forward_requests")
 	metricRemotePortForward   = clientmetric.NewCounter("ssh_remote_port_forward_requests")
 )
@@ -124,7 +124,10 @@
 //   - 81: 2023-11-17: MapResponse.PacketFilters (incremental packet filter updates)
 //   - 82: 2023-12-01: Client understands NodeAttrLinuxMustUseIPTables, NodeAttrLinuxMustUseNfTables, c2n /netfilter-kind
 //   - 83: 2023-12-18: Client understands DefaultAutoUpdate
-const CurrentCapabilityVersion CapabilityVersion = 83
+//   - 84: 2024-01-04: Client understands SSHAction.SFTP and records SFTP file operations
+//   - 85: 2024-01-09: Client understands SSHAction.Limits
+//   - 86: 2024-01-16: Client accepts tailnet lock keys of kind tka.KeyP256
+const CurrentCapabilityVersion CapabilityVersion = 86
 
 type StableID string
 
@@ -2375,8 +2378,66 @@ type SSHAction struct {
 	// OnRecorderFailure is the action to take if recording fails.
 	// If nil, the default action is to fail open.
 	OnRecordingFailure *SSHRecorderFailureAction `json:"onRecordingFailure,omitempty"`
+
+	// SFTP, if non-empty, restricts file transfers over SFTP and SCP in
+	// accepted connections. It does not restrict shell sessions or other
+	// commands. The empty value allows file transfers.
+	//
+	// SFTP sessions are recorded, when recording is enabled, as audit
+	// events for each file operation rather than as terminal output.
+	SFTP SSHSFTPPolicy `json:"sftp,omitempty"`
+
+	// Limits, if non-nil, limits the sessions of accepted connections, in
+	// addition to SessionDuration.
+	Limits *SSHSessionLimits `json:"limits,omitempty"`
 }
 
+// SSHSessionLimits limits the sessions of Tailscale SSH connections. Zero
+// fields are unlimited.
+type SSHSessionLimits struct {
+	// IdleTimeout, if non-zero, is how long a session can go without
+	// input from or output to the client before being terminated.
+	IdleTimeout time.Duration `json:"idleTimeout,omitempty"`
+
+	// MaxSessionsPerUser, if non-zero, is the maximum number of
+	// concurrent sessions to the node by the same Tailscale user, or for
+	// tagged nodes, by nodes with the same tags. Sessions over the limit
+	// are rejected.
+	MaxSessionsPerUser int `json:"maxSessionsPerUser,omitempty"`
+
+	// CPUMillis, if non-zero, limits the CPU time of the processes of a
+	// session, in thousandths of a CPU: 500 is half of one CPU, and 2000
+	// is two CPUs.
+	//
+	// CPUMillis, MemoryBytes and MaxProcesses can only be enforced on
+	// Linux with cgroup v2, when tailscaled is running as root in a cgroup
+	// delegated to it (as with Delegate=yes in its systemd unit).
+	// Elsewhere, sessions with these limits are rejected.
+	CPUMillis int64 `json:"cpuMillis,omitempty"`
+
+	// MemoryBytes, if non-zero, limits the memory used by the processes
+	// of a session.
+	MemoryBytes int64 `json:"memoryBytes,omitempty"`
+
+	// MaxProcesses, if non-zero, limits the number of processes (and
+	// threads) of a session.
+	MaxProcesses int64 `json:"maxProcesses,omitempty"`
+}
+
+// SSHSFTPPolicy restricts file transfers in SSH sessions, either with the
+// "sftp" subsystem (which OpenSSH's scp also uses, since OpenSSH 9.0) or
+// with the legacy SCP protocol.
+type SSHSFTPPolicy string
+
+const (
+	// SSHSFTPDeny rejects SFTP and SCP sessions.
+	SSHSFTPDeny SSHSFTPPolicy = "deny"
+
+	// SSHSFTPReadOnly allows SFTP and SCP sessions to read files and list
+	// directories, but not to modify the filesystem.
+	SSHSFTPReadOnly SSHSFTPPolicy = "read-only"
+)
+
 // SSHRecorderFailureAction is the action to take if recording fails.
 type SSHRecorderFailureAction struct {
 	// RejectSessionWithMessage, if not empty, specifies that the session should
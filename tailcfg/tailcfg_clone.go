@@ -503,6 +503,9 @@ func (src *SSHAction) Clone() *SSHAction {
 	if dst.OnRecordingFailure != nil {
 		dst.OnRecordingFailure = ptr.To(*src.OnRecordingFailure)
 	}
+	if dst.Limits != nil {
+		dst.Limits = ptr.To(*src.Limits)
+	}
 	return dst
 }
 
@@ -518,6 +521,8 @@ func (src *SSHAction) Clone() *SSHAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	SFTP                      SSHSFTPPolicy
+	Limits                    *SSHSessionLimits
 }{})
 
 // Clone makes a deep copy of SSHPrincipal.
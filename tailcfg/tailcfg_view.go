@@ -1197,6 +1197,15 @@ func (v SSHActionView) OnRecordingFailure() *SSHRecorderFailureAction {
 	return &x
 }
 
+func (v SSHActionView) SFTP() SSHSFTPPolicy { return v.ж.SFTP }
+func (v SSHActionView) Limits() *SSHSessionLimits {
+	if v.ж.Limits == nil {
+		return nil
+	}
+	x := *v.ж.Limits
+	return &x
+}
+
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _SSHActionViewNeedsRegeneration = SSHAction(struct {
 	Message                   string
@@ -1209,6 +1218,8 @@ func (v SSHActionView) OnRecordingFailure() *SSHRecorderFailureAction {
 	AllowRemotePortForwarding bool
 	Recorders                 []netip.AddrPort
 	OnRecordingFailure        *SSHRecorderFailureAction
+	SFTP                      SSHSFTPPolicy
+	Limits                    *SSHSessionLimits
 }{})
 
 // View returns a readonly view of SSHPrincipal.
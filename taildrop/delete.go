@@ -75,6 +75,12 @@ func (d *fileDeleter) Init(m *Manager, eventHook func(string)) {
 			switch {
 			case d.shutdownCtx.Err() != nil:
 				return false // terminate early
+			case strings.HasSuffix(de.Name(), pendingSuffix):
+				// Received, but waiting to be accepted or rejected.
+				return true
+			case de.IsDir() && strings.HasSuffix(de.Name(), partialSuffix):
+				// A directory transfer which was never committed.
+				d.Insert(de.Name())
 			case !de.Type().IsRegular():
 				return true
 			case strings.HasSuffix(de.Name(), partialSuffix):
@@ -155,7 +161,9 @@ func (d *fileDeleter) waitAndDelete(wait time.Duration) {
 					continue
 				}
 			}
-			if err := os.Remove(filepath.Join(d.dir, file.name)); err != nil && !os.IsNotExist(err) {
+			// Partial files may be the staging directories of directory
+			// transfers, so remove them recursively.
+			if err := os.RemoveAll(filepath.Join(d.dir, file.name)); err != nil {
 				d.logf("could not delete: %v", redactError(err))
 				failed = append(failed, elem)
 				continue
@@ -0,0 +1,117 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Digest is the SHA-256 digest of the contents of a file.
+//
+// A sender may provide the digest of a file up front, in which case the
+// file is verified once it has been received, before it is renamed to
+// its final name.
+type Digest [sha256.Size]byte
+
+// ErrDigestMismatch is returned when a received file does not match the
+// digest provided by its sender. The partially received file is discarded.
+var ErrDigestMismatch = errors.New("received file does not match its SHA-256 digest")
+
+// ParseDigest parses a hex-encoded SHA-256 digest.
+func ParseDigest(s string) (Digest, error) {
+	var d Digest
+	if len(s) != hex.EncodedLen(len(d)) {
+		return d, fmt.Errorf("invalid SHA-256 digest %q", s)
+	}
+	if _, err := hex.Decode(d[:], []byte(s)); err != nil {
+		return d, fmt.Errorf("invalid SHA-256 digest %q", s)
+	}
+	return d, nil
+}
+
+// String returns the digest hex-encoded, as accepted by ParseDigest.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// IsZero reports whether d is the zero value, meaning that no digest
+// was provided.
+func (d Digest) IsZero() bool {
+	return d == Digest{}
+}
+
+// cachedDigest is the digest of a received file, which is valid as long
+// as the file's size and modification time are unchanged.
+type cachedDigest struct {
+	size    int64
+	modTime time.Time
+	sum     Digest
+}
+
+// noteDigest records the digest of the just-received file name, a
+// slash-separated path relative to Dir, so that it can be reported by
+// WaitingFiles.
+func (m *Manager) noteDigest(name string, sum Digest) {
+	fi, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		return
+	}
+	m.digests.Store(name, cachedDigest{size: fi.Size(), modTime: fi.ModTime(), sum: sum})
+}
+
+// knownDigest returns the digest of the file name, a slash-separated path
+// relative to Dir, whose info is fi, if it was recorded when the file was
+// received and the file is unchanged since.
+//
+// Digests are not computed here, as hashing every file in the inbox (say,
+// after a restart) could take a long time. They are only kept in memory.
+func (m *Manager) knownDigest(name string, fi fs.FileInfo) (Digest, bool) {
+	d, ok := m.digests.Load(name)
+	if !ok || d.size != fi.Size() || !d.modTime.Equal(fi.ModTime()) {
+		return Digest{}, false
+	}
+	return d.sum, true
+}
+
+// moveDigests moves the recorded digests of the file or directory renamed
+// from the slash-separated path from to to, including those of the files
+// within a directory.
+func (m *Manager) moveDigests(from, to string) {
+	var names []string
+	m.digests.Range(func(name string, _ cachedDigest) bool {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			names = append(names, name)
+		}
+		return true
+	})
+	for _, name := range names {
+		if d, ok := m.digests.LoadAndDelete(name); ok {
+			m.digests.Store(to+strings.TrimPrefix(name, from), d)
+		}
+	}
+}
+
+// forgetRemovedDigests forgets the digests of files which are no longer
+// in Dir, such as those removed by clients which use and delete files
+// directly.
+func (m *Manager) forgetRemovedDigests() {
+	var names []string
+	m.digests.Range(func(name string, _ cachedDigest) bool {
+		names = append(names, name)
+		return true
+	})
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(m.opts.Dir, filepath.FromSlash(name))); os.IsNotExist(err) {
+			m.digests.Delete(name)
+		}
+	}
+}
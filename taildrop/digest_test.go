@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/util/must"
+)
+
+func TestParseDigest(t *testing.T) {
+	sum := Digest(sha256.Sum256([]byte("hello")))
+	if got := must.Get(ParseDigest(sum.String())); got != sum {
+		t.Errorf("ParseDigest(%q) = %v, want %v", sum.String(), got, sum)
+	}
+	for _, s := range []string{"", "abc", strings.Repeat("z", 64), sum.String() + "00"} {
+		if _, err := ParseDigest(s); err == nil {
+			t.Errorf("ParseDigest(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestPutFileDigest(t *testing.T) {
+	dir := t.TempDir()
+	m := ManagerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	sum := Digest(sha256.Sum256([]byte("hello")))
+	if _, err := m.PutFile("id", "bad.txt", strings.NewReader("jello"), 0, 5, sum); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("PutFile with wrong contents: got %v, want %v", err, ErrDigestMismatch)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("mismatched file was kept: %v", err)
+	}
+	if got := must.Get(m.PartialFiles("id")); len(got) != 0 {
+		t.Errorf("partial files kept after mismatch: %v", got)
+	}
+
+	must.Get(m.PutFile("id", "good.txt", strings.NewReader("hello"), 0, 5, sum))
+	must.Get(m.PutFile("id", "other.txt", strings.NewReader("other"), 0, 5, Digest{}))
+	wfs := must.Get(m.WaitingFiles())
+	if len(wfs) != 2 {
+		t.Fatalf("got %d waiting files, want 2: %+v", len(wfs), wfs)
+	}
+	if wfs[0].Name != "good.txt" || wfs[0].SHA256 != sum.String() {
+		t.Errorf("waiting file = %+v, want SHA256 %v", wfs[0], sum)
+	}
+	// The digest of a file received without one is computed as it is
+	// received.
+	if want := Digest(sha256.Sum256([]byte("other"))).String(); wfs[1].SHA256 != want {
+		t.Errorf("waiting file = %+v, want SHA256 %v", wfs[1], want)
+	}
+
+	// Digests of files which are removed are forgotten.
+	must.Do(os.Remove(filepath.Join(dir, "other.txt")))
+	must.Get(m.WaitingFiles())
+	if _, ok := m.digests.Load("other.txt"); ok {
+		t.Error("digest of removed file was kept")
+	}
+
+	// Digests are not computed for files received before a restart.
+	m2 := ManagerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m2.Shutdown()
+	wfs = must.Get(m2.WaitingFiles())
+	if len(wfs) != 1 || wfs[0].SHA256 != "" {
+		t.Errorf("waiting files after restart = %+v, want no SHA256", wfs)
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/version/distro"
+)
+
+// A directory is received in a staging directory next to where the
+// directory will finally be placed, named like a partial file:
+//
+//	photos.n12345CNTRL.partial/
+//		manifest.json      the apitype.DirManifest
+//		files/             the directory contents, once complete
+//			a.jpg          a complete file
+//			b/c.jpg.partial  a file still being received
+//
+// Once every file in the manifest has been received, CommitDir renames
+// files/ to its final name, so the directory appears all at once.
+const (
+	dirManifestName = "manifest.json"
+	dirFilesName    = "files"
+)
+
+const (
+	// maxDirFiles is the maximum number of files in a directory transfer.
+	maxDirFiles = 100_000
+	// maxRelPathLen is the maximum length of the path of a file within
+	// a directory transfer.
+	maxRelPathLen = 4096
+)
+
+// ErrNoDirTransfer is returned when sending a file within a directory
+// for which no transfer has been started with BeginDir.
+var ErrNoDirTransfer = errors.New("no directory transfer in progress")
+
+// validateManifest reports whether dm is well-formed: every path must be
+// a valid relative path which cannot escape the directory, and no path may
+// collide with another, including on case-insensitive filesystems.
+func validateManifest(dm *apitype.DirManifest) error {
+	switch {
+	case len(dm.Files) == 0:
+		return errors.New("no files in directory manifest")
+	case len(dm.Files) > maxDirFiles:
+		return fmt.Errorf("too many files in directory manifest (%d > %d)", len(dm.Files), maxDirFiles)
+	}
+	files := make(map[string]bool, len(dm.Files))
+	for _, f := range dm.Files {
+		if err := validRelPath(f.Path); err != nil {
+			return fmt.Errorf("%w: %q", err, f.Path)
+		}
+		if f.Size < 0 {
+			return fmt.Errorf("negative size for %q", f.Path)
+		}
+		if f.SHA256 != "" {
+			if _, err := ParseDigest(f.SHA256); err != nil {
+				return fmt.Errorf("%q: %w", f.Path, err)
+			}
+		}
+		key := strings.ToLower(f.Path)
+		if files[key] {
+			return fmt.Errorf("duplicate path %q", f.Path)
+		}
+		files[key] = true
+	}
+	// A file may not also be a parent directory of another file.
+	for _, f := range dm.Files {
+		p := strings.ToLower(f.Path)
+		for i := strings.LastIndexByte(p, '/'); i > 0; i = strings.LastIndexByte(p, '/') {
+			p = p[:i]
+			if files[p] {
+				return fmt.Errorf("path %q is both a file and a directory", p)
+			}
+		}
+	}
+	return nil
+}
+
+// manifestFile returns the entry for the file at relPath, and whether dm
+// contains it.
+func manifestFile(dm *apitype.DirManifest, relPath string) (apitype.DirFile, bool) {
+	for _, f := range dm.Files {
+		if f.Path == relPath {
+			return f, true
+		}
+	}
+	return apitype.DirFile{}, false
+}
+
+// validRelPath reports whether relPath is a slash-separated relative path
+// where every element is a valid base filename (see joinDir).
+func validRelPath(relPath string) error {
+	if relPath == "" || len(relPath) > maxRelPathLen {
+		return ErrInvalidFileName
+	}
+	for _, elem := range strings.Split(relPath, "/") {
+		if _, err := joinDir("", elem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinRelPath is like joinDir, but accepts a slash-separated relative path
+// such as "photos/2023/a.jpg".
+func joinRelPath(dir, relPath string) (string, error) {
+	if err := validRelPath(relPath); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(relPath)), nil
+}
+
+// checkDirTransfers reports whether m can receive directories.
+func (m *Manager) checkDirTransfers() error {
+	switch {
+	case m == nil || m.opts.Dir == "":
+		return ErrNoTaildrop
+	case !envknob.CanTaildrop():
+		return ErrNoTaildrop
+	case distro.Get() == distro.Unraid && !m.opts.DirectFileMode:
+		return ErrNotAccessible
+	case m.opts.DirectFileMode && m.opts.AvoidFinalRename:
+		// These users depend on the exact naming of partial files,
+		// which directories do not follow.
+		return errors.New("directory transfers not supported")
+	}
+	return nil
+}
+
+// stagingDir returns the path of the directory in which dirName is
+// received from id.
+func (m *Manager) stagingDir(id ClientID, dirName string) (string, error) {
+	dstPath, err := joinDir(m.opts.Dir, dirName)
+	if err != nil {
+		return "", err
+	}
+	return dstPath + id.partialSuffix(), nil
+}
+
+// readManifest reads the manifest of the directory transfer staged in
+// stagingDir.
+func readManifest(stagingDir string) (*apitype.DirManifest, error) {
+	b, err := os.ReadFile(filepath.Join(stagingDir, dirManifestName))
+	if os.IsNotExist(err) {
+		return nil, ErrNoDirTransfer
+	}
+	if err != nil {
+		return nil, redactError(err)
+	}
+	dm := new(apitype.DirManifest)
+	if err := json.Unmarshal(b, dm); err != nil {
+		return nil, err
+	}
+	if err := validateManifest(dm); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// dirActive reports whether any file within dirName is being received
+// from id.
+func (m *Manager) dirActive(id ClientID, dirName string) (active bool) {
+	prefix := dirName + "/"
+	m.incomingFiles.Range(func(k incomingFileKey, _ *incomingFile) bool {
+		if k.id == id && strings.HasPrefix(k.name, prefix) {
+			active = true
+			return false
+		}
+		return true
+	})
+	return active
+}
+
+// BeginDir starts (or resumes) receiving a directory named dirName from
+// the given client id, containing the files listed in manifest.
+//
+// It returns the paths of files in the manifest which have already been
+// completely received, which the sender need not send again. Each of the
+// remaining files must then be sent with PutDirFile, after which CommitDir
+// makes the directory visible. Files which were partially received may be
+// resumed with HashPartialDirFile.
+//
+// If a transfer of dirName with a different manifest was previously
+// started, it is discarded.
+func (m *Manager) BeginDir(id ClientID, dirName string, manifest apitype.DirManifest) (done []string, err error) {
+	if err := m.checkDirTransfers(); err != nil {
+		return nil, err
+	}
+	if err := validateManifest(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFileName, err)
+	}
+	staging, err := m.stagingDir(id, dirName)
+	if err != nil {
+		return nil, err
+	}
+
+	m.dirMu.Lock()
+	defer m.dirMu.Unlock()
+	if m.dirActive(id, dirName) {
+		return nil, ErrFileExists
+	}
+	m.deleter.Remove(filepath.Base(staging)) // avoid deleting the staging directory while receiving
+	m.markReceived()
+
+	if old, err := readManifest(staging); err == nil && slices.Equal(old.Files, manifest.Files) {
+		// Resuming an earlier transfer of the same directory.
+		filesDir := filepath.Join(staging, dirFilesName)
+		for _, f := range manifest.Files {
+			fi, err := os.Stat(filepath.Join(filesDir, filepath.FromSlash(f.Path)))
+			if err == nil && fi.Mode().IsRegular() && fi.Size() == f.Size {
+				done = append(done, f.Path)
+			}
+		}
+		return done, nil
+	}
+
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, redactError(err)
+	}
+	if err := os.MkdirAll(filepath.Join(staging, dirFilesName), 0755); err != nil {
+		return nil, redactError(err)
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(staging, dirManifestName), b, 0644); err != nil {
+		return nil, redactError(err)
+	}
+	return nil, nil
+}
+
+// DirBytesReceived returns how many bytes of the files in manifest were
+// already received by an earlier transfer of dirName from id, which
+// BeginDir would resume. Those bytes need not be admitted again.
+func (m *Manager) DirBytesReceived(id ClientID, dirName string, manifest apitype.DirManifest) int64 {
+	staging, err := m.stagingDir(id, dirName)
+	if err != nil {
+		return 0
+	}
+	m.dirMu.Lock()
+	defer m.dirMu.Unlock()
+	// The paths are only trusted once they match those of the manifest
+	// validated by BeginDir.
+	old, err := readManifest(staging)
+	if err != nil || !slices.Equal(old.Files, manifest.Files) {
+		return 0
+	}
+	var n int64
+	filesDir := filepath.Join(staging, dirFilesName)
+	for _, f := range manifest.Files {
+		p := filepath.Join(filesDir, filepath.FromSlash(f.Path))
+		fi, err := os.Stat(p)
+		if err != nil {
+			fi, err = os.Stat(p + partialSuffix)
+		}
+		if err == nil && fi.Mode().IsRegular() {
+			n += min(fi.Size(), f.Size)
+		}
+	}
+	return n
+}
+
+// PutDirFile stores the file at relPath within the directory dirName,
+// whose transfer from id was started with BeginDir. The relPath is a
+// slash-separated path which must be listed in the manifest. As with
+// PutFile, a non-zero offset resumes a partially received file.
+// It returns the length of the entire file.
+func (m *Manager) PutDirFile(id ClientID, dirName, relPath string, r io.Reader, offset, length int64) (_ int64, err error) {
+	if err := m.checkDirTransfers(); err != nil {
+		return 0, err
+	}
+	staging, err := m.stagingDir(id, dirName)
+	if err != nil {
+		return 0, err
+	}
+	dstPath, err := joinRelPath(filepath.Join(staging, dirFilesName), relPath)
+	if err != nil {
+		return 0, err
+	}
+	partialPath := dstPath + partialSuffix
+	inFileKey := incomingFileKey{id, dirName + "/" + relPath}
+
+	// Read the manifest and register the file as incoming while holding
+	// dirMu, so that a BeginDir with a different manifest can't discard
+	// the staging directory in between.
+	m.dirMu.Lock()
+	manifest, err := readManifest(staging)
+	if err != nil {
+		m.dirMu.Unlock()
+		return 0, err
+	}
+	mf, ok := manifestFile(manifest, relPath)
+	if !ok {
+		m.dirMu.Unlock()
+		return 0, ErrInvalidFileName
+	}
+	size := mf.Size
+	if length >= 0 && offset+length != size {
+		m.dirMu.Unlock()
+		return 0, fmt.Errorf("file is %d bytes, not %d", size, offset+length)
+	}
+	inFile, loaded := m.incomingFiles.LoadOrInit(inFileKey, func() *incomingFile {
+		inFile := &incomingFile{
+			clock:          m.opts.Clock,
+			started:        m.opts.Clock.Now(),
+			size:           size,
+			sendFileNotify: m.opts.SendFileNotify,
+			charge:         func(n int64) error { return m.chargeQuota(id, n) },
+		}
+		if m.opts.DirectFileMode {
+			inFile.partialPath = partialPath
+		}
+		return inFile
+	})
+	m.dirMu.Unlock()
+	if loaded {
+		return 0, ErrFileExists
+	}
+	defer m.incomingFiles.Delete(inFileKey)
+	var want Digest
+	if mf.SHA256 != "" {
+		want, _ = ParseDigest(mf.SHA256) // validated by readManifest
+	}
+
+	redactAndLogError := func(action string, err error) error {
+		err = redactError(err)
+		m.opts.Logf("put dir %v error: %v", action, err)
+		return err
+	}
+
+	m.deleter.Remove(filepath.Base(staging)) // avoid deleting the staging directory while receiving
+	defer func() {
+		if err != nil {
+			// Leave the staging directory for a while, so the transfer
+			// may be resumed.
+			m.deleter.Insert(filepath.Base(staging))
+		}
+	}()
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return 0, redactAndLogError("Mkdir", err)
+	}
+	// A complete file is being sent again, perhaps because its
+	// contents changed; start over.
+	if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+		return 0, redactAndLogError("Remove", err)
+	}
+	f, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return 0, redactAndLogError("Create", err)
+	}
+	defer f.Close()
+	inFile.w = f
+
+	currLength, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, redactAndLogError("Seek", err)
+	}
+	if offset < 0 || offset > currLength {
+		return 0, redactAndLogError("Seek", fmt.Errorf("offset %d beyond partial file of %d bytes", offset, currLength))
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, redactAndLogError("Seek", err)
+	}
+	if err := f.Truncate(offset); err != nil {
+		return 0, redactAndLogError("Truncate", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, offset)); err != nil {
+		return 0, redactAndLogError("Hash", err)
+	}
+
+	copyLength, err := io.Copy(inFile, io.TeeReader(io.LimitReader(r, size-offset+1), h))
+	if err != nil {
+		return 0, redactAndLogError("Copy", err)
+	}
+	if err := f.Close(); err != nil {
+		return 0, redactAndLogError("Close", err)
+	}
+	fileLength := offset + copyLength
+	if fileLength != size {
+		if fileLength > size {
+			os.Remove(partialPath)
+		}
+		return 0, redactAndLogError("Copy", fmt.Errorf("received %d bytes, want %d", fileLength, size))
+	}
+	if !want.IsZero() && Digest(h.Sum(nil)) != want {
+		os.Remove(partialPath)
+		return 0, redactAndLogError("Verify", ErrDigestMismatch)
+	}
+	if err := os.Rename(partialPath, dstPath); err != nil {
+		return 0, redactAndLogError("Rename", err)
+	}
+	m.opts.SendFileNotify()
+	return fileLength, nil
+}
+
+// HashPartialDirFile is like HashPartialFile, for a file within a
+// directory being received with BeginDir.
+func (m *Manager) HashPartialDirFile(id ClientID, dirName, relPath string) (next func() (BlockChecksum, error), close func() error, err error) {
+	if err := m.checkDirTransfers(); err != nil {
+		return nil, nil, err
+	}
+	staging, err := m.stagingDir(id, dirName)
+	if err != nil {
+		return nil, nil, err
+	}
+	dstPath, err := joinRelPath(filepath.Join(staging, dirFilesName), relPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(dstPath + partialSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return func() (BlockChecksum, error) { return BlockChecksum{}, io.EOF }, func() error { return nil }, nil
+		}
+		return nil, nil, redactError(err)
+	}
+	return hashBlocks(f), f.Close, nil
+}
+
+// CommitDir completes the transfer of dirName from id, once every file in
+// its manifest has been received with PutDirFile. The directory is renamed
+// into [Manager.Dir] in a single step, using a new name (as with
+// NextFilename) if dirName already exists. With
+// [Policy.RequireConfirmation], the directory is pending until accepted.
+// It returns the name the directory was given.
+func (m *Manager) CommitDir(id ClientID, dirName string) (string, error) {
+	if err := m.checkDirTransfers(); err != nil {
+		return "", err
+	}
+	staging, err := m.stagingDir(id, dirName)
+	if err != nil {
+		return "", err
+	}
+
+	m.dirMu.Lock()
+	defer m.dirMu.Unlock()
+	if m.dirActive(id, dirName) {
+		return "", ErrFileExists
+	}
+	manifest, err := readManifest(staging)
+	if err != nil {
+		return "", err
+	}
+	filesDir := filepath.Join(staging, dirFilesName)
+	for _, f := range manifest.Files {
+		fi, err := os.Stat(filepath.Join(filesDir, filepath.FromSlash(f.Path)))
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() != f.Size {
+			return "", fmt.Errorf("directory incomplete: %q not received", f.Path)
+		}
+	}
+
+	var suffix string
+	if m.opts.Policy.RequireConfirmation {
+		suffix = pendingSuffix
+	}
+	name := dirName
+	for retries := 10; ; retries-- {
+		if retries == 0 {
+			return "", errors.New("too many retries trying to rename directory")
+		}
+		renamed, err := m.renameNoReplace(filesDir, filepath.Join(m.opts.Dir, name+suffix))
+		if err != nil {
+			return "", redactError(err)
+		}
+		if renamed {
+			break
+		}
+		name = NextFilename(name)
+	}
+	for _, f := range manifest.Files {
+		if sum, err := ParseDigest(f.SHA256); err == nil {
+			m.noteDigest(name+suffix+"/"+f.Path, sum)
+		}
+	}
+	if suffix != "" {
+		m.notePending(name, id)
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		m.opts.Logf("put dir cleanup error: %v", redactError(err)) // non-fatal error
+		m.deleter.Insert(filepath.Base(staging))
+	}
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	return name, nil
+}
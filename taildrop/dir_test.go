@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/must"
+)
+
+func TestValidateManifest(t *testing.T) {
+	tests := []struct {
+		name   string
+		paths  []string
+		wantOk bool
+	}{
+		{"single", []string{"a"}, true},
+		{"nested", []string{"a", "b/c", "b/d/e"}, true},
+		{"empty", nil, false},
+		{"empty_path", []string{""}, false},
+		{"absolute", []string{"/etc/passwd"}, false},
+		{"dotdot", []string{"a/../../b"}, false},
+		{"dot", []string{"./a"}, false},
+		{"trailing_slash", []string{"a/"}, false},
+		{"double_slash", []string{"a//b"}, false},
+		{"backslash", []string{`a\..\b`}, false},
+		{"partial", []string{"a/b.partial"}, false},
+		{"deleted_dir", []string{"a.deleted/b"}, false},
+		{"duplicate", []string{"a/b", "a/b"}, false},
+		{"case_duplicate", []string{"a/B", "A/b"}, false},
+		{"file_and_dir", []string{"a", "a/b"}, false},
+		{"file_and_dir_case", []string{"A", "a/b"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dm apitype.DirManifest
+			for _, p := range tt.paths {
+				dm.Files = append(dm.Files, apitype.DirFile{Path: p, Size: 1})
+			}
+			err := validateManifest(&dm)
+			if gotOk := err == nil; gotOk != tt.wantOk {
+				t.Errorf("validateManifest() = %v, want ok=%v", err, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestPutDir(t *testing.T) {
+	dir := t.TempDir()
+	m := ManagerOptions{Logf: t.Logf, Dir: dir}.New()
+	defer m.Shutdown()
+
+	files := map[string]string{
+		"a.txt":       "hello",
+		"sub/b.txt":   "world",
+		"sub/c/d.bin": strings.Repeat("x", 1000),
+	}
+	var manifest apitype.DirManifest
+	for _, p := range []string{"a.txt", "sub/b.txt", "sub/c/d.bin"} {
+		manifest.Files = append(manifest.Files, apitype.DirFile{Path: p, Size: int64(len(files[p])), SHA256: sha256Hex(files[p])})
+	}
+	put := func(id ClientID, dirName, p string) error {
+		_, err := m.PutDirFile(id, dirName, p, strings.NewReader(files[p]), 0, int64(len(files[p])))
+		return err
+	}
+
+	done := must.Get(m.BeginDir("id", "photos", manifest))
+	if len(done) != 0 {
+		t.Fatalf("BeginDir reported done files %v for a new transfer", done)
+	}
+	must.Do(put("id", "photos", "a.txt"))
+	if _, err := m.PutDirFile("id", "photos", "not/in/manifest", strings.NewReader("x"), 0, 1); err == nil {
+		t.Error("PutDirFile of a file not in the manifest succeeded")
+	}
+	if _, err := m.PutDirFile("other", "photos", "a.txt", strings.NewReader("hello"), 0, 5); !errors.Is(err, ErrNoDirTransfer) {
+		t.Errorf("PutDirFile from another client = %v, want %v", err, ErrNoDirTransfer)
+	}
+
+	// Nothing is visible until the directory is committed.
+	if _, err := m.CommitDir("id", "photos"); err == nil {
+		t.Fatal("CommitDir of incomplete directory succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "photos")); !os.IsNotExist(err) {
+		t.Fatalf("directory visible before commit: %v", err)
+	}
+	if m.HasFilesWaiting() {
+		t.Error("HasFilesWaiting before commit")
+	}
+
+	// Restarting with the same manifest resumes where we left off.
+	if got := m.DirBytesReceived("id", "photos", manifest); got != 5 {
+		t.Errorf("DirBytesReceived = %d, want 5", got)
+	}
+	if got := m.DirBytesReceived("other", "photos", manifest); got != 0 {
+		t.Errorf("DirBytesReceived for another client = %d, want 0", got)
+	}
+	done = must.Get(m.BeginDir("id", "photos", manifest))
+	if !reflect.DeepEqual(done, []string{"a.txt"}) {
+		t.Fatalf("BeginDir done = %v, want [a.txt]", done)
+	}
+
+	// A file which does not match its digest is discarded.
+	if _, err := m.PutDirFile("id", "photos", "sub/b.txt", strings.NewReader("wrong"), 0, 5); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("PutDirFile with wrong contents: got %v, want %v", err, ErrDigestMismatch)
+	}
+	must.Do(put("id", "photos", "sub/b.txt"))
+
+	// Interrupt the last file part way through, then resume it.
+	want := files["sub/c/d.bin"]
+	r := io.MultiReader(strings.NewReader(want[:600]), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := m.PutDirFile("id", "photos", "sub/c/d.bin", r, 0, int64(len(want))); err == nil {
+		t.Fatal("interrupted PutDirFile succeeded")
+	}
+	oldBlockSize := blockSize
+	defer func() { blockSize = oldBlockSize }()
+	blockSize = 256
+	next, close, err := m.HashPartialDirFile("id", "photos", "sub/c/d.bin")
+	must.Do(err)
+	offset, rest, err := ResumeReader(strings.NewReader(want), next)
+	must.Do(err)
+	must.Do(close())
+	if offset != 600 {
+		t.Errorf("resume offset = %d, want 600", offset)
+	}
+	if got := m.DirBytesReceived("id", "photos", manifest); got != 5+5+600 {
+		t.Errorf("DirBytesReceived = %d, want %d", got, 5+5+600)
+	}
+	must.Get(m.PutDirFile("id", "photos", "sub/c/d.bin", rest, offset, int64(len(want))-offset))
+
+	// A directory of the same name already exists, so the new one is renamed.
+	must.Do(os.Mkdir(filepath.Join(dir, "photos"), 0755))
+	name := must.Get(m.CommitDir("id", "photos"))
+	if name != "photos (1)" {
+		t.Errorf("CommitDir name = %q, want %q", name, "photos (1)")
+	}
+	for p, want := range files {
+		got := must.Get(os.ReadFile(filepath.Join(dir, name, filepath.FromSlash(p))))
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", p, got, want)
+		}
+	}
+	des := must.Get(os.ReadDir(dir))
+	for _, de := range des {
+		if strings.HasSuffix(de.Name(), partialSuffix) {
+			t.Errorf("staging directory %q left behind", de.Name())
+		}
+	}
+
+	// The received files are waiting in the inbox, and can be read and
+	// deleted individually.
+	must.Do(os.Remove(filepath.Join(dir, "photos")))
+	wfs := must.Get(m.WaitingFiles())
+	wantWFs := []apitype.WaitingFile{
+		{Name: "photos (1)/a.txt", Size: 5, SHA256: sha256Hex(files["a.txt"])},
+		{Name: "photos (1)/sub/b.txt", Size: 5, SHA256: sha256Hex(files["sub/b.txt"])},
+		{Name: "photos (1)/sub/c/d.bin", Size: 1000, SHA256: sha256Hex(files["sub/c/d.bin"])},
+	}
+	if !reflect.DeepEqual(wfs, wantWFs) {
+		t.Fatalf("WaitingFiles = %v, want %v", wfs, wantWFs)
+	}
+	for _, wf := range wfs {
+		rc, _, err := m.OpenFile(wf.Name)
+		must.Do(err)
+		got := must.Get(io.ReadAll(rc))
+		rc.Close()
+		if !bytes.Equal(got, []byte(files[strings.TrimPrefix(wf.Name, "photos (1)/")])) {
+			t.Errorf("OpenFile(%q) returned wrong contents", wf.Name)
+		}
+		must.Do(m.DeleteFile(wf.Name))
+	}
+	if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Errorf("received directory not removed once empty: %v", err)
+	}
+	if m.HasFilesWaiting() {
+		t.Error("HasFilesWaiting after all files deleted")
+	}
+	if _, _, err := m.OpenFile("../" + filepath.Base(dir)); err == nil {
+		t.Error("OpenFile outside of the inbox succeeded")
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
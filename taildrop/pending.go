@@ -0,0 +1,152 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/mak"
+)
+
+// With [Policy.RequireConfirmation], a received file "foo.jpg" is renamed
+// to "foo.jpg.pending.partial" rather than "foo.jpg", and a received
+// directory "photos" to "photos.pending.partial". AcceptFile removes the
+// suffix.
+
+// notePending records that the file name (without pendingSuffix) was
+// received from id, and is pending.
+func (m *Manager) notePending(name string, id ClientID) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	mak.Set(&m.pendingSenders, name, m.senderNames[id])
+}
+
+// PendingFiles returns the files and directories which have been
+// received, but must be accepted with AcceptFile (or rejected with
+// RejectFile) because of [Policy.RequireConfirmation].
+func (m *Manager) PendingFiles() (ret []apitype.PendingFile, err error) {
+	if m == nil || m.opts.Dir == "" {
+		return nil, ErrNoTaildrop
+	}
+	m.pendingMu.Lock()
+	senders := make(map[string]string, len(m.pendingSenders))
+	for k, v := range m.pendingSenders {
+		senders[k] = v
+	}
+	m.pendingMu.Unlock()
+
+	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
+		name, ok := strings.CutSuffix(de.Name(), pendingSuffix)
+		if !ok || !de.IsDir() && !de.Type().IsRegular() {
+			return true
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return true
+		}
+		pf := apitype.PendingFile{
+			Name:     name,
+			Size:     fi.Size(),
+			IsDir:    de.IsDir(),
+			Sender:   senders[name], // unknown if received before a restart
+			Received: fi.ModTime(),
+		}
+		if pf.IsDir {
+			pf.Size = diskUsage(filepath.Join(m.opts.Dir, de.Name()))
+		}
+		ret = append(ret, pf)
+		return true
+	}); err != nil {
+		return nil, redactError(err)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// pendingPath returns the path of the pending file name.
+func (m *Manager) pendingPath(name string) (string, error) {
+	if m == nil || m.opts.Dir == "" {
+		return "", ErrNoTaildrop
+	}
+	path, err := joinDir(m.opts.Dir, name)
+	if err != nil {
+		return "", err
+	}
+	path += pendingSuffix
+	if _, err := os.Lstat(path); err != nil {
+		return "", redactError(err)
+	}
+	return path, nil
+}
+
+// AcceptFile accepts the pending file or directory name, as listed by
+// PendingFiles, making it available with WaitingFiles (or, in
+// DirectFileMode, moving it to its final name). If a file of that name
+// already exists, a new name is chosen as with NextFilename.
+// It returns the name the file was given.
+func (m *Manager) AcceptFile(name string) (string, error) {
+	src, err := m.pendingPath(name)
+	if err != nil {
+		return "", err
+	}
+	dstName := name
+	for retries := 10; ; retries-- {
+		if retries == 0 {
+			return "", errors.New("too many retries trying to rename pending file")
+		}
+		renamed, err := m.renameNoReplace(src, filepath.Join(m.opts.Dir, dstName))
+		if err != nil {
+			return "", redactError(err)
+		}
+		if renamed {
+			break
+		}
+		dstName = NextFilename(dstName)
+	}
+	m.moveDigests(name+pendingSuffix, dstName)
+	m.pendingMu.Lock()
+	delete(m.pendingSenders, name)
+	m.pendingMu.Unlock()
+	m.totalReceived.Add(1)
+	m.opts.SendFileNotify()
+	return dstName, nil
+}
+
+// RejectFile deletes the pending file or directory name, as listed by
+// PendingFiles.
+func (m *Manager) RejectFile(name string) error {
+	path, err := m.pendingPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return redactError(err)
+	}
+	m.pendingMu.Lock()
+	delete(m.pendingSenders, name)
+	m.pendingMu.Unlock()
+	m.invalidateInboxUsage()
+	return nil
+}
+
+// renameNoReplace renames src to dst, unless dst already exists.
+// It reports whether src was renamed.
+func (m *Manager) renameNoReplace(src, dst string) (bool, error) {
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+	switch _, err := os.Lstat(dst); {
+	case os.IsNotExist(err):
+		return true, os.Rename(src, dst)
+	case err != nil:
+		return false, err
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tailscale.com/util/mak"
+)
+
+// Policy restricts which files a [Manager] accepts, so that peers cannot
+// fill the disk or deliver files the user does not want.
+type Policy struct {
+	// MaxBytesPerSenderPerDay, if positive, limits the number of bytes
+	// received from each sender in a day. A sender's day starts when
+	// it is first charged for a file. Usage is not remembered across
+	// restarts.
+	MaxBytesPerSenderPerDay int64
+
+	// MaxInboxBytes, if positive, limits the total size of the files in
+	// [ManagerOptions.Dir], including those partially received or pending.
+	// In DirectFileMode, only partial and pending files are counted,
+	// since received files belong to the user.
+	MaxInboxBytes int64
+
+	// AllowedSenders, if non-empty, lists who may send files: either
+	// tags (such as "tag:ci"), matching nodes with that tag, or login
+	// names (such as "alice@example.com"), matching the untagged nodes
+	// of that user. Nodes of the receiving user are not exempt.
+	AllowedSenders []string
+
+	// RequireConfirmation specifies that received files are held as
+	// pending, and are not visible to WaitingFiles or moved to their
+	// final location, until accepted with [Manager.AcceptFile].
+	// It is not supported with AvoidFinalRename.
+	RequireConfirmation bool
+}
+
+// Sender describes the peer sending a file, for enforcing a [Policy].
+type Sender struct {
+	// ID is the ClientID the sender's files are received with.
+	ID ClientID
+	// Node is the name of the sending node.
+	Node string
+	// LoginName is the login name of the user owning the sending node,
+	// or empty if the node is tagged.
+	LoginName string
+	// Tags are the tags of the sending node.
+	Tags []string
+}
+
+// String returns a description of s for display to the user, such as
+// "alice@example.com on laptop".
+func (s Sender) String() string {
+	who := s.LoginName
+	if who == "" {
+		who = strings.Join(s.Tags, ",")
+	}
+	switch {
+	case who == "":
+		return s.Node
+	case s.Node == "":
+		return who
+	}
+	return who + " on " + s.Node
+}
+
+var (
+	ErrSenderNotAllowed = errors.New("sender not allowed to send files to this node")
+	ErrQuotaExceeded    = errors.New("sender has exceeded its daily Taildrop quota")
+	ErrInboxFull        = errors.New("Taildrop inbox is full")
+)
+
+// inboxRescanInterval is how long the computed size of the inbox is
+// trusted for. In between, it is only increased as files are received,
+// so files deleted by other means take a while to be accounted for.
+const inboxRescanInterval = 10 * time.Second
+
+// senderUsage is the number of bytes received from a sender in a day.
+type senderUsage struct {
+	start time.Time
+	bytes int64
+}
+
+// allows reports whether p accepts files from s.
+func (p *Policy) allows(s Sender) bool {
+	if len(p.AllowedSenders) == 0 {
+		return true
+	}
+	for _, a := range p.AllowedSenders {
+		if strings.HasPrefix(a, "tag:") {
+			for _, t := range s.Tags {
+				if t == a {
+					return true
+				}
+			}
+		} else if s.LoginName != "" && strings.EqualFold(a, s.LoginName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Admit reports whether the policy accepts size more bytes from s, where
+// size is -1 if unknown. It must be called before each call to PutFile,
+// BeginDir and PutDirFile, which enforce quotas as data arrives but
+// do not otherwise know who the sender is.
+func (m *Manager) Admit(s Sender, size int64) error {
+	if m == nil || m.opts.Dir == "" {
+		return ErrNoTaildrop
+	}
+	p := &m.opts.Policy
+	if !p.allows(s) {
+		m.opts.Logf("taildrop: rejected file from %v: not an allowed sender", s.ID)
+		return ErrSenderNotAllowed
+	}
+	m.pendingMu.Lock()
+	mak.Set(&m.senderNames, s.ID, s.String())
+	m.pendingMu.Unlock()
+	if size <= 0 {
+		return nil
+	}
+
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	if p.MaxBytesPerSenderPerDay > 0 && m.senderUsageLocked(s.ID).bytes+size > p.MaxBytesPerSenderPerDay {
+		return ErrQuotaExceeded
+	}
+	if p.MaxInboxBytes > 0 && m.inboxUsageLocked()+size > p.MaxInboxBytes {
+		return ErrInboxFull
+	}
+	return nil
+}
+
+// chargeQuota records that n more bytes are being received from id,
+// returning an error without recording them if that would exceed a quota.
+func (m *Manager) chargeQuota(id ClientID, n int64) error {
+	p := &m.opts.Policy
+	if p.MaxBytesPerSenderPerDay <= 0 && p.MaxInboxBytes <= 0 {
+		return nil
+	}
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	var u *senderUsage
+	if p.MaxBytesPerSenderPerDay > 0 {
+		u = m.senderUsageLocked(id)
+		if u.bytes+n > p.MaxBytesPerSenderPerDay {
+			return ErrQuotaExceeded
+		}
+	}
+	if p.MaxInboxBytes > 0 {
+		if m.inboxUsageLocked()+n > p.MaxInboxBytes {
+			return ErrInboxFull
+		}
+		m.inboxBytes += n
+	}
+	if u != nil {
+		u.bytes += n
+	}
+	return nil
+}
+
+// senderUsageLocked returns the usage of id in its current day.
+// m.quotaMu must be held.
+func (m *Manager) senderUsageLocked(id ClientID) *senderUsage {
+	now := m.opts.Clock.Now()
+	u := m.senderBytes[id]
+	if u == nil || now.Sub(u.start) >= 24*time.Hour {
+		u = &senderUsage{start: now}
+		mak.Set(&m.senderBytes, id, u)
+	}
+	return u
+}
+
+// inboxUsageLocked returns the number of bytes counted against
+// MaxInboxBytes, computing it afresh if needed.
+// m.quotaMu must be held.
+func (m *Manager) inboxUsageLocked() int64 {
+	now := m.opts.Clock.Now()
+	if m.inboxValid.IsZero() || now.Sub(m.inboxValid) >= inboxRescanInterval {
+		var n int64
+		rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
+			if !m.opts.DirectFileMode || hasReservedSuffix(de.Name()) {
+				n += diskUsage(filepath.Join(m.opts.Dir, de.Name()))
+			}
+			return true
+		})
+		m.inboxBytes = n
+		m.inboxValid = now
+	}
+	return m.inboxBytes
+}
+
+// invalidateInboxUsage forces the size of the inbox to be computed
+// afresh, after files have been removed from it.
+func (m *Manager) invalidateInboxUsage() {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	m.inboxValid = time.Time{}
+}
+
+// diskUsage returns the total size of the regular files at or under path.
+func diskUsage(path string) (n int64) {
+	filepath.WalkDir(path, func(_ string, de fs.DirEntry, err error) error {
+		if err == nil && de.Type().IsRegular() {
+			if fi, err := de.Info(); err == nil {
+				n += fi.Size()
+			}
+		}
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,201 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package taildrop
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/util/must"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	alice := Sender{ID: "n1", Node: "laptop", LoginName: "alice@example.com"}
+	ci := Sender{ID: "n2", Node: "runner", Tags: []string{"tag:build", "tag:ci"}}
+	tests := []struct {
+		name    string
+		allowed []string
+		sender  Sender
+		want    bool
+	}{
+		{"empty_allows_all", nil, alice, true},
+		{"user", []string{"alice@example.com"}, alice, true},
+		{"user_case", []string{"Alice@Example.com"}, alice, true},
+		{"other_user", []string{"bob@example.com"}, alice, false},
+		{"tag", []string{"bob@example.com", "tag:ci"}, ci, true},
+		{"other_tag", []string{"tag:prod"}, ci, false},
+		{"tag_does_not_match_user", []string{"tag:ci"}, alice, false},
+		{"user_does_not_match_tagged", []string{"alice@example.com"}, ci, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{AllowedSenders: tt.allowed}
+			if got := p.allows(tt.sender); got != tt.want {
+				t.Errorf("allows(%v) = %v, want %v", tt.sender, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := alice.String(), "alice@example.com on laptop"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got, want := ci.String(), "tag:build,tag:ci on runner"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	clock := tstest.NewClock(tstest.ClockOpts{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	dir := t.TempDir()
+	m := ManagerOptions{
+		Logf:  t.Logf,
+		Clock: tstime.DefaultClock{Clock: clock},
+		Dir:   dir,
+		Policy: Policy{
+			MaxBytesPerSenderPerDay: 100,
+			MaxInboxBytes:           150,
+			AllowedSenders:          []string{"alice@example.com", "bob@example.com"},
+		},
+	}.New()
+	defer m.Shutdown()
+
+	alice := Sender{ID: "alice", LoginName: "alice@example.com"}
+	bob := Sender{ID: "bob", LoginName: "bob@example.com"}
+	put := func(s Sender, name string, size int) error {
+		if err := m.Admit(s, int64(size)); err != nil {
+			return err
+		}
+		_, err := m.PutFile(s.ID, name, strings.NewReader(strings.Repeat("x", size)), 0, int64(size), Digest{})
+		return err
+	}
+
+	if err := m.Admit(Sender{ID: "eve", LoginName: "eve@example.com"}, 1); !errors.Is(err, ErrSenderNotAllowed) {
+		t.Fatalf("Admit(eve) = %v, want %v", err, ErrSenderNotAllowed)
+	}
+	must.Do(put(alice, "a1", 60))
+	if err := put(alice, "a2", 60); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("second file from alice: got %v, want %v", err, ErrQuotaExceeded)
+	}
+	// An unknown size is admitted, but the quota is enforced as the data
+	// arrives. The partial file is kept, so it counts towards the inbox.
+	must.Do(m.Admit(alice, -1))
+	if _, err := m.PutFile(alice.ID, "a3", strings.NewReader(strings.Repeat("x", 60)), 0, -1, Digest{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("unsized file from alice: got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Bob has a separate daily quota, but the inbox is nearly full.
+	if err := put(bob, "b1", 95); !errors.Is(err, ErrInboxFull) {
+		t.Fatalf("first file from bob: got %v, want %v", err, ErrInboxFull)
+	}
+	must.Do(put(bob, "b2", 50))
+
+	// Deleting files frees space in the inbox, and a day later alice may
+	// send again.
+	must.Do(m.DeleteFile("a1"))
+	must.Do(m.DeleteFile("b2"))
+	if err := put(alice, "a4", 60); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("file from alice: got %v, want %v", err, ErrQuotaExceeded)
+	}
+	clock.Advance(24 * time.Hour)
+	must.Do(put(alice, "a4", 90))
+}
+
+func TestRequireConfirmation(t *testing.T) {
+	dir := t.TempDir()
+	m := ManagerOptions{
+		Logf:   t.Logf,
+		Dir:    dir,
+		Policy: Policy{RequireConfirmation: true},
+	}.New()
+	defer m.Shutdown()
+
+	alice := Sender{ID: "alice", Node: "laptop", LoginName: "alice@example.com"}
+	put := func(name, contents string) {
+		t.Helper()
+		must.Do(m.Admit(alice, int64(len(contents))))
+		if _, err := m.PutFile(alice.ID, name, strings.NewReader(contents), 0, int64(len(contents)), Digest{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waiting := func() (names []string) {
+		t.Helper()
+		for _, wf := range must.Get(m.WaitingFiles()) {
+			names = append(names, wf.Name)
+		}
+		return names
+	}
+
+	put("foo.txt", "one")
+	put("bar.txt", "two")
+	must.Do(m.Admit(alice, 3))
+	must.Get(m.BeginDir(alice.ID, "photos", apitype.DirManifest{Files: []apitype.DirFile{{Path: "a/b.jpg", Size: 3, SHA256: sha256Hex("jpg")}}}))
+	must.Get(m.PutDirFile(alice.ID, "photos", "a/b.jpg", strings.NewReader("jpg"), 0, 3))
+	must.Get(m.CommitDir(alice.ID, "photos"))
+
+	if got := waiting(); len(got) != 0 || m.HasFilesWaiting() {
+		t.Fatalf("pending files are waiting: %v", got)
+	}
+	pending := must.Get(m.PendingFiles())
+	if len(pending) != 3 {
+		t.Fatalf("got %d pending files, want 3: %+v", len(pending), pending)
+	}
+	for i, want := range []apitype.PendingFile{
+		{Name: "bar.txt", Size: 3},
+		{Name: "foo.txt", Size: 3},
+		{Name: "photos", Size: 3, IsDir: true},
+	} {
+		got := pending[i]
+		if got.Name != want.Name || got.Size != want.Size || got.IsDir != want.IsDir {
+			t.Errorf("pending file %d = %+v, want %+v", i, got, want)
+		}
+		if got.Sender != "alice@example.com on laptop" {
+			t.Errorf("pending file %d sender = %q", i, got.Sender)
+		}
+	}
+
+	// A pending file cannot be opened, but the pending suffix does not
+	// stop files being sent with names like "x.pending".
+	if _, _, err := m.OpenFile("foo.txt" + pendingSuffix); !errors.Is(err, ErrInvalidFileName) {
+		t.Fatalf("OpenFile of pending file: got %v, want %v", err, ErrInvalidFileName)
+	}
+	must.Get(m.PutFile(alice.ID, "x.pending", strings.NewReader(""), 0, 0, Digest{}))
+	must.Do(m.RejectFile("x.pending"))
+
+	// A file which already exists is accepted under a new name.
+	must.Do(os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("old"), 0644))
+	if got := must.Get(m.AcceptFile("foo.txt")); got != "foo (1).txt" {
+		t.Errorf("AcceptFile(foo.txt) = %q, want %q", got, "foo (1).txt")
+	}
+	must.Get(m.AcceptFile("photos"))
+	must.Do(m.RejectFile("bar.txt"))
+	if _, err := m.AcceptFile("bar.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("AcceptFile of rejected file: got %v, want not exist", err)
+	}
+	if got := must.Get(m.PendingFiles()); len(got) != 0 {
+		t.Errorf("files still pending: %+v", got)
+	}
+	if got, want := strings.Join(waiting(), ","), "foo (1).txt,foo.txt,photos/a/b.jpg"; got != want {
+		t.Errorf("waiting files = %q, want %q", got, want)
+	}
+	// The digests of accepted files, and of the files in accepted
+	// directories, are kept.
+	sums := map[string]string{}
+	for _, wf := range must.Get(m.WaitingFiles()) {
+		sums[wf.Name] = wf.SHA256
+	}
+	if got, want := sums["foo (1).txt"], sha256Hex("one"); got != want {
+		t.Errorf("accepted file SHA256 = %q, want %q", got, want)
+	}
+	if got, want := sums["photos/a/b.jpg"], sha256Hex("jpg"); got != want {
+		t.Errorf("accepted directory file SHA256 = %q, want %q", got, want)
+	}
+}
@@ -106,9 +106,15 @@ func (m *Manager) HashPartialFile(id ClientID, baseName string) (next func() (Bl
 		return nil, nil, redactError(err)
 	}
 
+	return hashBlocks(f), f.Close, nil
+}
+
+// hashBlocks returns a function that hashes the next block read from r.
+// It returns (BlockChecksum{}, io.EOF) when r is exhausted.
+func hashBlocks(r io.Reader) func() (BlockChecksum, error) {
 	b := make([]byte, blockSize) // TODO: Pool this?
-	next = func() (BlockChecksum, error) {
-		switch n, err := io.ReadFull(f, b); {
+	return func() (BlockChecksum, error) {
+		switch n, err := io.ReadFull(r, b); {
 		case err != nil && err != io.EOF && err != io.ErrUnexpectedEOF:
 			return BlockChecksum{}, redactError(err)
 		case n == 0:
@@ -117,8 +123,6 @@ func (m *Manager) HashPartialFile(id ClientID, baseName string) (next func() (Bl
 			return BlockChecksum{hash(b[:n]), hashAlgorithm, int64(n)}, nil
 		}
 	}
-	close = f.Close
-	return next, close, nil
 }
 
 // ResumeReader reads and discards the leading content of r
@@ -5,6 +5,7 @@
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"io"
 	"math/rand"
 	"os"
@@ -25,6 +26,7 @@ func TestResume(t *testing.T) {
 	rn := rand.New(rand.NewSource(0))
 	want := make([]byte, 12345)
 	must.Get(io.ReadFull(rn, want))
+	sum := Digest(sha256.Sum256(want))
 
 	t.Run("resume-noexist", func(t *testing.T) {
 		r := io.Reader(bytes.NewReader(want))
@@ -36,7 +38,7 @@ func TestResume(t *testing.T) {
 		must.Do(err)
 		must.Do(close()) // Windows wants the file handle to be closed to rename it.
 
-		must.Get(m.PutFile("", "foo", r, offset, -1))
+		must.Get(m.PutFile("", "foo", r, offset, -1, sum))
 		got := must.Get(os.ReadFile(must.Get(joinDir(m.opts.Dir, "foo"))))
 		if !bytes.Equal(got, want) {
 			t.Errorf("content mismatches")
@@ -59,7 +61,7 @@ func TestResume(t *testing.T) {
 			if offset < int64(len(want)) {
 				r = io.MultiReader(io.LimitReader(r, numWant), iotest.ErrReader(io.ErrClosedPipe))
 			}
-			if _, err := m.PutFile("", "bar", r, offset, -1); err == nil {
+			if _, err := m.PutFile("", "bar", r, offset, -1, sum); err == nil {
 				break
 			}
 			if i > 1000 {
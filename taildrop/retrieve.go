@@ -12,6 +12,7 @@
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
@@ -38,7 +39,12 @@ func (m *Manager) HasFilesWaiting() (has bool) {
 	// Check whether there is at least one one waiting file.
 	err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
-		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
+		if de.IsDir() && !hasReservedSuffix(name) {
+			// A received directory, which is removed once empty.
+			has = true
+			return false
+		}
+		if hasReservedSuffix(name) || !de.Type().IsRegular() {
 			return true
 		}
 		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
@@ -70,7 +76,11 @@ func (m *Manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 	}
 	if err := rangeDir(m.opts.Dir, func(de fs.DirEntry) bool {
 		name := de.Name()
-		if isPartialOrDeleted(name) || !de.Type().IsRegular() {
+		if de.IsDir() && !hasReservedSuffix(name) {
+			ret = append(ret, m.waitingDirFiles(name)...)
+			return true
+		}
+		if hasReservedSuffix(name) || !de.Type().IsRegular() {
 			return true
 		}
 		_, err := os.Stat(filepath.Join(m.opts.Dir, name+deletedSuffix))
@@ -79,20 +89,60 @@ func (m *Manager) WaitingFiles() (ret []apitype.WaitingFile, err error) {
 			if err != nil {
 				return true
 			}
-			ret = append(ret, apitype.WaitingFile{
-				Name: filepath.Base(name),
-				Size: fi.Size(),
-			})
+			ret = append(ret, m.waitingFile(name, fi))
 		}
 		return true
 	}); err != nil {
 		return nil, redactError(err)
 	}
 	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	m.forgetRemovedDigests()
 	return ret, nil
 }
 
+// waitingFile returns the WaitingFile for the file name, a slash-separated
+// path relative to Dir, whose info is fi.
+func (m *Manager) waitingFile(name string, fi fs.FileInfo) apitype.WaitingFile {
+	wf := apitype.WaitingFile{
+		Name: name,
+		Size: fi.Size(),
+	}
+	if sum, ok := m.knownDigest(name, fi); ok {
+		wf.SHA256 = sum.String()
+	}
+	return wf
+}
+
+// waitingDirFiles returns the files within the received directory dirName,
+// named by their slash-separated paths relative to Dir.
+func (m *Manager) waitingDirFiles(dirName string) (ret []apitype.WaitingFile) {
+	dir := m.opts.Dir
+	root := filepath.Join(dir, dirName)
+	filepath.WalkDir(root, func(path string, de fs.DirEntry, err error) error {
+		if err != nil || !de.Type().IsRegular() || hasReservedSuffix(de.Name()) {
+			return nil
+		}
+		if _, err := os.Stat(path + deletedSuffix); !os.IsNotExist(err) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return nil
+		}
+		ret = append(ret, m.waitingFile(filepath.ToSlash(rel), fi))
+		return nil
+	})
+	return ret
+}
+
 // DeleteFile deletes a file of the given baseName from [Handler.Dir].
+// The baseName may also be the slash-separated path of a file within a
+// received directory, as returned by WaitingFiles; directories left empty
+// by its deletion are removed.
 // This method is only allowed when [Handler.DirectFileMode] is false.
 func (m *Manager) DeleteFile(baseName string) error {
 	if m == nil || m.opts.Dir == "" {
@@ -101,10 +151,13 @@ func (m *Manager) DeleteFile(baseName string) error {
 	if m.opts.DirectFileMode {
 		return errors.New("deletes not allowed in direct mode")
 	}
-	path, err := joinDir(m.opts.Dir, baseName)
+	path, err := joinRelPath(m.opts.Dir, baseName)
 	if err != nil {
 		return err
 	}
+	defer removeEmptyParents(m.opts.Dir, path)
+	defer m.invalidateInboxUsage()
+	defer m.digests.Delete(baseName)
 	var bo *backoff.Backoff
 	logf := m.opts.Logf
 	t0 := m.opts.Clock.Now()
@@ -141,6 +194,16 @@ func (m *Manager) DeleteFile(baseName string) error {
 	}
 }
 
+// removeEmptyParents removes the directories containing path, up to but
+// not including dir, for as long as they are empty.
+func removeEmptyParents(dir, path string) {
+	for p := filepath.Dir(path); p != dir && strings.HasPrefix(p, dir); p = filepath.Dir(p) {
+		if os.Remove(p) != nil {
+			return
+		}
+	}
+}
+
 func touchFile(path string) error {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 	if err != nil {
@@ -150,6 +213,8 @@ func touchFile(path string) error {
 }
 
 // OpenFile opens a file of the given baseName from [Handler.Dir].
+// As with DeleteFile, baseName may be the path of a file within a
+// received directory.
 // This method is only allowed when [Handler.DirectFileMode] is false.
 func (m *Manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err error) {
 	if m == nil || m.opts.Dir == "" {
@@ -158,7 +223,7 @@ func (m *Manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 	if m.opts.DirectFileMode {
 		return nil, 0, errors.New("opens not allowed in direct mode")
 	}
-	path, err := joinDir(m.opts.Dir, baseName)
+	path, err := joinRelPath(m.opts.Dir, baseName)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -174,5 +239,9 @@ func (m *Manager) OpenFile(baseName string) (rc io.ReadCloser, size int64, err e
 		f.Close()
 		return nil, 0, redactError(err)
 	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, ErrInvalidFileName
+	}
 	return f, fi.Size(), nil
 }
@@ -27,10 +27,11 @@ type incomingFile struct {
 	clock tstime.DefaultClock
 
 	started        time.Time
-	size           int64     // or -1 if unknown; never 0
-	w              io.Writer // underlying writer
-	sendFileNotify func()    // called when done
-	partialPath    string    // non-empty in direct mode
+	size           int64             // or -1 if unknown; never 0
+	w              io.Writer         // underlying writer
+	sendFileNotify func()            // called when done
+	partialPath    string            // non-empty in direct mode
+	charge         func(int64) error // called before each write, to enforce quotas
 
 	mu         sync.Mutex
 	copied     int64
@@ -39,6 +40,9 @@ type incomingFile struct {
 }
 
 func (f *incomingFile) Write(p []byte) (n int, err error) {
+	if err := f.charge(int64(len(p))); err != nil {
+		return 0, err
+	}
 	n, err = f.w.Write(p)
 
 	var needNotify bool
@@ -72,7 +76,15 @@ func (f *incomingFile) Write(p []byte) (n int, err error) {
 // specific partial file. This allows the client to determine whether to resume
 // a partial file. While resuming, PutFile may be called again with a non-zero
 // offset to specify where to resume receiving data at.
-func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, length int64) (int64, error) {
+//
+// If want is non-zero, it is the digest of the entire file, which is
+// verified once the file has been received; if it does not match, the
+// partial file is discarded and ErrDigestMismatch is returned.
+//
+// The [Policy] quotas are enforced as data is received, but the sender
+// must first be checked with Admit. With [Policy.RequireConfirmation],
+// the received file is pending until accepted with AcceptFile.
+func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, length int64, want Digest) (int64, error) {
 	switch {
 	case m == nil || m.opts.Dir == "":
 		return 0, ErrNoTaildrop
@@ -92,6 +104,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		return err
 	}
 
+	sender := id
 	avoidPartialRename := m.opts.DirectFileMode && m.opts.AvoidFinalRename
 	if avoidPartialRename {
 		// Users using AvoidFinalRename are depending on the exact filename
@@ -108,6 +121,7 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 			started:        m.opts.Clock.Now(),
 			size:           length,
 			sendFileNotify: m.opts.SendFileNotify,
+			charge:         func(n int64) error { return m.chargeQuota(sender, n) },
 		}
 		if m.opts.DirectFileMode {
 			inFile.partialPath = partialPath
@@ -137,19 +151,15 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 	}()
 	inFile.w = f
 
-	// Record that we have started to receive at least one file.
-	// This is used by the deleter upon a cold-start to scan the directory
-	// for any files that need to be deleted.
-	if m.opts.State != nil {
-		if b, _ := m.opts.State.ReadState(ipn.TaildropReceivedKey); len(b) == 0 {
-			if err := m.opts.State.WriteState(ipn.TaildropReceivedKey, []byte{1}); err != nil {
-				m.opts.Logf("WriteState error: %v", err) // non-fatal error
-			}
-		}
-	}
+	m.markReceived()
+
+	// The contents are hashed as they are received, to verify them against
+	// the sender's digest and to detect duplicate files.
+	h := sha256.New()
 
 	// A positive offset implies that we are resuming an existing file.
-	// Seek to the appropriate offset and truncate the file.
+	// Seek to the appropriate offset and truncate the file, and hash the
+	// part which was already received.
 	if offset != 0 {
 		currLength, err := f.Seek(0, io.SeekEnd)
 		if err != nil {
@@ -164,10 +174,13 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		if err := f.Truncate(offset); err != nil {
 			return 0, redactAndLogError("Truncate", err)
 		}
+		if _, err := io.Copy(h, io.NewSectionReader(f, 0, offset)); err != nil {
+			return 0, redactAndLogError("Hash", err)
+		}
 	}
 
 	// Copy the contents of the file.
-	copyLength, err := io.Copy(inFile, r)
+	copyLength, err := io.Copy(inFile, io.TeeReader(r, h))
 	if err != nil {
 		return 0, redactAndLogError("Copy", err)
 	}
@@ -178,6 +191,13 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		return 0, redactAndLogError("Close", err)
 	}
 	fileLength := offset + copyLength
+	var sum Digest
+	h.Sum(sum[:0])
+	if !want.IsZero() && sum != want {
+		// Resuming would only reproduce the same contents, so start over.
+		os.Remove(partialPath)
+		return 0, redactAndLogError("Verify", ErrDigestMismatch)
+	}
 
 	// Return early for avoidPartialRename since users of AvoidFinalRename
 	// are depending on the exact naming of partial files.
@@ -191,11 +211,13 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 	}
 
 	// File has been successfully received, rename the partial file
-	// to the final destination filename. If a file of that name already exists,
+	// to the final destination filename (or its pending name, if it must
+	// be confirmed). If a file of that name already exists,
 	// then try multiple times with variations of the filename.
-	computePartialSum := sync.OnceValues(func() ([sha256.Size]byte, error) {
-		return sha256File(partialPath)
-	})
+	var suffix string
+	if m.opts.Policy.RequireConfirmation {
+		suffix = pendingSuffix
+	}
 	maxRetries := 10
 	for ; maxRetries > 0; maxRetries-- {
 		// Atomically rename the partial file as the destination file if it doesn't exist.
@@ -204,9 +226,9 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 		dstLength, err := func() (int64, error) {
 			m.renameMu.Lock()
 			defer m.renameMu.Unlock()
-			switch fi, err := os.Stat(dstPath); {
+			switch fi, err := os.Stat(dstPath + suffix); {
 			case os.IsNotExist(err):
-				return -1, os.Rename(partialPath, dstPath)
+				return -1, os.Rename(partialPath, dstPath+suffix)
 			case err != nil:
 				return -1, err
 			default:
@@ -222,15 +244,11 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 
 		// Avoid the final rename if a destination file has the same contents.
 		if dstLength == fileLength {
-			partialSum, err := computePartialSum()
-			if err != nil {
-				return 0, redactAndLogError("Rename", err)
-			}
-			dstSum, err := sha256File(dstPath)
+			dstSum, err := sha256File(dstPath + suffix)
 			if err != nil {
 				return 0, redactAndLogError("Rename", err)
 			}
-			if dstSum == partialSum {
+			if dstSum == sum {
 				if err := os.Remove(partialPath); err != nil {
 					return 0, redactAndLogError("Remove", err)
 				}
@@ -244,11 +262,28 @@ func (m *Manager) PutFile(id ClientID, baseName string, r io.Reader, offset, len
 	if maxRetries <= 0 {
 		return 0, errors.New("too many retries trying to rename partial file")
 	}
+	m.noteDigest(filepath.Base(dstPath)+suffix, sum)
+	if suffix != "" {
+		m.notePending(filepath.Base(dstPath), sender)
+	}
 	m.totalReceived.Add(1)
 	m.opts.SendFileNotify()
 	return fileLength, nil
 }
 
+// markReceived records that we have started to receive at least one file.
+// This is used by the deleter upon a cold-start to scan the directory
+// for any files that need to be deleted.
+func (m *Manager) markReceived() {
+	if m.opts.State != nil {
+		if b, _ := m.opts.State.ReadState(ipn.TaildropReceivedKey); len(b) == 0 {
+			if err := m.opts.State.WriteState(ipn.TaildropReceivedKey, []byte{1}); err != nil {
+				m.opts.Logf("WriteState error: %v", err) // non-fatal error
+			}
+		}
+	}
+}
+
 func sha256File(file string) (out [sha256.Size]byte, err error) {
 	h := sha256.New()
 	f, err := os.Open(file)
@@ -22,6 +22,7 @@
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -51,6 +52,13 @@
 	// permitted to be uploaded directly on any platform, like
 	// partial files.
 	deletedSuffix = ".deleted"
+
+	// pendingSuffix is the suffix for files (and directories) which have
+	// been completely received, but are waiting for the user to accept
+	// them; see [Policy.RequireConfirmation]. It ends in partialSuffix so
+	// that it does not reserve any more names, as pending files are no
+	// more visible than partial ones.
+	pendingSuffix = ".pending" + partialSuffix
 )
 
 // ClientID is an opaque identifier for file resumption.
@@ -104,6 +112,10 @@ type ManagerOptions struct {
 	// to the function when reception completes.
 	// It is not called if nil.
 	SendFileNotify func()
+
+	// Policy restricts which files are accepted.
+	// The zero value accepts all files.
+	Policy Policy
 }
 
 // Manager manages the state for receiving and managing taildropped files.
@@ -112,12 +124,29 @@ type Manager struct {
 
 	// incomingFiles is a map of files actively being received.
 	incomingFiles syncs.Map[incomingFileKey, *incomingFile]
+	// digests caches the digests of received files, by their
+	// slash-separated paths relative to Dir.
+	digests syncs.Map[string, cachedDigest]
 	// deleter managers asynchronous deletion of files.
 	deleter fileDeleter
 
 	// renameMu is used to protect os.Rename calls so that they are atomic.
 	renameMu sync.Mutex
 
+	// dirMu serializes changes to the manifests of directory transfers.
+	dirMu sync.Mutex
+
+	// quotaMu guards the fields used to enforce Policy quotas.
+	quotaMu     sync.Mutex
+	senderBytes map[ClientID]*senderUsage // per-sender usage in the current day
+	inboxBytes  int64                     // estimated bytes used in Dir
+	inboxValid  time.Time                 // when inboxBytes was last computed; zero if invalid
+
+	// pendingMu guards senderNames and pendingSenders.
+	pendingMu      sync.Mutex
+	senderNames    map[ClientID]string // description of each admitted sender
+	pendingSenders map[string]string   // sender of each pending file, by name
+
 	// totalReceived counts the cumulative total of received files.
 	totalReceived atomic.Int64
 	// emptySince specifies that there were no waiting files
@@ -135,6 +164,12 @@ func (opts ManagerOptions) New() *Manager {
 	if opts.SendFileNotify == nil {
 		opts.SendFileNotify = func() {}
 	}
+	if opts.Policy.RequireConfirmation && opts.DirectFileMode && opts.AvoidFinalRename {
+		// Files are used as soon as they are received, as partial files,
+		// so there is no opportunity to confirm them.
+		opts.Logf("taildrop: RequireConfirmation is not supported with AvoidFinalRename; ignoring")
+		opts.Policy.RequireConfirmation = false
+	}
 	m := &Manager{opts: opts}
 	m.deleter.Init(m, func(string) {})
 	m.emptySince.Store(-1) // invalidate this cache
@@ -172,7 +207,7 @@ func validFilenameRune(r rune) bool {
 	return unicode.IsPrint(r)
 }
 
-func isPartialOrDeleted(s string) bool {
+func hasReservedSuffix(s string) bool {
 	return strings.HasSuffix(s, deletedSuffix) || strings.HasSuffix(s, partialSuffix)
 }
 
@@ -190,7 +225,7 @@ func joinDir(dir, baseName string) (fullPath string, err error) {
 	clean := path.Clean(baseName)
 	if clean != baseName ||
 		clean == "." || clean == ".." ||
-		isPartialOrDeleted(clean) {
+		hasReservedSuffix(clean) {
 		return "", ErrInvalidFileName
 	}
 	for _, r := range baseName {
@@ -162,6 +162,7 @@ func (a *AUM) StaticValidate() error {
 		return errors.New("absent parent must be represented by a nil slice")
 	}
 	for i, sig := range a.Signatures {
+		// Ed25519 and P-256 signatures are the same size.
 		if len(sig.KeyID) != 32 || len(sig.Signature) != ed25519.SignatureSize {
 			return fmt.Errorf("signature %d has missing keyID or malformed signature", i)
 		}
@@ -293,6 +294,19 @@ func (a *AUM) Parent() (h AUMHash, ok bool) {
 	return h, false
 }
 
+// Sign appends the signatures computed by signer over the AUM.
+//
+// It is used to co-sign AUMs which need signatures from several trusted
+// keys, such as those from UpdateBuilder.FinalizeProposal.
+func (a *AUM) Sign(signer Signer) error {
+	sigs, err := signer.SignAUM(a.SigHash())
+	if err != nil {
+		return fmt.Errorf("signing failed: %v", err)
+	}
+	a.Signatures = append(a.Signatures, sigs...)
+	return nil
+}
+
 func (a *AUM) sign25519(priv ed25519.PrivateKey) error {
 	key := Key{Kind: Key25519, Public: priv.Public().(ed25519.PublicKey)}
 	sigHash := a.SigHash()
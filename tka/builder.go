@@ -4,6 +4,7 @@
 package tka
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -97,6 +98,25 @@ func (b *UpdateBuilder) SetKeyMeta(keyID tkatype.KeyID, meta map[string]string)
 	return b.mkUpdate(AUM{MessageKind: AUMUpdateKey, Meta: meta, KeyID: keyID})
 }
 
+// SetKeyChangeThreshold sets the number of distinct trusted keys which must
+// sign future changes to the trusted keys. See State.KeyChangeThreshold.
+//
+// The change is made with a checkpoint, which must itself meet the current
+// threshold.
+func (b *UpdateBuilder) SetKeyChangeThreshold(threshold uint) error {
+	if threshold > uint(len(b.state.Keys)) {
+		return fmt.Errorf("threshold %d exceeds the number of trusted keys (%d)", threshold, len(b.state.Keys))
+	}
+	if threshold == b.state.KeyChangeThreshold {
+		return fmt.Errorf("threshold is already %d", threshold)
+	}
+	state := b.state.Clone()
+	state.KeyChangeThreshold = threshold
+	// Checkpoints cant specify a parent AUM.
+	state.LastAUMHash = nil
+	return b.mkUpdate(AUM{MessageKind: AUMCheckpoint, State: &state})
+}
+
 func (b *UpdateBuilder) generateCheckpoint() error {
 	// Compute the checkpoint state.
 	state := b.a.state
@@ -161,6 +181,45 @@ func (b *UpdateBuilder) Finalize(storage Chonk) ([]AUM, error) {
 	return b.out, nil
 }
 
+// FinalizeProposal returns a single AUM which actuates all the changes made
+// with the builder, signed by the builder's signer.
+//
+// Use this rather than Finalize when changes must be signed by more than one
+// trusted key (see Authority.KeyChangeThreshold). Signatures cover the
+// whole AUM, so a chain of several AUMs cannot be co-signed; instead, a
+// proposal carrying several changes is a checkpoint of the resulting state.
+// Other trusted keys co-sign the proposal with AUM.Sign, and once
+// Authority.ProposalSignatures reports it has enough signatures it can be
+// applied with Inform.
+func (b *UpdateBuilder) FinalizeProposal() (*AUM, error) {
+	switch len(b.out) {
+	case 0:
+		return nil, errors.New("no changes to propose")
+	case 1:
+		out := b.out[0]
+		return &out, nil
+	}
+
+	state := b.state.Clone()
+	// Checkpoints cant specify a parent AUM.
+	state.LastAUMHash = nil
+	parent := b.a.Head()
+	proposal := &AUM{
+		MessageKind: AUMCheckpoint,
+		State:       &state,
+		PrevAUMHash: parent[:],
+	}
+	if b.signer != nil {
+		if err := proposal.Sign(b.signer); err != nil {
+			return nil, err
+		}
+	}
+	if err := proposal.StaticValidate(); err != nil {
+		return nil, fmt.Errorf("generated proposal was invalid: %v", err)
+	}
+	return proposal, nil
+}
+
 // NewUpdater returns a builder you can use to make changes to
 // the tailnet key authority.
 //
@@ -5,6 +5,7 @@
 
 import (
 	"crypto/ed25519"
+	"errors"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -268,3 +269,133 @@ func TestAuthorityBuilderCheckpointsAfterXUpdates(t *testing.T) {
 		t.Errorf("stored and computed HEAD differ: got %v, want %v", a2.Head(), a.Head())
 	}
 }
+
+func TestAuthorityBuilderKeyChangeThreshold(t *testing.T) {
+	pub1, priv1 := testingKey25519(t, 1)
+	key1 := Key{Kind: Key25519, Public: pub1, Votes: 1}
+	pub2, priv2 := testingKey25519(t, 2)
+	key2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+	pub3, _ := testingKey25519(t, 3)
+	key3 := Key{Kind: Key25519, Public: pub3, Votes: 1}
+	pub4, _ := testingKey25519(t, 4)
+	key4 := Key{Kind: Key25519, Public: pub4, Votes: 1}
+
+	storage := &Mem{}
+	a, _, err := Create(storage, State{
+		Keys:               []Key{key1, key2, key3},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+		KeyChangeThreshold: 2,
+	}, signer25519(priv1))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+	if got, want := a.KeyChangeThreshold(), 2; got != want {
+		t.Errorf("KeyChangeThreshold() = %d, want %d", got, want)
+	}
+
+	// A single signature is not enough to add a key.
+	b := a.NewUpdater(signer25519(priv1))
+	if err := b.AddKey(key4); err != nil {
+		t.Fatalf("AddKey(%v) failed: %v", key4, err)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); !errors.Is(err, ErrThresholdNotMet) {
+		t.Fatalf("Inform() with one signature = %v, want %v", err, ErrThresholdNotMet)
+	}
+
+	// Nor can the threshold be lowered with a single signature.
+	b = a.NewUpdater(signer25519(priv1))
+	if err := b.SetKeyChangeThreshold(1); err != nil {
+		t.Fatalf("SetKeyChangeThreshold(1) failed: %v", err)
+	}
+	updates, err = b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); !errors.Is(err, ErrThresholdNotMet) {
+		t.Fatalf("Inform() lowering threshold = %v, want %v", err, ErrThresholdNotMet)
+	}
+
+	// A co-signed proposal succeeds.
+	b = a.NewUpdater(signer25519(priv1))
+	if err := b.AddKey(key4); err != nil {
+		t.Fatalf("AddKey(%v) failed: %v", key4, err)
+	}
+	if err := b.RemoveKey(key3.MustID()); err != nil {
+		t.Fatalf("RemoveKey(%v) failed: %v", key3, err)
+	}
+	proposal, err := b.FinalizeProposal()
+	if err != nil {
+		t.Fatalf("FinalizeProposal() failed: %v", err)
+	}
+	if have, need, err := a.ProposalSignatures(*proposal); err != nil || have != 1 || need != 2 {
+		t.Fatalf("ProposalSignatures() = %d, %d, %v; want 1, 2, nil", have, need, err)
+	}
+	// Signing twice with the same key does not count twice.
+	if err := proposal.Sign(signer25519(priv1)); err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	if err := a.Inform(storage, []AUM{*proposal}); !errors.Is(err, ErrThresholdNotMet) {
+		t.Fatalf("Inform() with duplicate signature = %v, want %v", err, ErrThresholdNotMet)
+	}
+	if err := proposal.Sign(signer25519(priv2)); err != nil {
+		t.Fatalf("Sign() failed: %v", err)
+	}
+	if have, need, err := a.ProposalSignatures(*proposal); err != nil || have != 2 || need != 2 {
+		t.Fatalf("ProposalSignatures() = %d, %d, %v; want 2, 2, nil", have, need, err)
+	}
+	if err := a.Inform(storage, []AUM{*proposal}); err != nil {
+		t.Fatalf("could not apply co-signed proposal: %v", err)
+	}
+	if !a.KeyTrusted(key4.MustID()) || a.KeyTrusted(key3.MustID()) {
+		t.Errorf("proposal was not applied: keys = %v", a.Keys())
+	}
+	if got, want := a.KeyChangeThreshold(), 2; got != want {
+		t.Errorf("KeyChangeThreshold() = %d, want %d", got, want)
+	}
+
+	// Checkpoints which do not change the keys need only one signature.
+	b = a.NewUpdater(signer25519(priv1))
+	if err := b.generateCheckpoint(); err != nil {
+		t.Fatalf("generateCheckpoint() failed: %v", err)
+	}
+	updates, err = b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatalf("could not apply checkpoint: %v", err)
+	}
+
+	// Nor can a single signature replace the disablement secrets.
+	b = a.NewUpdater(signer25519(priv1))
+	state := a.state.Clone()
+	state.LastAUMHash = nil
+	state.DisablementSecrets = [][]byte{DisablementKDF([]byte{4, 5, 6})}
+	if err := b.mkUpdate(AUM{MessageKind: AUMCheckpoint, State: &state}); err != nil {
+		t.Fatalf("mkUpdate() failed: %v", err)
+	}
+	updates, err = b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); !errors.Is(err, ErrThresholdNotMet) {
+		t.Fatalf("Inform() replacing disablement secrets = %v, want %v", err, ErrThresholdNotMet)
+	}
+	// Checkpoints which change the state ID are rejected regardless, but
+	// it is part of the key policy too.
+	state = a.state.Clone()
+	state.StateID1++
+	if a.state.sameKeyPolicy(state) {
+		t.Error("sameKeyPolicy() = true for a different state ID")
+	}
+
+	// The threshold cannot exceed the number of keys.
+	b = a.NewUpdater(signer25519(priv1))
+	if err := b.SetKeyChangeThreshold(4); err == nil {
+		t.Error("SetKeyChangeThreshold(4) succeeded with 3 keys, want error")
+	}
+}
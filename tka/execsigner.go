@@ -0,0 +1,122 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"tailscale.com/types/tkatype"
+)
+
+// ExecSigner is a Signer which delegates signing to an external process,
+// so the private key is never held in memory by the caller. For instance,
+// the process might be a small program which signs using a key held in a
+// hardware security module, by way of PKCS#11.
+//
+// For each signature, the command is run with the hex-encoded digest to
+// sign, followed by a newline, on its standard input. The command must
+// sign the 32-byte digest as given, rather than a hash of it, and write the
+// hex-encoded signature to its standard output. Signatures by P-256 keys
+// may be in either ASN.1 DER or r||s form: they are converted to the form
+// used by tailnet lock.
+type ExecSigner struct {
+	// Key is the public key corresponding to the private key the
+	// command signs with.
+	Key Key
+	// Command is the path to the signing program.
+	Command string
+	// Args are the arguments passed to the signing program.
+	Args []string
+	// Env specifies additional environment variables for the signing
+	// program, in the form "key=value".
+	Env []string
+	// Timeout bounds how long the signing program may run. If zero,
+	// a default of one minute is used.
+	Timeout time.Duration
+}
+
+// SignAUM implements Signer.
+func (s *ExecSigner) SignAUM(sigHash tkatype.AUMSigHash) ([]tkatype.Signature, error) {
+	keyID, err := s.Key.ID()
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.sign(sigHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return []tkatype.Signature{{
+		KeyID:     keyID,
+		Signature: sig,
+	}}, nil
+}
+
+// sign runs the signing program over digest, returning the signature
+// after checking it is valid for s.Key.
+func (s *ExecSigner) sign(digest []byte) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command, s.Args...)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(digest) + "\n")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if len(s.Env) > 0 {
+		cmd.Env = append(os.Environ(), s.Env...)
+	}
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("running signer: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("running signer: %v", err)
+	}
+
+	sig, err := hex.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signer output: %v", err)
+	}
+	if s.Key.Kind == KeyP256 {
+		if sig, err = normalizeP256Signature(sig); err != nil {
+			return nil, err
+		}
+	}
+	// Catch misconfiguration (such as the wrong key) here, rather than
+	// when the signature is rejected by another node.
+	if err := s.Key.verify(digest, sig); err != nil {
+		return nil, fmt.Errorf("signer produced an invalid signature: %v", err)
+	}
+	return sig, nil
+}
+
+// normalizeP256Signature converts a P-256 signature in either ASN.1 DER or
+// r||s form into the form used by tailnet lock.
+func normalizeP256Signature(sig []byte) ([]byte, error) {
+	var der struct {
+		R, S *big.Int
+	}
+	if rest, err := asn1.Unmarshal(sig, &der); err == nil && len(rest) == 0 {
+		return MarshalP256Signature(der.R, der.S)
+	}
+	if len(sig) != p256SignatureSize {
+		return nil, errors.New("signer output is neither an ASN.1 nor an r||s signature")
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	return MarshalP256Signature(r, s)
+}
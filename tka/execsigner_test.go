@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"tailscale.com/types/tkatype"
+)
+
+// TestExecSignerHelperProcess is not a real test: it is run as the
+// signing program by TestExecSigner.
+func TestExecSignerHelperProcess(t *testing.T) {
+	keyHex := os.Getenv("TS_TEST_EXEC_SIGNER_KEY")
+	if keyHex == "" {
+		t.Skip("not running as a signing program")
+	}
+	der, err := hex.DecodeString(keyHex)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	priv, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	digest, err := hex.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, digest)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%x\n", sig)
+	os.Exit(0)
+}
+
+func TestExecSigner(t *testing.T) {
+	key, priv := testingKeyP256(t)
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &ExecSigner{
+		Key:     key,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^TestExecSignerHelperProcess$"},
+		Env:     []string{"TS_TEST_EXEC_SIGNER_KEY=" + hex.EncodeToString(der)},
+	}
+
+	storage := &Mem{}
+	a, _, err := Create(storage, State{
+		Keys:               []Key{key},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer)
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	pub2, _ := testingKey25519(t, 2)
+	key2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+	b := a.NewUpdater(signer)
+	if err := b.AddKey(key2); err != nil {
+		t.Fatalf("AddKey(%v) failed: %v", key2, err)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatalf("could not apply generated updates: %v", err)
+	}
+	if !a.KeyTrusted(key2.MustID()) {
+		t.Error("added key is not trusted")
+	}
+
+	// A signer using the wrong key is caught before its signature is used.
+	wrongKey, _ := testingKeyP256(t)
+	signer.Key = wrongKey
+	if _, err := signer.SignAUM(tkatype.AUMSigHash{1, 2, 3}); err == nil {
+		t.Error("SignAUM() with mismatched key succeeded, want error")
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+)
+
+// ExportedAUM is a single AUM in an exported chain.
+//
+// Chains are exported as JSON lines: one ExportedAUM per line, with
+// parents before their children. Use Authority.Export and WriteExport to
+// produce an export, and ReadExport and ImportChain to verify one.
+type ExportedAUM struct {
+	// Hash is the hash of the AUM. It is checked when the export is read.
+	Hash AUMHash
+	// AUM is the serialized AUM.
+	AUM []byte
+	// CommitTime is when the exporting node stored the AUM, if known.
+	// It is informational only, and cannot be verified.
+	CommitTime *time.Time `json:",omitempty"`
+}
+
+// committer is implemented by Chonks which record when AUMs were stored.
+type committer interface {
+	CommitTime(AUMHash) (time.Time, error)
+}
+
+// Export returns all AUMs descending from the oldest ancestor of the
+// authority, including those in forks which are not part of the active
+// chain. Parents are returned before their children.
+func (a *Authority) Export(storage Chonk) ([]ExportedAUM, error) {
+	ct, _ := storage.(committer)
+
+	var out []ExportedAUM
+	seen := make(map[AUMHash]bool)
+	queue := []AUM{a.oldestAncestor}
+	for len(queue) > 0 {
+		aum := queue[0]
+		queue = queue[1:]
+		hash := aum.Hash()
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		e := ExportedAUM{Hash: hash, AUM: aum.Serialize()}
+		if ct != nil {
+			if t, err := ct.CommitTime(hash); err == nil && !t.IsZero() {
+				e.CommitTime = &t
+			}
+		}
+		out = append(out, e)
+
+		children, err := storage.ChildAUMs(hash)
+		if err != nil {
+			return nil, fmt.Errorf("reading children of %v: %v", hash, err)
+		}
+		// Sort siblings so exports of the same storage are identical.
+		slices.SortFunc(children, func(a, b AUM) int {
+			ah, bh := a.Hash(), b.Hash()
+			return bytes.Compare(ah[:], bh[:])
+		})
+		queue = append(queue, children...)
+	}
+	return out, nil
+}
+
+// WriteExport writes the exported AUMs to w as JSON lines.
+func WriteExport(w io.Writer, aums []ExportedAUM) error {
+	enc := json.NewEncoder(w)
+	for _, e := range aums {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxExportLineSize bounds the size of a single line of an export.
+const maxExportLineSize = 1 << 20
+
+// ReadExport reads AUMs exported by WriteExport, checking that each AUM
+// is well-formed and matches its hash.
+func ReadExport(r io.Reader) ([]ExportedAUM, []AUM, error) {
+	var (
+		exported []ExportedAUM
+		aums     []AUM
+	)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxExportLineSize)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		var e ExportedAUM
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		var aum AUM
+		if err := aum.Unserialize(e.AUM); err != nil {
+			return nil, nil, fmt.Errorf("line %d: decoding AUM: %v", line, err)
+		}
+		if err := aum.StaticValidate(); err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid AUM: %v", line, err)
+		}
+		if got := aum.Hash(); got != e.Hash {
+			return nil, nil, fmt.Errorf("line %d: AUM hash is %v, want %v", line, got, e.Hash)
+		}
+		exported = append(exported, e)
+		aums = append(aums, aum)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(aums) == 0 {
+		return nil, nil, errors.New("no AUMs in export")
+	}
+	return exported, aums, nil
+}
+
+// ImportChain replays the given AUMs, as returned by ReadExport, into
+// storage, verifying each one. The first AUM must be a checkpoint, which is
+// trusted as the starting point of the chain; callers should check its hash
+// against a known value (such as the genesis AUM) where possible.
+//
+// The storage must be empty.
+func ImportChain(storage Chonk, aums []AUM) (*Authority, error) {
+	if len(aums) == 0 {
+		return nil, errors.New("no AUMs to import")
+	}
+	a, err := Bootstrap(storage, aums[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(aums) > 1 {
+		if err := a.Inform(storage, aums[1:]); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// KeyChangeKind describes how a trusted key was changed by an AUM.
+type KeyChangeKind string
+
+// Valid KeyChangeKind values.
+const (
+	KeyAdded   KeyChangeKind = "add"
+	KeyRemoved KeyChangeKind = "remove"
+	KeyUpdated KeyChangeKind = "update"
+)
+
+// KeyChange describes a change to a trusted key.
+type KeyChange struct {
+	Kind KeyChangeKind
+	// Key is the key after the change, or before it for KeyRemoved.
+	Key Key
+	// PrevVotes and PrevMeta are the votes and metadata of the key
+	// before the change. They are only set for KeyUpdated.
+	PrevVotes uint              `json:",omitempty"`
+	PrevMeta  map[string]string `json:",omitempty"`
+}
+
+// AuditEntry describes an AUM in the active chain, and its effect on the
+// trusted keys.
+type AuditEntry struct {
+	Hash AUMHash
+	AUM  AUM
+	// CommitTime is when the AUM was stored, if known.
+	CommitTime time.Time
+	// KeyChanges describes the changes the AUM made to the trusted keys.
+	// For the oldest AUM in the chain, every key is reported as added.
+	KeyChanges []KeyChange
+	// PrevKeyChangeThreshold and KeyChangeThreshold are the values of
+	// State.KeyChangeThreshold before and after the AUM.
+	PrevKeyChangeThreshold, KeyChangeThreshold uint
+}
+
+// Audit returns an AuditEntry for each AUM in the active chain, from the
+// oldest ancestor to the head.
+//
+// If storage records when AUMs were stored (as FS does), CommitTime is
+// populated.
+func (a *Authority) Audit(storage Chonk) ([]AuditEntry, error) {
+	// Walk back from the head to find the AUMs in the active chain.
+	oldest := a.oldestAncestor.Hash()
+	chain := []AUM{a.head}
+	for cursor := a.head; cursor.Hash() != oldest; {
+		parent, hasParent := cursor.Parent()
+		if !hasParent {
+			return nil, errors.New("active chain does not include the oldest ancestor")
+		}
+		var err error
+		if cursor, err = storage.AUM(parent); err != nil {
+			return nil, fmt.Errorf("reading %v: %w", parent, err)
+		}
+		chain = append(chain, cursor)
+	}
+	slices.Reverse(chain)
+
+	ct, _ := storage.(committer)
+	out := make([]AuditEntry, 0, len(chain))
+	var state State
+	for i, aum := range chain {
+		var next State
+		var err error
+		if i == 0 {
+			next, err = computeStateAt(storage, maxScanIterations, aum.Hash())
+		} else {
+			next, err = state.applyVerifiedAUM(aum)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("computing state at %v: %v", aum.Hash(), err)
+		}
+
+		e := AuditEntry{
+			Hash:                   aum.Hash(),
+			AUM:                    aum,
+			KeyChanges:             diffKeys(state, next),
+			PrevKeyChangeThreshold: state.KeyChangeThreshold,
+			KeyChangeThreshold:     next.KeyChangeThreshold,
+		}
+		if ct != nil {
+			if t, err := ct.CommitTime(e.Hash); err == nil {
+				e.CommitTime = t
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		out = append(out, e)
+		state = next
+	}
+	return out, nil
+}
+
+// diffKeys returns the changes to the trusted keys between two states.
+func diffKeys(before, after State) []KeyChange {
+	var out []KeyChange
+	for _, k := range after.Keys {
+		id, err := k.ID()
+		if err != nil {
+			continue
+		}
+		prev, err := before.GetKey(id)
+		switch {
+		case err != nil:
+			out = append(out, KeyChange{Kind: KeyAdded, Key: k})
+		case prev.Votes != k.Votes || !maps.Equal(prev.Meta, k.Meta):
+			out = append(out, KeyChange{
+				Kind:      KeyUpdated,
+				Key:       k,
+				PrevVotes: prev.Votes,
+				PrevMeta:  prev.Meta,
+			})
+		}
+	}
+	for _, k := range before.Keys {
+		id, err := k.ID()
+		if err != nil {
+			continue
+		}
+		if _, err := after.GetKey(id); err != nil {
+			out = append(out, KeyChange{Kind: KeyRemoved, Key: k})
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExportImportAudit(t *testing.T) {
+	pub1, priv1 := testingKey25519(t, 1)
+	key1 := Key{Kind: Key25519, Public: pub1, Votes: 2}
+	pub2, _ := testingKey25519(t, 2)
+	key2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+
+	storage := &Mem{}
+	a, genesis, err := Create(storage, State{
+		Keys:               []Key{key1},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer25519(priv1))
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	b := a.NewUpdater(signer25519(priv1))
+	if err := b.AddKey(key2); err != nil {
+		t.Fatalf("AddKey() failed: %v", err)
+	}
+	if err := b.SetKeyVote(key2.MustID(), 3); err != nil {
+		t.Fatalf("SetKeyVote() failed: %v", err)
+	}
+	if err := b.RemoveKey(key2.MustID()); err != nil {
+		t.Fatalf("RemoveKey() failed: %v", err)
+	}
+	updates, err := b.Finalize(storage)
+	if err != nil {
+		t.Fatalf("Finalize() failed: %v", err)
+	}
+	if err := a.Inform(storage, updates); err != nil {
+		t.Fatalf("Inform() failed: %v", err)
+	}
+
+	exported, err := a.Export(storage)
+	if err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if got, want := len(exported), 1+len(updates); got != want {
+		t.Fatalf("Export() returned %d AUMs, want %d", got, want)
+	}
+	var buf bytes.Buffer
+	if err := WriteExport(&buf, exported); err != nil {
+		t.Fatalf("WriteExport() failed: %v", err)
+	}
+
+	// Tampering with an AUM must be detected.
+	tampered := strings.Replace(buf.String(), exported[1].Hash.String(), genesis.Hash().String(), 1)
+	if _, _, err := ReadExport(strings.NewReader(tampered)); err == nil {
+		t.Error("ReadExport() of tampered export succeeded, want error")
+	}
+
+	_, aums, err := ReadExport(&buf)
+	if err != nil {
+		t.Fatalf("ReadExport() failed: %v", err)
+	}
+	imported := &Mem{}
+	a2, err := ImportChain(imported, aums)
+	if err != nil {
+		t.Fatalf("ImportChain() failed: %v", err)
+	}
+	if a2.Head() != a.Head() {
+		t.Errorf("imported head = %v, want %v", a2.Head(), a.Head())
+	}
+
+	entries, err := a2.Audit(imported)
+	if err != nil {
+		t.Fatalf("Audit() failed: %v", err)
+	}
+	if got, want := len(entries), len(aums); got != want {
+		t.Fatalf("Audit() returned %d entries, want %d", got, want)
+	}
+	if entries[0].Hash != genesis.Hash() {
+		t.Errorf("first entry = %v, want genesis %v", entries[0].Hash, genesis.Hash())
+	}
+
+	// Checkpoints are interleaved by the builder, so look for the
+	// changes rather than at fixed positions.
+	var kinds []KeyChangeKind
+	for _, e := range entries {
+		for _, c := range e.KeyChanges {
+			kinds = append(kinds, c.Kind)
+			if c.Kind == KeyUpdated && (c.PrevVotes != 1 || c.Key.Votes != 3) {
+				t.Errorf("vote change = %d -> %d, want 1 -> 3", c.PrevVotes, c.Key.Votes)
+			}
+		}
+	}
+	want := []KeyChangeKind{KeyAdded, KeyAdded, KeyUpdated, KeyRemoved}
+	if !slices.Equal(kinds, want) {
+		t.Errorf("key changes = %v, want %v", kinds, want)
+	}
+}
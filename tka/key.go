@@ -4,11 +4,16 @@
 package tka
 
 import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"errors"
 	"fmt"
+	"math/big"
 
 	"github.com/hdevalence/ed25519consensus"
+	"golang.org/x/crypto/blake2s"
 	"tailscale.com/types/tkatype"
 )
 
@@ -19,6 +24,10 @@
 const (
 	KeyInvalid KeyKind = iota
 	Key25519
+	// KeyP256 is ECDSA using the NIST P-256 curve. Nodes older than
+	// capability version 86 fail to decode authorities with such keys,
+	// so LocalBackend refuses to add them while any peer is older.
+	KeyP256
 )
 
 func (k KeyKind) String() string {
@@ -27,6 +36,8 @@ func (k KeyKind) String() string {
 		return "invalid"
 	case Key25519:
 		return "25519"
+	case KeyP256:
+		return "p256"
 	default:
 		return fmt.Sprintf("Key?<%d>", int(k))
 	}
@@ -43,7 +54,8 @@ type Key struct {
 
 	// Public encodes the public key of the key. For 25519 keys,
 	// this is simply the point on the curve representing the public
-	// key.
+	// key. For P-256 keys, this is the uncompressed point as described
+	// in SEC 1, section 2.3.3.
 	Public []byte `cbor:"3,keyasint"`
 
 	// Meta describes arbitrary metadata about the key. This could be
@@ -90,6 +102,11 @@ func (k Key) ID() (tkatype.KeyID, error) {
 	// public as their 'key ID'.
 	case Key25519:
 		return tkatype.KeyID(k.Public), nil
+	// P-256 public keys are longer than the 32 bytes used for all
+	// key IDs, so we use their hash instead.
+	case KeyP256:
+		h := blake2s.Sum256(k.Public)
+		return tkatype.KeyID(h[:]), nil
 	default:
 		return nil, fmt.Errorf("unknown key kind: %v", k.Kind)
 	}
@@ -106,6 +123,37 @@ func (k Key) Ed25519() (ed25519.PublicKey, error) {
 	}
 }
 
+// ECDSA returns the ECDSA public key encoded by Key. An error is
+// returned for keys which do not represent valid P-256 public keys.
+func (k Key) ECDSA() (*ecdsa.PublicKey, error) {
+	if k.Kind != KeyP256 {
+		return nil, fmt.Errorf("key is of type %v, not p256", k.Kind)
+	}
+	if len(k.Public) != p256PublicKeySize || k.Public[0] != 4 {
+		return nil, errors.New("p256 key is not an uncompressed point")
+	}
+	// crypto/ecdh validates that the point is on the curve.
+	if _, err := ecdh.P256().NewPublicKey(k.Public); err != nil {
+		return nil, errors.New("p256 key is not on the curve")
+	}
+	const coordSize = (p256PublicKeySize - 1) / 2
+	return &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(k.Public[1 : 1+coordSize]),
+		Y:     new(big.Int).SetBytes(k.Public[1+coordSize:]),
+	}, nil
+}
+
+const (
+	// p256PublicKeySize is the size of an uncompressed P-256 point.
+	p256PublicKeySize = 65
+	// p256SignatureSize is the size of a P-256 signature, encoded as
+	// the concatenation of the 32-byte big-endian r and s values.
+	//
+	// This happens to be the same as ed25519.SignatureSize.
+	p256SignatureSize = 64
+)
+
 const maxMetaBytes = 512
 
 func (k Key) StaticValidate() error {
@@ -131,6 +179,10 @@ func (k Key) StaticValidate() error {
 
 	switch k.Kind {
 	case Key25519:
+	case KeyP256:
+		if _, err := k.ECDSA(); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unrecognized key kind: %v", k.Kind)
 	}
@@ -143,17 +195,78 @@ func signatureVerify(s *tkatype.Signature, aumDigest tkatype.AUMSigHash, key Key
 	// NOTE(tom): Even if we can compute the public from the KeyID,
 	//            its possible for the KeyID to be attacker-controlled
 	//            so we should use the public contained in the state machine.
-	switch key.Kind {
+	return key.verify(aumDigest[:], s.Signature)
+}
+
+// verify returns a nil error if sig is a valid signature by the key
+// over the given digest.
+func (k Key) verify(digest, sig []byte) error {
+	switch k.Kind {
 	case Key25519:
-		if len(key.Public) != ed25519.PublicKeySize {
-			return fmt.Errorf("ed25519 key has wrong length: %d", len(key.Public))
+		if len(k.Public) != ed25519.PublicKeySize {
+			return fmt.Errorf("ed25519 key has wrong length: %d", len(k.Public))
+		}
+		if ed25519consensus.Verify(ed25519.PublicKey(k.Public), digest, sig) {
+			return nil
 		}
-		if ed25519consensus.Verify(ed25519.PublicKey(key.Public), aumDigest[:], s.Signature) {
+		return errors.New("invalid signature")
+
+	case KeyP256:
+		pub, err := k.ECDSA()
+		if err != nil {
+			return err
+		}
+		r, s, err := parseP256Signature(sig)
+		if err != nil {
+			return err
+		}
+		// The digest is signed directly, rather than hashed again, so
+		// that signing can be performed by hardware tokens which only
+		// implement raw ECDSA (such as CKM_ECDSA in PKCS#11).
+		if ecdsa.Verify(pub, digest, r, s) {
 			return nil
 		}
 		return errors.New("invalid signature")
 
 	default:
-		return fmt.Errorf("unhandled key type: %v", key.Kind)
+		return fmt.Errorf("unhandled key type: %v", k.Kind)
+	}
+}
+
+// p256HalfOrder is half the order of the P-256 group.
+var p256HalfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
+// parseP256Signature decodes a P-256 signature in r||s form.
+//
+// ECDSA signatures are malleable: (r, -s mod N) is also a valid signature.
+// As the hash of an AUM covers its signatures, only the form where s is in
+// the lower half of the group order is accepted, so that a third party
+// cannot change the hash of an AUM without invalidating it.
+func parseP256Signature(sig []byte) (r, s *big.Int, err error) {
+	if len(sig) != p256SignatureSize {
+		return nil, nil, fmt.Errorf("p256 signature has wrong length: %d", len(sig))
+	}
+	r = new(big.Int).SetBytes(sig[:32])
+	s = new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(p256HalfOrder) > 0 {
+		return nil, nil, errors.New("p256 signature is not in low-S form")
+	}
+	return r, s, nil
+}
+
+// MarshalP256Signature encodes an ECDSA P-256 signature in the form used
+// by tailnet lock: the concatenation of the 32-byte big-endian r and s
+// values, with s normalized to the lower half of the group order.
+func MarshalP256Signature(r, s *big.Int) ([]byte, error) {
+	n := elliptic.P256().Params().N
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return nil, errors.New("signature values out of range")
+	}
+	if s.Cmp(p256HalfOrder) > 0 {
+		s = new(big.Int).Sub(n, s)
 	}
+	out := make([]byte, p256SignatureSize)
+	r.FillBytes(out[:32])
+	s.FillBytes(out[32:])
+	return out, nil
 }
@@ -5,8 +5,12 @@
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
 	"encoding/binary"
+	"math/big"
 	"math/rand"
 	"testing"
 
@@ -65,6 +69,72 @@ func TestVerify25519(t *testing.T) {
 	}
 }
 
+// generates a P-256 private key, returning it along with its Key.
+func testingKeyP256(t *testing.T) (Key, *ecdsa.PrivateKey) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{Kind: KeyP256, Public: pub.Bytes(), Votes: 1}, priv
+}
+
+func TestVerifyP256(t *testing.T) {
+	key, priv := testingKeyP256(t)
+	if err := key.StaticValidate(); err != nil {
+		t.Fatalf("StaticValidate() failed: %v", err)
+	}
+	if got := len(key.MustID()); got != 32 {
+		t.Errorf("len(ID()) = %d, want 32", got)
+	}
+
+	aum := AUM{
+		MessageKind: AUMRemoveKey,
+		KeyID:       []byte{1, 2, 3, 4},
+	}
+	sigHash := aum.SigHash()
+	r, s, err := ecdsa.Sign(crand.Reader, priv, sigHash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := MarshalP256Signature(r, s)
+	if err != nil {
+		t.Fatalf("MarshalP256Signature() failed: %v", err)
+	}
+	aum.Signatures = []tkatype.Signature{{KeyID: key.MustID(), Signature: sig}}
+	if err := aum.StaticValidate(); err != nil {
+		t.Errorf("StaticValidate() on signed AUM failed: %v", err)
+	}
+	if err := signatureVerify(&aum.Signatures[0], sigHash, key); err != nil {
+		t.Errorf("signature verification failed: %v", err)
+	}
+
+	// The malleated (high-S) form of the signature must be rejected.
+	n := elliptic.P256().Params().N
+	lowS := new(big.Int).SetBytes(sig[32:])
+	highSig := bytes.Clone(sig)
+	new(big.Int).Sub(n, lowS).FillBytes(highSig[32:])
+	if err := signatureVerify(&tkatype.Signature{KeyID: key.MustID(), Signature: highSig}, sigHash, key); err == nil {
+		t.Error("high-S signature verification did not fail")
+	}
+
+	// Make sure it fails with a different public key.
+	key2, _ := testingKeyP256(t)
+	if err := signatureVerify(&aum.Signatures[0], sigHash, key2); err == nil {
+		t.Error("signature verification with different key did not fail")
+	}
+
+	// Points not on the curve are rejected.
+	bad := key.Clone()
+	bad.Public[64] ^= 1
+	if err := bad.StaticValidate(); err == nil {
+		t.Error("StaticValidate() on invalid point did not fail")
+	}
+}
+
 func TestNLPrivate(t *testing.T) {
 	p := key.NewNLPrivate()
 	pub := p.Public()
@@ -10,7 +10,6 @@
 	"fmt"
 
 	"github.com/fxamacker/cbor/v2"
-	"github.com/hdevalence/ed25519consensus"
 	"golang.org/x/crypto/blake2s"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
@@ -250,19 +249,7 @@ func (s *NodeKeySignature) verifySignature(nodeKey key.NodePublic, verificationK
 		if s.Nested != nil {
 			return fmt.Errorf("invalid signature: signatures of type %v cannot nest another signature", s.SigKind)
 		}
-		switch verificationKey.Kind {
-		case Key25519:
-			if len(verificationKey.Public) != ed25519.PublicKeySize {
-				return fmt.Errorf("ed25519 key has wrong length: %d", len(verificationKey.Public))
-			}
-			if ed25519consensus.Verify(ed25519.PublicKey(verificationKey.Public), sigHash[:], s.Signature) {
-				return nil
-			}
-			return errors.New("invalid signature")
-
-		default:
-			return fmt.Errorf("unhandled key type: %v", verificationKey.Kind)
-		}
+		return verificationKey.verify(sigHash[:], s.Signature)
 
 	default:
 		return fmt.Errorf("unhandled signature type: %v", s.SigKind)
@@ -7,6 +7,8 @@
 	"bytes"
 	"errors"
 	"fmt"
+	"maps"
+	"slices"
 
 	"golang.org/x/crypto/argon2"
 	"tailscale.com/types/tkatype"
@@ -42,6 +44,15 @@ type State struct {
 	// use for this.
 	StateID1 uint64 `cbor:"4,keyasint,omitempty"`
 	StateID2 uint64 `cbor:"5,keyasint,omitempty"`
+
+	// KeyChangeThreshold is the minimum number of distinct trusted keys
+	// which must sign an AUM that changes the trusted keys (adding or
+	// removing them, or updating their votes or metadata), the
+	// disablement secrets, the state ID or this threshold. Zero and one both mean a single signature suffices.
+	//
+	// Nodes which predate this field do not enforce it, so it should
+	// only be set once all signing nodes understand it.
+	KeyChangeThreshold uint `cbor:"6,keyasint,omitempty"`
 }
 
 // GetKey returns the trusted key with the specified KeyID.
@@ -67,8 +78,9 @@ func (s State) GetKey(key tkatype.KeyID) (Key, error) {
 // must take care to preserve this.
 func (s State) Clone() State {
 	out := State{
-		StateID1: s.StateID1,
-		StateID2: s.StateID2,
+		StateID1:           s.StateID1,
+		StateID2:           s.StateID2,
+		KeyChangeThreshold: s.KeyChangeThreshold,
 	}
 
 	if s.LastAUMHash != nil {
@@ -94,6 +106,46 @@ func (s State) Clone() State {
 	return out
 }
 
+// keyChangeSignaturesRequired returns the number of distinct trusted keys
+// which must sign an AUM that changes the trusted keys.
+//
+// The threshold is capped at the number of trusted keys, so removing keys
+// can never leave the authority unable to make further changes.
+func (s State) keyChangeSignaturesRequired() int {
+	need := min(int(s.KeyChangeThreshold), len(s.Keys))
+	return max(need, 1)
+}
+
+// sameKeyPolicy reports whether s and o trust the same keys, with the same
+// votes and metadata, and have the same key-change threshold, disablement
+// secrets and state ID.
+//
+// The disablement secrets and state ID are compared as well as the keys, as
+// replacing them with a single signature would let one key disable the
+// authority, or start over with a new one, despite the threshold.
+func (s State) sameKeyPolicy(o State) bool {
+	if s.KeyChangeThreshold != o.KeyChangeThreshold || len(s.Keys) != len(o.Keys) {
+		return false
+	}
+	if s.StateID1 != o.StateID1 || s.StateID2 != o.StateID2 {
+		return false
+	}
+	if !slices.EqualFunc(s.DisablementSecrets, o.DisablementSecrets, bytes.Equal) {
+		return false
+	}
+	for _, k := range s.Keys {
+		keyID, err := k.ID()
+		if err != nil {
+			return false
+		}
+		ok, err := o.GetKey(keyID)
+		if err != nil || ok.Votes != k.Votes || !maps.Equal(ok.Meta, k.Meta) {
+			return false
+		}
+	}
+	return true
+}
+
 // cloneForUpdate is like Clone, except LastAUMHash is set based
 // on the hash of the given update.
 func (s State) cloneForUpdate(update *AUM) State {
@@ -281,6 +333,9 @@ func (s *State) staticValidateCheckpoint() error {
 	if len(s.Keys) == 0 {
 		return errors.New("at least one key is required")
 	}
+	if s.KeyChangeThreshold > uint(len(s.Keys)) {
+		return fmt.Errorf("key-change threshold (%d) exceeds the number of keys (%d)", s.KeyChangeThreshold, len(s.Keys))
+	}
 	if numKeys := len(s.Keys); numKeys > maxKeys {
 		return fmt.Errorf("too many keys (%d, max %d)", numKeys, maxKeys)
 	}
@@ -29,6 +29,11 @@
 	MaxMapPairs:      1024,
 }
 
+// ErrThresholdNotMet is returned when an AUM which changes the trusted keys
+// is not signed by enough distinct trusted keys to meet the authority's
+// key-change threshold.
+var ErrThresholdNotMet = errors.New("key-change threshold not met")
+
 // Arbitrarily chosen limit on scanning AUM trees.
 const maxScanIterations = 2000
 
@@ -431,6 +436,7 @@ func aumVerify(aum AUM, state State, isGenesisAUM bool) error {
 		return errors.New("unsigned AUM")
 	}
 	sigHash := aum.SigHash()
+	signers := make(set.Set[string], len(aum.Signatures))
 	for i, sig := range aum.Signatures {
 		key, err := state.GetKey(sig.KeyID)
 		if err != nil {
@@ -439,10 +445,37 @@ func aumVerify(aum AUM, state State, isGenesisAUM bool) error {
 		if err := signatureVerify(&sig, sigHash, key); err != nil {
 			return fmt.Errorf("signature %d: %v", i, err)
 		}
+		signers.Add(string(sig.KeyID))
+	}
+
+	// The genesis AUM establishes the threshold, so there is
+	// nothing to check it against.
+	if !isGenesisAUM && changesKeyPolicy(aum, state) {
+		if need := state.keyChangeSignaturesRequired(); len(signers) < need {
+			return fmt.Errorf("%w: signed by %d trusted keys, need %d", ErrThresholdNotMet, len(signers), need)
+		}
 	}
 	return nil
 }
 
+// changesKeyPolicy reports whether applying aum to state would change the
+// trusted keys, the key-change threshold or anything else compared by
+// State.sameKeyPolicy, such that the AUM must meet the threshold.
+//
+// AUMs of unknown kinds never do: they cannot change the trusted keys.
+func changesKeyPolicy(aum AUM, state State) bool {
+	switch aum.MessageKind {
+	case AUMAddKey, AUMRemoveKey, AUMUpdateKey:
+		return true
+	case AUMCheckpoint:
+		// Checkpoints which restate the current keys, such as those
+		// generated periodically by UpdateBuilder, change nothing.
+		return aum.State == nil || !state.sameKeyPolicy(*aum.State)
+	default:
+		return false
+	}
+}
+
 func checkParent(aum AUM, state State) error {
 	parent, hasParent := aum.Parent()
 	if !hasParent {
@@ -615,7 +648,7 @@ func (a *Authority) InformIdempotent(storage Chonk, updates []AUM) (Authority, e
 		}
 
 		if err := aumVerify(update, state, false); err != nil {
-			return Authority{}, fmt.Errorf("update %d invalid: %v", i, err)
+			return Authority{}, fmt.Errorf("update %d invalid: %w", i, err)
 		}
 		if stateAt[hash], err = state.applyVerifiedAUM(update); err != nil {
 			return Authority{}, fmt.Errorf("update %d cannot be applied: %v", i, err)
@@ -696,6 +729,41 @@ func (a *Authority) KeyTrusted(keyID tkatype.KeyID) bool {
 	return err == nil
 }
 
+// KeyChangeThreshold returns the number of distinct trusted keys which must
+// sign an AUM that changes the trusted keys.
+func (a *Authority) KeyChangeThreshold() int {
+	return a.state.keyChangeSignaturesRequired()
+}
+
+// ProposalSignatures reports how many distinct trusted keys have validly
+// signed the given AUM, and how many must do so before it can be applied.
+// The AUM must be a child of the current head, as is the case for the output
+// of UpdateBuilder.FinalizeProposal.
+func (a *Authority) ProposalSignatures(aum AUM) (have, need int, err error) {
+	if err := aum.StaticValidate(); err != nil {
+		return 0, 0, fmt.Errorf("invalid: %v", err)
+	}
+	if err := checkParent(aum, a.state); err != nil {
+		return 0, 0, err
+	}
+	sigHash := aum.SigHash()
+	signers := make(set.Set[string], len(aum.Signatures))
+	for _, sig := range aum.Signatures {
+		key, err := a.state.GetKey(sig.KeyID)
+		if err != nil {
+			continue
+		}
+		if signatureVerify(&sig, sigHash, key) == nil {
+			signers.Add(string(sig.KeyID))
+		}
+	}
+	need = 1
+	if changesKeyPolicy(aum, a.state) {
+		need = a.state.keyChangeSignaturesRequired()
+	}
+	return len(signers), need, nil
+}
+
 // Keys returns the set of keys trusted by the tailnet key authority.
 func (a *Authority) Keys() []Key {
 	out := make([]Key, len(a.state.Keys))
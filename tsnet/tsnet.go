@@ -159,6 +159,7 @@ type Server struct {
 type FallbackTCPHandler func(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool)
 
 // Dial connects to the address on the tailnet.
+// The network may be any of the "tcp" or "udp" networks.
 // It will start the server if it has not been started yet.
 func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	if err := s.Start(); err != nil {
@@ -548,6 +549,9 @@ func (s *Server) start() (reterr error) {
 	s.dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 		return ns.DialContextTCP(ctx, dst)
 	}
+	s.dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns.DialContextUDP(ctx, dst)
+	}
 
 	if s.Store == nil {
 		stateFile := filepath.Join(s.rootPath, "tailscaled.state")
@@ -915,6 +919,64 @@ func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
 	}), nil
 }
 
+// ListenPacket announces on the Tailscale network for UDP datagrams.
+// It will start the server if it has not been started yet.
+//
+// The network must be "udp", "udp4", or "udp6". The host part of addr
+// must be empty or an IP literal; to listen on this node's own address
+// use TailscaleIPs. An empty host is only permitted with "udp4" or
+// "udp6", in which case the returned PacketConn receives datagrams sent
+// to any address of that family handled by this node.
+//
+// Unlike Listen, the returned PacketConn receives datagrams from all
+// peers, and WriteTo may be used to reply to any of them.
+func (s *Server) ListenPacket(network, addr string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("ListenPacket(%q, %q): only udp is supported", network, addr)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("tsnet: %w", err)
+	}
+	port, err := net.LookupPort(network, portStr)
+	if err != nil || port < 0 || port > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid port: %w", err)
+	}
+	var ip netip.Addr
+	if host != "" {
+		ip, err = netip.ParseAddr(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ListenPacket addr %q; host part must be empty or IP literal", host)
+		}
+		ip = ip.Unmap()
+	}
+	switch {
+	case network == "udp" && !ip.IsValid():
+		return nil, fmt.Errorf("ListenPacket(%q, %q): must specify an IP address or use udp4 or udp6", network, addr)
+	case network == "udp" && ip.Is4(), network == "udp4":
+		network = "udp4"
+		if ip.IsValid() && !ip.Is4() {
+			return nil, fmt.Errorf("invalid non-IPv4 addr %v for network %q", host, network)
+		}
+	default:
+		network = "udp6"
+		if ip.IsValid() && !ip.Is6() {
+			return nil, fmt.Errorf("invalid non-IPv6 addr %v for network %q", host, network)
+		}
+	}
+
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	pc, err := s.netstack.ListenPacket(network, netip.AddrPortFrom(ip, uint16(port)))
+	if err != nil {
+		return nil, fmt.Errorf("tsnet: %w", err)
+	}
+	return pc, nil
+}
+
 // RegisterFallbackTCPHandler registers a callback which will be called
 // to handle a TCP flow to this tsnet node, for which no listeners will handle.
 //
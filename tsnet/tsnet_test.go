@@ -301,6 +301,77 @@ func TestConn(t *testing.T) {
 	}
 }
 
+func TestPacketConn(t *testing.T) {
+	tstest.ResourceCheck(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL, _ := startControl(t)
+	s1, s1ip, _ := startServer(t, ctx, controlURL, "s1")
+	s2, s2ip, _ := startServer(t, ctx, controlURL, "s2")
+
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ping to make sure the connection is up.
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s1.ListenPacket("udp", ":8081"); err == nil {
+		t.Fatal("unexpected success listening on udp without an IP")
+	}
+	if _, err := s1.ListenPacket("tcp", s1ip.String()+":8081"); err == nil {
+		t.Fatal("unexpected success listening on tcp")
+	}
+
+	pc, err := s1.ListenPacket("udp", netip.AddrPortFrom(s1ip, 8081).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	c, err := s2.Dial(ctx, "udp", netip.AddrPortFrom(s1ip, 8081).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	want := "hello"
+	if _, err := io.WriteString(c, want); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 1500)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	fromAddr, ok := netip.AddrFromSlice(from.(*net.UDPAddr).IP)
+	if !ok || fromAddr.Unmap() != s2ip {
+		t.Errorf("got datagram from %v, want %v", from, s2ip)
+	}
+
+	// Reply to the sender.
+	want = "world"
+	if _, err := pc.WriteTo([]byte(want), from); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err = c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
 func TestLoopbackLocalAPI(t *testing.T) {
 	flakytest.Mark(t, "https://github.com/tailscale/tailscale/issues/8557")
 	tstest.ResourceCheck(t)
@@ -61,7 +61,32 @@ func (lim *Limiter) Allow() bool {
 	return lim.allow(mono.Now())
 }
 
+// AllowN reports whether n events may happen now.
+// If so, n tokens are consumed; otherwise none are.
+// It is used for limits on quantities such as bytes,
+// where each event consumes a number of tokens.
+func (lim *Limiter) AllowN(n int) bool {
+	return lim.allowN(mono.Now(), n)
+}
+
+// CancelN returns n tokens consumed by AllowN (or one, by Allow) to the
+// limiter, for when the event they were consumed for did not happen after
+// all, such as when it was also subject to another limiter which didn't
+// allow it.
+func (lim *Limiter) CancelN(n int) {
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	lim.tokens += float64(n)
+	if lim.tokens > lim.burst {
+		lim.tokens = lim.burst
+	}
+}
+
 func (lim *Limiter) allow(now mono.Time) bool {
+	return lim.allowN(now, 1)
+}
+
+func (lim *Limiter) allowN(now mono.Time, n int) bool {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
@@ -77,8 +102,8 @@ func (lim *Limiter) allow(now mono.Time) bool {
 		tokens = lim.burst
 	}
 
-	// Consume a token.
-	tokens--
+	// Consume the tokens.
+	tokens -= float64(n)
 
 	// Update state.
 	ok := tokens >= 0
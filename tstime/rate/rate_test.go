@@ -105,6 +105,44 @@ func TestLimiterJumpBackwards(t *testing.T) {
 	})
 }
 
+func TestLimiterAllowN(t *testing.T) {
+	lim := NewLimiter(10, 5)
+	steps := []struct {
+		t  mono.Time
+		n  int
+		ok bool
+	}{
+		{t0, 3, true},
+		{t0, 3, false}, // only two tokens remain; none consumed
+		{t0, 2, true},
+		{t0, 1, false},
+		{t1, 1, true}, // got a token
+		{t1, 1, false},
+		{t2, 6, false}, // more than the burst is never allowed
+		{t2, 1, true},
+	}
+	for i, st := range steps {
+		if ok := lim.allowN(st.t, st.n); ok != st.ok {
+			t.Errorf("step %d: lim.AllowN(%v, %d) = %v want %v", i, st.t, st.n, ok, st.ok)
+		}
+	}
+}
+
+func TestLimiterCancelN(t *testing.T) {
+	lim := NewLimiter(10, 5)
+	if !lim.allowN(t0, 5) {
+		t.Fatal("AllowN(5) of full limiter = false")
+	}
+	lim.CancelN(3)
+	if !lim.allowN(t0, 3) || lim.allowN(t0, 1) {
+		t.Error("CancelN(3) did not return exactly 3 tokens")
+	}
+	lim.CancelN(100)
+	if lim.allowN(t0, 6) || !lim.allowN(t0, 5) {
+		t.Error("CancelN returned more tokens than the burst")
+	}
+}
+
 // Ensure that tokensFromDuration doesn't produce
 // rounding errors by truncating nanoseconds.
 // See golang.org/issues/34861.
@@ -506,6 +506,36 @@ func (ns *Impl) DialContextUDP(ctx context.Context, ipp netip.AddrPort) (*gonet.
 	return gonet.DialUDP(ns.ipstack, nil, remoteAddress, ipType)
 }
 
+// ListenPacket binds a UDP endpoint in netstack to the provided local
+// address. The network must be "udp4" or "udp6". If the address is
+// unspecified, the endpoint receives datagrams sent to any of the node's
+// addresses of that family.
+//
+// Datagrams for a bound endpoint are delivered to it rather than to
+// GetUDPHandlerForFlow.
+func (ns *Impl) ListenPacket(network string, ipp netip.AddrPort) (*gonet.UDPConn, error) {
+	var ipType tcpip.NetworkProtocolNumber
+	switch network {
+	case "udp4":
+		ipType = ipv4.ProtocolNumber
+	case "udp6":
+		ipType = ipv6.ProtocolNumber
+	default:
+		return nil, fmt.Errorf("netstack: unsupported network %q for ListenPacket", network)
+	}
+	localAddress := &tcpip.FullAddress{
+		NIC:  nicID,
+		Port: ipp.Port(),
+	}
+	if ip := ipp.Addr(); ip.IsValid() && !ip.IsUnspecified() {
+		if ip.Is4() != (ipType == ipv4.ProtocolNumber) {
+			return nil, fmt.Errorf("netstack: address %v does not match network %q", ip, network)
+		}
+		localAddress.Addr = tcpip.AddrFromSlice(ip.AsSlice())
+	}
+	return gonet.DialUDP(ns.ipstack, localAddress, nil, ipType)
+}
+
 // The inject goroutine reads in packets that netstack generated, and delivers
 // them to the correct path.
 func (ns *Impl) inject() {
@@ -615,6 +645,10 @@ func (ns *Impl) shouldProcessInbound(p *packet.Parsed, t *tstun.Wrapper) bool {
 			return true
 		}
 	}
+	// Handle UDP to the Tailscale IP(s) for serve, if enabled.
+	if ns.lb != nil && p.IPProto == ipproto.UDP && isLocal && ns.lb.ShouldInterceptUDPPort(p.Dst.Port()) {
+		return true
+	}
 	if p.IPVersion == 6 && !isLocal && viaRange.Contains(dstIP) {
 		return ns.lb != nil && ns.lb.ShouldHandleViaIP(dstIP)
 	}
@@ -1064,6 +1098,13 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 		return
 	}
 
+	if ns.lb != nil {
+		if h := ns.lb.UDPHandlerForDst(srcAddr, dstAddr); h != nil {
+			go h(gonet.NewUDPConn(ns.ipstack, &wq, ep))
+			return
+		}
+	}
+
 	if get := ns.GetUDPHandlerForFlow; get != nil {
 		h, intercept := get(srcAddr, dstAddr)
 		if intercept {